@@ -100,6 +100,17 @@ const VOICE_OPTIONS = [
   },
 ] as const;
 const DEFAULT_TTS_VOICE = 'en-US-Journey-O';
+// The purge runs as a backend job that removes the Firebase Auth user last;
+// poll it until it finishes, so a failed purge can still be retried.
+const DELETION_POLL_INTERVAL_MS = 2000;
+const DELETION_POLL_TIMEOUT_MS = 5 * 60 * 1000;
+
+type DeletionJobStatus = {
+  job_id: string;
+  status: string;
+  not_before?: string;
+  last_error?: string;
+};
 
 type SettingsTab = 'identity' | 'preferences' | 'account';
 
@@ -142,7 +153,11 @@ export default function SettingsScreen() {
   // DELETE ACCOUNT FLOW
   const [deleteModalVisible, setDeleteModalVisible] = useState(false);
   const [deleteConfirmText, setDeleteConfirmText] = useState('');
+  const [keepReflectionsOnDelete, setKeepReflectionsOnDelete] = useState(false);
   const [isDeletingAccount, setIsDeletingAccount] = useState(false);
+  const [deleteAfterGrace, setDeleteAfterGrace] = useState(true);
+  const [scheduledDeletion, setScheduledDeletion] = useState<{ jobId: string; notBefore: string } | null>(null);
+  const [cancellingDeletion, setCancellingDeletion] = useState(false);
 
   // DEVELOPER TOOLS
   const [resettingOnboarding, setResettingOnboarding] = useState(false);
@@ -671,48 +686,151 @@ export default function SettingsScreen() {
     }
   };
 
+  // Reads the deletion job's status, or null when there is no such job.
+  const fetchDeletionJob = useCallback(async (jobId: string): Promise<DeletionJobStatus | null> => {
+    if (!user) return null;
+    const res = await fetch(`${API_ENDPOINTS.DELETE_COMPANION_ACCOUNT}?job_id=${encodeURIComponent(jobId)}`, {
+      headers: { Authorization: `Bearer ${await user.getIdToken()}` },
+    });
+    if (res.status === 404) return null;
+    if (!res.ok) {
+      throw new Error(`Could not check cleanup progress: ${await res.text()}`);
+    }
+    return res.json();
+  }, [user]);
+
+  // Polls the deletion job until it completes. Throws if it fails or does not
+  // finish in time, leaving the account in place to retry from.
+  const waitForDeletionJob = async (jobId: string) => {
+    const deadline = Date.now() + DELETION_POLL_TIMEOUT_MS;
+    while (Date.now() < deadline) {
+      const job = await fetchDeletionJob(jobId);
+      if (!job) throw new Error('The cleanup job could not be found. Please try again.');
+      if (job.status === 'completed') return;
+      if (job.status === 'failed') {
+        throw new Error(`Cleanup failed${job.last_error ? `: ${job.last_error}` : ''}. Your account has been kept so you can try again.`);
+      }
+      await new Promise((resolve) => setTimeout(resolve, DELETION_POLL_INTERVAL_MS));
+    }
+    throw new Error('Cleanup is taking longer than expected. Your account has been kept; please try again later.');
+  };
+
+  // The deletion job removes the Firebase Auth record as its last step, so
+  // once it has completed only the local session is left to clear.
+  const finishAccountDeletion = async () => {
+    if (!user) return;
+    // Explicitly sign out so Firebase clears its local session cache.
+    // Without this, a stale token can survive a cold restart and route
+    // the user to the Welcome/Join screen instead of Login.
+    try { await signOut(); } catch { /* no-op: the account is already gone */ }
+
+    // Success: drop overlay, clear local storage, return to login
+    setIsDeletingAccount(false);
+    await AsyncStorage.clear();
+    router.replace('/');
+  };
+
+  const showDeletionError = (error: any) => {
+    setIsDeletingAccount(false);
+    Alert.alert('Something went wrong', error?.message ?? 'Account deletion failed. Please try again.');
+  };
+
+  // A scheduled deletion keeps the account through its grace period. Show it
+  // so it can be cancelled, and once the grace period is over wait for the
+  // purge and sign out.
+  useFocusEffect(
+    useCallback(() => {
+      if (!user?.uid) return;
+      let active = true;
+      (async () => {
+        const job = await fetchDeletionJob(user.uid);
+        if (!active) return;
+        if (job?.status === 'scheduled' && job.not_before) {
+          setScheduledDeletion({ jobId: job.job_id, notBefore: job.not_before });
+          return;
+        }
+        setScheduledDeletion(null);
+        if (job && ['pending', 'running', 'completed'].includes(job.status)) {
+          setIsDeletingAccount(true);
+          await waitForDeletionJob(job.job_id);
+          await finishAccountDeletion();
+        }
+      })().catch((error) => {
+        if (active) showDeletionError(error);
+      });
+      return () => {
+        active = false;
+      };
+      // eslint-disable-next-line react-hooks/exhaustive-deps
+    }, [user?.uid, fetchDeletionJob])
+  );
+
   const handleDeleteAccount = async () => {
     if (!user?.uid) return;
     setDeleteModalVisible(false);
     setDeleteConfirmText('');
     setIsDeletingAccount(true);
     try {
-      // Step A — backend purge
+      // Step A — backend purge, now or after the grace period
       const res = await fetch(API_ENDPOINTS.DELETE_COMPANION_ACCOUNT, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ user_id: user.uid }),
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${await user.getIdToken()}`,
+        },
+        body: JSON.stringify({
+          user_id: user.uid,
+          mode: deleteAfterGrace ? 'scheduled' : 'immediate',
+          keep_reflections: keepReflectionsOnDelete,
+          archived_sender_name: keepReflectionsOnDelete ? activeRelationship?.companionName ?? '' : '',
+        }),
       });
       if (!res.ok) {
         const body = await res.text();
         throw new Error(`Backend cleanup failed: ${body}`);
       }
-
-      // Step B — delete Firebase Auth record
-      await user.delete();
-      // Explicitly sign out so Firebase clears its local session cache.
-      // Without this, a stale token can survive a cold restart and route
-      // the user to the Welcome/Join screen instead of Login.
-      try { await signOut(); } catch { /* no-op: already signed out by delete */ }
-
-      // Step D — success: drop overlay, clear local storage, return to login
-      setIsDeletingAccount(false);
-      await AsyncStorage.clear();
-      router.replace('/');
-    } catch (error: any) {
-      setIsDeletingAccount(false);
-
-      // Step C — re-auth required
-      if (error?.code === 'auth/requires-recent-login') {
+      const job: DeletionJobStatus = await res.json();
+      if (job.status === 'scheduled' && job.not_before) {
+        // The account stays until the grace period ends; the purge then
+        // removes it, even if the app is closed.
+        setIsDeletingAccount(false);
+        setScheduledDeletion({ jobId: job.job_id, notBefore: job.not_before });
         Alert.alert(
-          'Re-login Required',
-          'For security, please log out and sign back in before deleting your account.',
-          [{ text: 'OK' }]
+          'Deletion Scheduled',
+          `Your account will be deleted on ${new Date(job.not_before).toLocaleString()}. Until then you can cancel from Settings.`
         );
         return;
       }
+      await waitForDeletionJob(job.job_id);
 
-      Alert.alert('Something went wrong', error?.message ?? 'Account deletion failed. Please try again.');
+      // Step B — the purge removed the Firebase Auth record; sign out
+      await finishAccountDeletion();
+    } catch (error: any) {
+      showDeletionError(error);
+    }
+  };
+
+  const handleCancelDeletion = async () => {
+    if (!user?.uid) return;
+    setCancellingDeletion(true);
+    try {
+      const res = await fetch(API_ENDPOINTS.DELETE_COMPANION_ACCOUNT, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${await user.getIdToken()}`,
+        },
+        body: JSON.stringify({ user_id: user.uid, action: 'cancel' }),
+      });
+      if (!res.ok) {
+        throw new Error(await res.text());
+      }
+      setScheduledDeletion(null);
+      Alert.alert('Deletion Cancelled', 'Your account and Reflections have been restored.');
+    } catch (error: any) {
+      Alert.alert('Error', `Could not cancel the deletion: ${error?.message ?? 'please try again.'}`);
+    } finally {
+      setCancellingDeletion(false);
     }
   };
 
@@ -1171,13 +1289,33 @@ export default function SettingsScreen() {
 
           <View style={styles.divider} />
 
-          <TouchableOpacity
-            style={styles.deleteAccountButton}
-            onPress={() => setDeleteModalVisible(true)}
-          >
-            <FontAwesome name="trash" size={14} color="#ff4d4d" style={{ marginRight: 8 }} />
-            <Text style={styles.deleteAccountText}>Delete Account</Text>
-          </TouchableOpacity>
+          {scheduledDeletion ? (
+            <>
+              <Text style={styles.description}>
+                Your account is scheduled to be deleted on {new Date(scheduledDeletion.notBefore).toLocaleString()}.
+                Your Reflections are hidden from your Explorer until then.
+              </Text>
+              <TouchableOpacity
+                style={styles.deleteAccountButton}
+                onPress={handleCancelDeletion}
+                disabled={cancellingDeletion}
+              >
+                {cancellingDeletion ? (
+                  <ActivityIndicator size="small" color={tintColor} />
+                ) : (
+                  <Text style={[styles.deleteAccountText, { color: tintColor }]}>Cancel Deletion</Text>
+                )}
+              </TouchableOpacity>
+            </>
+          ) : (
+            <TouchableOpacity
+              style={styles.deleteAccountButton}
+              onPress={() => setDeleteModalVisible(true)}
+            >
+              <FontAwesome name="trash" size={14} color="#ff4d4d" style={{ marginRight: 8 }} />
+              <Text style={styles.deleteAccountText}>Delete Account</Text>
+            </TouchableOpacity>
+          )}
         </View>
       </View>
 
@@ -1366,9 +1504,38 @@ export default function SettingsScreen() {
             <FontAwesome name="exclamation-triangle" size={28} color="#ff4d4d" style={{ marginBottom: 12 }} />
             <Text style={styles.deleteModalTitle}>Saying Goodbye</Text>
             <Text style={styles.deleteModalMessage}>
-              This will permanently delete your account and all Reflections you have sent. This cannot be undone.
+              {keepReflectionsOnDelete
+                ? 'This will permanently delete your account. The Reflections you have sent will stay with your Explorer.'
+                : 'This will permanently delete your account and all Reflections you have sent.'}
+              {deleteAfterGrace
+                ? ' Your Reflections are hidden right away, and you can cancel from Settings until the deletion happens.'
+                : ' This cannot be undone.'}
             </Text>
 
+            <View style={[styles.row, { width: '100%', marginBottom: 16 }]}>
+              <Text style={[styles.deleteModalPrompt, { flex: 1, marginBottom: 0 }]}>
+                Give me time to change my mind
+              </Text>
+              <Switch
+                value={deleteAfterGrace}
+                onValueChange={setDeleteAfterGrace}
+                trackColor={{ false: '#333', true: '#2e78b7' }}
+                thumbColor={Platform.OS === 'ios' ? '#fff' : '#f4f3f4'}
+              />
+            </View>
+
+            <View style={[styles.row, { width: '100%', marginBottom: 16 }]}>
+              <Text style={[styles.deleteModalPrompt, { flex: 1, marginBottom: 0 }]}>
+                Leave my Reflections for my Explorer
+              </Text>
+              <Switch
+                value={keepReflectionsOnDelete}
+                onValueChange={setKeepReflectionsOnDelete}
+                trackColor={{ false: '#333', true: '#2e78b7' }}
+                thumbColor={Platform.OS === 'ios' ? '#fff' : '#f4f3f4'}
+              />
+            </View>
+
             <Text style={styles.deleteModalPrompt}>
               Type <Text style={{ color: '#fff', fontWeight: '700' }}>{activeRelationship?.companionName || 'your name'}</Text> to confirm.
             </Text>
@@ -3,6 +3,7 @@ import {
   API_ENDPOINTS,
   AvatarFilterBar,
   coerceThumbnailTimeMs,
+  getAuthHeaders,
   Event,
   EventMetadata,
   ExplorerConfig,
@@ -1320,10 +1321,13 @@ export default function SentTimelineScreen({
     if (!currentExplorerId) return;
 
     try {
+      // ID token identifies the caller in the server-side audit log.
+      const authHeaders = await getAuthHeaders();
+
       // 1. Delete S3 objects (to/ path - reflection content)
       const deleteRes = await fetch(
         `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${item.event_id}&explorer_id=${currentExplorerId}&path=to`,
-        { method: 'DELETE' }
+        { method: 'DELETE', headers: authHeaders }
       );
       if (!deleteRes.ok) {
         const errData = await deleteRes.json().catch(() => null);
@@ -1336,7 +1340,7 @@ export default function SentTimelineScreen({
       if (responseEventId) {
         await fetch(
           `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${responseEventId}&path=from&explorer_id=${currentExplorerId}`,
-          { method: 'DELETE' }
+          { method: 'DELETE', headers: authHeaders }
         ).catch(() => {});
       }
 
@@ -31,7 +31,9 @@ import {
   EventMetadata,
   ExplorerConfig,
   coerceThumbnailTimeMs,
+  getAuthHeaders,
   getValidVideoTrimFromFields,
+  initialReflectionStatus,
   WaitOverlay,
   useAuth,
   useExplorer,
@@ -82,6 +84,11 @@ type AiDescriptionResponse = {
   audio_s3_key?: string | null;
   deep_dive_audio_s3_key?: string | null;
   staging_event_id?: string | null;
+  prompt_version?: string | null;
+  language?: string | null;
+  second_language?: string | null;
+  second_short_caption?: string | null;
+  second_deep_dive?: string | null;
   _stagingId?: string | null;
 };
 
@@ -172,6 +179,11 @@ const safeUploadToS3 = async (localUri: string, presignedUrl: string) => {
   }
 };
 
+/** Stills uploaded beside a video's staging thumbnail so captions can describe what happens over time. */
+const AI_CAPTION_KEYFRAME_COUNT = 4;
+/** Matches the server's video caption cap; frames are only sampled from this much of the clip. */
+const AI_CAPTION_MAX_VIDEO_MS = 60_000;
+
 /** Dark blue-slate surfaces for the creation sheet (timeline is #000; composer uses similar navy). */
 const CREATION_SURFACE_GRADIENT = ['#2c364d', '#181c28'] as const;
 const CREATION_SHEET_CORNER_BG = CREATION_SURFACE_GRADIENT[1];
@@ -216,6 +228,8 @@ export default function CreationModal({
   const [aiAudioS3Key, setAiAudioS3Key] = useState<string | null>(null);
   const [aiDeepDiveS3Key, setAiDeepDiveS3Key] = useState<string | null>(null);
   const stagingEventIdRef = useRef<string | null>(null); // Sync fallback; state can lag after async Sparkle
+  const aiPromptVersionRef = useRef<string | null>(null); // Server prompt template version behind the current AI caption
+  const aiLanguageMetadataRef = useRef<Pick<EventMetadata, 'caption_language' | 'second_language' | 'second_short_caption' | 'second_deep_dive'> | null>(null); // Caption language(s) behind the current AI caption
   /** Production `event_id` being edited (never use as staging folder id). */
   const editSourceEventIdRef = useRef<string | null>(null);
   /** Pinned copy of the edit event ID that survives state resets during media replacement.
@@ -962,6 +976,8 @@ export default function CreationModal({
       setAudioUri(null);
       setStagingEventId(null);
       stagingEventIdRef.current = null;
+      aiPromptVersionRef.current = null;
+      aiLanguageMetadataRef.current = null;
       lastProcessedUriRef.current = audioRecorder?.uri ?? lastProcessedUriRef.current;
       if (editSourceEventIdRef.current) {
         mediaReplacedDuringEditRef.current = true;
@@ -1008,6 +1024,11 @@ export default function CreationModal({
       skipTts?: boolean;
       captionVoice?: string;
       deepDiveVoice?: string;
+      forceRefresh?: boolean;
+      /** Set for video reflections; the server uses any keyframes beside the thumbnail. */
+      video?: { durationMs?: number };
+      /** Staged Companion recording; the server transcribes it as caption context. */
+      voiceNoteS3Key?: string;
     } = {}
   ): Promise<AiDescriptionResponse | null> => {
     if (!currentExplorerId || !imageUrl) {
@@ -1030,9 +1051,23 @@ export default function CreationModal({
 
       let fetchUrl = `${API_ENDPOINTS.AI_DESCRIPTION}?image_url=${encodeURIComponent(imageUrl)}&explorer_id=${currentExplorerId}`;
       fetchUrl += `&prompt=${encodeURIComponent(prompt)}`;
+      // Template variables for the server-side prompt registry (the prompt above is only used when the Explorer opts into it)
+      if (explorerName) fetchUrl += `&explorer_name=${encodeURIComponent(explorerName)}`;
+      if (companionName) fetchUrl += `&companion_name=${encodeURIComponent(companionName)}`;
+      fetchUrl += `&companion_in_reflection=${isCompanionInReflection}&explorer_in_reflection=${isExplorerInReflection}`;
+      if (peopleContext?.trim()) fetchUrl += `&people_context=${encodeURIComponent(peopleContext.trim())}`;
       if (options.targetCaption) fetchUrl += `&target_caption=${encodeURIComponent(options.targetCaption)}`;
       if (options.targetDeepDive) fetchUrl += `&target_deep_dive=${encodeURIComponent(options.targetDeepDive)}`;
       if (options.skipTts) fetchUrl += `&skip_tts=true`;
+      if (options.forceRefresh) fetchUrl += `&force_refresh=true`;
+      if (options.video) {
+        fetchUrl += `&media_type=video`;
+        if (options.video.durationMs && options.video.durationMs > 0) {
+          fetchUrl += `&video_duration_ms=${Math.round(options.video.durationMs)}`;
+        }
+      }
+      if (options.voiceNoteS3Key) fetchUrl += `&voice_note_s3_key=${encodeURIComponent(options.voiceNoteS3Key)}`;
+      if (user?.uid) fetchUrl += `&companion_id=${encodeURIComponent(user.uid)}`;
       const resolvedCaptionVoice = options.captionVoice ?? captionVoice;
       const resolvedDeepDiveVoice = options.deepDiveVoice ?? deepDiveVoice;
       if (resolvedCaptionVoice) fetchUrl += `&caption_voice=${encodeURIComponent(resolvedCaptionVoice)}`;
@@ -1064,6 +1099,15 @@ export default function CreationModal({
 
       // Parse JSON response
       const aiResponse = await parseJsonRecord(response);
+      const moderation = aiResponse?.moderation as { verdict?: string } | undefined;
+      if (moderation?.verdict === 'quarantine') {
+        // Server screening withheld the caption; nothing was spoken or cached.
+        debugLog('🛡️ AI caption withheld by content screening');
+        if (!options.silent) {
+          Alert.alert('Caption Unavailable', "This photo can't be described automatically. You can still write your own caption.");
+        }
+        return null;
+      }
       const shortCaptionValue = asOptionalString(aiResponse?.short_caption);
       const deepDiveValue = asOptionalString(aiResponse?.deep_dive);
 
@@ -1076,6 +1120,11 @@ export default function CreationModal({
           audio_s3_key: asOptionalString(aiResponse?.audio_s3_key),
           deep_dive_audio_s3_key: asOptionalString(aiResponse?.deep_dive_audio_s3_key),
           staging_event_id: asOptionalString(aiResponse?.staging_event_id),
+          prompt_version: asOptionalString(aiResponse?.prompt_version),
+          language: asOptionalString(aiResponse?.language),
+          second_language: asOptionalString(aiResponse?.second_language),
+          second_short_caption: asOptionalString(aiResponse?.second_short_caption),
+          second_deep_dive: asOptionalString(aiResponse?.second_deep_dive),
         };
         setShortCaption(result.short_caption ?? '');
         setDeepDive(result.deep_dive ?? '');
@@ -1087,6 +1136,21 @@ export default function CreationModal({
           setStagingEventId(result.staging_event_id);
           stagingEventIdRef.current = result.staging_event_id;
         }
+        if (result.prompt_version) {
+          aiPromptVersionRef.current = result.prompt_version;
+        }
+        aiLanguageMetadataRef.current = result.language
+          ? {
+              caption_language: result.language,
+              ...(result.second_language && result.second_short_caption
+                ? {
+                    second_language: result.second_language,
+                    second_short_caption: result.second_short_caption,
+                    ...(result.second_deep_dive ? { second_deep_dive: result.second_deep_dive } : {}),
+                  }
+                : {}),
+            }
+          : null;
 
         if (!options.silent) {
           // PROTECTION: Only update the description if the current one is empty
@@ -1392,6 +1456,8 @@ export default function CreationModal({
         setDeepDive('');
         setStagingEventId(null);
         stagingEventIdRef.current = null;
+        aiPromptVersionRef.current = null;
+        aiLanguageMetadataRef.current = null;
         setAudioUri(null);
         setAiAudioS3Key(null);
         setAiDeepDiveS3Key(null);
@@ -1467,7 +1533,8 @@ export default function CreationModal({
           silent: true,
           targetCaption: finalCaption,
           targetDeepDive: finalDeepDive || undefined,
-          skipTts: !!activeAudioUri
+          skipTts: !!activeAudioUri,
+          voiceNoteUri: activeAudioUri ?? undefined,
         });
 
         if (aiResult) {
@@ -1716,6 +1783,7 @@ export default function CreationModal({
       // 7. Build metadata for Firestore (not uploaded to S3)
       const contentType: NonNullable<EventMetadata['content_type']> =
         mediaType === 'video' ? 'video' : hasAudio ? 'audio' : 'text';
+      const audioOrigin: NonNullable<EventMetadata['audio_source']> = activeAudioUri ? 'companion' : 'tts';
       const resolvedLib = resolveLibrarySource(librarySourceKind, imageSourceType);
       const libSearchStored = (librarySearchTerm?.trim() || searchQueryContext?.trim()) || undefined;
       const libIdStored = libraryId?.trim() || undefined;
@@ -1732,6 +1800,8 @@ export default function CreationModal({
         ...(user?.uid ? { sender_id: user.uid } : {}),
         ...(finalCaption ? { short_caption: finalCaption } : {}),
         ...(finalDeepDive?.trim() ? { deep_dive: finalDeepDive } : {}),
+        ...(aiPromptVersionRef.current ? { prompt_version: aiPromptVersionRef.current } : {}),
+        ...(aiLanguageMetadataRef.current ?? {}),
         companion_in_reflection: isCompanionInReflection,
         explorer_in_reflection: isExplorerInReflection,
         is_companion_present: isCompanionInReflection,
@@ -1744,6 +1814,7 @@ export default function CreationModal({
         ...(searchQueryContext?.trim() ? { search_query: searchQueryContext.trim() } : {}),
         ...(searchCanonicalName?.trim() ? { search_canonical_name: searchCanonicalName.trim() } : {}),
         ...(lastEditedAtIso ? { last_edited_at: lastEditedAtIso } : {}),
+        ...(hasAudio ? { audio_source: audioOrigin } : {}),
       };
 
       let vmMeta = composerVideoMetaRef.current;
@@ -1797,7 +1868,10 @@ export default function CreationModal({
         try {
           const delParams = new URLSearchParams({ path: 'to', explorer_id: currentExplorerId });
           delParams.set('extra_keys', JSON.stringify(staleVideoKeys));
-          const delRes = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${delParams.toString()}`, { method: 'DELETE' });
+          const delRes = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${delParams.toString()}`, {
+            method: 'DELETE',
+            headers: await getAuthHeaders(),
+          });
           if (!delRes.ok) console.warn('Stale video cleanup failed:', delRes.status);
         } catch (e) {
           console.warn('Stale video cleanup failed (non-blocking):', e);
@@ -1848,7 +1922,10 @@ export default function CreationModal({
           if (stagingTtsKeysToDelete.length > 0) {
             deleteParams.set('extra_keys', JSON.stringify(stagingTtsKeysToDelete));
           }
-          const deleteRes = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${deleteParams.toString()}`, { method: 'DELETE' });
+          const deleteRes = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${deleteParams.toString()}`, {
+            method: 'DELETE',
+            headers: await getAuthHeaders(),
+          });
           if (!deleteRes.ok) {
             const body = await deleteRes.text();
             console.warn('Staging cleanup request failed:', deleteRes.status, body);
@@ -1876,7 +1953,6 @@ export default function CreationModal({
         event_id: eventID,
         sender: companionName || 'Companion',
         sender_id: user?.uid || undefined,
-        status: 'ready',
         timestamp: serverTimestamp(),
         type: 'mirror_event',
         metadata: eventMetadata,
@@ -1886,6 +1962,8 @@ export default function CreationModal({
         firestorePayload.narration_event_id = narrationEventId;
       }
       if (!startedAsEditBundle) {
+        // Withheld from the Explorer until the server releases it (firestore.rules require this).
+        firestorePayload.status = await initialReflectionStatus(currentExplorerId);
         firestorePayload.engagement_count = 0;
         firestorePayload.likedBy = [];
         firestorePayload.respondedRelationshipIds = [];
@@ -1924,6 +2002,8 @@ export default function CreationModal({
       setDeepDive('');
       setStagingEventId(null);
       stagingEventIdRef.current = null;
+      aiPromptVersionRef.current = null;
+      aiLanguageMetadataRef.current = null;
       setAudioUri(null);
       setAiAudioS3Key(null);
       setAiDeepDiveS3Key(null);
@@ -1983,7 +2063,10 @@ export default function CreationModal({
       const params = new URLSearchParams({ path: 'staging', explorer_id: currentExplorerId });
       if (stagingId) params.set('event_id', stagingId);
       if (ttsKeys.length > 0) params.set('extra_keys', JSON.stringify(ttsKeys));
-      const res = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${params.toString()}`, { method: 'DELETE' });
+      const res = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${params.toString()}`, {
+        method: 'DELETE',
+        headers: await getAuthHeaders(),
+      });
       if (!res.ok) console.warn('Staging cleanup request failed:', res.status, await res.text());
     } catch (err) {
       console.warn('Staging cleanup failed:', err);
@@ -2010,6 +2093,8 @@ export default function CreationModal({
     setIsAiThinking(false);
     setStagingEventId(null);
     stagingEventIdRef.current = null;
+    aiPromptVersionRef.current = null;
+    aiLanguageMetadataRef.current = null;
     setAudioUri(null);
     setAiAudioS3Key(null);
     setAiDeepDiveS3Key(null);
@@ -2051,6 +2136,8 @@ export default function CreationModal({
     setIsAiThinking(false);
     setStagingEventId(null);
     stagingEventIdRef.current = null;
+    aiPromptVersionRef.current = null;
+    aiLanguageMetadataRef.current = null;
     setAudioUri(null);
     setAiAudioS3Key(null);
     setAiDeepDiveS3Key(null);
@@ -2073,6 +2160,71 @@ export default function CreationModal({
     composerVideoMetaRef.current = null;
   };
 
+  /**
+   * Best-effort upload of staging/{id}/frame_{offsetMs}.jpg stills spread across the trimmed clip.
+   * Missing frames are fine: the server falls back to the thumbnail.
+   */
+  const uploadCaptionKeyframes = async (
+    stagingId: string,
+    sourceVideoUri: string,
+    vm: ComposerVideoMeta
+  ) => {
+    const spanMs = Math.min(vm.video_end_ms - vm.video_start_ms, AI_CAPTION_MAX_VIDEO_MS);
+    if (!currentExplorerId || !(spanMs > 0)) return;
+    const offsets = Array.from({ length: AI_CAPTION_KEYFRAME_COUNT }, (_, i) =>
+      Math.round((spanMs * (i + 0.5)) / AI_CAPTION_KEYFRAME_COUNT)
+    );
+    await Promise.all(
+      offsets.map(async (offsetMs) => {
+        let frameUri: string | null = null;
+        let uploadUri: string | null = null;
+        try {
+          frameUri = (
+            await VideoThumbnails.getThumbnailAsync(sourceVideoUri, {
+              time: vm.video_start_ms + offsetMs,
+              quality: 0.5,
+            })
+          ).uri;
+          uploadUri = await prepareImageForUpload(frameUri);
+          const res = await fetch(
+            `${API_ENDPOINTS.GET_S3_URL}?path=staging&event_id=${stagingId}&filename=frame_${offsetMs}.jpg&explorer_id=${currentExplorerId}`
+          );
+          if (!res.ok) throw new Error(`upload URL request failed: ${res.status}`);
+          const uploadUrl = asOptionalString((await parseJsonRecord(res))?.url);
+          if (!uploadUrl) throw new Error('upload URL was missing');
+          await safeUploadToS3(uploadUri, uploadUrl);
+        } catch (error) {
+          console.warn(`[generateDeepDiveBackground] keyframe at ${offsetMs}ms skipped`, error);
+        } finally {
+          for (const uri of new Set([frameUri, uploadUri])) {
+            safeDeleteCacheFile(uri).catch(() => { });
+          }
+        }
+      })
+    );
+  };
+
+  /**
+   * Best-effort upload of the Companion's local recording to staging/{id}/voice_note.m4a.
+   * Returns the S3 key, or undefined when there is nothing to send.
+   */
+  const uploadCaptionVoiceNote = async (stagingId: string, voiceNoteUri?: string) => {
+    if (!currentExplorerId || !voiceNoteUri?.startsWith('file')) return undefined;
+    try {
+      const res = await fetch(
+        `${API_ENDPOINTS.GET_S3_URL}?path=staging&event_id=${stagingId}&filename=voice_note.m4a&explorer_id=${currentExplorerId}`
+      );
+      if (!res.ok) throw new Error(`upload URL request failed: ${res.status}`);
+      const uploadUrl = asOptionalString((await parseJsonRecord(res))?.url);
+      if (!uploadUrl) throw new Error('upload URL was missing');
+      await safeUploadToS3(voiceNoteUri, uploadUrl);
+      return `staging/${stagingId}/voice_note.m4a`;
+    } catch (error) {
+      console.warn('[generateDeepDiveBackground] voice note skipped', error);
+      return undefined;
+    }
+  };
+
   const generateDeepDiveBackground = async (
     options: {
       silent?: boolean;
@@ -2081,6 +2233,9 @@ export default function CreationModal({
       skipTts?: boolean;
       captionVoice?: string;
       deepDiveVoice?: string;
+      forceRefresh?: boolean;
+      /** Local Companion recording to use as caption context; defaults to the recorded audio. */
+      voiceNoteUri?: string;
     } = { silent: true }
   ): Promise<AiDescriptionResponse | null> => {
     const currentPhotoUri = asOptionalString(photo?.uri);
@@ -2157,6 +2312,11 @@ export default function CreationModal({
             await FileSystem.deleteAsync(uriToUpload, { idempotent: true });
           } catch (cleanupError) { }
         }
+
+        // Keyframes let the caption follow the whole clip, not just the poster frame
+        if (mediaType === 'video' && videoUri && composerVideoMetaRef.current) {
+          await uploadCaptionKeyframes(stagingId, videoUri, composerVideoMetaRef.current);
+        }
       }
 
       // Get presigned GET URL for staging image
@@ -2165,7 +2325,17 @@ export default function CreationModal({
         const getStagingJson = await parseJsonRecord(getStagingUrlResponse);
         const getStagingUrl = asOptionalString(getStagingJson?.url);
         if (!getStagingUrl) return { _stagingId: stagingId };
-        const aiResult = await getAIDescription(getStagingUrl, options);
+        const vmCaption = composerVideoMetaRef.current;
+        const { voiceNoteUri, ...descriptionOptions } = options;
+        const voiceNoteS3Key = await uploadCaptionVoiceNote(stagingId, voiceNoteUri ?? audioUri ?? undefined);
+        const aiResult = await getAIDescription(getStagingUrl, {
+          ...descriptionOptions,
+          voiceNoteS3Key,
+          video:
+            mediaType === 'video'
+              ? { durationMs: vmCaption ? vmCaption.video_end_ms - vmCaption.video_start_ms : undefined }
+              : undefined,
+        });
         // Always return _stagingId so caller can delete staging even when AI fails
         return aiResult ? { ...aiResult, _stagingId: stagingId } : { _stagingId: stagingId };
       }
@@ -2196,6 +2366,7 @@ export default function CreationModal({
           params.set('extra_keys', JSON.stringify(ttsKeys));
           const res = await fetch(`${API_ENDPOINTS.DELETE_MIRROR_EVENT}?${params.toString()}`, {
             method: 'DELETE',
+            headers: await getAuthHeaders(),
           });
           if (!res.ok) console.warn('TTS staging cleanup failed:', res.status);
         } catch (e) {
@@ -2222,6 +2393,8 @@ export default function CreationModal({
     setDeepDive('');
     setStagingEventId(null);
     stagingEventIdRef.current = null;
+    aiPromptVersionRef.current = null;
+    aiLanguageMetadataRef.current = null;
     setAiAudioUrl(null);
     setAiDeepDiveAudioUrl(null);
     setAiAudioS3Key(null);
@@ -2267,6 +2440,8 @@ export default function CreationModal({
     setIsAiThinking(false);
     setStagingEventId(null);
     stagingEventIdRef.current = null;
+    aiPromptVersionRef.current = null;
+    aiLanguageMetadataRef.current = null;
     setAudioUri(null);
     lastProcessedUriRef.current = audioRecorder.uri ?? lastProcessedUriRef.current;
     setLibraryId('');
@@ -2642,6 +2817,8 @@ export default function CreationModal({
                   if (!options.preserveStaging) {
                     setStagingEventId(null);
                     stagingEventIdRef.current = null;
+                    aiPromptVersionRef.current = null;
+                    aiLanguageMetadataRef.current = null;
                   }
                   await generateDeepDiveBackground({
                     silent: false,
@@ -2649,6 +2826,7 @@ export default function CreationModal({
                     targetDeepDive: options.targetDeepDive,
                     captionVoice: options.captionVoice,
                     deepDiveVoice: options.deepDiveVoice,
+                    forceRefresh: options.forceRefresh,
                   });
                 }}
                 onSend={(data) => {
@@ -469,6 +469,7 @@ export function ReactionSheet({
         companionName: activeRelationship.companionName || 'Companion',
         role: activeRelationship.role ?? null,
         isCaregiver: activeRelationship.role === 'caregiver',
+        pendingDeletion: false,
         avatarUrl: resolvedCompanionAvatarUrl,
         avatarS3Key: activeRelationship.companionAvatarS3Key ?? null,
         color: getAvatarColor(user.uid),
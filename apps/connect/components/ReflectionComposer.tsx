@@ -79,6 +79,8 @@ export type TriggerMagicOptions = {
   preserveStaging?: boolean;
   captionVoice?: string;
   deepDiveVoice?: string;
+  /** Skip the server caption cache so a repeat Sparkle drafts new text. */
+  forceRefresh?: boolean;
 };
 
 export type ComposerSendPayload = {
@@ -571,10 +573,13 @@ function ReflectionComposerInner({
         // the media/context. Omitting targetDeepDive makes the backend regenerate
         // the deep dive while echoing the caption verbatim for the voice-over.
         options.targetCaption = caption.trim() || undefined;
+      } else if (hasAnyAiArtifacts) {
+        // Sparkle again on the same media is an explicit ask for new text.
+        options.forceRefresh = true;
       }
       return options;
     },
-    [caption, aiArtifacts?.deepDive, captionVoice, deepDiveVoice],
+    [caption, aiArtifacts?.deepDive, captionVoice, deepDiveVoice, hasAnyAiArtifacts],
   );
 
   const handleVoicePick = useCallback(
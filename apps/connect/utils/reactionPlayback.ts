@@ -1,4 +1,4 @@
-import { API_ENDPOINTS, Event, EventMetadata, ExplorerConfig, getAvatarColor, getAvatarInitial, type ReactionType } from '@projectmirror/shared';
+import { API_ENDPOINTS, Event, EventMetadata, ExplorerConfig, getAuthHeaders, getAvatarColor, getAvatarInitial, type ReactionType } from '@projectmirror/shared';
 import type { CompanionAvatar } from '@projectmirror/shared';
 import {
   arrayRemove,
@@ -655,7 +655,7 @@ export async function deleteReflectionDocument(
 ): Promise<void> {
   const deleteRes = await fetch(
     `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${eventId}&explorer_id=${explorerId}&path=to`,
-    { method: 'DELETE' },
+    { method: 'DELETE', headers: await getAuthHeaders() },
   );
   if (!deleteRes.ok) {
     const errData = await deleteRes.json().catch(() => null);
@@ -7,7 +7,7 @@ import {
 import { formatTypedReactionSpeechText } from '@/utils/reactionPlayback';
 import { loadVoicePreferences } from '@/utils/ttsVoices';
 import { diagnosticsAppLog } from '@/utils/diagnosticsLog';
-import { API_ENDPOINTS, EventMetadata, ExplorerConfig, type ReactionType } from '@projectmirror/shared';
+import { API_ENDPOINTS, EventMetadata, ExplorerConfig, initialReflectionStatus, type ReactionType } from '@projectmirror/shared';
 import {
   arrayUnion,
   collection,
@@ -301,7 +301,8 @@ export async function uploadReaction({
     event_id: eventID,
     sender: senderName || 'Companion',
     sender_id: senderId,
-    status: 'ready',
+    // Withheld from the Explorer until the server releases it (firestore.rules require this).
+    status: await initialReflectionStatus(explorerId),
     timestamp: serverTimestamp(),
     type: 'mirror_event',
     metadata: eventMetadata,
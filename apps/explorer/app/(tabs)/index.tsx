@@ -8,6 +8,7 @@ import { FontAwesome } from '@expo/vector-icons';
 import {
   API_ENDPOINTS,
   coerceThumbnailTimeMs,
+  getAuthHeaders,
   Event,
   EventMetadata,
   ExplorerConfig,
@@ -64,6 +65,9 @@ const coerceLikedBy = (value: unknown): string[] =>
 
 const coerceIsReaction = (value: unknown): boolean => value === true;
 
+/** Statuses the server sets on Reflections the Explorer must not see yet (screening / moderation / caregiver review). */
+const WITHHELD_REFLECTION_STATUSES = new Set(['screening', 'quarantined', 'pending_review', 'rejected']);
+
 /** Coerce Firestore `metadata` field (plain JSON / Timestamp) into EventMetadata. */
 function normalizeFirestoreMetadata(raw: unknown, fallbackEventId: string): EventMetadata | null {
   try {
@@ -218,6 +222,8 @@ export default function HomeScreen() {
   const [sessionArrivalTick, setSessionArrivalTick] = useState(0);
   const listFetchCompletedRef = useRef(false);
   const firestoreInitialReadyRef = useRef(false);
+  /** Reflection ids withheld in the last snapshot; a release or approval moves one back into view. */
+  const withheldIdsRef = useRef<Set<string>>(new Set());
   // Declared early — fetchEvents / Firestore listener write these before the later sync effect.
   const eventsRef = useRef(events);
   const selectedEventRef = useRef(selectedEvent);
@@ -311,9 +317,31 @@ export default function HomeScreen() {
     });
   }, [companions, explorerDisplayName, gridLikeFacesLikedBy]);
 
+  // Companions with a scheduled account deletion; their Reflections stay hidden for the
+  // whole grace period, including ones already on screen (ListMirrorEvents drops them too).
+  const pendingDeletionSenderIds = useMemo(
+    () => new Set(companions.filter((c) => c.pendingDeletion).map((c) => c.userId)),
+    [companions]
+  );
+  const pendingDeletionSenderIdsRef = useRef(pendingDeletionSenderIds);
+  useEffect(() => {
+    const previous = pendingDeletionSenderIdsRef.current;
+    pendingDeletionSenderIdsRef.current = pendingDeletionSenderIds;
+    // A cancelled deletion brings the Companion's Reflections back; the list fetched while
+    // they were hidden does not include them.
+    if ([...previous].some((id) => !pendingDeletionSenderIds.has(id))) {
+      fetchEventsRef.current();
+    }
+  }, [pendingDeletionSenderIds]);
+
   const nonReactionEvents = useMemo(
-    () => events.filter((e) => !reactionEventIds.has(e.event_id)),
-    [events, reactionEventIds]
+    () =>
+      events.filter((e) => {
+        if (reactionEventIds.has(e.event_id)) return false;
+        const senderId = eventMetadata[e.event_id]?.sender_id;
+        return !senderId || !pendingDeletionSenderIds.has(senderId);
+      }),
+    [events, reactionEventIds, eventMetadata, pendingDeletionSenderIds]
   );
 
   const filteredEvents = useMemo(() => {
@@ -335,6 +363,7 @@ export default function HomeScreen() {
     if (reactionSignals.length === 0) return map;
     const eventsById = new Map(events.map((e) => [e.event_id, e]));
     for (const signal of reactionSignals) {
+      if (signal.senderId && pendingDeletionSenderIds.has(signal.senderId)) continue;
       const reactionEvent = eventsById.get(signal.eventId);
       if (!reactionEvent) continue;
       const existing = map.get(signal.parentReflectionId) ?? [];
@@ -364,7 +393,7 @@ export default function HomeScreen() {
       map.set(key, list);
     }
     return map;
-  }, [reactionSignals, events]);
+  }, [reactionSignals, events, pendingDeletionSenderIds]);
 
   // Derive "new" from the live list: session arrivals / post-baseline reflections that are
   // still unread, have a poster, and aren't currently on the main stage. Pill shows N and
@@ -770,9 +799,15 @@ export default function HomeScreen() {
         const likesFromFirestore: Record<string, string[]> = {};
         const reactionIdsFromSnapshot = new Set<string>();
         const reactionSignalsFromSnapshot: ReactionSignal[] = [];
+        /** Screening, quarantined or awaiting caregiver review; skipped entirely until released. */
+        const withheldIds = new Set<string>();
         for (const docSnap of snapshot.docs) {
           const id = docSnap.id;
           const data = docSnap.data();
+          if (typeof data?.status === 'string' && WITHHELD_REFLECTION_STATUSES.has(data.status)) {
+            withheldIds.add(id);
+            continue;
+          }
           const meta = normalizeFirestoreMetadata(data?.metadata, id);
           if (meta) metadataFromFirestore[id] = meta;
           likesFromFirestore[id] = coerceLikedBy(data?.likedBy);
@@ -823,6 +858,8 @@ export default function HomeScreen() {
         setFirestoreSignalsReady(true);
         firestoreInitialReadyRef.current = true;
         trySealSessionBaseline('firestore-ready');
+        const previouslyWithheldIds = withheldIdsRef.current;
+        withheldIdsRef.current = withheldIds;
 
         if (isInitialLoad) {
           isInitialLoad = false;
@@ -835,7 +872,16 @@ export default function HomeScreen() {
         /** Ids that got non-null Firestore metadata this batch (for list API merge). Reuse snapshot map — no second normalize. */
         const firestoreMetadataById: Record<string, EventMetadata> = {};
         snapshot.docChanges().forEach((change) => {
-          if (change.type === 'added') {
+          if (change.type !== 'removed' && withheldIds.has(change.doc.id)) {
+            // New Reflections are withheld until screened or reviewed; treat them as removed.
+            removedReflectionIds.push(change.doc.id);
+          } else if (change.type === 'modified' && previouslyWithheldIds.has(change.doc.id)) {
+            // Released by screening or approved by a caregiver: it arrives now.
+            const id = change.doc.id;
+            newReflectionIds.push(id);
+            const m = metadataFromFirestore[id];
+            if (m) firestoreMetadataById[id] = m;
+          } else if (change.type === 'added') {
             const id = change.doc.id;
             newReflectionIds.push(id);
             const m = metadataFromFirestore[id];
@@ -1401,9 +1447,13 @@ export default function HomeScreen() {
 
   const deleteEvent = async (event: Event) => {
     try {
+      // ID token identifies the caller in the server-side audit log.
+      const authHeaders = await getAuthHeaders();
+
       // 1. Delete S3 objects
       const deleteResponse = await fetch(
-        `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${event.event_id}&explorer_id=${currentExplorerId}`
+        `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${event.event_id}&explorer_id=${currentExplorerId}`,
+        { method: 'DELETE', headers: authHeaders }
       );
 
       if (!deleteResponse.ok) {
@@ -1421,7 +1471,7 @@ export default function HomeScreen() {
           if (responseEventId) {
             await fetch(
               `${API_ENDPOINTS.DELETE_MIRROR_EVENT}?event_id=${responseEventId}&path=from&explorer_id=${currentExplorerId}`,
-              { method: 'DELETE' }
+              { method: 'DELETE', headers: authHeaders }
             ).catch(() => {});
           }
           await deleteDoc(responseRef);
@@ -627,11 +627,13 @@ export default function MainStageView({
     const mediaKind = getLikeFeedbackMediaKind(!!selectedEvent?.video_url);
     const phrase = buildLikeFeedbackPhrase(selectedMetadata?.sender, mediaKind);
     void playLikeFeedbackAudio(phrase, {
+      explorerId,
       onBeforePlay: () => pauseForLikeFeedbackRef.current(),
       onAfterPlay: () => resumeAfterLikeFeedbackRef.current(),
     });
   }, [
     currentUserId,
+    explorerId,
     likedByCurrentUser,
     onToggleLike,
     selectedEvent?.event_id,
@@ -11,6 +11,8 @@ export const LIKE_FEEDBACK_CACHE_PATH = `${FileSystem.cacheDirectory}like-feedba
 
 export type PlayLikeFeedbackAudioOptions = {
   voice?: string;
+  /** Attributes the synthesized characters to this circle's AI usage and budget. */
+  explorerId?: string | null;
   /** Duck video volume and pause narration/companion audio before like TTS. Video keeps playing. */
   onBeforePlay?: () => void | Promise<void>;
   /** Restore video volume and resume paused narration after like TTS finishes (or fails). */
@@ -75,7 +77,7 @@ export async function playLikeFeedbackAudio(
   text: string,
   options: PlayLikeFeedbackAudioOptions = {}
 ): Promise<void> {
-  const { voice = DEFAULT_LIKE_FEEDBACK_VOICE, onBeforePlay, onAfterPlay } = options;
+  const { voice = DEFAULT_LIKE_FEEDBACK_VOICE, explorerId, onBeforePlay, onAfterPlay } = options;
   await clearPendingLikeAfterPlay();
 
   if (activeLikeSound) {
@@ -100,7 +102,7 @@ export async function playLikeFeedbackAudio(
     const res = await fetch(API_ENDPOINTS.SYNTHESIZE_SPEECH, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
-      body: JSON.stringify({ text, voice }),
+      body: JSON.stringify({ text, voice, explorer_id: explorerId ?? undefined }),
     });
     if (!res.ok || requestId !== likeAudioRequestId) {
       await finishLikeFeedbackPlayback(requestId, onAfterPlay);
@@ -3,20 +3,26 @@ package functions
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"cloud.google.com/go/compute/metadata"
 	"cloud.google.com/go/firestore"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"firebase.google.com/go/v4/auth"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const firestoreBatchLimit = 500
 
+var errCompanionDeletionNotScheduled = errors.New("no scheduled deletion to cancel")
+
 // reflectionEntry holds the Firestore doc ref plus the S3-relevant fields
 // extracted at discovery time so we avoid a second round-trip per document.
 type reflectionEntry struct {
@@ -93,154 +99,834 @@ func commitBatches(ctx context.Context, fsClient *firestore.Client, refs []*fire
 	return nil
 }
 
-// CleanupCompanionData purges all data owned by a companion before their
-// Firebase Auth record is deleted. It performs three sequential phases:
-//
-//  1. Discover every Reflection document sent by userID.
-//  2. Delete the corresponding S3 media objects.
-//  3. Atomically delete (in chunked batches) all Reflection docs, all
-//     relationship docs, and the user's profile document from Firestore.
-//
-// Any failure returns a descriptive error so the caller knows not to proceed
-// with the Auth deletion.
-func CleanupCompanionData(ctx context.Context, userID string) error {
-	// --- AWS / S3 client -----------------------------------------------
-	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
-	if err != nil {
-		return fmt.Errorf("CleanupCompanionData: AWS config: %w", err)
+const (
+	deletionJobKindCompanion = "companion"
+
+	companionPhaseReflections            = "reflections"
+	companionPhaseLegacyReflections      = "legacy_reflections"
+	companionPhaseLikes                  = "likes"
+	companionPhaseRespondedCompanions    = "responded_companions"
+	companionPhaseRespondedRelationships = "responded_relationships"
+	companionPhaseNotificationsSent      = "notifications_sent"
+	companionPhaseNotificationsLiked     = "notifications_liked"
+	companionPhaseNotificationsReceived  = "notifications_received"
+	companionPhaseNotificationsProcessed = "notifications_processed"
+	companionPhaseSpeechCache            = "speech_cache"
+	companionPhaseMedia                  = "media"
+	companionPhaseRelationships          = "relationships"
+	companionPhaseUser                   = "user"
+	companionPhaseVerify                 = "verify"
+	companionPhaseAuthUser               = "auth_user"
+
+	responsesCollection = "responses"
+
+	// archivedSenderID replaces sender_id on Reflections kept after their
+	// companion's account is deleted; it never matches a Firebase UID.
+	archivedSenderID          = "archived_companion"
+	defaultArchivedSenderName = "In memoriam"
+
+	companionDeletionModeImmediate = "immediate"
+	companionDeletionModeScheduled = "scheduled"
+	defaultCompanionDeletionGrace  = 7 * 24 * time.Hour
+
+	// firestoreInLimit is the maximum number of values in an "in" or
+	// "array-contains-any" filter.
+	firestoreInLimit = 30
+)
+
+// companionDeletionPhases covers every place a companion's UID can appear.
+// Relationship-derived phases (responded_relationships, media) run before the
+// relationship docs themselves are deleted. The Firebase Auth account goes
+// last, once verify has passed, so a failed purge can still be retried by
+// the companion.
+var companionDeletionPhases = []string{
+	companionPhaseReflections,
+	companionPhaseLegacyReflections,
+	companionPhaseLikes,
+	companionPhaseRespondedCompanions,
+	companionPhaseRespondedRelationships,
+	companionPhaseNotificationsSent,
+	companionPhaseNotificationsLiked,
+	companionPhaseNotificationsReceived,
+	companionPhaseNotificationsProcessed,
+	companionPhaseSpeechCache,
+	companionPhaseMedia,
+	companionPhaseRelationships,
+	companionPhaseUser,
+	companionPhaseVerify,
+	companionPhaseAuthUser,
+	deletionPhaseDone,
+}
+
+// companionDeletionOptions are the caller's choices for a companion deletion;
+// the zero value deletes everything immediately.
+type companionDeletionOptions struct {
+	// PurgeAt, when set, schedules the job instead of starting it;
+	// ResumeDeletionJobs releases it once that time passes.
+	PurgeAt time.Time
+	// KeepReflections hands the companion's Reflections to an archived
+	// sender instead of deleting them. Media stays in S3; profile data,
+	// likes, avatars and relationships are still removed.
+	KeepReflections    bool
+	ArchivedSenderName string
+}
+
+// startCompanionDeletionJob records deletion_jobs/{userID}. The companion's
+// relationships are snapshotted on creation because later phases need their
+// IDs and Explorer IDs after the relationship docs are gone.
+func startCompanionDeletionJob(ctx context.Context, client *firestore.Client, userID string, opts companionDeletionOptions) (*deletionJob, error) {
+	seed := deletionJob{
+		UserID:             userID,
+		Kind:               deletionJobKindCompanion,
+		KeepReflections:    opts.KeepReflections,
+		ArchivedSenderName: strings.TrimSpace(opts.ArchivedSenderName),
+	}
+	if !opts.PurgeAt.IsZero() {
+		seed.Status = deletionStatusScheduled
+		seed.NotBefore = opts.PurgeAt
+	}
+	seenExplorers := map[string]struct{}{}
+	iter := client.Collection(relationshipsCollection).Where("userId", "==", userID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("relationships query: %w", err)
+		}
+		seed.RelationshipIDs = append(seed.RelationshipIDs, doc.Ref.ID)
+		explorerID, _ := doc.Data()["explorerId"].(string)
+		if _, seen := seenExplorers[explorerID]; explorerID != "" && !seen {
+			seenExplorers[explorerID] = struct{}{}
+			seed.ExplorerIDs = append(seed.ExplorerIDs, explorerID)
+		}
+	}
+	return createOrResumeDeletionJob(ctx, client, userID, seed)
+}
+
+// companionDeletionGrace reads COMPANION_DELETION_GRACE_HOURS, the window in
+// which a scheduled account deletion can still be cancelled.
+func companionDeletionGrace() time.Duration {
+	if raw := strings.TrimSpace(os.Getenv("COMPANION_DELETION_GRACE_HOURS")); raw != "" {
+		if hours, err := strconv.Atoi(raw); err == nil && hours > 0 {
+			return time.Duration(hours) * time.Hour
+		}
+		fmt.Printf("companionDeletionGrace: ignoring invalid COMPANION_DELETION_GRACE_HOURS %q\n", raw)
 	}
-	s3Client := s3.NewFromConfig(awsCfg)
+	return defaultCompanionDeletionGrace
+}
 
-	// --- Firestore client ---------------------------------------------
-	// Resolve project ID: env vars first (local/CI), then GCP metadata server
-	// (always available on Cloud Functions Gen2 / Cloud Run at runtime).
-	projectID := os.Getenv("GCP_PROJECT")
-	if projectID == "" {
-		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+// setCompanionPendingDeletion stamps (or, with a zero purgeAt, clears)
+// pendingDeletionAt on users/{userID} and each of the companion's
+// relationships. The relationship stamp is what hides their Reflections from
+// the Explorer; see hiddenReflectionIDs.
+func setCompanionPendingDeletion(ctx context.Context, client *firestore.Client, job *deletionJob, purgeAt time.Time) error {
+	var value any = purgeAt
+	if purgeAt.IsZero() {
+		value = firestore.Delete
 	}
-	if projectID == "" {
-		projectID, err = metadata.ProjectID()
+	batch := client.Batch()
+	batch.Set(client.Collection("users").Doc(job.UserID), map[string]any{"pendingDeletionAt": value}, firestore.Merge([]string{"pendingDeletionAt"}))
+	for _, relationshipID := range job.RelationshipIDs {
+		batch.Set(client.Collection(relationshipsCollection).Doc(relationshipID), map[string]any{"pendingDeletionAt": value}, firestore.Merge([]string{"pendingDeletionAt"}))
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("set pendingDeletionAt for %s: %w", job.UserID, err)
+	}
+	return nil
+}
+
+// cancelCompanionDeletion undoes a scheduled deletion that has not started,
+// making the companion's Reflections visible again.
+func cancelCompanionDeletion(ctx context.Context, client *firestore.Client, userID string) (*deletionJob, error) {
+	ref := client.Collection(deletionJobsCollection).Doc(userID)
+	var job deletionJob
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
 		if err != nil {
-			return fmt.Errorf("CleanupCompanionData: could not determine GCP project ID: %w", err)
+			return err
 		}
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status != deletionStatusScheduled {
+			return errCompanionDeletionNotScheduled
+		}
+		job.Status = deletionStatusCancelled
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: job.Status},
+			{Path: "cancelledAt", Value: firestore.ServerTimestamp},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := setCompanionPendingDeletion(ctx, client, &job, time.Time{}); err != nil {
+		return nil, err
 	}
-	fsClient, err := firestore.NewClient(ctx, projectID)
+	return &job, nil
+}
+
+// hiddenReflectionIDs returns the IDs of an Explorer's Reflections that must
+// not reach the Explorer's feed: those whose sender has a scheduled account
+// deletion, for the whole grace period, and those withheld by screening,
+// moderation or review (see withheldReflectionStatuses).
+func hiddenReflectionIDs(ctx context.Context, client *firestore.Client, explorerID string) (map[string]struct{}, error) {
+	docs, err := client.Collection(relationshipsCollection).
+		Where("explorerId", "==", explorerID).
+		Where("pendingDeletionAt", "!=", nil).
+		Documents(ctx).
+		GetAll()
 	if err != nil {
-		return fmt.Errorf("CleanupCompanionData: Firestore client: %w", err)
+		return nil, fmt.Errorf("pending deletion relationships: %w", err)
+	}
+	hidden := map[string]struct{}{}
+	withheld, err := client.Collection(reflectionsCollection).
+		Where("explorerId", "==", explorerID).
+		Where("status", "in", withheldReflectionStatuses).
+		Select().
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("withheld reflections: %w", err)
+	}
+	for _, ref := range withheld {
+		hidden[ref.Ref.ID] = struct{}{}
 	}
-	defer fsClient.Close()
+	var senders []string
+	for _, doc := range docs {
+		if userID, _ := doc.Data()["userId"].(string); userID != "" {
+			senders = append(senders, userID)
+		}
+	}
+	for start := 0; start < len(senders); start += firestoreInLimit {
+		chunk := senders[start:min(start+firestoreInLimit, len(senders))]
+		for _, field := range []string{"sender_id", "metadata.sender_id"} {
+			refs, err := client.Collection(reflectionsCollection).
+				Where("explorerId", "==", explorerID).
+				Where(field, "in", chunk).
+				Select().
+				Documents(ctx).
+				GetAll()
+			if err != nil {
+				return nil, fmt.Errorf("hidden reflections (%s): %w", field, err)
+			}
+			for _, ref := range refs {
+				hidden[ref.Ref.ID] = struct{}{}
+			}
+		}
+	}
+	return hidden, nil
+}
 
-	// ------------------------------------------------------------------
-	// Phase 1 — Discover Reflection documents belonging to this companion.
-	// ------------------------------------------------------------------
-	seenIDs := map[string]struct{}{}
+// advanceCompanionDeletion runs one step of a companion deletion job.
+func advanceCompanionDeletion(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	userID := job.UserID
+	reflections := client.Collection(reflectionsCollection)
+	notifications := client.Collection(pendingNotificationsCollection)
 
-	// Primary query: root-level sender_id (all new + backfilled docs).
-	primary, err := collectReflections(
-		fsClient.Collection("reflections").Where("sender_id", "==", userID).Documents(ctx),
-		seenIDs,
-	)
-	if err != nil {
-		return fmt.Errorf("CleanupCompanionData: reflections query (sender_id): %w", err)
+	switch job.Phase {
+	case companionPhaseReflections:
+		if job.KeepReflections {
+			return archiveReflectionPage(ctx, client, job, "sender_id")
+		}
+		return deleteReflectionPage(ctx, client, s3Client, job, "sender_id")
+	case companionPhaseLegacyReflections:
+		// Older docs may only carry metadata.sender_id.
+		if job.KeepReflections {
+			return archiveReflectionPage(ctx, client, job, "metadata.sender_id")
+		}
+		return deleteReflectionPage(ctx, client, s3Client, job, "metadata.sender_id")
+	case companionPhaseLikes:
+		return scrubArrayPage(ctx, client, job, reflections.Where("likedBy", "array-contains", userID), "likedBy", userID)
+	case companionPhaseRespondedCompanions:
+		return scrubArrayPage(ctx, client, job, reflections.Where("respondedCompanionIds", "array-contains", userID), "respondedCompanionIds", userID)
+	case companionPhaseRespondedRelationships:
+		return scrubRespondedRelationships(ctx, client, job)
+	case companionPhaseNotificationsSent:
+		return deleteQueryPage(ctx, client, job, notifications.Where("senderId", "==", userID), "pending_notifications senderId", &job.Counts.Notifications)
+	case companionPhaseNotificationsLiked:
+		return deleteQueryPage(ctx, client, job, notifications.Where("likerId", "==", userID), "pending_notifications likerId", &job.Counts.Notifications)
+	case companionPhaseNotificationsReceived:
+		return scrubNotificationRecipientPage(ctx, client, job)
+	case companionPhaseNotificationsProcessed:
+		return scrubProcessedRecipientPage(ctx, client, job)
+	case companionPhaseSpeechCache:
+		return deleteSpeechCachePage(ctx, client, s3Client, job, client.Collection(speechCacheCollection).Where("companionId", "==", userID))
+	case companionPhaseMedia:
+		return deleteCompanionMedia(ctx, client, s3Client, job)
+	case companionPhaseRelationships:
+		return deleteQueryPage(ctx, client, job, client.Collection(relationshipsCollection).Where("userId", "==", userID), "relationships", &job.Counts.Relationships)
+	case companionPhaseUser:
+		if _, err := client.Collection("users").Doc(userID).Delete(ctx); err != nil {
+			return fmt.Errorf("delete users/%s: %w", userID, err)
+		}
+		job.Counts.Users = 1
+		job.advancePhase()
+		return nil
+	case companionPhaseVerify:
+		return verifyCompanionDeletion(ctx, client, s3Client, job)
+	case companionPhaseAuthUser:
+		if err := deleteAuthUser(ctx, userID); err != nil {
+			return err
+		}
+		job.advancePhase()
+		return nil
+	default:
+		return fmt.Errorf("unknown companion deletion phase %q", job.Phase)
 	}
+}
 
-	// Defensive fallback: older docs may only carry metadata.sender_id.
-	fallback, err := collectReflections(
-		fsClient.Collection("reflections").Where("metadata.sender_id", "==", userID).Documents(ctx),
-		seenIDs,
-	)
+// deleteAuthUser removes the companion's Firebase Auth account, so a purge
+// that finishes while the Connect app is closed does not leave the sign-in
+// behind. An account that is already gone counts as deleted.
+func deleteAuthUser(ctx context.Context, userID string) error {
+	authClient, err := getFirebaseAuth(ctx)
 	if err != nil {
-		return fmt.Errorf("CleanupCompanionData: reflections query (metadata.sender_id): %w", err)
+		return err
+	}
+	if err := authClient.DeleteUser(ctx, userID); err != nil && !auth.IsUserNotFound(err) {
+		return fmt.Errorf("delete auth user %s: %w", userID, err)
 	}
+	return nil
+}
 
-	reflections := append(primary, fallback...)
-	fmt.Printf("CleanupCompanionData: found %d reflection(s) for user %s\n", len(reflections), userID)
+// deleteReflectionPage deletes one page of the companion's Reflections along
+// with their reaction/narration children, their selfie responses, and the S3
+// media for all of them.
+func deleteReflectionPage(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob, senderField string) error {
+	q := pageAfterCursor(client.Collection(reflectionsCollection).Where(senderField, "==", job.UserID), job)
+	seenIDs := map[string]struct{}{}
+	parents, err := collectReflections(q.Documents(ctx), seenIDs)
+	if err != nil {
+		return fmt.Errorf("reflections query (%s): %w", senderField, err)
+	}
+	children, err := collectReflectionChildren(ctx, client, parents, seenIDs)
+	if err != nil {
+		return err
+	}
+	all := append(append([]reflectionEntry{}, parents...), children...)
+	responseRefs, responseKeys, err := collectResponses(ctx, client, all)
+	if err != nil {
+		return err
+	}
 
-	// ------------------------------------------------------------------
-	// Phase 2 — Delete S3 media for each discovered Reflection.
-	// ------------------------------------------------------------------
-	for _, r := range reflections {
+	// S3 first: if any delete fails the docs stay put and the page is retried.
+	keys := responseKeys
+	for _, r := range all {
 		if r.explorerID == "" || r.eventID == "" {
 			// Skip malformed docs; S3 keys cannot be safely constructed.
-			fmt.Printf("CleanupCompanionData: skipping doc %s (missing explorerId or event_id)\n", r.ref.ID)
+			fmt.Printf("deleteReflectionPage: skipping S3 for doc %s (missing explorerId or event_id)\n", r.ref.ID)
 			continue
 		}
-		for _, key := range companionReflectionKeys(r.explorerID, r.eventID) {
-			if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-				Bucket: aws.String("reflections-1200b-storage"),
-				Key:    aws.String(key),
-			}); err != nil {
-				return fmt.Errorf("CleanupCompanionData: S3 delete %q: %w", key, err)
+		keys = append(keys, companionReflectionKeys(r.explorerID, r.eventID)...)
+	}
+	if err := deleteS3Keys(ctx, s3Client, job, keys); err != nil {
+		return err
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(all)+len(responseRefs))
+	for _, r := range all {
+		refs = append(refs, r.ref)
+	}
+	refs = append(refs, responseRefs...)
+	if err := commitBatches(ctx, client, refs); err != nil {
+		return fmt.Errorf("reflections batch delete: %w", err)
+	}
+	job.Counts.Reflections += len(all)
+	job.Counts.Responses += len(responseRefs)
+
+	lastID := ""
+	if len(parents) > 0 {
+		lastID = parents[len(parents)-1].ref.ID
+	}
+	finishPage(job, lastID, len(parents))
+	return nil
+}
+
+// archiveReflectionPage reassigns one page of the companion's Reflections to
+// the archived sender, leaving media, responses and reactions in place.
+// Rewritten docs stop matching senderField, so no cursor is needed.
+func archiveReflectionPage(ctx context.Context, client *firestore.Client, job *deletionJob, senderField string) error {
+	docs, err := queryPage(ctx, client.Collection(reflectionsCollection).Where(senderField, "==", job.UserID))
+	if err != nil {
+		return fmt.Errorf("reflections query (%s): %w", senderField, err)
+	}
+	senderName := job.ArchivedSenderName
+	if senderName == "" {
+		senderName = defaultArchivedSenderName
+	}
+	if len(docs) > 0 {
+		batch := client.Batch()
+		for _, doc := range docs {
+			updates := []firestore.Update{
+				{Path: "sender_id", Value: archivedSenderID},
+				{Path: "sender", Value: senderName},
+				{Path: "senderArchived", Value: true},
+				{Path: "archivedAt", Value: firestore.ServerTimestamp},
+				// Points at a relationship that is about to be deleted.
+				{Path: "responderRelationshipId", Value: firestore.Delete},
+			}
+			if metadata, ok := doc.Data()["metadata"].(map[string]any); ok && metadata != nil {
+				updates = append(updates,
+					firestore.Update{Path: "metadata.sender_id", Value: archivedSenderID},
+					firestore.Update{Path: "metadata.sender", Value: senderName},
+				)
 			}
+			batch.Update(doc.Ref, updates)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("reflections archive (%s): %w", senderField, err)
+		}
+	}
+	job.Counts.Archived += len(docs)
+	if len(docs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// collectReflectionChildren finds reaction and narration docs (from any
+// sender) whose parentReflectionId points at one of parents.
+func collectReflectionChildren(ctx context.Context, client *firestore.Client, parents []reflectionEntry, seenIDs map[string]struct{}) ([]reflectionEntry, error) {
+	var children []reflectionEntry
+	for start := 0; start < len(parents); start += firestoreInLimit {
+		end := min(start+firestoreInLimit, len(parents))
+		ids := make([]string, 0, end-start)
+		for _, p := range parents[start:end] {
+			ids = append(ids, p.ref.ID)
+		}
+		found, err := collectReflections(
+			client.Collection(reflectionsCollection).Where("parentReflectionId", "in", ids).Documents(ctx),
+			seenIDs,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("reflection children query: %w", err)
 		}
+		children = append(children, found...)
+	}
+	return children, nil
+}
+
+// collectResponses returns the responses/{event_id} docs for entries and the
+// S3 keys of their Explorer selfies, mirroring the "from" path used by
+// DeleteMirrorEvent.
+func collectResponses(ctx context.Context, client *firestore.Client, entries []reflectionEntry) ([]*firestore.DocumentRef, []string, error) {
+	var candidates []*firestore.DocumentRef
+	explorerByEvent := map[string]string{}
+	for _, r := range entries {
+		if r.eventID == "" {
+			continue
+		}
+		candidates = append(candidates, client.Collection(responsesCollection).Doc(r.eventID))
+		explorerByEvent[r.eventID] = r.explorerID
+	}
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	snaps, err := client.GetAll(ctx, candidates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("responses lookup: %w", err)
 	}
 
-	// ------------------------------------------------------------------
-	// Phase 3 — Discover relationship documents for this companion.
-	// ------------------------------------------------------------------
-	var relationshipRefs []*firestore.DocumentRef
-	relIter := fsClient.Collection("relationships").Where("userId", "==", userID).Documents(ctx)
+	var refs []*firestore.DocumentRef
+	var keys []string
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		refs = append(refs, snap.Ref)
+		data := snap.Data()
+		explorerID, _ := data["explorerId"].(string)
+		if explorerID == "" {
+			explorerID = explorerByEvent[snap.Ref.ID]
+		}
+		responseEventID, _ := data["response_event_id"].(string)
+		if responseEventID == "" {
+			responseEventID = snap.Ref.ID
+		}
+		if explorerID == "" {
+			continue
+		}
+		keys = append(keys,
+			fmt.Sprintf("%s/from/%s/image.jpg", explorerID, responseEventID),
+			fmt.Sprintf("%s/from/%s/image_original.jpg", explorerID, responseEventID),
+		)
+	}
+	return refs, keys, nil
+}
+
+// scrubRespondedRelationships removes the companion's relationship IDs from
+// respondedRelationshipIds on other Reflections, one filter chunk at a time.
+func scrubRespondedRelationships(ctx context.Context, client *firestore.Client, job *deletionJob) error {
+	if len(job.RelationshipIDs) == 0 {
+		job.advancePhase()
+		return nil
+	}
+	ids := make([]any, 0, len(job.RelationshipIDs))
+	for _, id := range job.RelationshipIDs {
+		ids = append(ids, id)
+	}
+	for start := 0; start < len(ids); start += firestoreInLimit {
+		chunk := ids[start:min(start+firestoreInLimit, len(ids))]
+		docs, err := queryPage(ctx, client.Collection(reflectionsCollection).Where("respondedRelationshipIds", "array-contains-any", chunk))
+		if err != nil {
+			return fmt.Errorf("respondedRelationshipIds query: %w", err)
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		batch := client.Batch()
+		for _, doc := range docs {
+			batch.Update(doc.Ref, []firestore.Update{{Path: "respondedRelationshipIds", Value: firestore.ArrayRemove(ids...)}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("respondedRelationshipIds scrub: %w", err)
+		}
+		job.Counts.References += len(docs)
+		// Come back for the next page; the phase ends on an all-empty sweep.
+		return nil
+	}
+	job.advancePhase()
+	return nil
+}
+
+// scrubNotificationRecipientPage deletes pending notifications addressed only
+// to the companion and removes them from multi-recipient ones.
+func scrubNotificationRecipientPage(ctx context.Context, client *firestore.Client, job *deletionJob) error {
+	docs, err := queryPage(ctx, client.Collection(pendingNotificationsCollection).Where("recipientIds", "array-contains", job.UserID))
+	if err != nil {
+		return fmt.Errorf("pending_notifications recipientIds query: %w", err)
+	}
+	if len(docs) > 0 {
+		batch := client.Batch()
+		for _, doc := range docs {
+			recipients, _ := doc.Data()["recipientIds"].([]any)
+			if len(recipients) <= 1 {
+				batch.Delete(doc.Ref)
+				continue
+			}
+			batch.Update(doc.Ref, []firestore.Update{{Path: "recipientIds", Value: firestore.ArrayRemove(job.UserID)}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("pending_notifications recipientIds scrub: %w", err)
+		}
+	}
+	job.Counts.Notifications += len(docs)
+	if len(docs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// scrubProcessedRecipientPage drops the companion's entry from the
+// processedRecipients map the slow-lane aggregator keeps on broadcast
+// notifications.
+func scrubProcessedRecipientPage(ctx context.Context, client *firestore.Client, job *deletionJob) error {
+	path := firestore.FieldPath{"processedRecipients", job.UserID}
+	docs, err := queryPage(ctx, client.Collection(pendingNotificationsCollection).WherePath(path, "!=", nil))
+	if err != nil {
+		return fmt.Errorf("pending_notifications processedRecipients query: %w", err)
+	}
+	if len(docs) > 0 {
+		batch := client.Batch()
+		for _, doc := range docs {
+			batch.Update(doc.Ref, []firestore.Update{{FieldPath: path, Value: firestore.Delete}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("pending_notifications processedRecipients scrub: %w", err)
+		}
+	}
+	job.Counts.References += len(docs)
+	if len(docs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// companionMediaPrefixes lists the per-Explorer S3 prefixes that hold media
+// owned by the companion rather than by a Reflection: their avatar uploads
+// and preview TTS generated while composing.
+func companionMediaPrefixes(job *deletionJob) []string {
+	var prefixes []string
+	for _, explorerID := range job.ExplorerIDs {
+		prefixes = append(prefixes,
+			fmt.Sprintf("%s/avatars/%s/", explorerID, job.UserID),
+			fmt.Sprintf("staging/%s/tts/%s/", explorerID, job.UserID),
+		)
+	}
+	return prefixes
+}
+
+// deleteCompanionMedia removes avatar and staging TTS objects, including any
+// companionAvatarS3Key on a relationship that lives outside the usual prefix.
+func deleteCompanionMedia(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	var keys []string
+	for _, prefix := range companionMediaPrefixes(job) {
+		found, err := listS3Prefix(ctx, s3Client, prefix)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, found...)
+	}
+	iter := client.Collection(relationshipsCollection).Where("userId", "==", job.UserID).Documents(ctx)
 	for {
-		doc, err := relIter.Next()
+		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("CleanupCompanionData: relationships query: %w", err)
+			return fmt.Errorf("relationships query: %w", err)
 		}
-		relationshipRefs = append(relationshipRefs, doc.Ref)
+		if key, _ := doc.Data()["companionAvatarS3Key"].(string); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := deleteS3Keys(ctx, s3Client, job, keys); err != nil {
+		return err
 	}
-	fmt.Printf("CleanupCompanionData: found %d relationship(s) for user %s\n", len(relationshipRefs), userID)
+	job.advancePhase()
+	return nil
+}
 
-	// ------------------------------------------------------------------
-	// Phase 4 — Atomic Firestore batch deletion (chunked ≤ 500 ops).
-	// Deletes: all Reflection docs + all relationship docs + users/{userID}.
-	// ------------------------------------------------------------------
-	var allRefs []*firestore.DocumentRef
-	for _, r := range reflections {
-		allRefs = append(allRefs, r.ref)
+// verifyCompanionDeletion proves nothing references the companion anymore by
+// re-running every discovery query (and S3 prefix listing) and expecting no
+// hits. Residual hits rewind the job for another sweep.
+func verifyCompanionDeletion(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	userID := job.UserID
+	reflections := client.Collection(reflectionsCollection)
+	notifications := client.Collection(pendingNotificationsCollection)
+	checks := []residualCheck{
+		{"reflections_sender_id", reflections.Where("sender_id", "==", userID)},
+		{"reflections_metadata_sender_id", reflections.Where("metadata.sender_id", "==", userID)},
+		{"reflections_likedBy", reflections.Where("likedBy", "array-contains", userID)},
+		{"reflections_respondedCompanionIds", reflections.Where("respondedCompanionIds", "array-contains", userID)},
+		{"pending_notifications_senderId", notifications.Where("senderId", "==", userID)},
+		{"pending_notifications_likerId", notifications.Where("likerId", "==", userID)},
+		{"pending_notifications_recipientIds", notifications.Where("recipientIds", "array-contains", userID)},
+		{"pending_notifications_processedRecipients", notifications.WherePath(firestore.FieldPath{"processedRecipients", userID}, "!=", nil)},
+		{"relationships_userId", client.Collection(relationshipsCollection).Where("userId", "==", userID)},
+		{"tts_cache_companionId", client.Collection(speechCacheCollection).Where("companionId", "==", userID)},
+		{"users", client.Collection("users").Where(firestore.DocumentID, "==", client.Collection("users").Doc(userID))},
+	}
+	for start := 0; start < len(job.RelationshipIDs); start += firestoreInLimit {
+		chunk := job.RelationshipIDs[start:min(start+firestoreInLimit, len(job.RelationshipIDs))]
+		checks = append(checks, residualCheck{
+			fmt.Sprintf("reflections_respondedRelationshipIds_%d", start/firestoreInLimit),
+			reflections.Where("respondedRelationshipIds", "array-contains-any", chunk),
+		})
+	}
+	residual, err := countResiduals(ctx, checks)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range companionMediaPrefixes(job) {
+		keys, err := listS3Prefix(ctx, s3Client, prefix)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			residual["s3:"+prefix] = len(keys)
+		}
+	}
+	return settleVerification(job, residual)
+}
+
+// CleanupCompanionData drains the companion's deletion job in-process. It
+// is meant for scripts and manual recovery; the HTTP path returns as soon as
+// the job is recorded and lets OnDeletionJobWritten do the work.
+//
+// The job deletes every Reflection sent by userID (with reaction/narration
+// children, selfie responses and S3 media), scrubs the UID from likes,
+// reaction markers and pending notifications, removes cached speech, avatar
+// and staging TTS uploads, the relationship docs and users/{userID}, then verifies that no
+// reference remains. Progress lives in deletion_jobs/{userID}, so an
+// interrupted run resumes from its last checkpoint instead of starting over.
+func CleanupCompanionData(ctx context.Context, userID string) error {
+	s3Client, err := awsS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("CleanupCompanionData: AWS config: %w", err)
 	}
-	allRefs = append(allRefs, relationshipRefs...)
-	allRefs = append(allRefs, fsClient.Collection("users").Doc(userID))
 
-	if err := commitBatches(ctx, fsClient, allRefs); err != nil {
-		return fmt.Errorf("CleanupCompanionData: Firestore batch delete: %w", err)
+	fsClient, err := firestoreClient(ctx)
+	if err != nil {
+		return fmt.Errorf("CleanupCompanionData: %w", err)
+	}
+
+	job, err := startCompanionDeletionJob(ctx, fsClient, userID, companionDeletionOptions{})
+	if err != nil {
+		return fmt.Errorf("CleanupCompanionData: %w", err)
+	}
+	for !job.terminal() {
+		next, err := runDeletionJobPass(ctx, fsClient, s3Client, userID, deletionJobPassBudget)
+		if err != nil {
+			return fmt.Errorf("CleanupCompanionData: %w", err)
+		}
+		if next == nil {
+			return fmt.Errorf("CleanupCompanionData: deletion job %s is leased by another worker", userID)
+		}
+		job = next
+	}
+	if job.Status == deletionStatusFailed {
+		return fmt.Errorf("CleanupCompanionData: deletion job %s failed: %s", userID, job.LastError)
 	}
 
-	fmt.Printf("CleanupCompanionData: successfully purged data for user %s\n", userID)
+	fmt.Printf("CleanupCompanionData: successfully purged data for user %s (%+v)\n", userID, job.Counts)
 	return nil
 }
 
-// DeleteCompanionAccount is the HTTP Cloud Function entry point that wraps
-// CleanupCompanionData. The Connect app calls this before deleting the
-// Firebase Auth record; a non-200 response signals that cleanup failed and
-// Auth deletion must be aborted.
+// DeleteCompanionAccount is the HTTP Cloud Function entry point for account
+// deletion. POST {"user_id": ...} records (or resumes) deletion_jobs/{userID}
+// and returns 202 with the job ID; the purge itself runs in
+// OnDeletionJobWritten and ends by deleting the Firebase Auth account.
+// GET ?job_id=... reports the job's phase and counts so the Connect app can
+// poll until status is "completed". Both require the companion's own
+// Firebase ID token, or an audit admin's (see isAuditAdmin).
+//
+// With "mode": "scheduled" the job is instead held until the grace period
+// (COMPANION_DELETION_GRACE_HOURS) expires. The companion's Reflections are
+// hidden from the Explorer straight away, and POST {"user_id", "action":
+// "cancel"} restores everything until the purge starts.
+//
+// With "keep_reflections": true the companion's Reflections stay with the
+// Explorer under an archived sender (optionally "archived_sender_name")
+// instead of being deleted; everything else about the account still goes.
 func DeleteCompanionAccount(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	if r.Method == http.MethodOptions {
 		return
 	}
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	ctx := r.Context()
+	fsClient, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		jobID := r.URL.Query().Get("job_id")
+		if jobID == "" {
+			http.Error(w, "job_id is required", http.StatusBadRequest)
+			return
+		}
+		job, err := getDeletionJob(ctx, fsClient, jobID)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "deletion job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load deletion job: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !canManageDeletionJob(token, job.UserID, job.RequestedBy) {
+			http.Error(w, "not allowed to view this deletion job", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.response(jobID))
+		return
+	}
+
 	var body struct {
-		UserID string `json:"user_id"`
+		UserID             string `json:"user_id"`
+		Mode               string `json:"mode"`
+		Action             string `json:"action"`
+		KeepReflections    bool   `json:"keep_reflections"`
+		ArchivedSenderName string `json:"archived_sender_name"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == "" {
 		http.Error(w, "user_id is required in the JSON body", http.StatusBadRequest)
 		return
 	}
 
-	if err := CleanupCompanionData(r.Context(), body.UserID); err != nil {
-		fmt.Printf("DeleteCompanionAccount: cleanup failed for user %s: %v\n", body.UserID, err)
+	audit := AuditRecord{
+		Actor:      token.UID,
+		Action:     "delete_companion_account",
+		Targets:    []string{"users/" + body.UserID, deletionJobsCollection + "/" + body.UserID},
+		RequestID:  auditRequestID(r),
+		BeforeHash: docBeforeHash(ctx, fsClient.Collection("users").Doc(body.UserID)),
+		Details:    map[string]any{"mode": body.Mode, "keepReflections": body.KeepReflections},
+	}
+	if !canManageDeletionJob(token, body.UserID) {
+		audit.Outcome = AuditOutcomeDenied
+		writeAuditRecord(ctx, fsClient, audit)
+		http.Error(w, "you can only delete your own account", http.StatusForbidden)
+		return
+	}
+
+	if body.Action == "cancel" {
+		job, err := cancelCompanionDeletion(ctx, fsClient, body.UserID)
+		audit.Action = "cancel_companion_deletion"
+		audit.Outcome, audit.Error = auditOutcome(err)
+		writeAuditRecord(ctx, fsClient, audit)
+		switch {
+		case status.Code(err) == codes.NotFound || errors.Is(err, errCompanionDeletionNotScheduled):
+			http.Error(w, errCompanionDeletionNotScheduled.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			fmt.Printf("DeleteCompanionAccount: could not cancel job for user %s: %v\n", body.UserID, err)
+			http.Error(w, "cancel failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("DeleteCompanionAccount: cancelled scheduled deletion for user %s\n", body.UserID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job.response(body.UserID))
+		return
+	}
+
+	opts := companionDeletionOptions{
+		KeepReflections:    body.KeepReflections,
+		ArchivedSenderName: body.ArchivedSenderName,
+	}
+	switch body.Mode {
+	case "", companionDeletionModeImmediate:
+	case companionDeletionModeScheduled:
+		opts.PurgeAt = time.Now().UTC().Add(companionDeletionGrace())
+	default:
+		http.Error(w, `mode must be "immediate" or "scheduled"`, http.StatusBadRequest)
+		return
+	}
+
+	job, err := startCompanionDeletionJob(ctx, fsClient, body.UserID, opts)
+	audit.Outcome, audit.Error = auditOutcome(err)
+	if job != nil {
+		audit.Details["jobStatus"] = job.Status
+	}
+	writeAuditRecord(ctx, fsClient, audit)
+	if errors.Is(err, errDeletionJobOptionsConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Printf("DeleteCompanionAccount: could not start job for user %s: %v\n", body.UserID, err)
 		http.Error(w, "cleanup failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if job.Status == deletionStatusScheduled {
+		if err := setCompanionPendingDeletion(ctx, fsClient, job, job.NotBefore); err != nil {
+			fmt.Printf("DeleteCompanionAccount: could not hide content for user %s: %v\n", body.UserID, err)
+			http.Error(w, "schedule failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("DeleteCompanionAccount: scheduled deletion for user %s at %s\n", body.UserID, job.NotBefore.Format(time.RFC3339))
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.response(body.UserID))
 }
@@ -3,61 +3,27 @@ package functions
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
-// extractFirstJSONObject tries to find the first balanced {...} JSON object in s.
-// This is a defensive fallback for when the model returns extra prose around JSON.
-func extractFirstJSONObject(s string) (string, bool) {
-	start := strings.IndexByte(s, '{')
-	if start < 0 {
-		return "", false
+// stagingTTSKey builds the S3 key for preview TTS generated before a
+// Reflection is sent. Keys are nested under the companion when known
+// (staging/{explorerID}/tts/{companionID}/...) so CleanupCompanionData can
+// delete them; the staging/ lifecycle rule expires everything after a day.
+func stagingTTSKey(explorerID, companionID, filename string) string {
+	if companionID == "" {
+		return fmt.Sprintf("staging/%s/tts/%s", explorerID, filename)
 	}
-	depth := 0
-	inString := false
-	escape := false
-	for i := start; i < len(s); i++ {
-		c := s[i]
-		if inString {
-			if escape {
-				escape = false
-				continue
-			}
-			if c == '\\' {
-				escape = true
-				continue
-			}
-			if c == '"' {
-				inString = false
-			}
-			continue
-		}
-
-		switch c {
-		case '"':
-			inString = true
-		case '{':
-			depth++
-		case '}':
-			depth--
-			if depth == 0 {
-				return s[start : i+1], true
-			}
-		}
-	}
-	return "", false
+	return fmt.Sprintf("staging/%s/tts/%s/%s", explorerID, companionID, filename)
 }
 
 func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
@@ -70,22 +36,7 @@ func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 2. Setup Gemini Client
 	ctx := context.Background()
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		http.Error(w, "GEMINI_API_KEY not configured", 500)
-		return
-	}
-
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		http.Error(w, "Failed to create Gemini client: "+err.Error(), 500)
-		return
-	}
-	defer client.Close()
-
-	model := client.GenerativeModel("gemini-2.5-flash-lite")
 
 	// 2. Get params
 	explorerID := getExplorerID(r)
@@ -93,8 +44,12 @@ func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "explorer_id is required", 400)
 		return
 	}
-	explorerName := getExplorerName(explorerID)
+	explorerName := strings.TrimSpace(r.URL.Query().Get("explorer_name"))
+	if explorerName == "" {
+		explorerName = getExplorerName(explorerID)
+	}
 
+	// The image is named by image_s3_key, event_id (+ event_path) or image_url; see loadCaptionImage.
 	imageURL := r.URL.Query().Get("image_url")
 	targetCaption := r.URL.Query().Get("target_caption")
 	targetDeepDive := r.URL.Query().Get("target_deep_dive")
@@ -105,16 +60,98 @@ func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
 	peopleContext := strings.TrimSpace(r.URL.Query().Get("people_context"))
 	captionVoice := r.URL.Query().Get("caption_voice")
 	deepDiveVoice := r.URL.Query().Get("deep_dive_voice")
+	// Optional: scopes staging TTS under the companion so account deletion can find it.
+	companionID := strings.TrimSpace(r.URL.Query().Get("companion_id"))
+	// Skips the caption cache lookup; the fresh result still replaces the entry.
+	forceRefresh := r.URL.Query().Get("force_refresh") == "true"
+	// Video reflections: media_type=video plus the trimmed duration and, when
+	// known, what is said in the clip. See loadCaptionVideo.
+	isVideo := r.URL.Query().Get("media_type") == "video"
+	videoDurationMs, _ := strconv.ParseInt(r.URL.Query().Get("video_duration_ms"), 10, 64)
+	transcript := r.URL.Query().Get("transcript")
+	// Optional BCP-47 caption languages; the explorer's and companion's
+	// preferences apply when unset. See resolveCaptionLanguages.
+	requestedLanguage := r.URL.Query().Get("language")
+	requestedSecondLanguage := r.URL.Query().Get("second_language")
 
 	var result struct {
-		ShortCaption       string `json:"short_caption"`
-		DeepDive           string `json:"deep_dive"`
-		AudioURL           string `json:"audio_url,omitempty"`
-		DeepDiveAudioURL   string `json:"deep_dive_audio_url,omitempty"`
-		AudioS3Key         string `json:"audio_s3_key,omitempty"`
-		DeepDiveAudioS3Key string `json:"deep_dive_audio_s3_key,omitempty"`
-		StagingEventID     string `json:"staging_event_id,omitempty"`
+		ShortCaption       string   `json:"short_caption"`
+		DeepDive           string   `json:"deep_dive"`
+		AudioURL           string   `json:"audio_url,omitempty"`
+		DeepDiveAudioURL   string   `json:"deep_dive_audio_url,omitempty"`
+		AudioS3Key         string   `json:"audio_s3_key,omitempty"`
+		DeepDiveAudioS3Key string   `json:"deep_dive_audio_s3_key,omitempty"`
+		StagingEventID     string   `json:"staging_event_id,omitempty"`
+		DetectedPeople     []string `json:"detected_people,omitempty"`
+		SafetyFlags        []string `json:"safety_flags,omitempty"`
+		PromptVersion      string   `json:"prompt_version,omitempty"`
+		OutputProfile      string   `json:"output_profile,omitempty"`
+		Cached             bool     `json:"cached,omitempty"`
+		// VideoInput is "clip" or "keyframes" when a video was captioned from
+		// more than its thumbnail.
+		VideoInput string `json:"video_input,omitempty"`
+		// VoiceNoteTranscript is what the caption model was told the
+		// Companion said (voice_note_s3_key).
+		VoiceNoteTranscript string `json:"voice_note_transcript,omitempty"`
+		// BudgetLimited means the explorer's AI budget is spent and no new
+		// audio was synthesized.
+		BudgetLimited bool `json:"budget_limited,omitempty"`
+		// TextOnly means speech synthesis failed or is unavailable, so some
+		// or all audio is missing.
+		TextOnly bool `json:"text_only,omitempty"`
+		// Moderation is set when screening flagged the caption. Quarantined
+		// captions come back empty, without audio.
+		Moderation *ModerationResult `json:"moderation,omitempty"`
+		// Language is what short_caption and deep_dive are written and
+		// spoken in. SecondLanguage, when set, is an extra rendering of the
+		// same caption with its own audio.
+		Language                 string `json:"language"`
+		SecondLanguage           string `json:"second_language,omitempty"`
+		SecondShortCaption       string `json:"second_short_caption,omitempty"`
+		SecondDeepDive           string `json:"second_deep_dive,omitempty"`
+		SecondAudioURL           string `json:"second_audio_url,omitempty"`
+		SecondAudioS3Key         string `json:"second_audio_s3_key,omitempty"`
+		SecondDeepDiveAudioURL   string `json:"second_deep_dive_audio_url,omitempty"`
+		SecondDeepDiveAudioS3Key string `json:"second_deep_dive_audio_s3_key,omitempty"`
+	}
+
+	// Firestore backs the prompt registry, the caption cache and the usage
+	// ledger; without it we still caption, using the built-in prompt.
+	fsClient, err := firestoreClient(ctx)
+	if err != nil {
+		log.Printf("Firestore unavailable, using built-in prompt without cache or budgets: %v", err)
+		fsClient = nil
+	}
+
+	budget := checkAIBudget(ctx, fsClient, explorerID)
+	if budget.Action == AIBudgetActionRefuse {
+		log.Printf("Refusing AI request for %s: %s", explorerID, budget.Reason)
+		http.Error(w, budget.Reason, http.StatusTooManyRequests)
+		return
+	}
+	if budget.Action == AIBudgetActionTextOnly {
+		log.Printf("Text-only AI request for %s: %s", explorerID, budget.Reason)
+		result.BudgetLimited = true
+	}
+	usage := AIUsage{ExplorerID: explorerID, CompanionID: companionID, Source: "generate-ai-description"}
+
+	// The explorer's glossary names people, pets and places in the caption
+	// and tells TTS how to say them.
+	glossary, err := loadGlossary(ctx, fsClient, explorerID)
+	if err != nil {
+		log.Printf("Glossary unavailable, captioning without it: %v", err)
 	}
+	pronunciations := glossaryPronunciations(glossary)
+	companionLanguage := loadCompanionLanguage(ctx, fsClient, companionID)
+
+	// Full generations (no target texts) are cached by image, prompt and voices.
+	var cacheKey string
+	var cached *captionCacheEntry
+	var captionModelName string
+	cacheTTL := captionCacheTTL()
+	// Everything the explorer would see or hear is screened before TTS.
+	var screen ModerationInput
+	var captionBlocked *CaptionBlockedError
 
 	// Extract staging event_id from image URL (e.g. .../staging/1738941234567/image.jpg) for client cleanup
 	if imageURL != "" {
@@ -126,135 +163,209 @@ func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// 3. Logic: If we have both target texts, just do TTS. If missing either, call Gemini for image analysis.
+	// S3 client for image reads and TTS storage (shared)
+	s3Client, err := awsS3Client(ctx)
+	if err != nil {
+		log.Printf("AWS Config Error: %v", err)
+		http.Error(w, "S3 Config Error", 500)
+		return
+	}
+	presignClient := s3.NewPresignClient(s3Client)
+
+	// 3. Logic: If we have both target texts, just do TTS. If missing either, call the caption model for image analysis.
 	if targetCaption != "" && targetDeepDive != "" {
 		log.Printf("TTS-only mode: using provided texts")
 		result.ShortCaption = targetCaption
 		result.DeepDive = targetDeepDive
+		result.Language = explorerCaptionLanguages(ctx, fsClient, explorerID, requestedLanguage, "", companionLanguage).Primary
 	} else {
-		if imageURL == "" {
-			http.Error(w, "image_url parameter required if target texts are missing", 400)
+		// Never fetch arbitrary client URLs: the image is read from our bucket
+		// or from an allowlisted CDN, size-capped and sniffed.
+		img, err := loadCaptionImage(ctx, s3Client, r, explorerID)
+		if errors.Is(err, errCaptionImageRejected) {
+			http.Error(w, err.Error(), 400)
 			return
 		}
-
-		res, err := http.Get(imageURL)
 		if err != nil {
 			http.Error(w, "Failed to fetch image: "+err.Error(), 500)
 			return
 		}
-		defer res.Body.Close()
-
-		if res.StatusCode != http.StatusOK {
-			http.Error(w, fmt.Sprintf("Failed to fetch image: HTTP %d", res.StatusCode), 500)
-			return
+		if id := stagingEventIDFromKey(img.Key); id != "" {
+			result.StagingEventID = id
 		}
-
-		imgData, err := io.ReadAll(res.Body)
+		screen.Image = img.Data
+		screen.ImageMIME = img.MIME
+
+		// The prompt comes from the versioned template registry; the app's own
+		// prompt is only used for explorers that opt into it.
+		prompt := resolveCaptionPrompt(ctx, fsClient, explorerID, PromptVars{
+			ExplorerName:      explorerName,
+			SenderName:        companionName,
+			SenderInImage:     companionInReflection,
+			ExplorerInImage:   explorerInReflection,
+			PeopleContext:     peopleContext,
+			Glossary:          relevantGlossaryEntries(glossary, peopleContext, companionName),
+			Language:          requestedLanguage,
+			SecondLanguage:    requestedSecondLanguage,
+			CompanionLanguage: companionLanguage,
+		}, clientPrompt)
+		result.PromptVersion = prompt.Version
+		result.OutputProfile = prompt.Output.Label()
+		result.Language = prompt.Languages.Primary
+		log.Printf("Using caption prompt %s (%d chars, %s)", prompt.Version, len(prompt.Text), prompt.Languages.Primary)
+
+		// Captions are cached under the primary model's resolved name, so a
+		// change to the default model invalidates them.
+		modelCfg := CaptionModelConfigFromEnv()
+		model, err := NewCaptionModel(ctx, modelCfg)
 		if err != nil {
-			http.Error(w, "Failed to read image data: "+err.Error(), 500)
+			http.Error(w, "Failed to create caption model: "+err.Error(), 500)
 			return
 		}
-
-		// Use client-provided prompt if available; otherwise fall back to
-		// server-side construction (backwards compat for older app versions).
-		var promptText string
-		if clientPrompt != "" {
-			promptText = clientPrompt
-			log.Printf("Using client-provided prompt (%d chars)", len(clientPrompt))
-		} else {
-			log.Printf("No client prompt — using legacy server-side prompt builder")
-			var contextLines []string
-			if companionName != "" && companionInReflection {
-				contextLines = append(contextLines, fmt.Sprintf("%s is the sender and has confirmed they appear in the image.", companionName))
-			} else if companionName != "" {
-				contextLines = append(contextLines, fmt.Sprintf("%s is the sender of this Reflection.", companionName))
-			} else {
-				contextLines = append(contextLines, "A family member or caregiver is the sender of this Reflection.")
-			}
-			if explorerInReflection {
-				contextLines = append(contextLines, fmt.Sprintf("%s has been confirmed to be in this image.", explorerName))
+		defer model.Close()
+		captionModelName = model.Name()
+
+		// Videos are captioned from the clip or keyframes stored next to the
+		// thumbnail; when neither is usable the thumbnail alone is used.
+		var video *captionVideo
+		captionPrompt := prompt
+		captionMedia := img.Data
+		if isVideo {
+			duration := time.Duration(videoDurationMs) * time.Millisecond
+			if video = loadCaptionVideo(ctx, s3Client, img.Key, duration, transcript, modelCfg.SupportsVideo()); video != nil {
+				captionPrompt.Text = videoCaptionPrompt(prompt.Text, video)
+				captionMedia = video.fingerprint(img.Data)
+				result.VideoInput = video.Input()
+				log.Printf("Video caption from %s (%d frames, %d clip bytes)", video.Input(), len(video.Frames), len(video.Clip))
 			} else {
-				contextLines = append(contextLines, fmt.Sprintf("%s is the AUDIENCE — they are NOT in this image.", explorerName))
-			}
-			if peopleContext != "" {
-				contextLines = append(contextLines, fmt.Sprintf("The sender identified these people: %s", peopleContext))
+				log.Printf("Video caption: no clip or keyframes next to %s, using the thumbnail", img.Key)
 			}
+		}
 
-			var identityRules string
-			if explorerInReflection {
-				identityRules = fmt.Sprintf("IDENTITY RULES:\n1. %s IS in this image. You may use their name.\n2. Use provided names for others; otherwise describe by visible traits.\n3. DO NOT guess medical conditions.", explorerName)
-			} else {
-				identityRules = fmt.Sprintf("CRITICAL IDENTITY RULES:\n1. NEVER identify anyone as %s. They are the viewer.\n2. Use provided names if given; otherwise describe by visible traits.\n3. DO NOT guess medical conditions.", explorerName)
+		// A voice note the Companion has already recorded is transcribed on
+		// cache misses and given to the model as context. It is optional, so
+		// only invalid keys fail the request.
+		var voiceNote []byte
+		if key := r.URL.Query().Get("voice_note_s3_key"); key != "" {
+			voiceNote, err = loadVoiceNote(ctx, s3Client, explorerID, key)
+			if errors.Is(err, errCaptionImageRejected) {
+				http.Error(w, err.Error(), 400)
+				return
 			}
-
-			var companionRules string
-			if companionName != "" && companionInReflection {
-				companionRules = fmt.Sprintf("Since %s is both sender and visible, use their name naturally.", companionName)
-			} else if companionName != "" {
-				companionRules = fmt.Sprintf("Work %s's name in so it feels like the Reflection comes from them.", companionName)
+			if err != nil {
+				log.Printf("Voice note %s unavailable, captioning without it: %v", key, err)
 			} else {
-				companionRules = "You may refer to the sender as a family member or caregiver."
-			}
-
-			var contextBlock string
-			for _, line := range contextLines {
-				contextBlock += "- " + line + "\n"
+				captionMedia = voiceNoteFingerprint(captionMedia, voiceNote)
 			}
-			promptText = fmt.Sprintf("Analyze this image for a 15-year-old with Angelman Syndrome named %s.\n\nCONTEXT:\n%s\n%s\n\nCONTENT RULES:\n4. short_caption: warm greeting TO %s (max 10 words).\n5. deep_dive: 2-3 sentence story speaking TO %s.\n6. %s\n\nReturn JSON: {\"short_caption\": \"string\", \"deep_dive\": \"string\"}",
-				explorerName, contextBlock, identityRules, explorerName, explorerName, companionRules)
-		}
-
-		parts := []genai.Part{
-			genai.Text(promptText),
-			genai.ImageData("jpeg", imgData),
 		}
 
-		resp, err := model.GenerateContent(ctx, parts...)
-		if err != nil {
-			http.Error(w, "Gemini Error: "+err.Error(), 500)
-			return
-		}
-
-		if len(resp.Candidates) == 0 {
-			http.Error(w, "No response from Gemini", 500)
-			return
+		if fsClient != nil && cacheTTL > 0 && targetCaption == "" && targetDeepDive == "" {
+			cacheKey = captionCacheKey(explorerID, captionMedia, captionPrompt, captionModelName, captionVoice, deepDiveVoice, pronunciations)
+			if forceRefresh {
+				log.Printf("Caption cache bypassed (force_refresh)")
+			} else if cached = lookupCaptionCache(ctx, fsClient, cacheKey); cached != nil {
+				log.Printf("Caption cache hit %s (prompt %s, model %s)", cacheKey, cached.PromptVersion, cached.Model)
+			}
 		}
 
-		part := resp.Candidates[0].Content.Parts[0]
-		var text string
-		if v, ok := part.(genai.Text); ok {
-			text = string(v)
+		if cached != nil {
+			result.ShortCaption = cached.ShortCaption
+			result.DeepDive = cached.DeepDive
+			result.DetectedPeople = cached.DetectedPeople
+			result.SafetyFlags = cached.SafetyFlags
+			result.VoiceNoteTranscript = cached.VoiceNoteTranscript
+			result.SecondLanguage = cached.SecondLanguage
+			result.SecondShortCaption = cached.SecondShortCaption
+			result.SecondDeepDive = cached.SecondDeepDive
+			result.Cached = true
 		} else {
-			http.Error(w, "Unexpected response type", 500)
-			return
-		}
-
-		jsonText := strings.TrimSpace(text)
-		jsonText = strings.TrimPrefix(jsonText, "```json")
-		jsonText = strings.TrimPrefix(jsonText, "```")
-		jsonText = strings.TrimSuffix(jsonText, "```")
-		jsonText = strings.TrimSpace(jsonText)
-
-		if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
-			// Defensive fallback: sometimes the model includes extra text around JSON.
-			// Log the parse error + a small snippet for debugging.
-			snippet := jsonText
-			if len(snippet) > 500 {
-				snippet = snippet[:500] + "..."
-			}
-			log.Printf("JSON Parse Error: %v. Raw snippet: %q", err, snippet)
-
-			if extracted, ok := extractFirstJSONObject(jsonText); ok {
-				if err2 := json.Unmarshal([]byte(extracted), &result); err2 == nil {
-					// Successfully recovered.
-				} else {
-					log.Printf("JSON Parse Error (recovery failed): %v. Extracted snippet: %q", err2, extracted)
-					http.Error(w, "JSON Parse Error", 500)
-					return
+			basePrompt := prompt.Text
+			if len(voiceNote) > 0 {
+				if text := transcribeVoiceNote(ctx, fsClient, usage, voiceNote); text != "" {
+					basePrompt = voiceNotePrompt(basePrompt, text)
+					result.VoiceNoteTranscript = text
 				}
-			} else {
-				http.Error(w, "JSON Parse Error", 500)
+			}
+			req := CaptionRequest{
+				Prompt:        basePrompt,
+				PromptVersion: prompt.Version,
+				Profile:       prompt.Output,
+				Image:         img.Data,
+				ImageMIME:     img.MIME,
+			}
+			if video != nil {
+				req.Prompt = videoCaptionPrompt(basePrompt, video)
+				req.Frames = video.Frames
+				req.Video = video.Clip
+				req.VideoMIME = video.ClipMIME
+			}
+			captioned, err := GenerateValidatedCaption(ctx, model, req)
+			RecordCaptionUsage(ctx, fsClient, usage, model.Name(), captioned)
+			if err != nil && video != nil && !errors.As(err, &captionBlocked) {
+				// Fall back to the thumbnail, and keep the fallback out of the
+				// cache so the next request tries the video again.
+				log.Printf("Video caption from %s failed, retrying with the thumbnail: %v", video.Input(), err)
+				result.VideoInput = ""
+				cacheKey = ""
+				req = CaptionRequest{Prompt: basePrompt, PromptVersion: prompt.Version, Profile: prompt.Output, Image: img.Data, ImageMIME: img.MIME}
+				captioned, err = GenerateValidatedCaption(ctx, model, req)
+				RecordCaptionUsage(ctx, fsClient, usage, model.Name(), captioned)
+			}
+			switch {
+			case errors.As(err, &captionBlocked):
+				// The provider refused the image itself; screening below
+				// quarantines it rather than failing the request.
+				log.Printf("Caption model %s blocked the image: %v", model.Name(), err)
+				screen.Ratings = captionBlocked.Ratings
+			case err != nil:
+				log.Printf("Caption model %s failed: %v", model.Name(), err)
+				http.Error(w, "Caption Error: "+err.Error(), 500)
 				return
+			default:
+				if served := model.Name(); served != captionModelName {
+					// A fallback model answered; only the primary's captions
+					// are cached, so the next request tries it again.
+					log.Printf("Caption from fallback model %s", served)
+					captionModelName = served
+					cacheKey = ""
+				}
+				if len(captioned.Violations) > 0 {
+					log.Printf("Using caption that still breaks rules after %d attempts: %s", captioned.Attempts, strings.Join(captioned.Violations, " "))
+				}
+				result.ShortCaption = captioned.Caption.ShortCaption
+				result.DeepDive = captioned.Caption.DeepDive
+				result.DetectedPeople = captioned.Caption.DetectedPeople
+				result.SafetyFlags = captioned.Caption.SafetyFlags
+				screen.Ratings = captioned.Caption.SafetyRatings
+
+				// The second language renders the caption as it will be sent,
+				// including any text the Companion supplied.
+				if second := prompt.Languages.Second; second != "" {
+					source := *captioned.Caption
+					if targetCaption != "" {
+						source.ShortCaption = targetCaption
+					}
+					if targetDeepDive != "" {
+						source.DeepDive = targetDeepDive
+					}
+					profile := translationProfile(prompt.Output)
+					translated, err := GenerateValidatedCaption(ctx, model, CaptionRequest{
+						Prompt:        translationPrompt(&source, second, profile),
+						PromptVersion: prompt.Version,
+						Profile:       profile,
+						Image:         img.Data,
+						ImageMIME:     img.MIME,
+					})
+					RecordCaptionUsage(ctx, fsClient, usage, model.Name(), translated)
+					if err != nil {
+						// The primary caption is still usable; only the extra rendering is lost.
+						log.Printf("Second-language (%s) caption failed: %v", second, err)
+					} else {
+						result.SecondLanguage = second
+						result.SecondShortCaption = translated.Caption.ShortCaption
+						result.SecondDeepDive = translated.Caption.DeepDive
+					}
+				}
 			}
 		}
 
@@ -267,71 +378,129 @@ func GenerateAIDescription(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Setup AWS Config for TTS storage (shared)
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
-	if err != nil {
-		log.Printf("AWS Config Error: %v", err)
-		http.Error(w, "S3 Config Error", 500)
-		return
+	// Cached captions were screened when they were generated; everything
+	// else, including texts the Companion typed, is screened now.
+	if cached != nil {
+		result.Moderation = cached.Moderation
+	} else {
+		screen.Texts = []string{result.ShortCaption, result.DeepDive, result.VoiceNoteTranscript, result.SecondShortCaption, result.SecondDeepDive}
+		screen.SafetyFlags = result.SafetyFlags
+		moderation := moderateContent(ctx, fsClient, usage, explorerID, screen)
+		if captionBlocked != nil && !moderation.Quarantined() {
+			moderation.raise(ModerationQuarantine, "caption blocked by provider")
+		}
+		if moderation.Verdict != ModerationAllow {
+			result.Moderation = moderation
+		}
+	}
+	if result.Moderation.Quarantined() {
+		// Nothing is spoken or cached; the Companion is told why.
+		result.ShortCaption = ""
+		result.DeepDive = ""
+		result.DetectedPeople = nil
+		result.VoiceNoteTranscript = ""
+		result.SecondLanguage = ""
+		result.SecondShortCaption = ""
+		result.SecondDeepDive = ""
+		cacheKey = ""
 	}
-	s3Client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(s3Client)
 
-	// Synthesizes speech with one retry; TTS failures here must never be silent —
-	// a missing audio URL forces the apps onto the robotic device-TTS fallback.
-	synthesizeSpeechWithRetry := func(label, text, voiceName string) []byte {
-		speechData, ttsErr := GenerateSpeechWithOptions(text, SpeechOptions{VoiceName: voiceName})
-		if ttsErr != nil || len(speechData) == 0 {
-			log.Printf("TTS ERROR (%s, attempt 1/2): err=%v, bytes=%d — retrying", label, ttsErr, len(speechData))
-			speechData, ttsErr = GenerateSpeechWithOptions(text, SpeechOptions{VoiceName: voiceName})
+	// Synthesizes speech; GenerateSpeechWithOptions retries transient errors.
+	// TTS failures here must never be silent — a missing audio URL forces the
+	// apps onto the robotic device-TTS fallback. Once synthesis has failed
+	// the response is text-only: the remaining texts are not attempted.
+	// A voice from another language is swapped for the language's default.
+	synthesizeSpeech := func(label, text, voiceName, language string) []byte {
+		if result.TextOnly {
+			return nil
 		}
+		opts := SpeechOptions{VoiceName: voiceName, LanguageCode: language, Pronunciations: pronunciations, SentencePause: narrationSentencePause}
+		speechData, ttsErr := GenerateMeteredSpeech(ctx, fsClient, usage, text, opts)
 		if ttsErr != nil || len(speechData) == 0 {
-			log.Printf("TTS ERROR (%s, attempt 2/2): err=%v, bytes=%d — returning without audio", label, ttsErr, len(speechData))
+			log.Printf("TTS ERROR (%s): err=%v, bytes=%d — returning without audio", label, ttsErr, len(speechData))
+			result.TextOnly = true
 			return nil
 		}
 		return speechData
 	}
 
-	// 6. Generate speech using Google Cloud TTS (Journey voice)
-	if result.ShortCaption != "" {
-		log.Printf("TTS: Generating speech for caption: %s", result.ShortCaption)
-		speechData := synthesizeSpeechWithRetry("caption", result.ShortCaption, captionVoice)
-		if speechData != nil {
-			audioKey := fmt.Sprintf("staging/%s/tts/%d.mp3", explorerID, time.Now().UnixNano())
-
-			if err := UploadToS3(ctx, audioKey, speechData, "audio/mpeg"); err == nil {
-				presignedRes, _ := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-					Bucket: aws.String("reflections-1200b-storage"),
-					Key:    aws.String(audioKey),
-				})
-				result.AudioURL = presignedRes.URL
-				result.AudioS3Key = audioKey
-				log.Printf("Generated TTS for caption at: %s", audioKey)
-			} else {
-				log.Printf("TTS ERROR (caption): S3 upload failed: %v", err)
-			}
+	// Reuse cached audio when it is still in staging; the app deletes staging
+	// TTS when a draft is discarded or sent, so a hit may have lost either file.
+	presignAudio := func(key string) string {
+		presignedRes, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("reflections-1200b-storage"),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return ""
+		}
+		return presignedRes.URL
+	}
+	reuseAudio := func(key string, url, s3Key *string) {
+		if key != "" && S3FileExists(ctx, s3Client, "reflections-1200b-storage", key) {
+			*s3Key = key
+			*url = presignAudio(key)
 		}
 	}
+	if cached != nil {
+		reuseAudio(cached.AudioS3Key, &result.AudioURL, &result.AudioS3Key)
+		reuseAudio(cached.DeepDiveAudioS3Key, &result.DeepDiveAudioURL, &result.DeepDiveAudioS3Key)
+		reuseAudio(cached.SecondAudioS3Key, &result.SecondAudioURL, &result.SecondAudioS3Key)
+		reuseAudio(cached.SecondDeepDiveAudioS3Key, &result.SecondDeepDiveAudioURL, &result.SecondDeepDiveAudioS3Key)
+	}
 
+	// 6. Generate speech using Google Cloud TTS, staged in S3 under the
+	// filename prefix; audio that is already set (cache hit) is kept.
+	stageSpeech := func(label, text, voiceName, language, prefix string, url, s3Key *string) {
+		if text == "" || *s3Key != "" || result.BudgetLimited {
+			return
+		}
+		log.Printf("TTS: Generating speech for %s: %s", label, text)
+		speechData := synthesizeSpeech(label, text, voiceName, language)
+		if speechData == nil {
+			return
+		}
+		audioKey := stagingTTSKey(explorerID, companionID, fmt.Sprintf("%s%d.mp3", prefix, time.Now().UnixNano()))
+		if err := UploadToS3(ctx, audioKey, speechData, "audio/mpeg"); err != nil {
+			log.Printf("TTS ERROR (%s): S3 upload failed: %v", label, err)
+			return
+		}
+		*url = presignAudio(audioKey)
+		*s3Key = audioKey
+		log.Printf("Generated TTS for %s at: %s", label, audioKey)
+	}
+	stageSpeech("caption", result.ShortCaption, captionVoice, result.Language, "", &result.AudioURL, &result.AudioS3Key)
 	// 8. Generate Speech for Deep Dive
-	if result.DeepDive != "" {
-		log.Printf("TTS: Generating speech for deep dive: %s", result.DeepDive)
-		deepDiveSpeechData := synthesizeSpeechWithRetry("deep_dive", result.DeepDive, deepDiveVoice)
-		if deepDiveSpeechData != nil {
-			deepDiveAudioKey := fmt.Sprintf("staging/%s/tts/deepdive_%d.mp3", explorerID, time.Now().UnixNano())
+	stageSpeech("deep_dive", result.DeepDive, deepDiveVoice, result.Language, "deepdive_", &result.DeepDiveAudioURL, &result.DeepDiveAudioS3Key)
+	// The second language is spoken by the same voices when they speak it,
+	// otherwise by its default voice.
+	if result.SecondLanguage != "" {
+		lang := strings.ToLower(result.SecondLanguage) + "_"
+		stageSpeech("second_caption", result.SecondShortCaption, captionVoice, result.SecondLanguage, lang, &result.SecondAudioURL, &result.SecondAudioS3Key)
+		stageSpeech("second_deep_dive", result.SecondDeepDive, deepDiveVoice, result.SecondLanguage, "deepdive_"+lang, &result.SecondDeepDiveAudioURL, &result.SecondDeepDiveAudioS3Key)
+	}
 
-			if err := UploadToS3(ctx, deepDiveAudioKey, deepDiveSpeechData, "audio/mpeg"); err == nil {
-				presignedRes, _ := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-					Bucket: aws.String("reflections-1200b-storage"),
-					Key:    aws.String(deepDiveAudioKey),
-				})
-				result.DeepDiveAudioURL = presignedRes.URL
-				result.DeepDiveAudioS3Key = deepDiveAudioKey
-				log.Printf("Generated Deep Dive TTS at: %s", deepDiveAudioKey)
-			} else {
-				log.Printf("TTS ERROR (deep_dive): S3 upload failed: %v", err)
-			}
-		}
+	if cacheKey != "" && (cached == nil ||
+		cached.AudioS3Key != result.AudioS3Key || cached.DeepDiveAudioS3Key != result.DeepDiveAudioS3Key ||
+		cached.SecondAudioS3Key != result.SecondAudioS3Key || cached.SecondDeepDiveAudioS3Key != result.SecondDeepDiveAudioS3Key) {
+		storeCaptionCache(ctx, fsClient, cacheKey, captionCacheEntry{
+			ExplorerID:               explorerID,
+			ShortCaption:             result.ShortCaption,
+			DeepDive:                 result.DeepDive,
+			DetectedPeople:           result.DetectedPeople,
+			SafetyFlags:              result.SafetyFlags,
+			VoiceNoteTranscript:      result.VoiceNoteTranscript,
+			Moderation:               result.Moderation,
+			SecondLanguage:           result.SecondLanguage,
+			SecondShortCaption:       result.SecondShortCaption,
+			SecondDeepDive:           result.SecondDeepDive,
+			PromptVersion:            result.PromptVersion,
+			Model:                    captionModelName,
+			AudioS3Key:               result.AudioS3Key,
+			DeepDiveAudioS3Key:       result.DeepDiveAudioS3Key,
+			SecondAudioS3Key:         result.SecondAudioS3Key,
+			SecondDeepDiveAudioS3Key: result.SecondDeepDiveAudioS3Key,
+		}, cacheTTL)
 	}
 
 	// Return JSON response
@@ -0,0 +1,469 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	aiUsageCollection       = "ai_usage"
+	aiUsageTotalsCollection = "ai_usage_totals"
+	aiUsageLogSource        = "ai-usage"
+
+	// aiUsageUnattributed collects calls made without an explorer, such as
+	// like-feedback TTS from older app builds.
+	aiUsageUnattributed = "unattributed"
+
+	AIUsageKindCaption       = "caption"
+	AIUsageKindTTS           = "tts"
+	AIUsageKindTranscription = "transcription"
+	AIUsageKindModeration    = "moderation"
+
+	AIBudgetActionTextOnly = "text_only"
+	AIBudgetActionRefuse   = "refuse"
+)
+
+var usageMonthPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+// AIUsage is one model or TTS call in the ai_usage ledger. Costs are list
+// price estimates (captionModelPrices, ttsVoicePrice) stored in
+// micro-dollars so totals can be summed with integer increments.
+type AIUsage struct {
+	ExplorerID   string    `firestore:"explorerId"`
+	CompanionID  string    `firestore:"companionId,omitempty"`
+	Kind         string    `firestore:"kind"`
+	Model        string    `firestore:"model"`
+	Source       string    `firestore:"source"`
+	InputTokens  int64     `firestore:"inputTokens,omitempty"`
+	OutputTokens int64     `firestore:"outputTokens,omitempty"`
+	Characters   int64     `firestore:"characters,omitempty"`
+	LatencyMs    int64     `firestore:"latencyMs"`
+	CostMicros   int64     `firestore:"costMicros"`
+	Day          string    `firestore:"day"`
+	Month        string    `firestore:"month"`
+	CreatedAt    time.Time `firestore:"createdAt,serverTimestamp"`
+}
+
+// aiTokenPrice is USD per million input and output tokens.
+type aiTokenPrice struct {
+	Input, Output float64
+}
+
+// captionModelPrices are the public list prices of the Gemini models we
+// deploy for captions, transcription and moderation (audio input is billed
+// somewhat higher; text rates are close enough for budgets). Self-hosted and
+// fake models are free.
+var captionModelPrices = map[string]aiTokenPrice{
+	"gemini/gemini-2.5-flash-lite": {Input: 0.10, Output: 0.40},
+	"gemini/gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+	"gemini/gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+	"gemini/gemini-2.0-flash":      {Input: 0.10, Output: 0.40},
+}
+
+// ttsVoicePrice is USD per million characters by Google TTS voice tier.
+func ttsVoicePrice(voice string) float64 {
+	switch {
+	case strings.Contains(voice, "-Studio-"):
+		return 160
+	case strings.Contains(voice, "-Chirp3-HD-"), strings.Contains(voice, "-Journey-"):
+		return 30
+	case strings.Contains(voice, "-Standard-"):
+		return 4
+	default: // Neural2, WaveNet and Casual
+		return 16
+	}
+}
+
+// estimateCostMicros prices u from its kind, model and counts.
+func estimateCostMicros(u AIUsage) int64 {
+	var usd float64
+	switch u.Kind {
+	case AIUsageKindCaption, AIUsageKindTranscription, AIUsageKindModeration:
+		price := captionModelPrices[u.Model]
+		usd = (float64(u.InputTokens)*price.Input + float64(u.OutputTokens)*price.Output) / 1e6
+	case AIUsageKindTTS:
+		usd = float64(u.Characters) * ttsVoicePrice(u.Model) / 1e6
+	}
+	return int64(math.Round(usd * 1e6))
+}
+
+func usageTotalsDocID(explorerID, period string) string {
+	return explorerID + "_" + period
+}
+
+// RecordAIUsage appends u to the ai_usage ledger and adds it to the
+// explorer's daily and monthly totals. It also emits an ai-usage log entry,
+// which is all that happens when client is nil. Failures are logged and
+// never fail the caller's request.
+func RecordAIUsage(ctx context.Context, client *firestore.Client, u AIUsage) {
+	now := time.Now().UTC()
+	if u.ExplorerID == "" {
+		u.ExplorerID = aiUsageUnattributed
+	}
+	u.Day = now.Format("2006-01-02")
+	u.Month = now.Format("2006-01")
+	u.CostMicros = estimateCostMicros(u)
+
+	if data, err := json.Marshal(map[string]any{
+		"severity":    "INFO",
+		"source":      aiUsageLogSource,
+		"message":     "ai usage",
+		"explorer_id": u.ExplorerID,
+		"kind":        u.Kind,
+		"model":       u.Model,
+		"tokens_in":   u.InputTokens,
+		"tokens_out":  u.OutputTokens,
+		"characters":  u.Characters,
+		"latency_ms":  u.LatencyMs,
+		"cost_micros": u.CostMicros,
+	}); err == nil {
+		fmt.Fprintf(os.Stdout, "%s\n", data)
+	}
+	if client == nil {
+		return
+	}
+
+	increments := map[string]any{
+		"calls":        firestore.Increment(1),
+		"costMicros":   firestore.Increment(u.CostMicros),
+		"inputTokens":  firestore.Increment(u.InputTokens),
+		"outputTokens": firestore.Increment(u.OutputTokens),
+		"characters":   firestore.Increment(u.Characters),
+	}
+	batch := client.Batch()
+	batch.Create(client.Collection(aiUsageCollection).NewDoc(), u)
+	for _, period := range []string{u.Day, u.Month} {
+		totals := map[string]any{
+			"explorerId": u.ExplorerID,
+			"period":     period,
+			"updatedAt":  firestore.ServerTimestamp,
+			"byKind": map[string]any{
+				u.Kind: map[string]any{"calls": firestore.Increment(1), "costMicros": firestore.Increment(u.CostMicros)},
+			},
+		}
+		for k, v := range increments {
+			totals[k] = v
+		}
+		if u.CompanionID != "" {
+			totals["byCompanion"] = map[string]any{
+				u.CompanionID: map[string]any{"calls": firestore.Increment(1), "costMicros": firestore.Increment(u.CostMicros)},
+			}
+		}
+		batch.Set(client.Collection(aiUsageTotalsCollection).Doc(usageTotalsDocID(u.ExplorerID, period)), totals, firestore.MergeAll)
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		fmt.Printf("RecordAIUsage: %s %s for %s: %v\n", u.Kind, u.Model, u.ExplorerID, err)
+	}
+}
+
+// RecordCaptionUsage records a GenerateValidatedCaption call, including
+// failed ones, since rejected attempts are billed too.
+func RecordCaptionUsage(ctx context.Context, client *firestore.Client, u AIUsage, modelName string, result *CaptionResult) {
+	if result == nil || result.Attempts == 0 {
+		return
+	}
+	u.Kind = AIUsageKindCaption
+	u.Model = modelName
+	u.InputTokens = result.Usage.InputTokens
+	u.OutputTokens = result.Usage.OutputTokens
+	u.LatencyMs = result.Latency.Milliseconds()
+	RecordAIUsage(ctx, client, u)
+}
+
+// GenerateMeteredSpeech is GenerateSpeechWithOptions plus a ledger entry
+// for the characters synthesized, served from the synthesis cache when it
+// can be (see tts_cache.go). Only successful calls are recorded; Google
+// does not bill failed ones or cache hits.
+func GenerateMeteredSpeech(ctx context.Context, client *firestore.Client, u AIUsage, text string, opts SpeechOptions) ([]byte, error) {
+	speech, err := GenerateCachedSpeech(ctx, client, u, text, opts)
+	if speech == nil {
+		return nil, err
+	}
+	return speech.Audio, err
+}
+
+func generateMeteredSpeech(ctx context.Context, client *firestore.Client, u AIUsage, text string, opts SpeechOptions) ([]byte, error) {
+	start := time.Now()
+	speechData, err := GenerateSpeechWithOptions(text, opts)
+	if err != nil || len(speechData) == 0 {
+		return speechData, err
+	}
+	u.Kind = AIUsageKindTTS
+	u.Model, _ = resolveGoogleTTSVoice(opts.VoiceName, opts.LanguageCode)
+	u.Characters = int64(utf8.RuneCountInString(text))
+	u.LatencyMs = time.Since(start).Milliseconds()
+	RecordAIUsage(ctx, client, u)
+	return speechData, nil
+}
+
+// AIBudget limits an explorer's estimated AI spend. Zero limits are
+// unlimited. Action says what happens once a limit is reached: text_only
+// keeps captioning but skips speech synthesis, refuse rejects the call.
+type AIBudget struct {
+	DailyUSD   float64 `json:"daily_usd"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+	Action     string  `json:"action"`
+}
+
+func parseBudgetUSD(raw string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
+
+func normalizeBudgetAction(action string) string {
+	if strings.TrimSpace(action) == AIBudgetActionRefuse {
+		return AIBudgetActionRefuse
+	}
+	return AIBudgetActionTextOnly
+}
+
+// aiBudgetFromEnv reads the deployment-wide defaults AI_BUDGET_DAILY_USD,
+// AI_BUDGET_MONTHLY_USD and AI_BUDGET_ACTION.
+func aiBudgetFromEnv() AIBudget {
+	return AIBudget{
+		DailyUSD:   parseBudgetUSD(os.Getenv("AI_BUDGET_DAILY_USD")),
+		MonthlyUSD: parseBudgetUSD(os.Getenv("AI_BUDGET_MONTHLY_USD")),
+		Action:     normalizeBudgetAction(os.Getenv("AI_BUDGET_ACTION")),
+	}
+}
+
+// loadAIBudget returns the explorer's budget: the environment defaults,
+// overridden field by field by explorers/{id}.settings.ai_budget.
+func loadAIBudget(ctx context.Context, client *firestore.Client, explorerID string) AIBudget {
+	budget := aiBudgetFromEnv()
+	snap, err := client.Collection("explorers").Doc(explorerID).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			fmt.Printf("loadAIBudget: explorer %s: %v\n", explorerID, err)
+		}
+		return budget
+	}
+	settings, _ := snap.Data()["settings"].(map[string]any)
+	custom, _ := settings["ai_budget"].(map[string]any)
+	for key, field := range map[string]*float64{"daily_usd": &budget.DailyUSD, "monthly_usd": &budget.MonthlyUSD} {
+		switch v := custom[key].(type) {
+		case float64:
+			*field = max(v, 0)
+		case int64:
+			*field = max(float64(v), 0)
+		}
+	}
+	if action, ok := custom["action"].(string); ok {
+		budget.Action = normalizeBudgetAction(action)
+	}
+	return budget
+}
+
+// aiBudgetCheck is the outcome of checkAIBudget. Action is empty while the
+// explorer is within budget.
+type aiBudgetCheck struct {
+	Action string
+	Reason string
+}
+
+// aiBudgetLimit is one period's cap and what has been spent against it.
+type aiBudgetLimit struct {
+	period      string
+	usd         float64
+	spentMicros int64
+}
+
+// exceededAIBudget returns the check for the first limit whose spend has
+// reached its cap. Zero caps are unlimited.
+func exceededAIBudget(action string, limits []aiBudgetLimit) aiBudgetCheck {
+	for _, limit := range limits {
+		if limit.usd == 0 || float64(limit.spentMicros) < limit.usd*1e6 {
+			continue
+		}
+		return aiBudgetCheck{
+			Action: action,
+			Reason: fmt.Sprintf("AI budget of $%.2f for %s reached ($%.2f spent)", limit.usd, limit.period, float64(limit.spentMicros)/1e6),
+		}
+	}
+	return aiBudgetCheck{}
+}
+
+// checkAIBudget compares the explorer's spend so far today and this month
+// against its budget. Read errors allow the call: an unavailable ledger must
+// not take captioning down.
+func checkAIBudget(ctx context.Context, client *firestore.Client, explorerID string) aiBudgetCheck {
+	if client == nil {
+		return aiBudgetCheck{}
+	}
+	budget := loadAIBudget(ctx, client, explorerID)
+	if budget.DailyUSD == 0 && budget.MonthlyUSD == 0 {
+		return aiBudgetCheck{}
+	}
+	now := time.Now().UTC()
+	limits := []aiBudgetLimit{
+		{period: now.Format("2006-01-02"), usd: budget.DailyUSD},
+		{period: now.Format("2006-01"), usd: budget.MonthlyUSD},
+	}
+	for i := range limits {
+		if limits[i].usd == 0 {
+			continue
+		}
+		snap, err := client.Collection(aiUsageTotalsCollection).Doc(usageTotalsDocID(explorerID, limits[i].period)).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("checkAIBudget: %s %s: %v\n", explorerID, limits[i].period, err)
+			continue
+		}
+		limits[i].spentMicros, _ = snap.Data()["costMicros"].(int64)
+	}
+	return exceededAIBudget(budget.Action, limits)
+}
+
+// aiUsageBreakdown is calls and cost for one kind or companion.
+type aiUsageBreakdown struct {
+	Calls   int64   `json:"calls"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// aiUsageCircleReport is one explorer's totals for the report month.
+type aiUsageCircleReport struct {
+	ExplorerID   string                      `json:"explorer_id"`
+	Calls        int64                       `json:"calls"`
+	CostUSD      float64                     `json:"cost_usd"`
+	InputTokens  int64                       `json:"input_tokens"`
+	OutputTokens int64                       `json:"output_tokens"`
+	Characters   int64                       `json:"characters"`
+	ByKind       map[string]aiUsageBreakdown `json:"by_kind"`
+	ByCompanion  map[string]aiUsageBreakdown `json:"by_companion"`
+}
+
+func usageBreakdowns(raw any) map[string]aiUsageBreakdown {
+	out := map[string]aiUsageBreakdown{}
+	entries, _ := raw.(map[string]any)
+	for key, v := range entries {
+		fields, _ := v.(map[string]any)
+		calls, _ := fields["calls"].(int64)
+		cost, _ := fields["costMicros"].(int64)
+		out[key] = aiUsageBreakdown{Calls: calls, CostUSD: float64(cost) / 1e6}
+	}
+	return out
+}
+
+func circleReportFromTotals(data map[string]any) aiUsageCircleReport {
+	report := aiUsageCircleReport{
+		ByKind:      usageBreakdowns(data["byKind"]),
+		ByCompanion: usageBreakdowns(data["byCompanion"]),
+	}
+	report.ExplorerID, _ = data["explorerId"].(string)
+	report.Calls, _ = data["calls"].(int64)
+	cost, _ := data["costMicros"].(int64)
+	report.CostUSD = float64(cost) / 1e6
+	report.InputTokens, _ = data["inputTokens"].(int64)
+	report.OutputTokens, _ = data["outputTokens"].(int64)
+	report.Characters, _ = data["characters"].(int64)
+	return report
+}
+
+// GetAIUsageReport returns estimated AI cost by circle for one month
+// (?month=YYYY-MM, default the current UTC month). With explorer_id it
+// reports that circle and is open to its owners and admins; without it,
+// it lists every circle and requires an audit admin.
+func GetAIUsageReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	}
+	if !usageMonthPattern.MatchString(month) {
+		http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	explorerID := strings.TrimSpace(r.URL.Query().Get("explorer_id"))
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	circles := []aiUsageCircleReport{}
+	if explorerID != "" {
+		if !isAuditAdmin(token) {
+			if _, err := requireExplorerAdmin(ctx, client, explorerID, token.UID); err != nil {
+				http.Error(w, "explorer admin access required", http.StatusForbidden)
+				return
+			}
+		}
+		snap, err := client.Collection(aiUsageTotalsCollection).Doc(usageTotalsDocID(explorerID, month)).Get(ctx)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			http.Error(w, "usage lookup failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		default:
+			circles = append(circles, circleReportFromTotals(snap.Data()))
+		}
+	} else {
+		if !isAuditAdmin(token) {
+			http.Error(w, "admin access required", http.StatusForbidden)
+			return
+		}
+		iter := client.Collection(aiUsageTotalsCollection).Where("period", "==", month).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				fmt.Printf("GetAIUsageReport: query failed: %v\n", err)
+				http.Error(w, "usage query failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			circles = append(circles, circleReportFromTotals(doc.Data()))
+		}
+	}
+	sort.Slice(circles, func(i, j int) bool { return circles[i].CostUSD > circles[j].CostUSD })
+
+	var total float64
+	for _, c := range circles {
+		total += c.CostUSD
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"month":          month,
+		"total_cost_usd": total,
+		"circles":        circles,
+	})
+}
@@ -0,0 +1,70 @@
+package functions
+
+import "testing"
+
+func TestEstimateCostMicros(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage AIUsage
+		want  int64
+	}{
+		{"flash caption", AIUsage{Kind: AIUsageKindCaption, Model: "gemini/gemini-2.5-flash", InputTokens: 1_000_000, OutputTokens: 1_000_000}, 2_800_000},
+		{"flash-lite caption", AIUsage{Kind: AIUsageKindCaption, Model: "gemini/gemini-2.5-flash-lite", InputTokens: 1200, OutputTokens: 300}, 240},
+		{"flash-lite moderation", AIUsage{Kind: AIUsageKindModeration, Model: "gemini/gemini-2.5-flash-lite", InputTokens: 1200, OutputTokens: 300}, 240},
+		{"unpriced model is free", AIUsage{Kind: AIUsageKindCaption, Model: "openai/llava", InputTokens: 5000, OutputTokens: 500}, 0},
+		{"journey voice", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Journey-O", Characters: 1000}, 30_000},
+		{"chirp voice", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Chirp3-HD-Aoede", Characters: 1000}, 30_000},
+		{"studio voice", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Studio-O", Characters: 1000}, 160_000},
+		{"standard voice", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Standard-C", Characters: 1000}, 4_000},
+		{"neural voice", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Neural2-F", Characters: 1000}, 16_000},
+		{"rounds to the nearest micro-dollar", AIUsage{Kind: AIUsageKindTTS, Model: "en-US-Standard-C", Characters: 1}, 4},
+		{"unknown kind", AIUsage{Kind: "embedding", Model: "gemini/gemini-2.5-flash", InputTokens: 1000}, 0},
+	}
+	for _, tt := range tests {
+		if got := estimateCostMicros(tt.usage); got != tt.want {
+			t.Errorf("%s: estimateCostMicros = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExceededAIBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     []aiBudgetLimit
+		wantAction string
+	}{
+		{"no limits", nil, ""},
+		{"under both", []aiBudgetLimit{{"2026-10-19", 1, 999_999}, {"2026-10", 10, 5_000_000}}, ""},
+		{"daily reached exactly", []aiBudgetLimit{{"2026-10-19", 1, 1_000_000}, {"2026-10", 10, 5_000_000}}, AIBudgetActionRefuse},
+		{"monthly over", []aiBudgetLimit{{"2026-10-19", 1, 0}, {"2026-10", 10, 12_000_000}}, AIBudgetActionRefuse},
+		{"zero cap is unlimited", []aiBudgetLimit{{"2026-10-19", 0, 50_000_000}}, ""},
+	}
+	for _, tt := range tests {
+		got := exceededAIBudget(AIBudgetActionRefuse, tt.limits)
+		if got.Action != tt.wantAction {
+			t.Errorf("%s: Action = %q, want %q", tt.name, got.Action, tt.wantAction)
+		}
+		if (got.Reason != "") != (tt.wantAction != "") {
+			t.Errorf("%s: Reason = %q", tt.name, got.Reason)
+		}
+	}
+}
+
+func TestAIBudgetFromEnv(t *testing.T) {
+	tests := []struct {
+		daily, monthly, action string
+		want                   AIBudget
+	}{
+		{"", "", "", AIBudget{Action: AIBudgetActionTextOnly}},
+		{"2.5", " 40 ", "refuse", AIBudget{DailyUSD: 2.5, MonthlyUSD: 40, Action: AIBudgetActionRefuse}},
+		{"-1", "lots", "shout", AIBudget{Action: AIBudgetActionTextOnly}},
+	}
+	for _, tt := range tests {
+		t.Setenv("AI_BUDGET_DAILY_USD", tt.daily)
+		t.Setenv("AI_BUDGET_MONTHLY_USD", tt.monthly)
+		t.Setenv("AI_BUDGET_ACTION", tt.action)
+		if got := aiBudgetFromEnv(); got != tt.want {
+			t.Errorf("aiBudgetFromEnv(%q, %q, %q) = %+v, want %+v", tt.daily, tt.monthly, tt.action, got, tt.want)
+		}
+	}
+}
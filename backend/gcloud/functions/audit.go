@@ -0,0 +1,342 @@
+package functions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	auditLogCollection = "audit_log"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDenied  = "denied"
+
+	auditActorDeletionWorker = "system:deletion-worker"
+
+	// auditTargetsPerRecord keeps a run's records well under the Firestore
+	// document size limit; larger runs are split across several records
+	// sharing one request ID.
+	auditTargetsPerRecord = 400
+
+	defaultAuditQueryLimit = 50
+	maxAuditQueryLimit     = 500
+)
+
+// AuditRecord is one append-only entry in audit_log. Targets are document
+// paths (reflections/{id}) or S3 keys so "array-contains" queries can answer
+// who touched a given object.
+type AuditRecord struct {
+	Actor      string         `firestore:"actor" json:"actor"`
+	Action     string         `firestore:"action" json:"action"`
+	Targets    []string       `firestore:"targets" json:"targets"`
+	RequestID  string         `firestore:"requestId" json:"request_id"`
+	Outcome    string         `firestore:"outcome" json:"outcome"`
+	Error      string         `firestore:"error,omitempty" json:"error,omitempty"`
+	BeforeHash string         `firestore:"beforeHash,omitempty" json:"before_hash,omitempty"`
+	Details    map[string]any `firestore:"details,omitempty" json:"details,omitempty"`
+	CreatedAt  time.Time      `firestore:"createdAt,serverTimestamp" json:"created_at"`
+}
+
+// WriteAuditRecord appends rec to audit_log. Records are only ever created
+// under fresh IDs, never updated, and the collection is closed to clients.
+func WriteAuditRecord(ctx context.Context, client *firestore.Client, rec AuditRecord) error {
+	if rec.RequestID == "" {
+		rec.RequestID = NewAuditRequestID()
+	}
+	if rec.Targets == nil {
+		rec.Targets = []string{}
+	}
+	if _, err := client.Collection(auditLogCollection).NewDoc().Create(ctx, rec); err != nil {
+		return fmt.Errorf("write audit record %s: %w", rec.Action, err)
+	}
+	return nil
+}
+
+// writeAuditRecord is the HTTP-handler form: audit failures are logged but do
+// not fail the request that has already done its work.
+func writeAuditRecord(ctx context.Context, client *firestore.Client, rec AuditRecord) {
+	if err := WriteAuditRecord(ctx, client, rec); err != nil {
+		fmt.Printf("audit: %v\n", err)
+	}
+}
+
+// AuditBeforeHash returns a stable SHA-256 of v's JSON encoding (maps are
+// encoded with sorted keys), so a record can later prove what state an
+// action started from without storing the state itself.
+func AuditBeforeHash(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", v))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAuditRequestID returns a random ID for actions that do not arrive with
+// one, such as command-line runs.
+func NewAuditRequestID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// auditRequestID takes the trace ID Cloud Functions attaches to each request
+// so audit records line up with the request's logs.
+func auditRequestID(r *http.Request) string {
+	if tp := r.Header.Get("traceparent"); tp != "" {
+		if parts := strings.Split(tp, "-"); len(parts) >= 2 && parts[1] != "" {
+			return parts[1]
+		}
+	}
+	if tc := r.Header.Get("X-Cloud-Trace-Context"); tc != "" {
+		if id, _, _ := strings.Cut(tc, "/"); id != "" {
+			return id
+		}
+	}
+	return NewAuditRequestID()
+}
+
+// docBeforeHash hashes a document's current data for an audit record,
+// returning "" when it does not exist or cannot be read.
+func docBeforeHash(ctx context.Context, ref *firestore.DocumentRef) string {
+	snap, err := ref.Get(ctx)
+	if err != nil || !snap.Exists() {
+		return ""
+	}
+	return AuditBeforeHash(snap.Data())
+}
+
+func auditOutcome(err error) (string, string) {
+	if err != nil {
+		return AuditOutcomeFailure, err.Error()
+	}
+	return AuditOutcomeSuccess, ""
+}
+
+// AuditRun collects the targets of a batch operation (a backfill -apply
+// run) and writes them as one or more audit records when the run finishes.
+type AuditRun struct {
+	client    *firestore.Client
+	actor     string
+	action    string
+	requestID string
+	details   map[string]any
+	targets   []string
+	hashes    []string
+}
+
+// StartAuditRun begins collecting an audit trail for action. A nil client
+// (dry runs) makes every method a no-op.
+func StartAuditRun(client *firestore.Client, actor, action string, details map[string]any) *AuditRun {
+	return &AuditRun{
+		client:    client,
+		actor:     actor,
+		action:    action,
+		requestID: NewAuditRequestID(),
+		details:   details,
+	}
+}
+
+// Touch records that target was changed from the before state. Call it
+// after a successful write so the record lists only what actually changed.
+func (a *AuditRun) Touch(target string, before any) {
+	if a == nil || a.client == nil {
+		return
+	}
+	a.targets = append(a.targets, target)
+	a.hashes = append(a.hashes, AuditBeforeHash(before))
+}
+
+// Finish writes the run's records with the outcome implied by runErr.
+func (a *AuditRun) Finish(ctx context.Context, runErr error) error {
+	if a == nil || a.client == nil {
+		return nil
+	}
+	outcome, errText := auditOutcome(runErr)
+	parts := max(1, (len(a.targets)+auditTargetsPerRecord-1)/auditTargetsPerRecord)
+	for part := range parts {
+		start := part * auditTargetsPerRecord
+		end := min(start+auditTargetsPerRecord, len(a.targets))
+		details := map[string]any{"part": part + 1, "parts": parts, "totalTargets": len(a.targets)}
+		for k, v := range a.details {
+			details[k] = v
+		}
+		rec := AuditRecord{
+			Actor:      a.actor,
+			Action:     a.action,
+			Targets:    a.targets[start:end],
+			RequestID:  a.requestID,
+			Outcome:    outcome,
+			Error:      errText,
+			BeforeHash: AuditBeforeHash(a.hashes[start:end]),
+			Details:    details,
+		}
+		if err := WriteAuditRecord(ctx, a.client, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fatalf records the run as failed and exits, for command-line tools that
+// would otherwise call log.Fatalf mid-run.
+func (a *AuditRun) Fatalf(ctx context.Context, format string, args ...any) {
+	runErr := fmt.Errorf(format, args...)
+	if err := a.Finish(ctx, runErr); err != nil {
+		log.Printf("audit log write failed: %v", err)
+	}
+	log.Fatal(runErr)
+}
+
+// AuditDocTarget formats a document reference as an audit target, e.g.
+// "reflections/1712345678901", matching the paths HTTP handlers record.
+func AuditDocTarget(ref *firestore.DocumentRef) string {
+	if _, rel, ok := strings.Cut(ref.Path, "/documents/"); ok {
+		return rel
+	}
+	return ref.Path
+}
+
+// CLIAuditActor identifies whoever is running a command-line tool.
+func CLIAuditActor() string {
+	for _, key := range []string{"AUDIT_ACTOR", "USER", "USERNAME"} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return "cli:" + v
+		}
+	}
+	return "cli:unknown"
+}
+
+// isAuditAdmin allows callers with an "admin" custom claim or a UID listed in
+// AUDIT_ADMIN_UIDS (comma-separated).
+func isAuditAdmin(token *auth.Token) bool {
+	if admin, _ := token.Claims["admin"].(bool); admin {
+		return true
+	}
+	for _, uid := range strings.Split(os.Getenv("AUDIT_ADMIN_UIDS"), ",") {
+		if strings.TrimSpace(uid) == token.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageDeletionJob allows the companions a deletion job belongs to
+// (owners, typically its subject and whoever requested it) and audit admins.
+func canManageDeletionJob(token *auth.Token, owners ...string) bool {
+	for _, uid := range owners {
+		if uid != "" && uid == token.UID {
+			return true
+		}
+	}
+	return isAuditAdmin(token)
+}
+
+// QueryAuditLog is the admin HTTP endpoint for reading audit_log, newest
+// first. Filters: target (exact document path or S3 key), actor, action,
+// request_id, since / until (RFC 3339) and limit. For example
+// ?target=reflections/1712345678901 answers who deleted that Reflection and
+// when.
+func QueryAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if !isAuditAdmin(token) {
+		http.Error(w, "admin access required", http.StatusForbidden)
+		return
+	}
+
+	params := r.URL.Query()
+	limit := defaultAuditQueryLimit
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAuditQueryLimit)
+	}
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	q := client.Collection(auditLogCollection).Query
+	if target := params.Get("target"); target != "" {
+		q = q.Where("targets", "array-contains", target)
+	}
+	for param, field := range map[string]string{"actor": "actor", "action": "action", "request_id": "requestId"} {
+		if v := params.Get(param); v != "" {
+			q = q.Where(field, "==", v)
+		}
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<="} {
+		raw := params.Get(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(w, param+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		q = q.Where("createdAt", op, t)
+	}
+
+	records := []AuditRecord{}
+	iter := q.OrderBy("createdAt", firestore.Desc).Limit(limit).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			fmt.Printf("QueryAuditLog: query failed: %v\n", err)
+			http.Error(w, "audit query failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var rec AuditRecord
+		if err := doc.DataTo(&rec); err != nil {
+			fmt.Printf("QueryAuditLog: skipping undecodable record %s: %v\n", doc.Ref.ID, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	fmt.Printf("QueryAuditLog: %s read %d record(s) (%s)\n", token.UID, len(records), r.URL.RawQuery)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"records": records})
+}
@@ -0,0 +1,78 @@
+package functions
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+)
+
+func TestAuditBeforeHash(t *testing.T) {
+	a := AuditBeforeHash(map[string]any{"sender": "Granddad", "status": "ready"})
+	b := AuditBeforeHash(map[string]any{"status": "ready", "sender": "Granddad"})
+	if a != b {
+		t.Errorf("AuditBeforeHash depends on map order: %q vs %q", a, b)
+	}
+	if c := AuditBeforeHash(map[string]any{"sender": "Granddad", "status": "deleted"}); c == a {
+		t.Error("AuditBeforeHash ignores a changed value")
+	}
+	if len(a) != 64 {
+		t.Errorf("AuditBeforeHash length = %d, want a hex SHA-256", len(a))
+	}
+}
+
+func TestAuditRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"traceparent", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"cloud trace context", map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"}, "105445aa7843bc8bf206b12000100000"},
+		{"traceparent wins", map[string]string{"traceparent": "00-aaa-bbb-01", "X-Cloud-Trace-Context": "ccc/1"}, "aaa"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := auditRequestID(r); got != tt.want {
+			t.Errorf("%s: auditRequestID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	generated := auditRequestID(httptest.NewRequest("GET", "/", nil))
+	if generated == "" || generated == auditRequestID(httptest.NewRequest("GET", "/", nil)) {
+		t.Errorf("auditRequestID without trace headers should be fresh and non-empty, got %q", generated)
+	}
+}
+
+func TestAuditDocTarget(t *testing.T) {
+	ref := &firestore.DocumentRef{Path: "projects/reflections-1200b/databases/(default)/documents/reflections/1712345678901"}
+	if got := AuditDocTarget(ref); got != "reflections/1712345678901" {
+		t.Errorf("AuditDocTarget = %q, want %q", got, "reflections/1712345678901")
+	}
+}
+
+func TestCanManageDeletionJob(t *testing.T) {
+	t.Setenv("AUDIT_ADMIN_UIDS", "ops-1, ops-2")
+	tests := []struct {
+		name   string
+		token  *auth.Token
+		owners []string
+		want   bool
+	}{
+		{"owner", &auth.Token{UID: "companion-1"}, []string{"companion-1"}, true},
+		{"requester", &auth.Token{UID: "companion-2"}, []string{"companion-1", "companion-2"}, true},
+		{"stranger", &auth.Token{UID: "companion-3"}, []string{"companion-1", "companion-2"}, false},
+		{"empty owner never matches", &auth.Token{UID: ""}, []string{""}, false},
+		{"admin listed in AUDIT_ADMIN_UIDS", &auth.Token{UID: "ops-2"}, []string{"companion-1"}, true},
+		{"admin claim", &auth.Token{UID: "someone", Claims: map[string]any{"admin": true}}, []string{"companion-1"}, true},
+	}
+	for _, tt := range tests {
+		if got := canManageDeletionJob(tt.token, tt.owners...); got != tt.want {
+			t.Errorf("%s: canManageDeletionJob = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/auth"
+)
+
+var (
+	firebaseAuthOnce   sync.Once
+	firebaseAuthClient *auth.Client
+	firebaseAuthErr    error
+)
+
+func getFirebaseAuth(ctx context.Context) (*auth.Client, error) {
+	firebaseAuthOnce.Do(func() {
+		projectID := os.Getenv("GCP_PROJECT")
+		if projectID == "" {
+			projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+		}
+		if projectID == "" {
+			projectID = "reflections-1200b"
+		}
+		conf := &firebase.Config{ProjectID: projectID}
+		app, err := firebase.NewApp(ctx, conf)
+		if err != nil {
+			firebaseAuthErr = fmt.Errorf("firebase app: %w", err)
+			return
+		}
+		firebaseAuthClient, firebaseAuthErr = app.Auth(ctx)
+	})
+	return firebaseAuthClient, firebaseAuthErr
+}
+
+// verifyBearerUID verifies the Firebase ID token in the Authorization header
+// and returns the caller's UID. On failure it also returns the HTTP status
+// the handler should respond with.
+func verifyBearerUID(r *http.Request) (string, int, error) {
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		return "", code, err
+	}
+	return token.UID, code, nil
+}
+
+// verifyBearerToken is verifyBearerUID for handlers that also need the
+// token's custom claims.
+func verifyBearerToken(r *http.Request) (*auth.Token, int, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+	idToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if idToken == "" {
+		return nil, http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+
+	ctx := r.Context()
+	authClient, err := getFirebaseAuth(ctx)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("auth init failed")
+	}
+	decoded, err := authClient.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("invalid token")
+	}
+	return decoded, http.StatusOK, nil
+}
@@ -0,0 +1,143 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	captionCacheCollection = "caption_cache"
+
+	// Cached TTS keys live under staging/, which the bucket lifecycle rule
+	// empties after a day, so entries must expire before their audio does.
+	defaultCaptionCacheTTL = 20 * time.Hour
+	maxCaptionCacheTTL     = 23 * time.Hour
+)
+
+// captionCacheEntry is one caption_cache document. expiresAt backs the
+// collection's Firestore TTL policy; lookups also check it because TTL
+// deletion can lag by a day.
+type captionCacheEntry struct {
+	ExplorerID          string   `firestore:"explorerId"`
+	ShortCaption        string   `firestore:"shortCaption"`
+	DeepDive            string   `firestore:"deepDive"`
+	DetectedPeople      []string `firestore:"detectedPeople"`
+	SafetyFlags         []string `firestore:"safetyFlags"`
+	VoiceNoteTranscript string   `firestore:"voiceNoteTranscript,omitempty"`
+	// Moderation is the flag raised when the caption was screened; nil when
+	// it was allowed. Quarantined captions are never cached.
+	Moderation *ModerationResult `firestore:"moderation,omitempty"`
+	// SecondLanguage is the extra rendering's language; "" when there is none.
+	SecondLanguage           string    `firestore:"secondLanguage,omitempty"`
+	SecondShortCaption       string    `firestore:"secondShortCaption,omitempty"`
+	SecondDeepDive           string    `firestore:"secondDeepDive,omitempty"`
+	PromptVersion            string    `firestore:"promptVersion"`
+	Model                    string    `firestore:"model"`
+	AudioS3Key               string    `firestore:"audioS3Key,omitempty"`
+	DeepDiveAudioS3Key       string    `firestore:"deepDiveAudioS3Key,omitempty"`
+	SecondAudioS3Key         string    `firestore:"secondAudioS3Key,omitempty"`
+	SecondDeepDiveAudioS3Key string    `firestore:"secondDeepDiveAudioS3Key,omitempty"`
+	CreatedAt                time.Time `firestore:"createdAt,serverTimestamp"`
+	ExpiresAt                time.Time `firestore:"expiresAt"`
+}
+
+// captionCacheTTL reads CAPTION_CACHE_TTL_HOURS, capped below the staging
+// lifecycle. Zero disables the cache.
+func captionCacheTTL() time.Duration {
+	raw := os.Getenv("CAPTION_CACHE_TTL_HOURS")
+	if raw == "" {
+		return defaultCaptionCacheTTL
+	}
+	hours, err := strconv.ParseFloat(raw, 64)
+	if err != nil || hours < 0 {
+		return defaultCaptionCacheTTL
+	}
+	return min(time.Duration(hours*float64(time.Hour)), maxCaptionCacheTTL)
+}
+
+// captionCacheKey identifies a caption by image content, the prompt that
+// produced it (including any second language) and the voices and glossary
+// pronunciations its audio used. The rendered prompt is hashed alongside its
+// version because template variables (sender, people context) change the
+// caption without changing the version. Keys are scoped to the explorer so
+// circles never share captions.
+func captionCacheKey(explorerID string, image []byte, prompt RenderedPrompt, model, captionVoice, deepDiveVoice string, pronunciations map[string]string) string {
+	imageSum := sha256.Sum256(image)
+	promptSum := sha256.Sum256([]byte(prompt.Text))
+	parts := []string{
+		explorerID,
+		hex.EncodeToString(imageSum[:]),
+		prompt.Version,
+		hex.EncodeToString(promptSum[:]),
+		model,
+		captionVoice,
+		deepDiveVoice,
+		pronunciationsDigest(pronunciations),
+	}
+	// Only appended when set, so keys without a second language are stable.
+	if prompt.Languages.Second != "" {
+		parts = append(parts, prompt.Languages.Second)
+	}
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s|", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pronunciationsDigest identifies the glossary pronunciations cached audio
+// was spoken with; "" when there are none, so keys without them are stable.
+func pronunciationsDigest(pronunciations map[string]string) string {
+	if len(pronunciations) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(pronunciations))
+	for name := range pronunciations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%d:%s=%d:%s|", len(name), name, len(pronunciations[name]), pronunciations[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupCaptionCache returns the live entry for key, or nil on a miss or
+// any read error (the cache is an optimisation, never a dependency).
+func lookupCaptionCache(ctx context.Context, client *firestore.Client, key string) *captionCacheEntry {
+	snap, err := client.Collection(captionCacheCollection).Doc(key).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("captionCache: lookup %s failed: %v\n", key, err)
+		return nil
+	}
+	var entry captionCacheEntry
+	if err := snap.DataTo(&entry); err != nil {
+		fmt.Printf("captionCache: decode %s failed: %v\n", key, err)
+		return nil
+	}
+	if !entry.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &entry
+}
+
+func storeCaptionCache(ctx context.Context, client *firestore.Client, key string, entry captionCacheEntry, ttl time.Duration) {
+	entry.ExpiresAt = time.Now().Add(ttl)
+	if _, err := client.Collection(captionCacheCollection).Doc(key).Set(ctx, entry); err != nil {
+		fmt.Printf("captionCache: store %s failed: %v\n", key, err)
+	}
+}
@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaptionCacheKey(t *testing.T) {
+	image := []byte("jpeg bytes")
+	prompt := RenderedPrompt{Text: "Describe this for Sam.", Version: "caption@v2"}
+	base := captionCacheKey("explorer-1", image, prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil)
+
+	if again := captionCacheKey("explorer-1", []byte("jpeg bytes"), prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil); again != base {
+		t.Fatalf("captionCacheKey is not stable: %q vs %q", base, again)
+	}
+
+	variants := map[string]string{
+		"explorer":        captionCacheKey("explorer-2", image, prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil),
+		"image":           captionCacheKey("explorer-1", []byte("other bytes"), prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil),
+		"prompt text":     captionCacheKey("explorer-1", image, RenderedPrompt{Text: "Describe this for Grandma.", Version: "caption@v2"}, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil),
+		"prompt version":  captionCacheKey("explorer-1", image, RenderedPrompt{Text: prompt.Text, Version: "caption@v3"}, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", nil),
+		"model":           captionCacheKey("explorer-1", image, prompt, "gemini-2.5-pro", "en-US-Journey-O", "en-US-Journey-F", nil),
+		"caption voice":   captionCacheKey("explorer-1", image, prompt, "gemini-2.5-flash", "en-US-Journey-D", "en-US-Journey-F", nil),
+		"deep dive voice": captionCacheKey("explorer-1", image, prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-D", nil),
+		// Length-prefixing keeps "ab"+"c" and "a"+"bc" apart.
+		"pronunciations": captionCacheKey("explorer-1", image, prompt, "gemini-2.5-flash", "en-US-Journey-O", "en-US-Journey-F", map[string]string{"Nona": "NOH-nah"}),
+		"shifted voices": captionCacheKey("explorer-1", image, prompt, "gemini-2.5-flash", "en-US-Journey-O"+"en-US", "-Journey-F", nil),
+	}
+	for changed, key := range variants {
+		if key == base {
+			t.Errorf("changing the %s did not change the key", changed)
+		}
+	}
+}
+
+func TestPronunciationsDigest(t *testing.T) {
+	if got := pronunciationsDigest(nil); got != "" {
+		t.Errorf("pronunciationsDigest(nil) = %q, want empty", got)
+	}
+	a := pronunciationsDigest(map[string]string{"Nona": "NOH-nah", "Dante": "DAHN-tay"})
+	b := pronunciationsDigest(map[string]string{"Dante": "DAHN-tay", "Nona": "NOH-nah"})
+	if a == "" || a != b {
+		t.Errorf("pronunciationsDigest depends on map order: %q vs %q", a, b)
+	}
+	if c := pronunciationsDigest(map[string]string{"Nona": "NO-nah", "Dante": "DAHN-tay"}); c == a {
+		t.Error("changing a pronunciation did not change the digest")
+	}
+}
+
+func TestCaptionCacheTTL(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":      defaultCaptionCacheTTL,
+		"6":     6 * time.Hour,
+		"0.5":   30 * time.Minute,
+		"0":     0,
+		"48":    maxCaptionCacheTTL,
+		"-1":    defaultCaptionCacheTTL,
+		"never": defaultCaptionCacheTTL,
+	}
+	for raw, want := range tests {
+		t.Setenv("CAPTION_CACHE_TTL_HOURS", raw)
+		if got := captionCacheTTL(); got != want {
+			t.Errorf("captionCacheTTL with %q = %s, want %s", raw, got, want)
+		}
+	}
+}
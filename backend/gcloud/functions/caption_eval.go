@@ -0,0 +1,120 @@
+package functions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CaptionEvalCase is one golden image for cmd/captioneval: the context a
+// Companion would have given for it and what a good caption must satisfy.
+type CaptionEvalCase struct {
+	Name string `json:"name"`
+	// Image is the file name, relative to the cases file.
+	Image           string `json:"image"`
+	ExplorerName    string `json:"explorer_name"`
+	SenderName      string `json:"sender_name,omitempty"`
+	SenderInImage   bool   `json:"sender_in_image,omitempty"`
+	ExplorerInImage bool   `json:"explorer_in_image,omitempty"`
+	PeopleContext   string `json:"people_context,omitempty"`
+	// Profile is an output profile name (see caption_profile.go); empty is
+	// standard. Vocabulary is the core_vocabulary word list, if any.
+	Profile    string              `json:"profile,omitempty"`
+	Vocabulary []string            `json:"vocabulary,omitempty"`
+	Expect     CaptionExpectations `json:"expect"`
+}
+
+// CaptionExpectations are checks on top of the rules every caption is held
+// to (ValidateCaption and the case's output profile). Zero values check
+// nothing.
+type CaptionExpectations struct {
+	MaxShortCaptionWords int `json:"max_short_caption_words,omitempty"`
+	MaxDeepDiveWords     int `json:"max_deep_dive_words,omitempty"`
+	// RequiredNames must each appear in short_caption or deep_dive.
+	RequiredNames []string `json:"required_names,omitempty"`
+	// ForbiddenWords must appear in neither, e.g. a name the family does
+	// not use or a diagnosis.
+	ForbiddenWords []string `json:"forbidden_words,omitempty"`
+}
+
+// PromptVars returns the template variables the case describes.
+func (tc CaptionEvalCase) PromptVars() PromptVars {
+	return PromptVars{
+		ExplorerName:    tc.ExplorerName,
+		SenderName:      tc.SenderName,
+		SenderInImage:   tc.SenderInImage,
+		ExplorerInImage: tc.ExplorerInImage,
+		PeopleContext:   tc.PeopleContext,
+	}
+}
+
+// OutputProfile returns the case's output profile.
+func (tc CaptionEvalCase) OutputProfile() CaptionOutputProfile {
+	return captionOutputProfileFor(tc.Profile, tc.Vocabulary, tc.PromptVars())
+}
+
+// RenderEvalPrompt renders a caption prompt template body for tc, the way
+// resolveCaptionPrompt would for an explorer with the case's profile and no
+// glossary. An empty body renders the built-in prompt.
+func RenderEvalPrompt(body string, tc CaptionEvalCase) (string, error) {
+	tmpl := builtinCaptionTemplate
+	if body != "" {
+		var err error
+		if tmpl, err = parsePromptTemplate("eval", body); err != nil {
+			return "", fmt.Errorf("parse prompt template: %w", err)
+		}
+	}
+	text, err := renderPromptTemplate(tmpl, tc.PromptVars())
+	if err != nil {
+		return "", fmt.Errorf("render prompt template: %w", err)
+	}
+	return tc.OutputProfile().apply(text), nil
+}
+
+// explorerPresencePattern matches the explorer's name used as the subject
+// of what the image shows ("Cole is", "Cole's smiling", "Cole sits"). A
+// greeting addressed to them ("Look, Cole!") does not match.
+func explorerPresencePattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(name) +
+		`('s|’s)?\s+(is|was|are|were|has|looks|smiles|smiling|sits|sitting|stands|standing|plays|playing|holds|holding|wears|wearing)\b`)
+}
+
+// ScoreCaption returns every rule c breaks for tc: ValidateCaption, the
+// output profile, the case's expectations and, when the explorer is not in
+// the image, any sign of the caption placing them in it. An empty result
+// is a pass.
+func ScoreCaption(c *Caption, tc CaptionEvalCase) []string {
+	violations := append(ValidateCaption(c), tc.OutputProfile().Validate(c)...)
+	text := c.ShortCaption + "\n" + c.DeepDive
+
+	if name := strings.TrimSpace(tc.ExplorerName); name != "" && !tc.ExplorerInImage {
+		for _, person := range c.DetectedPeople {
+			if mentionsName(person, name) {
+				violations = append(violations, fmt.Sprintf("detected_people names %s, who is not in the image.", name))
+				break
+			}
+		}
+		if explorerPresencePattern(name).MatchString(text) {
+			violations = append(violations, fmt.Sprintf("The caption describes %s as if they were in the image.", name))
+		}
+	}
+
+	e := tc.Expect
+	if words := len(strings.Fields(c.ShortCaption)); e.MaxShortCaptionWords > 0 && words > e.MaxShortCaptionWords {
+		violations = append(violations, fmt.Sprintf("short_caption has %d words; the case allows %d.", words, e.MaxShortCaptionWords))
+	}
+	if words := len(strings.Fields(c.DeepDive)); e.MaxDeepDiveWords > 0 && words > e.MaxDeepDiveWords {
+		violations = append(violations, fmt.Sprintf("deep_dive has %d words; the case allows %d.", words, e.MaxDeepDiveWords))
+	}
+	for _, name := range e.RequiredNames {
+		if !mentionsName(text, name) {
+			violations = append(violations, fmt.Sprintf("The caption does not mention %s.", name))
+		}
+	}
+	for _, word := range e.ForbiddenWords {
+		if mentionsName(text, word) {
+			violations = append(violations, fmt.Sprintf("The caption uses %q.", word))
+		}
+	}
+	return violations
+}
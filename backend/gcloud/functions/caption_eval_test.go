@@ -0,0 +1,104 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScoreCaption(t *testing.T) {
+	base := CaptionEvalCase{Name: "park", ExplorerName: "Cole", SenderName: "Dad"}
+	valid := Caption{
+		ShortCaption: "Look, Cole, a big slide!",
+		DeepDive:     "Dad is at the park. The slide is tall and red.",
+	}
+	tests := []struct {
+		name   string
+		edit   func(*CaptionEvalCase, *Caption)
+		breaks []string
+	}{
+		{name: "pass", edit: func(*CaptionEvalCase, *Caption) {}},
+		{name: "schema rules apply", edit: func(_ *CaptionEvalCase, c *Caption) { c.DeepDive = "Only one." },
+			breaks: []string{"deep_dive has 1 sentences"}},
+		{name: "explorer placed in the image", edit: func(_ *CaptionEvalCase, c *Caption) { c.DeepDive = "Cole is on the slide. Dad watches." },
+			breaks: []string{"The caption describes Cole as if they were in the image."}},
+		{name: "explorer's possessive counts", edit: func(_ *CaptionEvalCase, c *Caption) { c.DeepDive = "Cole’s smiling at the top. Dad watches." },
+			breaks: []string{"The caption describes Cole as if they were in the image."}},
+		{name: "explorer named in detected_people", edit: func(_ *CaptionEvalCase, c *Caption) { c.DetectedPeople = []string{"Dad", "Cole"} },
+			breaks: []string{"detected_people names Cole, who is not in the image."}},
+		{name: "explorer who is in the image may be described", edit: func(tc *CaptionEvalCase, c *Caption) {
+			tc.ExplorerInImage = true
+			c.DeepDive = "Cole is on the slide. Dad watches."
+			c.DetectedPeople = []string{"Cole"}
+		}},
+		{name: "word limits", edit: func(tc *CaptionEvalCase, _ *Caption) {
+			tc.Expect.MaxShortCaptionWords = 3
+			tc.Expect.MaxDeepDiveWords = 5
+		}, breaks: []string{"short_caption has 5 words; the case allows 3.", "deep_dive has 11 words; the case allows 5."}},
+		{name: "required and forbidden words", edit: func(tc *CaptionEvalCase, _ *Caption) {
+			tc.Expect.RequiredNames = []string{"Dad", "Grandma"}
+			tc.Expect.ForbiddenWords = []string{"slide", "park bench"}
+		}, breaks: []string{"The caption does not mention Grandma.", `The caption uses "slide".`}},
+		{name: "very simple profile applies", edit: func(tc *CaptionEvalCase, _ *Caption) { tc.Profile = CaptionProfileVerySimple },
+			breaks: []string{`deep_dive phrase "The slide is tall and red" has 6 words`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, c := base, valid
+			tt.edit(&tc, &c)
+			got := ScoreCaption(&c, tc)
+			if len(got) != len(tt.breaks) {
+				t.Fatalf("ScoreCaption = %q, want %d violation(s)", got, len(tt.breaks))
+			}
+			for i, want := range tt.breaks {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("violation %d = %q, want prefix %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExplorerPresencePattern(t *testing.T) {
+	pattern := explorerPresencePattern("Cole")
+	tests := map[string]bool{
+		"Cole is smiling.":           true,
+		"cole sits on the grass.":    true,
+		"Look how Cole's holding it": true,
+		"Look, Cole!":                false,
+		"This is for Cole.":          false,
+		"Nicole is here.":            false,
+		"Coleman is here.":           false,
+	}
+	for text, want := range tests {
+		if got := pattern.MatchString(text); got != want {
+			t.Errorf("explorerPresencePattern matches %q = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestRenderEvalPrompt(t *testing.T) {
+	tc := CaptionEvalCase{ExplorerName: "Cole", SenderName: "Dad", SenderInImage: true}
+
+	builtin, err := RenderEvalPrompt("", tc)
+	if err != nil {
+		t.Fatalf("built-in prompt: %v", err)
+	}
+	if !strings.Contains(builtin, "Cole") || !strings.Contains(builtin, "Dad is the sender and has confirmed they appear in the image") {
+		t.Errorf("built-in prompt does not carry the case's context:\n%s", builtin)
+	}
+
+	custom, err := RenderEvalPrompt("Describe this for {{.ExplorerName}} from {{.SenderName}}.", tc)
+	if err != nil || custom != "Describe this for Cole from Dad." {
+		t.Errorf("custom prompt = %q, %v", custom, err)
+	}
+
+	tc.Profile = CaptionProfileVerySimple
+	simple, err := RenderEvalPrompt("Describe this.", tc)
+	if err != nil || !strings.Contains(simple, "OUTPUT PROFILE: VERY SIMPLE") {
+		t.Errorf("very simple prompt = %q, %v", simple, err)
+	}
+
+	if _, err := RenderEvalPrompt("{{.ExplorerName", tc); err == nil {
+		t.Error("RenderEvalPrompt accepted a broken template")
+	}
+}
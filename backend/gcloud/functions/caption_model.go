@@ -0,0 +1,515 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	CaptionProviderGemini = "gemini"
+	CaptionProviderOpenAI = "openai"
+	CaptionProviderFake   = "fake"
+
+	DefaultGeminiCaptionModel = "gemini-2.5-flash-lite"
+	// Ollama's OpenAI-compatible endpoint; llama.cpp's server uses :8080/v1.
+	defaultOpenAICaptionBaseURL = "http://localhost:11434/v1"
+	defaultOpenAICaptionModel   = "llava"
+	openAICaptionTimeout        = 2 * time.Minute
+)
+
+// CaptionRequest is one image-plus-prompt captioning call. For videos,
+// Video (when the model supports it) or Frames replace the thumbnail in
+// Image.
+type CaptionRequest struct {
+	Prompt string
+	Image  []byte
+	// ImageMIME is the image's content type, e.g. "image/jpeg". Empty means JPEG.
+	ImageMIME string
+	// Frames are keyframes in time order.
+	Frames []CaptionFrame
+	// Video is a whole clip of type VideoMIME, only set when the provider
+	// accepts video (CaptionModelConfig.SupportsVideo).
+	Video     []byte
+	VideoMIME string
+	// PromptVersion labels where Prompt came from (see prompt_templates.go);
+	// it is only recorded, never sent to the model.
+	PromptVersion string
+	// Profile adds output profile rules to validation; Prompt must already
+	// carry them (RenderedPrompt does).
+	Profile CaptionOutputProfile
+}
+
+// Caption is the structured result every CaptionModel returns. Providers
+// are asked for exactly these fields through a declared response schema
+// (see caption_schema.go).
+type Caption struct {
+	ShortCaption   string   `json:"short_caption"`
+	DeepDive       string   `json:"deep_dive"`
+	DetectedPeople []string `json:"detected_people"`
+	SafetyFlags    []string `json:"safety_flags"`
+
+	// Usage is what the call consumed, as reported by the provider. It is
+	// never part of the model's reply.
+	Usage CaptionUsage `json:"-"`
+	// SafetyRatings are the provider's own ratings of the reply, for
+	// providers that report them (see moderation.go).
+	SafetyRatings []SafetyRating `json:"-"`
+}
+
+// CaptionBlockedError is returned when the provider refused to caption the
+// content on safety grounds. It is not retried; the content is quarantined.
+type CaptionBlockedError struct {
+	Ratings []SafetyRating
+}
+
+func (e *CaptionBlockedError) Error() string {
+	return "caption blocked by provider safety filters"
+}
+
+// CaptionUsage counts the tokens one or more caption calls consumed. Providers
+// that do not report usage leave it zero.
+type CaptionUsage struct {
+	InputTokens  int64
+	OutputTokens int64
+}
+
+func (u *CaptionUsage) add(o CaptionUsage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+}
+
+// CaptionModel turns an image and prompt into a Caption. Implementations are
+// selected by NewCaptionModel so handlers and tools never talk to a specific
+// provider's SDK directly.
+type CaptionModel interface {
+	// Name identifies the provider and model for logs, e.g. "gemini/gemini-2.5-flash-lite".
+	Name() string
+	// GenerateCaption returns the decoded caption with its Usage set. When the
+	// reply does not decode (errCaptionSchema) it still returns a Caption
+	// carrying only Usage, so the spent tokens are accounted for.
+	GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error)
+	Close() error
+}
+
+// CaptionModelConfig selects and configures a CaptionModel.
+type CaptionModelConfig struct {
+	Provider string // gemini (default), openai or fake
+	Model    string // provider-specific model name; empty uses the provider default
+	APIKey   string // Gemini API key, or optional bearer token for openai
+	BaseURL  string // openai only: server root including /v1
+	// Fallbacks are tried in order when this model keeps failing or its
+	// circuit is open (see fallbackCaptionModel).
+	Fallbacks []CaptionModelConfig
+	// Retry applies to each model in the chain; the zero value uses
+	// RetryPolicyFromEnv.
+	Retry RetryPolicy
+}
+
+// SupportsVideo reports whether the provider accepts whole clips; the
+// OpenAI-compatible servers we target only take images, so they get keyframes.
+func (c CaptionModelConfig) SupportsVideo() bool {
+	return c.Provider != CaptionProviderOpenAI
+}
+
+// CaptionModelConfigFromEnv reads CAPTION_PROVIDER, CAPTION_MODEL and
+// CAPTION_BASE_URL. The API key is CAPTION_API_KEY, falling back to
+// GEMINI_API_KEY for the Gemini provider so existing deployments keep working.
+// CAPTION_FALLBACK_MODELS is a "|"-separated fallback chain, e.g.
+// "gemini-2.5-flash|openai/llava": bare names use the same provider and
+// credentials, "provider/model" entries that provider's defaults.
+func CaptionModelConfigFromEnv() CaptionModelConfig {
+	cfg := CaptionModelConfig{
+		Provider: strings.ToLower(strings.TrimSpace(os.Getenv("CAPTION_PROVIDER"))),
+		Model:    strings.TrimSpace(os.Getenv("CAPTION_MODEL")),
+		APIKey:   os.Getenv("CAPTION_API_KEY"),
+		BaseURL:  strings.TrimSpace(os.Getenv("CAPTION_BASE_URL")),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = CaptionProviderGemini
+	}
+	if cfg.APIKey == "" && cfg.Provider == CaptionProviderGemini {
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+	}
+	for _, entry := range strings.Split(os.Getenv("CAPTION_FALLBACK_MODELS"), "|") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		fallback := CaptionModelConfig{Provider: cfg.Provider, Model: entry, APIKey: cfg.APIKey, BaseURL: cfg.BaseURL}
+		if provider, model, ok := strings.Cut(entry, "/"); ok && provider != cfg.Provider {
+			fallback = CaptionModelConfig{Provider: strings.ToLower(provider), Model: model}
+			if fallback.Provider == CaptionProviderGemini {
+				fallback.APIKey = os.Getenv("GEMINI_API_KEY")
+			}
+		} else if ok {
+			fallback.Model = model
+		}
+		cfg.Fallbacks = append(cfg.Fallbacks, fallback)
+	}
+	return cfg
+}
+
+// NewCaptionModel builds the CaptionModel described by cfg: its provider's
+// model, followed by any fallbacks, with every call retried under
+// cfg.Retry. A fallback that cannot be built is logged and left out.
+func NewCaptionModel(ctx context.Context, cfg CaptionModelConfig) (CaptionModel, error) {
+	primary, err := newProviderCaptionModel(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	chain := &fallbackCaptionModel{
+		models: []captionModelLink{{primary, cfg.SupportsVideo()}},
+		retry:  cfg.Retry,
+	}
+	if chain.retry.MaxAttempts == 0 {
+		chain.retry = RetryPolicyFromEnv()
+	}
+	for _, fallbackCfg := range cfg.Fallbacks {
+		fallback, err := newProviderCaptionModel(ctx, fallbackCfg)
+		if err != nil {
+			log.Printf("Caption fallback %s/%s unavailable: %v", fallbackCfg.Provider, fallbackCfg.Model, err)
+			continue
+		}
+		chain.models = append(chain.models, captionModelLink{fallback, fallbackCfg.SupportsVideo()})
+	}
+	return chain, nil
+}
+
+func newProviderCaptionModel(ctx context.Context, cfg CaptionModelConfig) (CaptionModel, error) {
+	switch cfg.Provider {
+	case "", CaptionProviderGemini:
+		return NewGeminiCaptionModel(ctx, cfg.APIKey, cfg.Model)
+	case CaptionProviderOpenAI:
+		return NewOpenAICaptionModel(cfg.BaseURL, cfg.Model, cfg.APIKey), nil
+	case CaptionProviderFake:
+		return &FakeCaptionModel{}, nil
+	default:
+		return nil, fmt.Errorf("unknown caption provider %q", cfg.Provider)
+	}
+}
+
+type captionModelLink struct {
+	model         CaptionModel
+	supportsVideo bool
+}
+
+// fallbackCaptionModel is the CaptionModel NewCaptionModel returns. Each
+// model is called through CallWithRetry; when one still fails with a
+// transient error, or its circuit is open, the next model is tried. Safety
+// blocks and malformed replies are returned as they are, since another
+// model would not fix them. Name reports the model that answered the last
+// call, so usage is attributed to it.
+type fallbackCaptionModel struct {
+	models []captionModelLink
+	retry  RetryPolicy
+
+	mu     sync.Mutex
+	served string
+}
+
+func (m *fallbackCaptionModel) Name() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.served != "" {
+		return m.served
+	}
+	return m.models[0].model.Name()
+}
+
+func (m *fallbackCaptionModel) Close() error {
+	var errs []error
+	for _, link := range m.models {
+		errs = append(errs, link.model.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *fallbackCaptionModel) GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error) {
+	var caption *Caption
+	var err error
+	for i, link := range m.models {
+		if i > 0 && len(req.Video) > 0 && !link.supportsVideo {
+			continue
+		}
+		name := link.model.Name()
+		caption, err = CallWithRetry(ctx, name, m.retry, func(ctx context.Context) (*Caption, error) {
+			return link.model.GenerateCaption(ctx, req)
+		})
+		var open *CircuitOpenError
+		retryable, _ := RetryableError(err)
+		if err == nil || ctx.Err() != nil || !(retryable || errors.As(err, &open)) {
+			m.mu.Lock()
+			m.served = name
+			m.mu.Unlock()
+			return caption, err
+		}
+		if i < len(m.models)-1 {
+			log.Printf("Caption model %s failed, falling back: %v", name, err)
+		}
+	}
+	return caption, err
+}
+
+// captionWithUsage decodes a provider reply and attaches usage, following
+// the GenerateCaption contract for replies that do not decode.
+func captionWithUsage(text string, usage CaptionUsage) (*Caption, error) {
+	caption, err := decodeCaption(text)
+	if err != nil {
+		return &Caption{Usage: usage}, err
+	}
+	caption.Usage = usage
+	return caption, nil
+}
+
+func captionImageMIME(req CaptionRequest) string {
+	if req.ImageMIME == "" {
+		return "image/jpeg"
+	}
+	return req.ImageMIME
+}
+
+// GeminiCaptionModel captions with the Gemini API.
+type GeminiCaptionModel struct {
+	clients   *sharedClient[*genai.Client]
+	modelName string
+}
+
+func NewGeminiCaptionModel(ctx context.Context, apiKey, modelName string) (*GeminiCaptionModel, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not configured")
+	}
+	if modelName == "" {
+		modelName = DefaultGeminiCaptionModel
+	}
+	clients := geminiClientFor(apiKey)
+	if _, err := clients.get(ctx); err != nil {
+		return nil, err
+	}
+	return &GeminiCaptionModel{clients: clients, modelName: modelName}, nil
+}
+
+func (m *GeminiCaptionModel) Name() string { return CaptionProviderGemini + "/" + m.modelName }
+
+// Close is a no-op: the Gemini client is shared (see clients.go).
+func (m *GeminiCaptionModel) Close() error { return nil }
+
+func (m *GeminiCaptionModel) GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error) {
+	client, err := m.clients.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model := client.GenerativeModel(m.modelName)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = captionGeminiSchema()
+	parts := []genai.Part{genai.Text(req.Prompt)}
+	switch {
+	case len(req.Video) > 0:
+		parts = append(parts, genai.Blob{MIMEType: req.VideoMIME, Data: req.Video})
+	case len(req.Frames) > 0:
+		for _, f := range req.Frames {
+			parts = append(parts, genai.Text(frameLabel(f)), genai.ImageData(strings.TrimPrefix(f.MIME, "image/"), f.Image))
+		}
+	default:
+		parts = append(parts, genai.ImageData(strings.TrimPrefix(captionImageMIME(req), "image/"), req.Image))
+	}
+	resp, err := model.GenerateContent(ctx, parts...)
+	m.clients.report(client, err)
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return nil, &CaptionBlockedError{Ratings: blockedSafetyRatings(blocked)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gemini generate: %w", err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no response from Gemini")
+	}
+	text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Gemini response type %T", resp.Candidates[0].Content.Parts[0])
+	}
+	var usage CaptionUsage
+	if resp.UsageMetadata != nil {
+		usage.InputTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		usage.OutputTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	caption, err := captionWithUsage(string(text), usage)
+	caption.SafetyRatings = geminiSafetyRatings(resp.Candidates[0].SafetyRatings)
+	return caption, err
+}
+
+// OpenAICaptionModel captions through any server speaking the OpenAI chat
+// completions API with image inputs, such as Ollama or llama.cpp's server.
+type OpenAICaptionModel struct {
+	baseURL    string
+	modelName  string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewOpenAICaptionModel(baseURL, modelName, apiKey string) *OpenAICaptionModel {
+	if baseURL == "" {
+		baseURL = defaultOpenAICaptionBaseURL
+	}
+	if modelName == "" {
+		modelName = defaultOpenAICaptionModel
+	}
+	return &OpenAICaptionModel{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		modelName:  modelName,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: openAICaptionTimeout},
+	}
+}
+
+func (m *OpenAICaptionModel) Name() string { return CaptionProviderOpenAI + "/" + m.modelName }
+
+func (m *OpenAICaptionModel) Close() error { return nil }
+
+func (m *OpenAICaptionModel) GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error) {
+	imageContent := func(mime string, data []byte) map[string]any {
+		url := "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+		return map[string]any{"type": "image_url", "image_url": map[string]string{"url": url}}
+	}
+	content := []map[string]any{{"type": "text", "text": req.Prompt}}
+	if len(req.Frames) > 0 {
+		for _, f := range req.Frames {
+			content = append(content, map[string]any{"type": "text", "text": frameLabel(f)}, imageContent(f.MIME, f.Image))
+		}
+	} else {
+		content = append(content, imageContent(captionImageMIME(req), req.Image))
+	}
+	body, err := json.Marshal(map[string]any{
+		"model": m.modelName,
+		"messages": []map[string]any{{
+			"role":    "user",
+			"content": content,
+		}},
+		"response_format": map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "caption",
+				"strict": true,
+				"schema": captionJSONSchema(),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode chat request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build chat request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	res, err := m.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("chat request: %w", err)
+	}
+	defer res.Body.Close()
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read chat response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chat request: %w", newHTTPStatusError(res, respBody))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(respBody, &completion); err != nil {
+		return nil, fmt.Errorf("decode chat response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in chat response")
+	}
+	return captionWithUsage(completion.Choices[0].Message.Content, CaptionUsage{
+		InputTokens:  completion.Usage.PromptTokens,
+		OutputTokens: completion.Usage.CompletionTokens,
+	})
+}
+
+// FakeCaptionModel is a deterministic CaptionModel for tests and offline
+// runs. With no Response set, the caption is derived from the image hash so
+// the same image always gets the same text.
+type FakeCaptionModel struct {
+	Response *Caption
+	Err      error
+
+	mu       sync.Mutex
+	requests []CaptionRequest
+}
+
+func (m *FakeCaptionModel) Name() string { return CaptionProviderFake }
+
+func (m *FakeCaptionModel) Close() error { return nil }
+
+func (m *FakeCaptionModel) GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error) {
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if m.Response != nil {
+		caption := *m.Response
+		return &caption, nil
+	}
+	h := sha256.New()
+	h.Write(req.Image)
+	for _, f := range req.Frames {
+		h.Write(f.Image)
+	}
+	h.Write(req.Video)
+	tag := hex.EncodeToString(h.Sum(nil)[:4])
+	if len(req.Frames) > 0 || len(req.Video) > 0 {
+		return &Caption{
+			ShortCaption:   "Watch this video for you!",
+			DeepDive:       fmt.Sprintf("This is video %s. First something starts, then it changes. Someone who loves you sent it.", tag),
+			DetectedPeople: []string{},
+			SafetyFlags:    []string{},
+		}, nil
+	}
+	return &Caption{
+		ShortCaption:   "Look at this picture for you!",
+		DeepDive:       fmt.Sprintf("This is picture %s. Someone who loves you sent it. They hope it makes you smile.", tag),
+		DetectedPeople: []string{},
+		SafetyFlags:    []string{},
+	}, nil
+}
+
+// Requests returns the calls made so far, oldest first.
+func (m *FakeCaptionModel) Requests() []CaptionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]CaptionRequest(nil), m.requests...)
+}
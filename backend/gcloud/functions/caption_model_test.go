@@ -0,0 +1,151 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// namedCaptionModel is a FakeCaptionModel under another name, so chains of
+// them get separate circuit breakers.
+type namedCaptionModel struct {
+	*FakeCaptionModel
+	name string
+}
+
+func (m namedCaptionModel) Name() string { return m.name }
+
+func TestFakeCaptionModel(t *testing.T) {
+	ctx := context.Background()
+	model := &FakeCaptionModel{}
+
+	first, err := model.GenerateCaption(ctx, CaptionRequest{Image: []byte("one")})
+	if err != nil {
+		t.Fatalf("GenerateCaption: %v", err)
+	}
+	again, _ := model.GenerateCaption(ctx, CaptionRequest{Image: []byte("one")})
+	other, _ := model.GenerateCaption(ctx, CaptionRequest{Image: []byte("two")})
+	video, _ := model.GenerateCaption(ctx, CaptionRequest{Video: []byte("clip")})
+
+	if first.DeepDive != again.DeepDive {
+		t.Errorf("same image captioned differently: %q vs %q", first.DeepDive, again.DeepDive)
+	}
+	if first.DeepDive == other.DeepDive {
+		t.Errorf("different images captioned the same: %q", first.DeepDive)
+	}
+	if video.ShortCaption != "Watch this video for you!" {
+		t.Errorf("video ShortCaption = %q", video.ShortCaption)
+	}
+	if violations := ValidateCaption(first); len(violations) > 0 {
+		t.Errorf("fake caption breaks rules: %v", violations)
+	}
+	if got := len(model.Requests()); got != 4 {
+		t.Errorf("Requests() has %d calls, want 4", got)
+	}
+
+	fixed := &FakeCaptionModel{Response: &Caption{ShortCaption: "Fixed."}}
+	caption, _ := fixed.GenerateCaption(ctx, CaptionRequest{})
+	caption.ShortCaption = "changed"
+	if fixed.Response.ShortCaption != "Fixed." {
+		t.Error("GenerateCaption returned the Response itself instead of a copy")
+	}
+
+	failing := &FakeCaptionModel{Err: errors.New("boom")}
+	if _, err := failing.GenerateCaption(ctx, CaptionRequest{}); err == nil || err.Error() != "boom" {
+		t.Errorf("GenerateCaption error = %v, want boom", err)
+	}
+}
+
+func TestFallbackCaptionModel(t *testing.T) {
+	unavailable := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	badRequest := &HTTPStatusError{StatusCode: http.StatusBadRequest}
+	answer := &Caption{ShortCaption: "From the fallback."}
+
+	tests := []struct {
+		name       string
+		primaryErr error
+		video      bool
+		wantServed string
+		wantErr    error
+		wantCalls  [2]int
+	}{
+		{name: "primary answers", wantServed: "test/primary", wantCalls: [2]int{1, 0}},
+		{name: "transient failure falls back", primaryErr: unavailable, wantServed: "test/fallback", wantCalls: [2]int{1, 1}},
+		{name: "bad request is returned", primaryErr: badRequest, wantServed: "test/primary", wantErr: badRequest, wantCalls: [2]int{1, 0}},
+		{name: "video skips fallback without video", primaryErr: unavailable, video: true, wantErr: unavailable, wantCalls: [2]int{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := namedCaptionModel{&FakeCaptionModel{Err: tt.primaryErr}, "test/primary"}
+			fallback := namedCaptionModel{&FakeCaptionModel{Response: answer}, "test/fallback"}
+			chain := &fallbackCaptionModel{
+				models: []captionModelLink{{primary, true}, {fallback, false}},
+				retry:  RetryPolicy{MaxAttempts: 1},
+			}
+			t.Cleanup(func() {
+				circuitBreakersMu.Lock()
+				delete(circuitBreakers, "test/primary")
+				delete(circuitBreakers, "test/fallback")
+				circuitBreakersMu.Unlock()
+			})
+
+			req := CaptionRequest{Image: []byte("image")}
+			if tt.video {
+				req.Video = []byte("clip")
+			}
+			_, err := chain.GenerateCaption(context.Background(), req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantServed != "" && chain.Name() != tt.wantServed {
+				t.Errorf("Name() = %q, want %q", chain.Name(), tt.wantServed)
+			}
+			calls := [2]int{len(primary.Requests()), len(fallback.Requests())}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCaptionModelConfigFromEnv(t *testing.T) {
+	t.Setenv("CAPTION_PROVIDER", "")
+	t.Setenv("CAPTION_MODEL", "gemini-2.5-pro")
+	t.Setenv("CAPTION_API_KEY", "")
+	t.Setenv("CAPTION_BASE_URL", "")
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	t.Setenv("CAPTION_FALLBACK_MODELS", "gemini-2.5-flash | openai/llava |gemini/gemini-2.0-flash")
+
+	cfg := CaptionModelConfigFromEnv()
+	if cfg.Provider != CaptionProviderGemini || cfg.APIKey != "gemini-key" || cfg.Model != "gemini-2.5-pro" {
+		t.Errorf("config = %+v, want gemini-2.5-pro with the Gemini key", cfg)
+	}
+	want := []CaptionModelConfig{
+		{Provider: CaptionProviderGemini, Model: "gemini-2.5-flash", APIKey: "gemini-key"},
+		{Provider: CaptionProviderOpenAI, Model: "llava"},
+		{Provider: CaptionProviderGemini, Model: "gemini-2.0-flash", APIKey: "gemini-key"},
+	}
+	if !reflect.DeepEqual(cfg.Fallbacks, want) {
+		t.Errorf("Fallbacks = %+v, want %+v", cfg.Fallbacks, want)
+	}
+
+	t.Setenv("CAPTION_PROVIDER", " OpenAI ")
+	t.Setenv("CAPTION_BASE_URL", "http://localhost:8000/v1")
+	t.Setenv("CAPTION_FALLBACK_MODELS", "")
+	cfg = CaptionModelConfigFromEnv()
+	if cfg.Provider != CaptionProviderOpenAI || cfg.APIKey != "" || cfg.BaseURL != "http://localhost:8000/v1" {
+		t.Errorf("config = %+v, want openai without the Gemini key", cfg)
+	}
+}
+
+func TestNewCaptionModelUnknownProvider(t *testing.T) {
+	if _, err := NewCaptionModel(context.Background(), CaptionModelConfig{Provider: "bard"}); err == nil {
+		t.Error("NewCaptionModel accepted an unknown provider")
+	}
+	model, err := NewCaptionModel(context.Background(), CaptionModelConfig{Provider: CaptionProviderFake})
+	if err != nil || model.Name() != CaptionProviderFake {
+		t.Errorf("NewCaptionModel(fake) = %v, %v", model, err)
+	}
+}
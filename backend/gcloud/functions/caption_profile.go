@@ -0,0 +1,256 @@
+package functions
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Caption output profiles, selected per explorer with settings.caption_profile.
+const (
+	CaptionProfileStandard       = "standard"
+	CaptionProfileVerySimple     = "very_simple"
+	CaptionProfileCoreVocabulary = "core_vocabulary"
+
+	minVerySimpleWords = 3
+	maxVerySimpleWords = 5
+
+	// maxCoreVocabularyWords bounds settings.core_vocabulary, which is also
+	// sent in the prompt.
+	maxCoreVocabularyWords = 500
+	// maxReportedWords keeps repair prompts short when many words are off-list.
+	maxReportedWords = 10
+)
+
+// defaultCoreVocabulary is a general AAC core word list, used when an
+// explorer on the core_vocabulary profile has not set their own
+// settings.core_vocabulary (usually the words on their device).
+var defaultCoreVocabulary = []string{
+	"a", "all", "and", "are", "at", "away", "baby", "back", "bad", "ball",
+	"be", "big", "book", "but", "can", "car", "cat", "come", "dad", "day",
+	"different", "do", "dog", "done", "down", "drink", "eat", "feel", "finished", "for",
+	"friend", "fun", "get", "give", "go", "good", "happy", "have", "he", "help",
+	"her", "here", "him", "home", "hot", "hug", "i", "in", "is", "it",
+	"like", "little", "look", "love", "make", "me", "mom", "more", "my", "new",
+	"nice", "no", "not", "now", "of", "off", "on", "open", "out", "over",
+	"park", "play", "put", "read", "ride", "run", "sad", "same", "see", "she",
+	"sit", "sleep", "smile", "some", "stop", "that", "the", "they", "this", "time",
+	"to", "today", "turn", "up", "want", "we", "what", "where", "who", "with",
+	"yes", "you", "your",
+}
+
+// CaptionOutputProfile constrains caption wording to an explorer's
+// comprehension level. The zero value is the standard profile, which adds
+// nothing to the prompt or the validation rules.
+type CaptionOutputProfile struct {
+	Name string
+	// Vocabulary lists the words core_vocabulary captions may use.
+	Vocabulary []string
+	// Names are proper names (explorer, sender, people context, glossary)
+	// allowed on top of Vocabulary.
+	Names []string
+}
+
+// captionOutputProfileFor builds the profile an explorer selected. Unknown
+// names fall back to standard so a typo in settings never blocks captions.
+func captionOutputProfileFor(selection string, vocabulary []string, vars PromptVars) CaptionOutputProfile {
+	switch selection {
+	case "", CaptionProfileStandard:
+		return CaptionOutputProfile{}
+	case CaptionProfileVerySimple:
+		return CaptionOutputProfile{Name: CaptionProfileVerySimple}
+	case CaptionProfileCoreVocabulary:
+		words := normalizeVocabulary(vocabulary)
+		if len(words) == 0 {
+			words = defaultCoreVocabulary
+		}
+		return CaptionOutputProfile{Name: CaptionProfileCoreVocabulary, Vocabulary: words, Names: promptNames(vars)}
+	default:
+		fmt.Printf("captionOutputProfileFor: unknown caption profile %q; using standard\n", selection)
+		return CaptionOutputProfile{}
+	}
+}
+
+// Label names the profile for logs and responses.
+func (p CaptionOutputProfile) Label() string {
+	if p.Name == "" {
+		return CaptionProfileStandard
+	}
+	return p.Name
+}
+
+// apply adds the profile's rules to a rendered caption prompt.
+func (p CaptionOutputProfile) apply(prompt string) string {
+	switch p.Name {
+	case CaptionProfileVerySimple:
+		return prompt + fmt.Sprintf("\n\nOUTPUT PROFILE: VERY SIMPLE. These rules override the lengths above. short_caption is one phrase of %d-%d words. deep_dive is %d-%d phrases of %d-%d words each, each ending with a period. Use short, concrete, everyday words.",
+			minVerySimpleWords, maxVerySimpleWords, minDeepDiveSentences, maxDeepDiveSentences, minVerySimpleWords, maxVerySimpleWords)
+	case CaptionProfileCoreVocabulary:
+		var b strings.Builder
+		b.WriteString(prompt)
+		b.WriteString("\n\nOUTPUT PROFILE: CORE VOCABULARY. The viewer reads with an AAC symbol board. short_caption and deep_dive may use ONLY these words")
+		if len(p.Names) > 0 {
+			fmt.Fprintf(&b, " and these names (%s)", strings.Join(p.Names, ", "))
+		}
+		b.WriteString(": ")
+		b.WriteString(strings.Join(p.Vocabulary, ", "))
+		b.WriteString(". Keep sentences short and literal.")
+		return b.String()
+	default:
+		return prompt
+	}
+}
+
+// Validate returns the profile rules c breaks, worded like ValidateCaption
+// so they can be shown to the model when it is re-asked.
+func (p CaptionOutputProfile) Validate(c *Caption) []string {
+	var violations []string
+	switch p.Name {
+	case CaptionProfileVerySimple:
+		if words := len(strings.Fields(c.ShortCaption)); words < minVerySimpleWords || words > maxVerySimpleWords {
+			violations = append(violations, fmt.Sprintf("short_caption has %d words; it must have %d to %d.", words, minVerySimpleWords, maxVerySimpleWords))
+		}
+		for _, sentence := range splitSentences(c.DeepDive) {
+			if words := len(strings.Fields(sentence)); words < minVerySimpleWords || words > maxVerySimpleWords {
+				violations = append(violations, fmt.Sprintf("deep_dive phrase %q has %d words; each must have %d to %d.", sentence, words, minVerySimpleWords, maxVerySimpleWords))
+			}
+		}
+	case CaptionProfileCoreVocabulary:
+		allowed := p.allowedWords()
+		for _, field := range []struct{ name, text string }{
+			{"short_caption", c.ShortCaption},
+			{"deep_dive", c.DeepDive},
+		} {
+			if off := offVocabularyWords(field.text, allowed); len(off) > 0 {
+				violations = append(violations, fmt.Sprintf("%s uses words outside the allowed list: %s.", field.name, strings.Join(off, ", ")))
+			}
+		}
+	}
+	return violations
+}
+
+func (p CaptionOutputProfile) allowedWords() map[string]bool {
+	allowed := make(map[string]bool, len(p.Vocabulary)+len(p.Names))
+	for _, word := range p.Vocabulary {
+		allowed[strings.ToLower(word)] = true
+	}
+	for _, name := range p.Names {
+		for _, word := range captionWords(name) {
+			allowed[word] = true
+		}
+	}
+	return allowed
+}
+
+// captionWords splits text into lowercased words, keeping contractions.
+func captionWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’'
+	})
+	words := fields[:0]
+	for _, field := range fields {
+		if field = strings.Trim(strings.ReplaceAll(field, "’", "'"), "'"); field != "" {
+			words = append(words, field)
+		}
+	}
+	return words
+}
+
+// offVocabularyWords lists the distinct words of text not in allowed. Plain
+// inflections of allowed words (plays, played, playing, mom's) pass.
+func offVocabularyWords(text string, allowed map[string]bool) []string {
+	var off []string
+	seen := map[string]bool{}
+	for _, word := range captionWords(text) {
+		if seen[word] || vocabularyAllows(allowed, word) {
+			continue
+		}
+		seen[word] = true
+		if len(off) < maxReportedWords {
+			off = append(off, word)
+		}
+	}
+	return off
+}
+
+func vocabularyAllows(allowed map[string]bool, word string) bool {
+	if allowed[word] {
+		return true
+	}
+	word = strings.TrimSuffix(word, "'s")
+	if allowed[word] {
+		return true
+	}
+	for _, suffix := range []string{"s", "es", "ed", "d", "ing"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+		if !ok || stem == "" {
+			continue
+		}
+		if allowed[stem] || allowed[stem+"e"] {
+			return true
+		}
+		// running, stopped: the final consonant is doubled.
+		if n := len(stem); n > 1 && stem[n-1] == stem[n-2] && allowed[stem[:n-1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSentences returns the non-empty sentences of text, as counted by
+// countSentences.
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, s := range sentenceEndPattern.Split(strings.TrimSpace(text), -1) {
+		if s = strings.TrimSpace(s); s != "" {
+			sentences = append(sentences, s)
+		}
+	}
+	return sentences
+}
+
+func normalizeVocabulary(words []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		out = append(out, word)
+		if len(out) == maxCoreVocabularyWords {
+			break
+		}
+	}
+	return out
+}
+
+// promptNames collects the proper names a core_vocabulary caption may use:
+// the explorer, the sender, capitalised words in the people context and the
+// glossary.
+func promptNames(vars PromptVars) []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		name = strings.Trim(name, ".,;:!?()\"'")
+		if name != "" && !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			names = append(names, name)
+		}
+	}
+	for _, word := range strings.Fields(vars.ExplorerName + " " + vars.SenderName) {
+		add(word)
+	}
+	for _, word := range strings.Fields(vars.PeopleContext) {
+		if r := []rune(strings.TrimLeft(word, "\"'(")); len(r) > 0 && unicode.IsUpper(r[0]) {
+			add(word)
+		}
+	}
+	for _, entry := range vars.Glossary {
+		for _, name := range append(entry.names(), entry.displayName()) {
+			add(name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,83 @@
+package functions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptionOutputProfileValidate(t *testing.T) {
+	verySimple := CaptionOutputProfile{Name: CaptionProfileVerySimple}
+	core := CaptionOutputProfile{
+		Name:       CaptionProfileCoreVocabulary,
+		Vocabulary: []string{"look", "at", "the", "big", "dog", "play", "run", "it", "is", "happy", "mom", "with"},
+		Names:      []string{"Sam", "Grandma Rose"},
+	}
+	tests := []struct {
+		name    string
+		profile CaptionOutputProfile
+		caption Caption
+		want    []string // prefixes of the expected violations, in order
+	}{
+		{"standard allows anything", CaptionOutputProfile{}, Caption{ShortCaption: "An extraordinarily long and winding caption about kites", DeepDive: "Anything."}, nil},
+		{"very simple within limits", verySimple, Caption{ShortCaption: "Look at the kite!", DeepDive: "Grandpa has a kite. It goes up high."}, nil},
+		{"very simple short caption too long", verySimple, Caption{ShortCaption: "Look at the great big red kite!", DeepDive: "Grandpa has a kite. It goes up high."},
+			[]string{"short_caption has 7 words"}},
+		{"very simple phrase too short", verySimple, Caption{ShortCaption: "Look at the kite!", DeepDive: "Wow. It goes up high."},
+			[]string{`deep_dive phrase "Wow" has 1 words`}},
+		{"core words, names and inflections", core, Caption{ShortCaption: "Look at Sam!", DeepDive: "The dog is running with Grandma Rose. Mom's dog plays. It looked happy."}, nil},
+		{"core off-list words", core, Caption{ShortCaption: "Look at the magnificent dog!", DeepDive: "The dog is happy. The puppy is happy."},
+			[]string{"short_caption uses words outside the allowed list: magnificent.", "deep_dive uses words outside the allowed list: puppy."}},
+	}
+	for _, tt := range tests {
+		got := tt.profile.Validate(&tt.caption)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Validate = %q, want %d violation(s)", tt.name, got, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.HasPrefix(got[i], want) {
+				t.Errorf("%s: violation %d = %q, want prefix %q", tt.name, i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestOffVocabularyWordsReportsEachWordOnce(t *testing.T) {
+	allowed := map[string]bool{"a": true}
+	text := strings.Repeat("zebra ", 3) + "one two three four five six seven eight nine ten eleven"
+	off := offVocabularyWords(text, allowed)
+	if len(off) != maxReportedWords || off[0] != "zebra" || off[1] != "one" {
+		t.Errorf("offVocabularyWords = %q, want %d distinct words starting with zebra", off, maxReportedWords)
+	}
+}
+
+func TestCaptionOutputProfileFor(t *testing.T) {
+	vars := PromptVars{ExplorerName: "Sam", SenderName: "Grandma Rose", PeopleContext: "baby Dante, dog Dalton, at Nona's house"}
+	tests := []struct {
+		selection  string
+		vocabulary []string
+		wantLabel  string
+		wantVocab  []string
+	}{
+		{"", nil, CaptionProfileStandard, nil},
+		{"very_simple", nil, CaptionProfileVerySimple, nil},
+		{"sparkly", nil, CaptionProfileStandard, nil},
+		{"core_vocabulary", nil, CaptionProfileCoreVocabulary, defaultCoreVocabulary},
+		{"core_vocabulary", []string{" Go ", "go", "STOP", ""}, CaptionProfileCoreVocabulary, []string{"go", "stop"}},
+	}
+	for _, tt := range tests {
+		profile := captionOutputProfileFor(tt.selection, tt.vocabulary, vars)
+		if profile.Label() != tt.wantLabel || !reflect.DeepEqual(profile.Vocabulary, tt.wantVocab) {
+			t.Errorf("captionOutputProfileFor(%q, %q) = %s with %q, want %s with %q", tt.selection, tt.vocabulary, profile.Label(), profile.Vocabulary, tt.wantLabel, tt.wantVocab)
+		}
+	}
+
+	core := captionOutputProfileFor(CaptionProfileCoreVocabulary, nil, vars)
+	if want := []string{"Sam", "Grandma", "Rose", "Dante", "Dalton", "Nona's"}; !reflect.DeepEqual(core.Names, want) {
+		t.Errorf("Names = %q, want %q", core.Names, want)
+	}
+	if prompt := core.apply("Base."); !strings.Contains(prompt, "ONLY these words and these names (Sam, Grandma") {
+		t.Errorf("core_vocabulary prompt does not list the names:\n%s", prompt)
+	}
+}
@@ -0,0 +1,552 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	captionRevisionsCollection = "caption_revisions"
+
+	// Revisions belong to a staging event, whose image and audio the
+	// staging/ lifecycle rule deletes after a day; expiresAt backs the
+	// collection's Firestore TTL policy so the history goes with them.
+	captionRevisionTTL = 24 * time.Hour
+
+	maxCaptionRevisions      = 20
+	maxCaptionFeedbackLength = 300
+
+	// CaptionRevisionSourceOriginal is the caption the Companion started
+	// from, recorded as revision 1 by the first revision request.
+	CaptionRevisionSourceOriginal = "original"
+	// CaptionRevisionSourceEdited is a caption the Companion changed by hand
+	// before asking for another revision.
+	CaptionRevisionSourceEdited = "edited"
+	// CaptionRevisionSourceFeedback is a caption rewritten from feedback.
+	CaptionRevisionSourceFeedback = "feedback"
+)
+
+var (
+	errCaptionRevisionForbidden = errors.New("only members of this Explorer's circle may revise its captions")
+	errCaptionRevisionLimit     = fmt.Errorf("a staging event holds at most %d caption revisions", maxCaptionRevisions)
+	errCaptionRevisionNotFound  = errors.New("caption revision not found")
+)
+
+// CaptionRevision is one version of a staging event's caption, stored at
+// caption_revisions/{explorerId}_{stagingEventId}_{revision}. Revision 1 is
+// the caption the Companion started from; each later one rewrites BasedOn
+// with Feedback. Audio keys point at staging TTS and may have been deleted
+// once the draft was sent or discarded.
+type CaptionRevision struct {
+	ExplorerID     string `firestore:"explorerId" json:"-"`
+	StagingEventID string `firestore:"stagingEventId" json:"staging_event_id"`
+	Revision       int    `firestore:"revision" json:"revision"`
+	// BasedOn is the revision Feedback was applied to; 0 for original and
+	// edited captions.
+	BasedOn            int               `firestore:"basedOn" json:"based_on,omitempty"`
+	Source             string            `firestore:"source" json:"source"`
+	Feedback           string            `firestore:"feedback,omitempty" json:"feedback,omitempty"`
+	ShortCaption       string            `firestore:"shortCaption" json:"short_caption"`
+	DeepDive           string            `firestore:"deepDive" json:"deep_dive"`
+	Language           string            `firestore:"language,omitempty" json:"language,omitempty"`
+	PromptVersion      string            `firestore:"promptVersion,omitempty" json:"prompt_version,omitempty"`
+	Moderation         *ModerationResult `firestore:"moderation,omitempty" json:"moderation,omitempty"`
+	AudioS3Key         string            `firestore:"audioS3Key,omitempty" json:"audio_s3_key,omitempty"`
+	DeepDiveAudioS3Key string            `firestore:"deepDiveAudioS3Key,omitempty" json:"deep_dive_audio_s3_key,omitempty"`
+	AudioURL           string            `firestore:"-" json:"audio_url,omitempty"`
+	DeepDiveAudioURL   string            `firestore:"-" json:"deep_dive_audio_url,omitempty"`
+	CreatedBy          string            `firestore:"createdBy" json:"created_by"`
+	CreatedAt          time.Time         `firestore:"createdAt" json:"created_at"`
+	ExpiresAt          time.Time         `firestore:"expiresAt" json:"-"`
+}
+
+// captionRevisionRequest is the POST body of ReviseCaption. ShortCaption and
+// DeepDive are the caption being revised: required on the first revision of
+// a staging event, and otherwise defaulting to BaseRevision, or the latest
+// revision when that is 0. Text that matches no stored revision is recorded
+// before the rewrite, so hand edits are part of the history.
+type captionRevisionRequest struct {
+	Feedback           string `json:"feedback"`
+	BaseRevision       int    `json:"base_revision"`
+	ShortCaption       string `json:"short_caption"`
+	DeepDive           string `json:"deep_dive"`
+	AudioS3Key         string `json:"audio_s3_key"`
+	DeepDiveAudioS3Key string `json:"deep_dive_audio_s3_key"`
+	// The remaining fields are the caption context GenerateAIDescription
+	// took, so the rewrite keeps the same prompt, glossary and voices.
+	ExplorerName          string `json:"explorer_name"`
+	CompanionName         string `json:"companion_name"`
+	CompanionInReflection bool   `json:"companion_in_reflection"`
+	ExplorerInReflection  bool   `json:"explorer_in_reflection"`
+	PeopleContext         string `json:"people_context"`
+	CaptionVoice          string `json:"caption_voice"`
+	DeepDiveVoice         string `json:"deep_dive_voice"`
+	Language              string `json:"language"`
+}
+
+// normalizeCaptionFeedback collapses whitespace so feedback reads as one
+// instruction in the prompt.
+func normalizeCaptionFeedback(feedback string) (string, error) {
+	feedback = strings.Join(strings.Fields(feedback), " ")
+	if feedback == "" {
+		return "", errors.New("feedback is required")
+	}
+	if len([]rune(feedback)) > maxCaptionFeedbackLength {
+		return "", fmt.Errorf("feedback must be at most %d characters", maxCaptionFeedbackLength)
+	}
+	return feedback, nil
+}
+
+// stagingAudioKeyFor accepts a client-supplied audio key only when it is
+// staging TTS for this explorer; anything else is dropped.
+func stagingAudioKeyFor(explorerID, key string) string {
+	if key == "" || strings.Contains(key, "..") || !strings.HasPrefix(key, fmt.Sprintf("staging/%s/tts/", explorerID)) {
+		return ""
+	}
+	return key
+}
+
+// revisionPrompt asks the caption model to rewrite an existing caption. The
+// Companion's feedback is quoted as data; the caption rules in prompt still
+// apply, so feedback cannot lift them.
+func revisionPrompt(prompt string, previous *Caption, feedback string) string {
+	source, _ := json.Marshal(previous)
+	return prompt + fmt.Sprintf(`
+
+REVISION: A caption was already written for this image:
+
+%s
+
+The Companion asked for these changes, quoted exactly: %q
+
+Rewrite short_caption and deep_dive to follow that request while keeping every rule above. Change only what the request asks for. If the request asks not to use a word or name, do not use it anywhere.`, source, feedback)
+}
+
+func captionRevisionID(explorerID, stagingEventID string, revision int) string {
+	return fmt.Sprintf("%s_%s_%d", explorerID, stagingEventID, revision)
+}
+
+func captionRevisionsQuery(client *firestore.Client, explorerID, stagingEventID string) firestore.Query {
+	return client.Collection(captionRevisionsCollection).
+		Where("explorerId", "==", explorerID).
+		Where("stagingEventId", "==", stagingEventID)
+}
+
+// loadCaptionRevisions returns a staging event's live revisions, oldest
+// first. Expired ones are skipped because TTL deletion can lag by a day.
+func loadCaptionRevisions(ctx context.Context, client *firestore.Client, explorerID, stagingEventID string) ([]CaptionRevision, error) {
+	docs, err := captionRevisionsQuery(client, explorerID, stagingEventID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("load caption revisions for %s: %w", stagingEventID, err)
+	}
+	now := time.Now()
+	revisions := make([]CaptionRevision, 0, len(docs))
+	for _, doc := range docs {
+		var rev CaptionRevision
+		if err := doc.DataTo(&rev); err != nil {
+			fmt.Printf("loadCaptionRevisions: skipping undecodable revision %s: %v\n", doc.Ref.Path, err)
+			continue
+		}
+		if now.After(rev.ExpiresAt) {
+			continue
+		}
+		revisions = append(revisions, rev)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	return revisions, nil
+}
+
+// findCaptionRevision returns revision n, or the latest when n is 0; nil
+// when there is none.
+func findCaptionRevision(revisions []CaptionRevision, n int) *CaptionRevision {
+	if n == 0 && len(revisions) > 0 {
+		return &revisions[len(revisions)-1]
+	}
+	for i := range revisions {
+		if revisions[i].Revision == n {
+			return &revisions[i]
+		}
+	}
+	return nil
+}
+
+// storeCaptionRevisions numbers and creates revs after the event's current
+// latest revision in one transaction, so concurrent revisions from two
+// devices never share a number.
+func storeCaptionRevisions(ctx context.Context, client *firestore.Client, explorerID, stagingEventID string, revs ...*CaptionRevision) error {
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(captionRevisionsQuery(client, explorerID, stagingEventID)).GetAll()
+		if err != nil {
+			return err
+		}
+		latest := 0
+		for _, doc := range docs {
+			if n, ok := doc.Data()["revision"].(int64); ok && int(n) > latest {
+				latest = int(n)
+			}
+		}
+		if latest+len(revs) > maxCaptionRevisions {
+			return errCaptionRevisionLimit
+		}
+		numberCaptionRevisions(latest, revs)
+		now := time.Now().UTC()
+		for _, rev := range revs {
+			rev.ExplorerID = explorerID
+			rev.StagingEventID = stagingEventID
+			rev.CreatedAt = now
+			rev.ExpiresAt = now.Add(captionRevisionTTL)
+			ref := client.Collection(captionRevisionsCollection).Doc(captionRevisionID(explorerID, stagingEventID, rev.Revision))
+			if err := tx.Create(ref, rev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// numberCaptionRevisions numbers revs in order after latest. A feedback
+// revision without BasedOn is based on the revision numbered just before it.
+func numberCaptionRevisions(latest int, revs []*CaptionRevision) {
+	for _, rev := range revs {
+		if rev.Source == CaptionRevisionSourceFeedback && rev.BasedOn == 0 {
+			rev.BasedOn = latest
+		}
+		latest++
+		rev.Revision = latest
+	}
+}
+
+// requireCaptionRevisionAccess checks that userID is in the explorer's
+// circle; any member may revise the captions of their own drafts.
+func requireCaptionRevisionAccess(ctx context.Context, client *firestore.Client, explorerID, userID string) error {
+	docs, err := client.Collection(relationshipsCollection).
+		Where("userId", "==", userID).
+		Where("explorerId", "==", explorerID).
+		Limit(1).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return fmt.Errorf("relationship lookup: %w", err)
+	}
+	if len(docs) == 0 {
+		return errCaptionRevisionForbidden
+	}
+	return nil
+}
+
+// ReviseCaption is the caption revision endpoint for a draft Reflection:
+//
+//	GET  ?explorer_id=&staging_event_id=[&revision=]  lists revisions, or returns one
+//	POST ?explorer_id=&staging_event_id= {captionRevisionRequest}
+//
+// POST rewrites the previous caption with the Companion's feedback
+// ("shorter", "mention the beach", "don't say Grandpa"), screens and
+// narrates it like GenerateAIDescription, and stores it as the next
+// revision. The first POST for a staging event also records the caption it
+// started from as revision 1, so the Companion can step back to any version
+// before sending; hand edits in between are recorded too. Revisions are in
+// the explorer's primary caption language only. Circle members and audit
+// admins may revise.
+func ReviseCaption(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	explorerID := strings.TrimSpace(r.URL.Query().Get("explorer_id"))
+	if explorerID == "" || strings.Contains(explorerID, "/") {
+		http.Error(w, "explorer_id is required", http.StatusBadRequest)
+		return
+	}
+	stagingEventID := strings.TrimSpace(r.URL.Query().Get("staging_event_id"))
+	if stagingEventID == "" || strings.ContainsAny(stagingEventID, "/.") {
+		http.Error(w, "staging_event_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !isAuditAdmin(token) {
+		err := requireCaptionRevisionAccess(ctx, client, explorerID, token.UID)
+		if errors.Is(err, errCaptionRevisionForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s3Client, err := awsS3Client(ctx)
+	if err != nil {
+		http.Error(w, "S3 Config Error", http.StatusInternalServerError)
+		return
+	}
+	presignClient := s3.NewPresignClient(s3Client)
+	// Audio is presigned only while it is still in staging.
+	presignRevisionAudio := func(rev *CaptionRevision) {
+		for _, a := range []struct{ key, url *string }{
+			{&rev.AudioS3Key, &rev.AudioURL},
+			{&rev.DeepDiveAudioS3Key, &rev.DeepDiveAudioURL},
+		} {
+			if *a.key == "" || !S3FileExists(ctx, s3Client, mediaBucket, *a.key) {
+				continue
+			}
+			presigned, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(mediaBucket),
+				Key:    aws.String(*a.key),
+			})
+			if err == nil {
+				*a.url = presigned.URL
+			}
+		}
+	}
+
+	revisions, err := loadCaptionRevisions(ctx, client, explorerID, stagingEventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if raw := r.URL.Query().Get("revision"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			rev := findCaptionRevision(revisions, n)
+			if err != nil || n < 1 || rev == nil {
+				http.Error(w, errCaptionRevisionNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			presignRevisionAudio(rev)
+			json.NewEncoder(w).Encode(rev)
+			return
+		}
+		for i := range revisions {
+			presignRevisionAudio(&revisions[i])
+		}
+		json.NewEncoder(w).Encode(map[string]any{"staging_event_id": stagingEventID, "revisions": revisions})
+
+	case http.MethodPost:
+		var req captionRevisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		feedback, err := normalizeCaptionFeedback(req.Feedback)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The caption being revised; supplied text that is not already a
+		// revision is recorded first.
+		base := findCaptionRevision(revisions, req.BaseRevision)
+		if base == nil && req.BaseRevision != 0 {
+			http.Error(w, errCaptionRevisionNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		var edited *CaptionRevision
+		if shortCaption := strings.TrimSpace(req.ShortCaption); shortCaption != "" {
+			deepDive := strings.TrimSpace(req.DeepDive)
+			if base == nil || base.ShortCaption != shortCaption || base.DeepDive != deepDive {
+				edited = &CaptionRevision{
+					Source:             CaptionRevisionSourceEdited,
+					ShortCaption:       shortCaption,
+					DeepDive:           deepDive,
+					AudioS3Key:         stagingAudioKeyFor(explorerID, req.AudioS3Key),
+					DeepDiveAudioS3Key: stagingAudioKeyFor(explorerID, req.DeepDiveAudioS3Key),
+					CreatedBy:          token.UID,
+				}
+				if len(revisions) == 0 {
+					edited.Source = CaptionRevisionSourceOriginal
+				}
+				base = edited
+			}
+		}
+		if base == nil {
+			http.Error(w, "short_caption is required for the first revision", http.StatusBadRequest)
+			return
+		}
+		if len(revisions) >= maxCaptionRevisions {
+			http.Error(w, errCaptionRevisionLimit.Error(), http.StatusConflict)
+			return
+		}
+
+		budget := checkAIBudget(ctx, client, explorerID)
+		if budget.Action == AIBudgetActionRefuse {
+			log.Printf("Refusing caption revision for %s: %s", explorerID, budget.Reason)
+			http.Error(w, budget.Reason, http.StatusTooManyRequests)
+			return
+		}
+		usage := AIUsage{ExplorerID: explorerID, CompanionID: token.UID, Source: "revise-caption"}
+
+		imageKey, err := eventImageKey(explorerID, stagingEventID, "staging")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		image, err := readS3CaptionImage(ctx, s3Client, imageKey)
+		if err != nil {
+			http.Error(w, "Failed to fetch image: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		imageMIME, ok := sniffCaptionImageMIME(image)
+		if !ok {
+			http.Error(w, "image must be JPEG, PNG or HEIC", http.StatusBadRequest)
+			return
+		}
+
+		explorerName := strings.TrimSpace(req.ExplorerName)
+		if explorerName == "" {
+			explorerName = getExplorerName(explorerID)
+		}
+		companionName := strings.TrimSpace(req.CompanionName)
+		peopleContext := strings.TrimSpace(req.PeopleContext)
+		glossary, err := loadGlossary(ctx, client, explorerID)
+		if err != nil {
+			log.Printf("Glossary unavailable, revising without it: %v", err)
+		}
+		prompt := resolveCaptionPrompt(ctx, client, explorerID, PromptVars{
+			ExplorerName:      explorerName,
+			SenderName:        companionName,
+			SenderInImage:     req.CompanionInReflection,
+			ExplorerInImage:   req.ExplorerInReflection,
+			PeopleContext:     peopleContext,
+			Glossary:          relevantGlossaryEntries(glossary, peopleContext, companionName),
+			Language:          req.Language,
+			CompanionLanguage: loadCompanionLanguage(ctx, client, token.UID),
+		}, "")
+
+		model, err := NewCaptionModel(ctx, CaptionModelConfigFromEnv())
+		if err != nil {
+			http.Error(w, "Failed to create caption model: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer model.Close()
+		captioned, err := GenerateValidatedCaption(ctx, model, CaptionRequest{
+			Prompt:        revisionPrompt(prompt.Text, &Caption{ShortCaption: base.ShortCaption, DeepDive: base.DeepDive}, feedback),
+			PromptVersion: prompt.Version,
+			Profile:       prompt.Output,
+			Image:         image,
+			ImageMIME:     imageMIME,
+		})
+		RecordCaptionUsage(ctx, client, usage, model.Name(), captioned)
+
+		rev := &CaptionRevision{
+			BasedOn:       base.Revision,
+			Source:        CaptionRevisionSourceFeedback,
+			Feedback:      feedback,
+			Language:      prompt.Languages.Primary,
+			PromptVersion: prompt.Version,
+			CreatedBy:     token.UID,
+		}
+		screen := ModerationInput{Image: image, ImageMIME: imageMIME}
+		var captionBlocked *CaptionBlockedError
+		switch {
+		case errors.As(err, &captionBlocked):
+			screen.Ratings = captionBlocked.Ratings
+		case err != nil:
+			log.Printf("Caption revision with %s failed: %v", model.Name(), err)
+			http.Error(w, "Caption Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		default:
+			rev.ShortCaption = captioned.Caption.ShortCaption
+			rev.DeepDive = captioned.Caption.DeepDive
+			screen.Texts = []string{rev.ShortCaption, rev.DeepDive}
+			screen.SafetyFlags = captioned.Caption.SafetyFlags
+			screen.Ratings = captioned.Caption.SafetyRatings
+		}
+		moderation := moderateContent(ctx, client, usage, explorerID, screen)
+		if captionBlocked != nil && !moderation.Quarantined() {
+			moderation.raise(ModerationQuarantine, "caption blocked by provider")
+		}
+		if moderation.Verdict != ModerationAllow {
+			rev.Moderation = moderation
+		}
+		if moderation.Quarantined() {
+			// Nothing is spoken or stored; the earlier revisions still stand.
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(CaptionRevision{
+				StagingEventID: stagingEventID,
+				BasedOn:        base.Revision,
+				Source:         CaptionRevisionSourceFeedback,
+				Feedback:       feedback,
+				Moderation:     moderation,
+			})
+			return
+		}
+
+		if budget.Action != AIBudgetActionTextOnly {
+			opts := SpeechOptions{LanguageCode: rev.Language, Pronunciations: glossaryPronunciations(glossary), SentencePause: narrationSentencePause}
+			for _, a := range []struct {
+				label, text, voice, prefix string
+				key                        *string
+			}{
+				{"caption", rev.ShortCaption, req.CaptionVoice, "revision_", &rev.AudioS3Key},
+				{"deep_dive", rev.DeepDive, req.DeepDiveVoice, "revision_deepdive_", &rev.DeepDiveAudioS3Key},
+			} {
+				if a.text == "" {
+					continue
+				}
+				opts.VoiceName = a.voice
+				speechData, err := GenerateMeteredSpeech(ctx, client, usage, a.text, opts)
+				if err != nil || len(speechData) == 0 {
+					log.Printf("TTS ERROR (revision %s): err=%v, bytes=%d — revising without audio", a.label, err, len(speechData))
+					continue
+				}
+				audioKey := stagingTTSKey(explorerID, token.UID, fmt.Sprintf("%s%d.mp3", a.prefix, time.Now().UnixNano()))
+				if err := UploadToS3(ctx, audioKey, speechData, "audio/mpeg"); err != nil {
+					log.Printf("TTS ERROR (revision %s): S3 upload failed: %v", a.label, err)
+					continue
+				}
+				*a.key = audioKey
+			}
+		}
+
+		toStore := []*CaptionRevision{rev}
+		if edited != nil {
+			toStore = []*CaptionRevision{edited, rev}
+		}
+		if err := storeCaptionRevisions(ctx, client, explorerID, stagingEventID, toStore...); err != nil {
+			if errors.Is(err, errCaptionRevisionLimit) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, "store revision failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("ReviseCaption: %s stored revision %d of %s for %s\n", token.UID, rev.Revision, stagingEventID, explorerID)
+		presignRevisionAudio(rev)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rev)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,69 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberCaptionRevisions(t *testing.T) {
+	original := &CaptionRevision{Source: CaptionRevisionSourceOriginal}
+	edited := &CaptionRevision{Source: CaptionRevisionSourceEdited}
+	feedback := &CaptionRevision{Source: CaptionRevisionSourceFeedback}
+	numberCaptionRevisions(0, []*CaptionRevision{original, edited, feedback})
+	if original.Revision != 1 || edited.Revision != 2 || feedback.Revision != 3 {
+		t.Errorf("revisions = %d, %d, %d; want 1, 2, 3", original.Revision, edited.Revision, feedback.Revision)
+	}
+	if feedback.BasedOn != 2 || original.BasedOn != 0 || edited.BasedOn != 0 {
+		t.Errorf("based on = %d, %d, %d; want 0, 0, 2", original.BasedOn, edited.BasedOn, feedback.BasedOn)
+	}
+
+	stepBack := &CaptionRevision{Source: CaptionRevisionSourceFeedback, BasedOn: 1}
+	numberCaptionRevisions(5, []*CaptionRevision{stepBack})
+	if stepBack.Revision != 6 || stepBack.BasedOn != 1 {
+		t.Errorf("feedback on an earlier revision: revision %d based on %d, want 6 based on 1", stepBack.Revision, stepBack.BasedOn)
+	}
+}
+
+func TestFindCaptionRevision(t *testing.T) {
+	revisions := []CaptionRevision{{Revision: 1}, {Revision: 2}, {Revision: 3}}
+	if got := findCaptionRevision(revisions, 0); got == nil || got.Revision != 3 {
+		t.Errorf("findCaptionRevision(0) = %v, want the latest", got)
+	}
+	if got := findCaptionRevision(revisions, 2); got == nil || got.Revision != 2 {
+		t.Errorf("findCaptionRevision(2) = %v, want revision 2", got)
+	}
+	if got := findCaptionRevision(revisions, 7); got != nil {
+		t.Errorf("findCaptionRevision(7) = %v, want nil", got)
+	}
+	if got := findCaptionRevision(nil, 0); got != nil {
+		t.Errorf("findCaptionRevision with no revisions = %v, want nil", got)
+	}
+}
+
+func TestNormalizeCaptionFeedback(t *testing.T) {
+	got, err := normalizeCaptionFeedback("  make it\n shorter  ")
+	if err != nil || got != "make it shorter" {
+		t.Errorf("normalizeCaptionFeedback = %q, %v; want %q", got, err, "make it shorter")
+	}
+	if _, err := normalizeCaptionFeedback(" \n "); err == nil {
+		t.Error("normalizeCaptionFeedback accepted blank feedback")
+	}
+	if _, err := normalizeCaptionFeedback(strings.Repeat("x", maxCaptionFeedbackLength+1)); err == nil {
+		t.Error("normalizeCaptionFeedback accepted over-long feedback")
+	}
+}
+
+func TestStagingAudioKeyFor(t *testing.T) {
+	tests := map[string]string{
+		"staging/explorer-1/tts/123.mp3":         "staging/explorer-1/tts/123.mp3",
+		"staging/explorer-2/tts/123.mp3":         "",
+		"staging/explorer-1/tts/../to/1/img.jpg": "",
+		"explorer-1/to/123/audio.mp3":            "",
+		"":                                       "",
+	}
+	for key, want := range tests {
+		if got := stagingAudioKeyFor("explorer-1", key); got != want {
+			t.Errorf("stagingAudioKeyFor(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	maxShortCaptionWords = 10
+	minDeepDiveSentences = 2
+	maxDeepDiveSentences = 3
+
+	// captionMaxRepairs is how many times a caption is re-asked after the
+	// first attempt breaks the schema or the content rules.
+	captionMaxRepairs = 2
+
+	captionQualityLogSource = "caption-quality"
+)
+
+// Safety flags the model may raise about an image. They are advisory: the
+// caption is still returned, and callers decide what to do with the flags.
+var captionSafetyFlags = []string{
+	"medical",
+	"injury",
+	"distress",
+	"unsafe_activity",
+	"explicit",
+	"identity_uncertain",
+}
+
+// errCaptionSchema marks a model reply that is not JSON matching the caption
+// schema. GenerateValidatedCaption re-asks on it instead of failing.
+var errCaptionSchema = errors.New("caption response does not match schema")
+
+var sentenceEndPattern = regexp.MustCompile(`[.!?]+["')\]]*(\s+|$)`)
+
+// captionGeminiSchema is the declared response schema for Gemini calls.
+func captionGeminiSchema() *genai.Schema {
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"short_caption": {
+				Type:        genai.TypeString,
+				Description: fmt.Sprintf("Warm greeting to the viewer, at most %d words.", maxShortCaptionWords),
+			},
+			"deep_dive": {
+				Type:        genai.TypeString,
+				Description: fmt.Sprintf("A %d-%d sentence story about the image, spoken to the viewer.", minDeepDiveSentences, maxDeepDiveSentences),
+			},
+			"detected_people": {
+				Type:        genai.TypeArray,
+				Description: "Names of people given in the context who appear in the image, or short visible descriptions of unnamed people.",
+				Items:       &genai.Schema{Type: genai.TypeString},
+			},
+			"safety_flags": {
+				Type:        genai.TypeArray,
+				Description: "Concerns about the image content. Empty when there are none.",
+				Items:       &genai.Schema{Type: genai.TypeString, Enum: captionSafetyFlags},
+			},
+		},
+		Required: []string{"short_caption", "deep_dive", "detected_people", "safety_flags"},
+	}
+}
+
+// captionJSONSchema is the same schema in JSON Schema form, for providers
+// that accept OpenAI's response_format json_schema.
+func captionJSONSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"short_caption":   map[string]any{"type": "string"},
+			"deep_dive":       map[string]any{"type": "string"},
+			"detected_people": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"safety_flags": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string", "enum": captionSafetyFlags},
+			},
+		},
+		"required":             []string{"short_caption", "deep_dive", "detected_people", "safety_flags"},
+		"additionalProperties": false,
+	}
+}
+
+// decodeCaption strictly decodes a schema-constrained reply. Anything other
+// than a single caption object is errCaptionSchema.
+func decodeCaption(text string) (*Caption, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	var caption Caption
+	if err := dec.Decode(&caption); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCaptionSchema, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("%w: trailing data after JSON object", errCaptionSchema)
+	}
+	return &caption, nil
+}
+
+// countSentences counts sentence-ending punctuation runs, treating trailing
+// text without punctuation as one more sentence.
+func countSentences(text string) int {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0
+	}
+	ends := sentenceEndPattern.FindAllStringIndex(text, -1)
+	n := len(ends)
+	if n == 0 || ends[n-1][1] < len(text) {
+		n++
+	}
+	return n
+}
+
+// ValidateCaption returns the content rules c breaks, as short sentences the
+// model can be shown when it is re-asked. An empty result means c is valid.
+func ValidateCaption(c *Caption) []string {
+	var violations []string
+	switch words := len(strings.Fields(c.ShortCaption)); {
+	case words == 0:
+		violations = append(violations, "short_caption is empty.")
+	case words > maxShortCaptionWords:
+		violations = append(violations, fmt.Sprintf("short_caption has %d words; it must have at most %d.", words, maxShortCaptionWords))
+	}
+	switch sentences := countSentences(c.DeepDive); {
+	case sentences == 0:
+		violations = append(violations, "deep_dive is empty.")
+	case sentences < minDeepDiveSentences || sentences > maxDeepDiveSentences:
+		violations = append(violations, fmt.Sprintf("deep_dive has %d sentences; it must have %d to %d.", sentences, minDeepDiveSentences, maxDeepDiveSentences))
+	}
+	for _, flag := range c.SafetyFlags {
+		if !containsString(captionSafetyFlags, flag) {
+			violations = append(violations, fmt.Sprintf("safety_flags contains %q; allowed values are %s.", flag, strings.Join(captionSafetyFlags, ", ")))
+		}
+	}
+	return violations
+}
+
+// CaptionResult is a caption together with how much repair it needed.
+type CaptionResult struct {
+	Caption  *Caption
+	Attempts int
+	// Violations lists the rules the returned caption still breaks after the
+	// last repair attempt. Callers may still use it; it is the best the model
+	// produced.
+	Violations []string
+	// Usage sums every attempt, including replies that were rejected.
+	Usage CaptionUsage
+	// Latency is the wall time of all attempts together.
+	Latency time.Duration
+}
+
+// Repaired reports whether the first reply was rejected.
+func (r *CaptionResult) Repaired() bool { return r.Attempts > 1 }
+
+// repairCaptionPrompt appends the broken rules and the previous reply to the
+// original prompt so the model can correct itself.
+func repairCaptionPrompt(prompt string, previous *Caption, violations []string) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\nYOUR PREVIOUS ANSWER BROKE THESE RULES:\n")
+	for _, v := range violations {
+		b.WriteString("- " + v + "\n")
+	}
+	if previous != nil {
+		if data, err := json.Marshal(previous); err == nil {
+			b.WriteString("\nPrevious answer: ")
+			b.Write(data)
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\nReturn a corrected JSON object that follows every rule.")
+	return b.String()
+}
+
+// GenerateValidatedCaption calls model and re-asks, up to captionMaxRepairs
+// times, while the reply fails to decode or breaks ValidateCaption or the
+// request's output profile.
+// Transport and provider errors end the attempts: if an earlier attempt
+// produced a caption, that caption is returned (with its Violations) instead
+// of the error, unless the provider blocked the image. Otherwise the result
+// is still returned, with a nil Caption, so callers can account for the
+// usage of the attempts that were made.
+func GenerateValidatedCaption(ctx context.Context, model CaptionModel, req CaptionRequest) (*CaptionResult, error) {
+	result := &CaptionResult{}
+	start := time.Now()
+	attemptReq := req
+	var lastSchemaErr error
+	for attempt := 0; attempt <= captionMaxRepairs; attempt++ {
+		result.Attempts = attempt + 1
+		caption, err := model.GenerateCaption(ctx, attemptReq)
+		result.Latency = time.Since(start)
+		if caption != nil {
+			result.Usage.add(caption.Usage)
+		}
+		var violations []string
+		switch {
+		case errors.Is(err, errCaptionSchema):
+			lastSchemaErr = err
+			caption = nil
+			log.Printf("Caption attempt %d/%d from %s: %v", attempt+1, captionMaxRepairs+1, model.Name(), err)
+			violations = []string{"The answer was not a single JSON object with exactly the fields short_caption, deep_dive, detected_people and safety_flags."}
+		case err != nil:
+			var blocked *CaptionBlockedError
+			if result.Caption != nil && !errors.As(err, &blocked) {
+				log.Printf("Caption repair attempt %d/%d from %s failed, keeping the best earlier caption: %v", attempt+1, captionMaxRepairs+1, model.Name(), err)
+				recordCaptionQuality(model.Name(), req, result, nil)
+				return result, nil
+			}
+			result.Caption, result.Violations = nil, nil
+			recordCaptionQuality(model.Name(), req, result, err)
+			return result, err
+		default:
+			violations = append(ValidateCaption(caption), req.Profile.Validate(caption)...)
+			if result.Caption == nil || len(violations) <= len(result.Violations) {
+				result.Caption = caption
+				result.Violations = violations
+			}
+			if len(violations) == 0 {
+				recordCaptionQuality(model.Name(), req, result, nil)
+				return result, nil
+			}
+			log.Printf("Caption attempt %d/%d from %s broke rules: %s", attempt+1, captionMaxRepairs+1, model.Name(), strings.Join(violations, " "))
+		}
+		attemptReq.Prompt = repairCaptionPrompt(req.Prompt, caption, violations)
+	}
+
+	if result.Caption == nil {
+		err := fmt.Errorf("no usable caption after %d attempts: %w", result.Attempts, lastSchemaErr)
+		recordCaptionQuality(model.Name(), req, result, err)
+		return result, err
+	}
+	recordCaptionQuality(model.Name(), req, result, nil)
+	return result, nil
+}
+
+var captionStats struct {
+	calls, repaired, unresolved, failed atomic.Int64
+}
+
+// CaptionRepairStats counts GenerateValidatedCaption outcomes in this
+// process: Repaired needed at least one re-ask, Unresolved still broke a
+// rule after the last one, and Failed produced no caption at all.
+type CaptionRepairStats struct {
+	Calls, Repaired, Unresolved, Failed int64
+}
+
+// CaptionStats returns the process-wide repair counters, for command-line
+// summaries. Deployed functions report the same data per call as
+// caption-quality log entries, which back the log-based repair-rate metric.
+func CaptionStats() CaptionRepairStats {
+	return CaptionRepairStats{
+		Calls:      captionStats.calls.Load(),
+		Repaired:   captionStats.repaired.Load(),
+		Unresolved: captionStats.unresolved.Load(),
+		Failed:     captionStats.failed.Load(),
+	}
+}
+
+func recordCaptionQuality(modelName string, req CaptionRequest, result *CaptionResult, err error) {
+	captionStats.calls.Add(1)
+	if result.Repaired() {
+		captionStats.repaired.Add(1)
+	}
+	severity := "INFO"
+	switch {
+	case err != nil:
+		captionStats.failed.Add(1)
+		severity = "ERROR"
+	case len(result.Violations) > 0:
+		captionStats.unresolved.Add(1)
+		severity = "WARNING"
+	}
+
+	payload := map[string]any{
+		"severity":   severity,
+		"source":     captionQualityLogSource,
+		"message":    "caption generated",
+		"model":      modelName,
+		"prompt":     req.PromptVersion,
+		"profile":    req.Profile.Label(),
+		"attempts":   result.Attempts,
+		"repaired":   result.Repaired(),
+		"violations": result.Violations,
+		"tokens_in":  result.Usage.InputTokens,
+		"tokens_out": result.Usage.OutputTokens,
+		"latency_ms": result.Latency.Milliseconds(),
+	}
+	if err != nil {
+		payload["message"] = "caption failed"
+		payload["error"] = err.Error()
+	}
+	data, mErr := json.Marshal(payload)
+	if mErr != nil {
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", data)
+}
@@ -0,0 +1,173 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCountSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"One sentence.", 1},
+		{"No punctuation", 1},
+		{"One. Two! Three?", 3},
+		{"Wait... what?! Really.", 3},
+		{`She said "hi." Then left.`, 2},
+		{"Ends with a clause. And then some", 2},
+		{"Version 2.5 is out.", 1},
+	}
+	for _, tt := range tests {
+		if got := countSentences(tt.text); got != tt.want {
+			t.Errorf("countSentences(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCaption(t *testing.T) {
+	valid := Caption{
+		ShortCaption: "Look at the big red kite!",
+		DeepDive:     "Grandpa is flying a kite. It goes up high in the sky.",
+		SafetyFlags:  []string{"medical"},
+	}
+	tests := []struct {
+		name   string
+		edit   func(*Caption)
+		breaks []string
+	}{
+		{name: "valid", edit: func(*Caption) {}},
+		{name: "empty short caption", edit: func(c *Caption) { c.ShortCaption = " " }, breaks: []string{"short_caption is empty."}},
+		{name: "long short caption", edit: func(c *Caption) { c.ShortCaption = strings.Repeat("word ", maxShortCaptionWords+1) },
+			breaks: []string{"short_caption has 11 words"}},
+		{name: "empty deep dive", edit: func(c *Caption) { c.DeepDive = "" }, breaks: []string{"deep_dive is empty."}},
+		{name: "one sentence", edit: func(c *Caption) { c.DeepDive = "Just one." }, breaks: []string{"deep_dive has 1 sentences"}},
+		{name: "four sentences", edit: func(c *Caption) { c.DeepDive = "One. Two. Three. Four." }, breaks: []string{"deep_dive has 4 sentences"}},
+		{name: "unknown flag", edit: func(c *Caption) { c.SafetyFlags = []string{"medical", "spooky"} }, breaks: []string{`safety_flags contains "spooky"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.edit(&c)
+			got := ValidateCaption(&c)
+			if len(got) != len(tt.breaks) {
+				t.Fatalf("ValidateCaption = %q, want %d violation(s)", got, len(tt.breaks))
+			}
+			for i, want := range tt.breaks {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("violation %d = %q, want prefix %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCaption(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"valid", `{"short_caption":"Hi!","deep_dive":"A. B.","detected_people":[],"safety_flags":[]}`, false},
+		{"unknown field", `{"short_caption":"Hi!","mood":"happy"}`, true},
+		{"trailing object", `{"short_caption":"Hi!"} {"short_caption":"Again"}`, true},
+		{"not JSON", "Here is your caption: Hi!", true},
+	}
+	for _, tt := range tests {
+		_, err := decodeCaption(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeCaption error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, errCaptionSchema) {
+			t.Errorf("%s: error %v is not errCaptionSchema", tt.name, err)
+		}
+	}
+}
+
+// scriptedCaptionModel answers each call with the next scripted reply.
+type scriptedCaptionModel struct {
+	replies []scriptedCaption
+	prompts []string
+}
+
+type scriptedCaption struct {
+	caption *Caption
+	err     error
+}
+
+func (m *scriptedCaptionModel) Name() string { return "test/scripted" }
+
+func (m *scriptedCaptionModel) Close() error { return nil }
+
+func (m *scriptedCaptionModel) GenerateCaption(ctx context.Context, req CaptionRequest) (*Caption, error) {
+	m.prompts = append(m.prompts, req.Prompt)
+	if len(m.prompts) > len(m.replies) {
+		return nil, fmt.Errorf("unexpected call %d", len(m.prompts))
+	}
+	reply := m.replies[len(m.prompts)-1]
+	return reply.caption, reply.err
+}
+
+func TestGenerateValidatedCaption(t *testing.T) {
+	good := &Caption{ShortCaption: "Look at the kite!", DeepDive: "Grandpa flies a kite. It goes up high."}
+	tooLong := &Caption{ShortCaption: "Look at the kite!", DeepDive: "One. Two. Three. Four."}
+	worse := &Caption{ShortCaption: "", DeepDive: "One."}
+	schemaErr := fmt.Errorf("%w: bad reply", errCaptionSchema)
+	transportErr := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	blockedErr := &CaptionBlockedError{}
+
+	tests := []struct {
+		name           string
+		replies        []scriptedCaption
+		wantCaption    *Caption
+		wantErr        error
+		wantAttempts   int
+		wantViolations int
+	}{
+		{name: "first reply valid", replies: []scriptedCaption{{caption: good}}, wantCaption: good, wantAttempts: 1},
+		{name: "repaired", replies: []scriptedCaption{{caption: tooLong}, {caption: good}}, wantCaption: good, wantAttempts: 2},
+		{name: "schema error then valid", replies: []scriptedCaption{{err: schemaErr}, {caption: good}}, wantCaption: good, wantAttempts: 2},
+		{name: "keeps the best unresolved reply",
+			replies:     []scriptedCaption{{caption: tooLong}, {caption: worse}, {caption: worse}},
+			wantCaption: tooLong, wantAttempts: 3, wantViolations: 1},
+		{name: "never decodes",
+			replies: []scriptedCaption{{err: schemaErr}, {err: schemaErr}, {err: schemaErr}},
+			wantErr: errCaptionSchema, wantAttempts: 3},
+		{name: "transport failure first", replies: []scriptedCaption{{err: transportErr}}, wantErr: transportErr, wantAttempts: 1},
+		{name: "transport failure during repair keeps the earlier caption",
+			replies:     []scriptedCaption{{caption: tooLong}, {err: transportErr}},
+			wantCaption: tooLong, wantAttempts: 2, wantViolations: 1},
+		{name: "block during repair is returned",
+			replies: []scriptedCaption{{caption: tooLong}, {err: blockedErr}},
+			wantErr: blockedErr, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &scriptedCaptionModel{replies: tt.replies}
+			result, err := GenerateValidatedCaption(context.Background(), model, CaptionRequest{Prompt: "Caption this."})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if result.Caption != tt.wantCaption {
+				t.Errorf("Caption = %+v, want %+v", result.Caption, tt.wantCaption)
+			}
+			if result.Attempts != tt.wantAttempts {
+				t.Errorf("Attempts = %d, want %d", result.Attempts, tt.wantAttempts)
+			}
+			if len(result.Violations) != tt.wantViolations {
+				t.Errorf("Violations = %q, want %d", result.Violations, tt.wantViolations)
+			}
+			for i, prompt := range model.prompts[1:] {
+				if !strings.Contains(prompt, "YOUR PREVIOUS ANSWER BROKE THESE RULES") {
+					t.Errorf("repair prompt %d does not list the broken rules", i+1)
+				}
+			}
+		})
+	}
+}
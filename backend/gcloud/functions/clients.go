@@ -0,0 +1,180 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	texttospeech "cloud.google.com/go/texttospeech/apiv1"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Service clients are expensive to create (each loads credentials and dials
+// its own connection) and safe for concurrent use, so every instance opens
+// one of each on first use and shares it across requests and warm
+// invocations. Callers must not Close them.
+//
+// Calls made through a client report their outcome; when the connection is
+// gone, or keeps failing at the transport level, the client is retired and
+// the next caller opens a fresh one. Firestore and S3 are not reported on:
+// both redial dropped connections themselves and are used from too many
+// places to observe.
+
+const (
+	// clientReconnectFailures transport failures in a row retire a client.
+	clientReconnectFailures = 3
+	// clientRetireGrace is how long a retired client stays open for calls
+	// that already hold it.
+	clientRetireGrace = time.Minute
+)
+
+// sharedClient lazily opens one client and hands it to every caller.
+type sharedClient[T comparable] struct {
+	name  string
+	open  func(context.Context) (T, error)
+	close func(T) error // nil when the client holds nothing to release
+
+	mu       sync.Mutex
+	client   T
+	failures int
+}
+
+var (
+	ttsClients = &sharedClient[*texttospeech.Client]{
+		name:  "Text-to-Speech",
+		open:  func(ctx context.Context) (*texttospeech.Client, error) { return texttospeech.NewClient(ctx) },
+		close: (*texttospeech.Client).Close,
+	}
+	firestoreClients = &sharedClient[*firestore.Client]{
+		name:  "Firestore",
+		open:  openFirestoreClient,
+		close: (*firestore.Client).Close,
+	}
+	s3Clients = &sharedClient[*s3.Client]{
+		name: "S3",
+		open: func(ctx context.Context) (*s3.Client, error) {
+			cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+			if err != nil {
+				return nil, err
+			}
+			return s3.NewFromConfig(cfg), nil
+		},
+	}
+
+	geminiClientsMu sync.Mutex
+	geminiClients   = map[string]*sharedClient[*genai.Client]{}
+)
+
+// get returns the current client, opening it if there is none. The client
+// outlives ctx, so only ctx's values are used to open it.
+func (s *sharedClient[T]) get(ctx context.Context) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var zero T
+	if s.client != zero {
+		return s.client, nil
+	}
+	client, err := s.open(context.WithoutCancel(ctx))
+	if err != nil {
+		return zero, err
+	}
+	s.client = client
+	s.failures = 0
+	return client, nil
+}
+
+// report records the outcome of a call made with client. A closed
+// connection retires the client at once, clientReconnectFailures transport
+// failures in a row retire it too, and any other outcome shows the service
+// was reached.
+func (s *sharedClient[T]) report(client T, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client != s.client {
+		// Already retired.
+		return
+	}
+	if !connectionFailure(err) {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.failures < clientReconnectFailures && !connectionClosed(err) {
+		return
+	}
+	log.Printf("Reconnecting %s client after %d failure(s): %v", s.name, s.failures, err)
+	var zero T
+	s.client = zero
+	s.failures = 0
+	if s.close != nil {
+		time.AfterFunc(clientRetireGrace, func() {
+			if err := s.close(client); err != nil {
+				log.Printf("Closing retired %s client: %v", s.name, err)
+			}
+		})
+	}
+}
+
+// connectionClosed reports whether err came from a client whose connection
+// has been shut down; such a client will never work again.
+func connectionClosed(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Canceled && strings.Contains(s.Message(), "client connection is closing")
+}
+
+// connectionFailure reports whether err means the service could not be
+// reached, as opposed to an answer from it.
+func connectionFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if connectionClosed(err) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.Unavailable
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// geminiClientFor returns the shared Gemini client for apiKey.
+func geminiClientFor(apiKey string) *sharedClient[*genai.Client] {
+	geminiClientsMu.Lock()
+	defer geminiClientsMu.Unlock()
+	clients, ok := geminiClients[apiKey]
+	if !ok {
+		clients = &sharedClient[*genai.Client]{
+			name: "Gemini",
+			open: func(ctx context.Context) (*genai.Client, error) {
+				client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+				if err != nil {
+					return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+				}
+				return client, nil
+			},
+			close: (*genai.Client).Close,
+		}
+		geminiClients[apiKey] = clients
+	}
+	return clients
+}
+
+// awsS3Client returns the shared S3 client.
+func awsS3Client(ctx context.Context) (*s3.Client, error) {
+	return s3Clients.get(ctx)
+}
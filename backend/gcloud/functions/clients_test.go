@@ -0,0 +1,116 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testClient struct{ id int }
+
+func TestSharedClient(t *testing.T) {
+	opened := 0
+	shared := &sharedClient[*testClient]{
+		name: "test",
+		open: func(context.Context) (*testClient, error) {
+			opened++
+			return &testClient{id: opened}, nil
+		},
+	}
+	ctx := context.Background()
+	first, err := shared.get(ctx)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if again, _ := shared.get(ctx); again != first || opened != 1 {
+		t.Fatalf("second get opened a new client (%d opens)", opened)
+	}
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	for i := 1; i < clientReconnectFailures; i++ {
+		shared.report(first, unavailable)
+	}
+	shared.report(first, nil)
+	shared.report(first, unavailable)
+	if current, _ := shared.get(ctx); current != first {
+		t.Fatal("a success did not reset the failure count")
+	}
+	for i := 0; i < clientReconnectFailures; i++ {
+		shared.report(first, unavailable)
+	}
+	second, _ := shared.get(ctx)
+	if second == first || opened != 2 {
+		t.Fatalf("client was not replaced after %d failures (%d opens)", clientReconnectFailures, opened)
+	}
+
+	shared.report(first, status.Error(codes.Canceled, "grpc: the client connection is closing"))
+	if current, _ := shared.get(ctx); current != second {
+		t.Fatal("a report about a retired client replaced the current one")
+	}
+	shared.report(second, status.Error(codes.Canceled, "grpc: the client connection is closing"))
+	if third, _ := shared.get(ctx); third == second || opened != 3 {
+		t.Fatal("a closed connection did not replace the client at once")
+	}
+}
+
+func TestSharedClientOpenError(t *testing.T) {
+	fail := true
+	shared := &sharedClient[*testClient]{
+		name: "test",
+		open: func(context.Context) (*testClient, error) {
+			if fail {
+				return nil, errors.New("no credentials")
+			}
+			return &testClient{}, nil
+		},
+	}
+	if _, err := shared.get(context.Background()); err == nil {
+		t.Fatal("get returned no error when opening failed")
+	}
+	fail = false
+	if client, err := shared.get(context.Background()); err != nil || client == nil {
+		t.Errorf("get after a failed open = %v, %v; want a client", client, err)
+	}
+}
+
+func TestConnectionFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.Canceled, "grpc: the client connection is closing"), true},
+		{status.Error(codes.Canceled, "context canceled"), false},
+		{status.Error(codes.InvalidArgument, "bad voice"), false},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{errors.New("quota exceeded"), false},
+	}
+	for _, tt := range tests {
+		if got := connectionFailure(tt.err); got != tt.want {
+			t.Errorf("connectionFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestGeminiClientFor(t *testing.T) {
+	t.Cleanup(func() {
+		geminiClientsMu.Lock()
+		delete(geminiClients, "key-a")
+		delete(geminiClients, "key-b")
+		geminiClientsMu.Unlock()
+	})
+	if geminiClientFor("key-a") != geminiClientFor("key-a") {
+		t.Error("the same key got two shared clients")
+	}
+	if geminiClientFor("key-a") == geminiClientFor("key-b") {
+		t.Error("different keys share a client")
+	}
+}
@@ -11,8 +11,13 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 const (
 	relationshipsCollection = "relationships"
 	reflectionsCollection   = "reflections"
@@ -58,6 +63,7 @@ func main() {
 	mode := "DRY RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_last_reflection_sent", map[string]any{"project": projectID, "explorer": explorerID})
 	}
 	fmt.Printf("Starting lastReflectionSentAt backfill [%s] for project %s\n", mode, projectID)
 	if explorerID != "" {
@@ -66,7 +72,10 @@ func main() {
 
 	stats, err := backfillRelationships(ctx, client, apply, explorerID)
 	if err != nil {
-		log.Fatalf("Backfill failed: %v", err)
+		auditRun.Fatalf(ctx, "Backfill failed: %v", err)
+	}
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
 	}
 
 	fmt.Println("\nBackfill summary")
@@ -176,6 +185,7 @@ func backfillRelationships(
 			fmt.Printf("  write error: %v\n", err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 		stats.updated++
 	}
 
@@ -12,8 +12,13 @@ import (
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 const (
 	oldLikeTrigger = "cole_like"
 	newLikeTrigger = "explorer_like"
@@ -61,15 +66,19 @@ func main() {
 	mode := "DRY RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_notification_neutral_names", map[string]any{"project": projectID})
 	}
 	fmt.Printf("Starting notification neutral-name backfill [%s] for project %s\n", mode, projectID)
 
 	s := stats{}
 	if err := migrateSystemConfig(ctx, client, apply, &s); err != nil {
-		log.Fatalf("system_config migration failed: %v", err)
+		auditRun.Fatalf(ctx, "system_config migration failed: %v", err)
 	}
 	if err := migratePendingNotifications(ctx, client, apply, &s); err != nil {
-		log.Fatalf("pending_notifications migration failed: %v", err)
+		auditRun.Fatalf(ctx, "pending_notifications migration failed: %v", err)
+	}
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
 	}
 
 	fmt.Println("\nBackfill summary")
@@ -119,6 +128,7 @@ func migrateSystemConfig(ctx context.Context, client *firestore.Client, apply bo
 			fmt.Printf("  write failed: %v\n", err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 		s.systemConfigsUpdated++
 	}
 }
@@ -154,6 +164,7 @@ func migratePendingNotifications(ctx context.Context, client *firestore.Client,
 				fmt.Printf("  write failed: %v\n", err)
 				continue
 			}
+			auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 			s.pendingNotificationsUpdated++
 			continue
 		}
@@ -175,6 +186,7 @@ func migratePendingNotifications(ctx context.Context, client *firestore.Client,
 			continue
 		}
 		if renamed {
+			auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 			s.pendingNotificationsRenamed++
 		}
 	}
@@ -11,8 +11,13 @@ import (
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 type stats struct {
 	usersScanned               int
 	usersUpdated               int
@@ -54,18 +59,22 @@ func main() {
 	mode := "DRY RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_notifications_defaults", map[string]any{"project": projectID})
 	}
 	fmt.Printf("Starting notification defaults backfill [%s] for project %s\n", mode, projectID)
 
 	explorerDevices, s, err := loadExplorerDevicesAndBackfillConfig(ctx, client, apply)
 	if err != nil {
-		log.Fatalf("Explorer/system_config backfill failed: %v", err)
+		auditRun.Fatalf(ctx, "Explorer/system_config backfill failed: %v", err)
 	}
 	if err := backfillUsers(ctx, client, apply, &s); err != nil {
-		log.Fatalf("User backfill failed: %v", err)
+		auditRun.Fatalf(ctx, "User backfill failed: %v", err)
 	}
 	if err := migrateHistoricalLikes(ctx, client, explorerDevices, apply, &s); err != nil {
-		log.Fatalf("Historical like migration failed: %v", err)
+		auditRun.Fatalf(ctx, "Historical like migration failed: %v", err)
+	}
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
 	}
 
 	fmt.Println("\nBackfill summary")
@@ -109,6 +118,7 @@ func backfillUsers(ctx context.Context, client *firestore.Client, apply bool, s
 			fmt.Printf("  write failed: %v\n", err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 		s.usersUpdated++
 	}
 }
@@ -160,6 +170,7 @@ func loadExplorerDevicesAndBackfillConfig(ctx context.Context, client *firestore
 			fmt.Printf("  write failed: %v\n", err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(configRef), nil)
 		s.systemConfigsCreated++
 	}
 }
@@ -224,6 +235,7 @@ func migrateHistoricalLikes(ctx context.Context, client *firestore.Client, explo
 			fmt.Printf("  write failed: %v\n", err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 		s.reflectionsLikeMigrated++
 	}
 }
@@ -9,8 +9,13 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 func main() {
 	var apply bool
 	var projectID string
@@ -29,6 +34,7 @@ func main() {
 	mode := "DRY-RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_relationship_explorer_name", map[string]any{"project": projectID})
 	}
 	fmt.Printf("Backfill relationship explorerName [%s]\n\n", mode)
 
@@ -41,7 +47,7 @@ func main() {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			auditRun.Fatalf(ctx, "iterate relationships: %v", err)
 		}
 
 		data := doc.Data()
@@ -84,10 +90,14 @@ func main() {
 				fmt.Printf("  write error: %v\n", err)
 				continue
 			}
+			auditRun.Touch(functions.AuditDocTarget(doc.Ref), data)
 		}
 		updated++
 	}
 
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
+	}
 	fmt.Printf("\nUpdated: %d | Already had name: %d\n", updated, skipped)
 }
 
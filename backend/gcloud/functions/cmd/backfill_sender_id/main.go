@@ -11,8 +11,13 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 type relationship struct {
 	explorerID    string
 	userID        string
@@ -50,6 +55,7 @@ func main() {
 	mode := "DRY RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_sender_id", map[string]any{"project": projectID, "explorer": explorerID, "aliases": aliasesArg})
 	}
 	fmt.Printf("Starting sender_id backfill [%s] for project %s\n", mode, projectID)
 	if explorerID != "" {
@@ -62,7 +68,7 @@ func main() {
 
 	nameIndex, relCount, err := loadRelationshipIndex(ctx, client, explorerID)
 	if err != nil {
-		log.Fatalf("Failed loading relationships: %v", err)
+		auditRun.Fatalf(ctx, "Failed loading relationships: %v", err)
 	}
 	fmt.Printf("Loaded %d relationship rows across %d explorer(s)\n", relCount, len(nameIndex))
 	if relCount == 0 {
@@ -71,7 +77,10 @@ func main() {
 
 	stats, err := backfillReflections(ctx, client, nameIndex, explorerID, aliases, apply)
 	if err != nil {
-		log.Fatalf("Backfill failed: %v", err)
+		auditRun.Fatalf(ctx, "Backfill failed: %v", err)
+	}
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
 	}
 
 	fmt.Println("\nBackfill summary")
@@ -318,6 +327,7 @@ func backfillReflections(
 				out.writeErrors++
 				continue
 			}
+			auditRun.Touch(functions.AuditDocTarget(doc.Ref), doc.Data())
 		}
 		out.updated++
 	}
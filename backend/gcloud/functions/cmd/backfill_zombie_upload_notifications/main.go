@@ -24,8 +24,13 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"mirror.local/functions"
 )
 
+// auditRun records every -apply write in audit_log; it is nil (a no-op) on
+// dry runs.
+var auditRun *functions.AuditRun
+
 const (
 	collectionName          = "pending_notifications"
 	companionUploadTrigger  = "companion_upload"
@@ -75,6 +80,7 @@ func main() {
 	mode := "DRY RUN"
 	if apply {
 		mode = "APPLY"
+		auditRun = functions.StartAuditRun(client, functions.CLIAuditActor(), "backfill_zombie_upload_notifications", map[string]any{"project": projectID, "explorer": explorerID, "doc": docID})
 	}
 	fmt.Printf("Reopening zombie companion_upload notifications [%s] project=%s\n", mode, projectID)
 	if explorerID != "" {
@@ -87,7 +93,10 @@ func main() {
 
 	s, examples, err := repairZombies(ctx, client, apply, explorerID, docID)
 	if err != nil {
-		log.Fatalf("Backfill failed: %v", err)
+		auditRun.Fatalf(ctx, "Backfill failed: %v", err)
+	}
+	if err := auditRun.Finish(ctx, nil); err != nil {
+		log.Printf("Audit log write failed: %v", err)
 	}
 
 	fmt.Println("\nSummary")
@@ -203,6 +212,7 @@ func repairZombies(
 			log.Printf("update failed for %s: %v", doc.Ref.ID, err)
 			continue
 		}
+		auditRun.Touch(functions.AuditDocTarget(doc.Ref), doc.Data())
 		out.repaired++
 	}
 
@@ -0,0 +1,293 @@
+// Command captioneval runs a directory of golden images through two caption
+// prompt/model variants, scores each caption against the case's rules and
+// writes a Markdown diff report.
+//
+//	go run ./cmd/captioneval -dir cmd/captioneval/testdata -prompt-b new_prompt.txt
+//	go run ./cmd/captioneval -dir cmd/captioneval/testdata -model-a fake -out /tmp/report.md
+//
+// The directory holds cases.json (a list of functions.CaptionEvalCase) and
+// the images it names. A variant is a prompt template file (empty for the
+// built-in prompt) and a model: "env" reads CAPTION_PROVIDER and friends,
+// "fake" runs offline, and "provider/model" picks one directly.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mirror.local/functions"
+)
+
+type variant struct {
+	label  string
+	prompt string // template body; empty is the built-in prompt
+	model  functions.CaptionModel
+}
+
+// outcome is one variant's caption for one case.
+type outcome struct {
+	caption    *functions.Caption
+	attempts   int
+	violations []string
+	err        error
+}
+
+func main() {
+	var (
+		dir              string
+		promptA, promptB string
+		modelA, modelB   string
+		out              string
+		failOnRegression bool
+	)
+
+	flag.StringVar(&dir, "dir", "", "Directory holding cases.json and its images")
+	flag.StringVar(&promptA, "prompt-a", "", "Prompt template file for variant A (default: built-in prompt)")
+	flag.StringVar(&promptB, "prompt-b", "", "Prompt template file for variant B (default: built-in prompt)")
+	flag.StringVar(&modelA, "model-a", "env", `Model for variant A: "env", "fake" or "provider/model"`)
+	flag.StringVar(&modelB, "model-b", "", "Model for variant B (default: same as -model-a)")
+	// Caption-quality log entries go to stdout, so the report gets its own file.
+	flag.StringVar(&out, "out", "captioneval.md", "Write the Markdown report to this file")
+	flag.BoolVar(&failOnRegression, "fail-on-regression", false, "Exit 1 when B breaks more rules than A on any case")
+	flag.Parse()
+
+	if dir == "" {
+		log.Fatal("Missing -dir. Pass the directory holding cases.json.")
+	}
+	if modelB == "" {
+		modelB = modelA
+	}
+
+	cases, err := loadCases(filepath.Join(dir, "cases.json"))
+	if err != nil {
+		log.Fatalf("Failed to load cases: %v", err)
+	}
+
+	ctx := context.Background()
+	a, err := newVariant(ctx, promptA, modelA)
+	if err != nil {
+		log.Fatalf("Variant A: %v", err)
+	}
+	defer a.model.Close()
+	b, err := newVariant(ctx, promptB, modelB)
+	if err != nil {
+		log.Fatalf("Variant B: %v", err)
+	}
+	defer b.model.Close()
+	fmt.Fprintf(os.Stderr, "A: %s\nB: %s\n", a.label, b.label)
+
+	results := make([][2]outcome, len(cases))
+	for i, tc := range cases {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", i+1, len(cases), tc.Name)
+		image, err := os.ReadFile(filepath.Join(dir, tc.Image))
+		if err != nil {
+			log.Fatalf("Case %s: %v", tc.Name, err)
+		}
+		results[i][0] = runCase(ctx, a, tc, image)
+		results[i][1] = runCase(ctx, b, tc, image)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatalf("Failed to create report: %v", err)
+	}
+	regressions := writeReport(f, a, b, cases, results)
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Report written to %s; %d case(s) regressed\n", out, regressions)
+	if failOnRegression && regressions > 0 {
+		os.Exit(1)
+	}
+}
+
+func loadCases(path string) ([]functions.CaptionEvalCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cases []functions.CaptionEvalCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, tc := range cases {
+		if tc.Image == "" {
+			return nil, fmt.Errorf("case %d has no image", i+1)
+		}
+		if tc.Name == "" {
+			cases[i].Name = tc.Image
+		}
+	}
+	return cases, nil
+}
+
+func newVariant(ctx context.Context, promptFile, modelSpec string) (variant, error) {
+	v := variant{label: "builtin"}
+	if promptFile != "" {
+		data, err := os.ReadFile(promptFile)
+		if err != nil {
+			return v, err
+		}
+		v.prompt = string(data)
+		v.label = "file:" + filepath.Base(promptFile)
+	}
+
+	cfg := functions.CaptionModelConfigFromEnv()
+	switch modelSpec {
+	case "", "env":
+	case functions.CaptionProviderFake:
+		cfg = functions.CaptionModelConfig{Provider: functions.CaptionProviderFake}
+	default:
+		provider, model, ok := strings.Cut(modelSpec, "/")
+		if !ok {
+			return v, fmt.Errorf("model %q is not env, fake or provider/model", modelSpec)
+		}
+		cfg = functions.CaptionModelConfig{Provider: provider, Model: model, BaseURL: os.Getenv("CAPTION_BASE_URL")}
+		if provider == functions.CaptionProviderGemini {
+			cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+		} else {
+			cfg.APIKey = os.Getenv("CAPTION_API_KEY")
+		}
+	}
+	// Fallbacks would hide which model produced a caption.
+	cfg.Fallbacks = nil
+	model, err := functions.NewCaptionModel(ctx, cfg)
+	if err != nil {
+		return v, err
+	}
+	v.model = model
+	v.label += " / " + model.Name()
+	return v, nil
+}
+
+func runCase(ctx context.Context, v variant, tc functions.CaptionEvalCase, image []byte) outcome {
+	prompt, err := functions.RenderEvalPrompt(v.prompt, tc)
+	if err != nil {
+		return outcome{err: err}
+	}
+	result, err := functions.GenerateValidatedCaption(ctx, v.model, functions.CaptionRequest{
+		Prompt:        prompt,
+		Image:         image,
+		ImageMIME:     http.DetectContentType(image),
+		PromptVersion: v.label,
+		Profile:       tc.OutputProfile(),
+	})
+	o := outcome{err: err}
+	if result != nil {
+		o.attempts = result.Attempts
+		o.caption = result.Caption
+	}
+	if o.caption != nil {
+		o.violations = functions.ScoreCaption(o.caption, tc)
+	}
+	return o
+}
+
+// score counts broken rules; a failed call counts as one.
+func (o outcome) score() int {
+	if o.caption == nil {
+		return 1
+	}
+	return len(o.violations)
+}
+
+// writeReport writes the comparison and returns how many cases B did worse on.
+func writeReport(w io.Writer, a, b variant, cases []functions.CaptionEvalCase, results [][2]outcome) int {
+	var totals [2]int
+	var passed [2]int
+	rules := [2]map[string]int{{}, {}}
+	var regressed, improved []string
+	for i, pair := range results {
+		for side, o := range pair {
+			totals[side] += o.score()
+			if o.score() == 0 {
+				passed[side]++
+			}
+			for _, v := range o.violations {
+				rules[side][v]++
+			}
+			if o.err != nil {
+				rules[side]["(call failed)"]++
+			}
+		}
+		switch {
+		case pair[1].score() > pair[0].score():
+			regressed = append(regressed, cases[i].Name)
+		case pair[1].score() < pair[0].score():
+			improved = append(improved, cases[i].Name)
+		}
+	}
+
+	fmt.Fprintf(w, "# Caption evaluation\n\n")
+	fmt.Fprintf(w, "- **A:** %s\n- **B:** %s\n\n", a.label, b.label)
+	fmt.Fprintf(w, "| | A | B |\n|---|---|---|\n")
+	fmt.Fprintf(w, "| Cases passed | %d/%d | %d/%d |\n", passed[0], len(cases), passed[1], len(cases))
+	fmt.Fprintf(w, "| Rules broken | %d | %d |\n\n", totals[0], totals[1])
+	fmt.Fprintf(w, "Regressed: %s\n\nImproved: %s\n\n", nameList(regressed), nameList(improved))
+
+	fmt.Fprintf(w, "## Rules broken\n\n| Rule | A | B |\n|---|---|---|\n")
+	seen := map[string]bool{}
+	var ruleNames []string
+	for _, counts := range rules {
+		for rule := range counts {
+			if !seen[rule] {
+				seen[rule] = true
+				ruleNames = append(ruleNames, rule)
+			}
+		}
+	}
+	sort.Strings(ruleNames)
+	for _, rule := range ruleNames {
+		fmt.Fprintf(w, "| %s | %d | %d |\n", markdownCell(rule), rules[0][rule], rules[1][rule])
+	}
+
+	fmt.Fprintf(w, "\n## Cases\n")
+	for i, tc := range cases {
+		pair := results[i]
+		fmt.Fprintf(w, "\n### %s (%s)\n\n", tc.Name, tc.Image)
+		fmt.Fprintf(w, "| | A | B |\n|---|---|---|\n")
+		fmt.Fprintf(w, "| Short caption | %s | %s |\n", captionField(pair[0], func(c *functions.Caption) string { return c.ShortCaption }), captionField(pair[1], func(c *functions.Caption) string { return c.ShortCaption }))
+		fmt.Fprintf(w, "| Deep dive | %s | %s |\n", captionField(pair[0], func(c *functions.Caption) string { return c.DeepDive }), captionField(pair[1], func(c *functions.Caption) string { return c.DeepDive }))
+		fmt.Fprintf(w, "| Attempts | %d | %d |\n", pair[0].attempts, pair[1].attempts)
+		fmt.Fprintf(w, "| Rules broken | %s | %s |\n", violationCell(pair[0]), violationCell(pair[1]))
+	}
+	return len(regressed)
+}
+
+func nameList(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+func captionField(o outcome, field func(*functions.Caption) string) string {
+	if o.caption == nil {
+		return "—"
+	}
+	return markdownCell(field(o.caption))
+}
+
+func violationCell(o outcome) string {
+	if o.err != nil && o.caption == nil {
+		return markdownCell("call failed: " + o.err.Error())
+	}
+	if len(o.violations) == 0 {
+		return "none"
+	}
+	return markdownCell(strings.Join(o.violations, "\n"))
+}
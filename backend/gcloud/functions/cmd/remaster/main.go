@@ -10,11 +10,10 @@ import (
 	"strings"
 	"time"
 
+	"cloud.google.com/go/firestore"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 	"mirror.local/functions"
 )
 
@@ -22,6 +21,7 @@ var (
 	BucketName = "reflections-1200b-storage"
 	UserID     = getEnv("EXPLORER_ID", "explorer")
 	Region     = "us-east-1"
+	ProjectID  = getEnv("GCP_PROJECT", "reflections-1200b")
 )
 
 func getEnv(key, fallback string) string {
@@ -33,11 +33,6 @@ func getEnv(key, fallback string) string {
 
 func main() {
 	// 1. Setup Environment
-	apiKeyGemini := os.Getenv("GEMINI_API_KEY")
-	if apiKeyGemini == "" {
-		log.Fatal("❌ GEMINI_API_KEY is not set")
-	}
-
 	ctx := context.Background()
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(Region))
 	if err != nil {
@@ -46,13 +41,24 @@ func main() {
 
 	s3Client := s3.NewFromConfig(cfg)
 
-	// Setup Gemini
-	genaiClient, err := genai.NewClient(ctx, option.WithAPIKey(apiKeyGemini))
+	// Caption and TTS calls are recorded in the explorer's AI usage ledger.
+	fsClient, err := firestore.NewClient(ctx, ProjectID)
+	if err != nil {
+		log.Fatalf("❌ Firestore Error: %v", err)
+	}
+	defer fsClient.Close()
+	usage := functions.AIUsage{ExplorerID: UserID, Source: "remaster"}
+
+	// Setup caption model (CAPTION_PROVIDER selects gemini, openai or fake).
+	// A batch run can afford to wait out a rate-limit window between retries.
+	modelCfg := functions.CaptionModelConfigFromEnv()
+	modelCfg.Retry = functions.RetryPolicy{MaxAttempts: 4, BaseDelay: 5 * time.Second, MaxDelay: 60 * time.Second}
+	model, err := functions.NewCaptionModel(ctx, modelCfg)
 	if err != nil {
-		log.Fatalf("❌ Gemini Client Error: %v", err)
+		log.Fatalf("❌ Caption Model Error: %v", err)
 	}
-	defer genaiClient.Close()
-	model := genaiClient.GenerativeModel("gemini-2.5-flash-lite")
+	defer model.Close()
+	fmt.Printf("🤖 Caption model: %s\n", model.Name())
 
 	// Wait between AI calls to stay under rate limits
 	const AIDelay = 5 * time.Second
@@ -96,7 +102,7 @@ func main() {
 			json.Unmarshal(metaData, &meta)
 		}
 
-		// 4. Metadata Enrichment: If we are missing Description OR DeepDive, call Gemini
+		// 4. Metadata Enrichment: If we are missing Description OR DeepDive, call the caption model
 		if meta.Description == "" || meta.DeepDive == "" {
 			fmt.Printf("   ✨ Calling AI to enrich metadata (missing fields)...\n")
 			imageKey := folder + "image.jpg"
@@ -114,72 +120,44 @@ func main() {
 			explorerName := strings.Title(UserID)
 			prompt := fmt.Sprintf("Analyze this image for a 15-year-old with Angelman Syndrome (%s). Return a SINGLE JSON object: {\"short_caption\": \"...\", \"deep_dive\": \"...\"}", explorerName)
 
-			parts := []genai.Part{
-				genai.ImageData("jpeg", imgData),
-				genai.Text(prompt),
-			}
-
 			// Wait between AI calls to stay under rate limits
 			time.Sleep(AIDelay)
 
-			var resp *genai.GenerateContentResponse
-			var genErr error
-
-			// Simple retry logic for 429s (max 3 attempts)
-			for attempt := 1; attempt <= 3; attempt++ {
-				resp, genErr = model.GenerateContent(ctx, parts...)
-				if genErr != nil && strings.Contains(genErr.Error(), "429") {
-					fmt.Printf("   ⏳ Rate limit hit (Attempt %d/3). Waiting 60s...\n", attempt)
-					time.Sleep(60 * time.Second)
-					continue
-				}
-				break
-			}
+			// Rate limits and other transient errors are retried by the model.
+			captioned, genErr := functions.GenerateValidatedCaption(ctx, model, functions.CaptionRequest{Prompt: prompt, Image: imgData})
+			functions.RecordCaptionUsage(ctx, fsClient, usage, model.Name(), captioned)
 
 			if genErr != nil {
-				fmt.Printf("   ❌ Gemini Error: %v\n", genErr)
+				fmt.Printf("   ❌ Caption Error: %v\n", genErr)
 				errorCount++
 				continue
 			}
 
-			if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-				respText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
-				jsonStr := strings.TrimSpace(respText)
-				jsonStr = strings.TrimPrefix(jsonStr, "```json")
-				jsonStr = strings.TrimPrefix(jsonStr, "```")
-				jsonStr = strings.TrimSuffix(jsonStr, "```")
-				jsonStr = strings.TrimSpace(jsonStr)
-
-				var aiResult struct {
-					ShortCaption string `json:"short_caption"`
-					DeepDive     string `json:"deep_dive"`
-				}
-
-				if err := json.Unmarshal([]byte(jsonStr), &aiResult); err == nil {
-					// Rule: Always preserve original description if it was already there (Companion recorded/typed)
-					if meta.Description == "" {
-						meta.Description = aiResult.ShortCaption
-					}
-					meta.DeepDive = aiResult.DeepDive
-					meta.EventID = eventID
-					if meta.Timestamp == "" {
-						meta.Timestamp = time.Now().Format(time.RFC3339)
-					}
-					if meta.Sender == "" {
-						meta.Sender = "Granddad"
-					}
-
-					// Write updated metadata.json back to S3
-					newMetaData, _ := json.MarshalIndent(meta, "", "  ")
-					err = functions.UploadToS3(ctx, metaKey, newMetaData, "application/json")
-					if err != nil {
-						fmt.Printf("   ❌ Error saving metadata: %v\n", err)
-					} else {
-						fmt.Printf("   ✅ Metadata enriched and saved\n")
-					}
-				} else {
-					fmt.Printf("   ❌ Failed to parse AI JSON: %s\n", jsonStr)
-				}
+			if len(captioned.Violations) > 0 {
+				fmt.Printf("   ⚠️  Caption still breaks rules after %d attempts: %s\n", captioned.Attempts, strings.Join(captioned.Violations, " "))
+			}
+			aiResult := captioned.Caption
+
+			// Rule: Always preserve original description if it was already there (Companion recorded/typed)
+			if meta.Description == "" {
+				meta.Description = aiResult.ShortCaption
+			}
+			meta.DeepDive = aiResult.DeepDive
+			meta.EventID = eventID
+			if meta.Timestamp == "" {
+				meta.Timestamp = time.Now().Format(time.RFC3339)
+			}
+			if meta.Sender == "" {
+				meta.Sender = "Granddad"
+			}
+
+			// Write updated metadata.json back to S3
+			newMetaData, _ := json.MarshalIndent(meta, "", "  ")
+			err = functions.UploadToS3(ctx, metaKey, newMetaData, "application/json")
+			if err != nil {
+				fmt.Printf("   ❌ Error saving metadata: %v\n", err)
+			} else {
+				fmt.Printf("   ✅ Metadata enriched and saved\n")
 			}
 		}
 
@@ -192,7 +170,7 @@ func main() {
 
 		if !hasHumanAudio && !hasAIAudio && meta.Description != "" {
 			fmt.Printf("   🎙️ Generating Primary Caption AI audio...\n")
-			speechData, err := functions.GenerateSpeech(meta.Description)
+			speechData, err := functions.GenerateMeteredSpeech(ctx, fsClient, usage, meta.Description, functions.SpeechOptions{})
 			if err == nil {
 				functions.UploadToS3(ctx, folder+"audio_caption.mp3", speechData, "audio/mpeg")
 				fmt.Printf("   ✅ Saved: audio_caption.mp3\n")
@@ -207,7 +185,7 @@ func main() {
 
 		if !hasDeepDiveAudio && meta.DeepDive != "" {
 			fmt.Printf("   🧠 Generating Deep Dive AI audio...\n")
-			speechData, err := functions.GenerateSpeech(meta.DeepDive)
+			speechData, err := functions.GenerateMeteredSpeech(ctx, fsClient, usage, meta.DeepDive, functions.SpeechOptions{})
 			if err == nil {
 				functions.UploadToS3(ctx, folder+"deep_dive_audio.mp3", speechData, "audio/mpeg")
 				fmt.Printf("   ✅ Saved: deep_dive_audio.mp3\n")
@@ -219,4 +197,6 @@ func main() {
 
 	fmt.Printf("\n✨ Remastering Complete!\n")
 	fmt.Printf("📊 Summary: Actions: %d, Errors: %d\n", processedCount, errorCount)
+	stats := functions.CaptionStats()
+	fmt.Printf("🩹 Captions: %d, Repaired: %d, Unresolved: %d, Failed: %d\n", stats.Calls, stats.Repaired, stats.Unresolved, stats.Failed)
 }
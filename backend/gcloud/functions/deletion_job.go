@@ -0,0 +1,723 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	deletionJobsCollection = "deletion_jobs"
+
+	deletionStatusAwaitingConfirmation = "awaiting_confirmation"
+	deletionStatusScheduled            = "scheduled"
+	deletionStatusPending              = "pending"
+	deletionStatusRunning              = "running"
+	deletionStatusCompleted            = "completed"
+	deletionStatusFailed               = "failed"
+	deletionStatusCancelled            = "cancelled"
+
+	deletionPhaseDone = "done"
+
+	// deletionJobPageSize bounds how many documents a single step touches so
+	// progress is checkpointed frequently.
+	deletionJobPageSize = 100
+	// deletionJobPassBudget keeps one worker pass well inside the function
+	// timeout; remaining work is picked up by the next pass.
+	deletionJobPassBudget    = 45 * time.Second
+	deletionJobLeaseDuration = 2 * time.Minute
+	deletionJobRetryBackoff  = 5 * time.Minute
+	maxDeletionJobFailures   = 10
+	// maxDeletionVerifyRounds caps how many times a failed verification
+	// rewinds the job to its first phase before giving up.
+	maxDeletionVerifyRounds = 2
+)
+
+// errDeletionJobOptionsConflict is returned when a job is requested again
+// with different options after it has started working under the old ones.
+var errDeletionJobOptionsConflict = errors.New("a deletion job with different options is already under way")
+
+// deletionPhases lists, per job kind, the order in which a deletion job walks
+// through the data it owns. Each phase is idempotent, so a pass that dies
+// mid-phase simply repeats the current page on resume.
+var deletionPhases = map[string][]string{
+	deletionJobKindCompanion:      companionDeletionPhases,
+	deletionJobKindExplorerCircle: explorerCircleDeletionPhases,
+}
+
+type deletionJobCounts struct {
+	Reflections   int `firestore:"reflections" json:"reflections"`
+	Responses     int `firestore:"responses" json:"responses"`
+	S3Objects     int `firestore:"s3Objects" json:"s3_objects"`
+	References    int `firestore:"references" json:"references"`
+	Notifications int `firestore:"notifications" json:"notifications"`
+	Relationships int `firestore:"relationships" json:"relationships"`
+	Users         int `firestore:"users" json:"users"`
+	Explorers     int `firestore:"explorers" json:"explorers"`
+	Glossary      int `firestore:"glossary" json:"glossary"`
+	Archived      int `firestore:"archived" json:"archived"`
+	SpeechCache   int `firestore:"speechCache" json:"speech_cache"`
+}
+
+// deletionJob mirrors a deletion_jobs/{jobID} document. For companions the
+// job ID is the user ID so repeated requests resume a single job; Explorer
+// circle jobs use explorerCircleJobID.
+type deletionJob struct {
+	UserID          string            `firestore:"userId"`
+	ExplorerID      string            `firestore:"explorerId"`
+	Kind            string            `firestore:"kind"`
+	Status          string            `firestore:"status"`
+	Phase           string            `firestore:"phase"`
+	Cursor          string            `firestore:"cursor"`
+	Counts          deletionJobCounts `firestore:"counts"`
+	Failures        int               `firestore:"failures"`
+	LastError       string            `firestore:"lastError"`
+	LeaseExpiresAt  time.Time         `firestore:"leaseExpiresAt"`
+	ExplorerIDs     []string          `firestore:"explorerIds"`
+	RelationshipIDs []string          `firestore:"relationshipIds"`
+	VerifyRounds    int               `firestore:"verifyRounds"`
+	Residual        map[string]int    `firestore:"residual"`
+	// Companion jobs that hand Reflections over instead of deleting them.
+	KeepReflections    bool   `firestore:"keepReflections"`
+	ArchivedSenderName string `firestore:"archivedSenderName"`
+	// Set only on jobs that require confirmation before they may run.
+	RequestedBy           string    `firestore:"requestedBy"`
+	ConfirmationTokenHash string    `firestore:"confirmationTokenHash"`
+	NotBefore             time.Time `firestore:"notBefore"`
+}
+
+type deletionJobResponse struct {
+	JobID     string            `json:"job_id"`
+	Status    string            `json:"status"`
+	Phase     string            `json:"phase"`
+	Counts    deletionJobCounts `json:"counts"`
+	Residual  map[string]int    `json:"residual,omitempty"`
+	NotBefore string            `json:"not_before,omitempty"`
+	LastError string            `json:"last_error,omitempty"`
+}
+
+func (j *deletionJob) response(jobID string) deletionJobResponse {
+	resp := deletionJobResponse{
+		JobID:     jobID,
+		Status:    j.Status,
+		Phase:     j.Phase,
+		Counts:    j.Counts,
+		Residual:  j.Residual,
+		LastError: j.LastError,
+	}
+	if !j.NotBefore.IsZero() {
+		resp.NotBefore = j.NotBefore.UTC().Format(time.RFC3339)
+	}
+	return resp
+}
+
+func (j *deletionJob) terminal() bool {
+	return j.Status == deletionStatusCompleted || j.Status == deletionStatusFailed || j.Status == deletionStatusCancelled
+}
+
+// runnable reports whether a worker may pick the job up. Jobs that are still
+// awaiting confirmation or sitting out a grace period are not runnable.
+func (j *deletionJob) runnable() bool {
+	return j.Status == deletionStatusPending || j.Status == deletionStatusRunning
+}
+
+// subject is the ID of whatever the job deletes, for logging.
+func (j *deletionJob) subject() string {
+	if j.Kind == deletionJobKindExplorerCircle {
+		return j.ExplorerID
+	}
+	return j.UserID
+}
+
+func (j *deletionJob) advancePhase() {
+	phases := deletionPhases[j.Kind]
+	j.Cursor = ""
+	for i, p := range phases {
+		if p == j.Phase && i+1 < len(phases) {
+			j.Phase = phases[i+1]
+			return
+		}
+	}
+	j.Phase = deletionPhaseDone
+}
+
+// started reports whether a worker has begun on the job, after which its
+// options can no longer change.
+func (j *deletionJob) started() bool {
+	if j.Status != deletionStatusPending && j.Status != deletionStatusScheduled {
+		return true
+	}
+	return j.Phase != deletionPhases[j.Kind][0] || j.Cursor != "" || !j.LeaseExpiresAt.IsZero()
+}
+
+// rewind restarts the job from its first phase, keeping counts. Used when
+// verification finds references the earlier phases missed.
+func (j *deletionJob) rewind() {
+	j.Phase = deletionPhases[j.Kind][0]
+	j.Cursor = ""
+}
+
+// createOrResumeDeletionJob creates deletion_jobs/{jobID} from seed or returns
+// the existing job. The seed's status defaults to pending; a seed that is
+// scheduled must carry NotBefore. A failed job is reset to pending without
+// touching its phase or cursor, so asking again resumes where it stopped. A
+// cancelled job is replaced by the seed, and a scheduled job is brought
+// forward when the seed asks for immediate deletion. A job that has not
+// started takes the seed's options; one that has returns
+// errDeletionJobOptionsConflict if they differ.
+func createOrResumeDeletionJob(ctx context.Context, client *firestore.Client, jobID string, seed deletionJob) (*deletionJob, error) {
+	ref := client.Collection(deletionJobsCollection).Doc(jobID)
+	if seed.Status == "" {
+		seed.Status = deletionStatusPending
+	}
+	var job deletionJob
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			job = seed
+			job.Phase = deletionPhases[seed.Kind][0]
+			return tx.Create(ref, newDeletionJobData(job))
+		}
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status == deletionStatusCancelled {
+			job = seed
+			job.Phase = deletionPhases[seed.Kind][0]
+			return tx.Set(ref, newDeletionJobData(job))
+		}
+		var updates []firestore.Update
+		if job.KeepReflections != seed.KeepReflections || job.ArchivedSenderName != seed.ArchivedSenderName {
+			if job.started() {
+				return errDeletionJobOptionsConflict
+			}
+			job.KeepReflections = seed.KeepReflections
+			job.ArchivedSenderName = seed.ArchivedSenderName
+			updates = append(updates,
+				firestore.Update{Path: "keepReflections", Value: job.KeepReflections},
+				firestore.Update{Path: "archivedSenderName", Value: job.ArchivedSenderName},
+			)
+		}
+		switch job.Status {
+		case deletionStatusScheduled:
+			if seed.Status == deletionStatusPending {
+				job.Status = deletionStatusPending
+				job.NotBefore = time.Time{}
+				updates = append(updates,
+					firestore.Update{Path: "status", Value: job.Status},
+					firestore.Update{Path: "notBefore", Value: job.NotBefore},
+				)
+			}
+		case deletionStatusFailed:
+			job.Status = deletionStatusPending
+			job.Failures = 0
+			job.VerifyRounds = 0
+			job.LeaseExpiresAt = time.Time{}
+			updates = append(updates,
+				firestore.Update{Path: "status", Value: job.Status},
+				firestore.Update{Path: "failures", Value: 0},
+				firestore.Update{Path: "verifyRounds", Value: 0},
+				firestore.Update{Path: "leaseExpiresAt", Value: job.LeaseExpiresAt},
+			)
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Update(ref, append(updates, firestore.Update{Path: "updatedAt", Value: firestore.ServerTimestamp}))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start deletion job %s: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+// newDeletionJobData is the full document written when a job is first
+// recorded (or re-requested after cancellation).
+func newDeletionJobData(job deletionJob) map[string]any {
+	return map[string]any{
+		"userId":                job.UserID,
+		"explorerId":            job.ExplorerID,
+		"kind":                  job.Kind,
+		"status":                job.Status,
+		"phase":                 job.Phase,
+		"cursor":                "",
+		"counts":                job.Counts,
+		"failures":              0,
+		"lastError":             "",
+		"leaseExpiresAt":        time.Time{},
+		"explorerIds":           job.ExplorerIDs,
+		"relationshipIds":       job.RelationshipIDs,
+		"verifyRounds":          0,
+		"keepReflections":       job.KeepReflections,
+		"archivedSenderName":    job.ArchivedSenderName,
+		"requestedBy":           job.RequestedBy,
+		"confirmationTokenHash": job.ConfirmationTokenHash,
+		"notBefore":             job.NotBefore,
+		"createdAt":             firestore.ServerTimestamp,
+		"updatedAt":             firestore.ServerTimestamp,
+	}
+}
+
+func getDeletionJob(ctx context.Context, client *firestore.Client, jobID string) (*deletionJob, error) {
+	snap, err := client.Collection(deletionJobsCollection).Doc(jobID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var job deletionJob
+	if err := snap.DataTo(&job); err != nil {
+		return nil, fmt.Errorf("decode deletion job %s: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+// acquireDeletionJobLease claims the job for this worker. It returns
+// (nil, nil) when the job is finished or another worker holds a live lease.
+func acquireDeletionJobLease(ctx context.Context, client *firestore.Client, jobID string) (*deletionJob, error) {
+	ref := client.Collection(deletionJobsCollection).Doc(jobID)
+	var acquired *deletionJob
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		acquired = nil
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var job deletionJob
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if !job.runnable() || job.LeaseExpiresAt.After(now) {
+			return nil
+		}
+		job.Status = deletionStatusRunning
+		job.LeaseExpiresAt = now.Add(deletionJobLeaseDuration)
+		acquired = &job
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: job.Status},
+			{Path: "leaseExpiresAt", Value: job.LeaseExpiresAt},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("acquire deletion job lease %s: %w", jobID, err)
+	}
+	return acquired, nil
+}
+
+func saveDeletionJob(ctx context.Context, client *firestore.Client, jobID string, job *deletionJob) error {
+	updates := []firestore.Update{
+		{Path: "status", Value: job.Status},
+		{Path: "phase", Value: job.Phase},
+		{Path: "cursor", Value: job.Cursor},
+		{Path: "counts", Value: job.Counts},
+		{Path: "failures", Value: job.Failures},
+		{Path: "lastError", Value: job.LastError},
+		{Path: "leaseExpiresAt", Value: job.LeaseExpiresAt},
+		{Path: "verifyRounds", Value: job.VerifyRounds},
+		{Path: "residual", Value: job.Residual},
+		{Path: "updatedAt", Value: firestore.ServerTimestamp},
+	}
+	if job.Status == deletionStatusCompleted {
+		updates = append(updates, firestore.Update{Path: "completedAt", Value: firestore.ServerTimestamp})
+	}
+	if _, err := client.Collection(deletionJobsCollection).Doc(jobID).Update(ctx, updates); err != nil {
+		return fmt.Errorf("save deletion job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// runDeletionJobPass advances a job for at most budget before checkpointing
+// and releasing its lease. Releasing the lease writes the job document, which
+// re-enters the worker through OnDeletionJobWritten until the job is done.
+// Failures hold the lease for deletionJobRetryBackoff so ResumeDeletionJobs,
+// rather than a hot trigger loop, drives the retry.
+func runDeletionJobPass(ctx context.Context, client *firestore.Client, s3Client *s3.Client, jobID string, budget time.Duration) (*deletionJob, error) {
+	job, err := acquireDeletionJobLease(ctx, client, jobID)
+	if err != nil || job == nil {
+		return job, err
+	}
+
+	deadline := time.Now().Add(budget)
+	for job.Phase != deletionPhaseDone && time.Now().Before(deadline) {
+		if stepErr := advanceDeletionJob(ctx, client, s3Client, job); stepErr != nil {
+			job.Failures++
+			job.LastError = stepErr.Error()
+			if job.Failures >= maxDeletionJobFailures {
+				job.Status = deletionStatusFailed
+				job.LeaseExpiresAt = time.Time{}
+				defer auditDeletionJob(ctx, client, jobID, job)
+			} else {
+				job.LeaseExpiresAt = time.Now().UTC().Add(deletionJobRetryBackoff)
+			}
+			fmt.Printf("runDeletionJobPass: job %s phase %s failed (%d/%d): %v\n", jobID, job.Phase, job.Failures, maxDeletionJobFailures, stepErr)
+			if err := saveDeletionJob(ctx, client, jobID, job); err != nil {
+				return job, err
+			}
+			return job, stepErr
+		}
+		job.Failures = 0
+		job.LastError = ""
+		if err := saveDeletionJob(ctx, client, jobID, job); err != nil {
+			return job, err
+		}
+	}
+
+	if job.Phase == deletionPhaseDone {
+		job.Status = deletionStatusCompleted
+		fmt.Printf("runDeletionJobPass: job %s completed (%+v)\n", jobID, job.Counts)
+	}
+	job.LeaseExpiresAt = time.Time{}
+	if err := saveDeletionJob(ctx, client, jobID, job); err != nil {
+		return job, err
+	}
+	if job.Status == deletionStatusCompleted {
+		auditDeletionJob(ctx, client, jobID, job)
+	}
+	return job, nil
+}
+
+// auditDeletionJob records the worker's final outcome for a job. The request
+// that started it is audited separately by its HTTP handler.
+func auditDeletionJob(ctx context.Context, client *firestore.Client, jobID string, job *deletionJob) {
+	targets := []string{deletionJobsCollection + "/" + jobID}
+	switch job.Kind {
+	case deletionJobKindCompanion:
+		targets = append(targets, "users/"+job.UserID)
+	case deletionJobKindExplorerCircle:
+		targets = append(targets, "explorers/"+job.ExplorerID)
+	}
+	outcome := AuditOutcomeSuccess
+	if job.Status == deletionStatusFailed {
+		outcome = AuditOutcomeFailure
+	}
+	writeAuditRecord(ctx, client, AuditRecord{
+		Actor:     auditActorDeletionWorker,
+		Action:    "deletion_job." + job.Kind,
+		Targets:   targets,
+		RequestID: jobID,
+		Outcome:   outcome,
+		Error:     job.LastError,
+		Details:   map[string]any{"counts": job.Counts, "requestedBy": job.RequestedBy},
+	})
+}
+
+// advanceDeletionJob processes one page of the job's current phase, updating
+// its phase, cursor and counts in memory. The caller persists the result.
+func advanceDeletionJob(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	switch job.Kind {
+	case deletionJobKindCompanion:
+		return advanceCompanionDeletion(ctx, client, s3Client, job)
+	case deletionJobKindExplorerCircle:
+		return advanceExplorerCircleDeletion(ctx, client, s3Client, job)
+	default:
+		return fmt.Errorf("unknown deletion job kind %q", job.Kind)
+	}
+}
+
+// pageAfterCursor orders q by document ID and resumes after job.Cursor.
+func pageAfterCursor(q firestore.Query, job *deletionJob) firestore.Query {
+	q = q.OrderBy(firestore.DocumentID, firestore.Asc).Limit(deletionJobPageSize)
+	if job.Cursor != "" {
+		q = q.StartAfter(job.Cursor)
+	}
+	return q
+}
+
+// finishPage records the cursor for a processed page and moves to the next
+// phase once a short page shows the query is exhausted.
+func finishPage(job *deletionJob, lastID string, size int) {
+	if size < deletionJobPageSize {
+		job.advancePhase()
+		return
+	}
+	job.Cursor = lastID
+}
+
+func queryPage(ctx context.Context, q firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+	var docs []*firestore.DocumentSnapshot
+	iter := q.Limit(deletionJobPageSize).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// scrubArrayPage removes values from an array field on one page of the docs
+// matched by q. Scrubbed docs stop matching, so no cursor is needed; the phase
+// advances once a short page comes back.
+func scrubArrayPage(ctx context.Context, client *firestore.Client, job *deletionJob, q firestore.Query, field string, values ...any) error {
+	docs, err := queryPage(ctx, q)
+	if err != nil {
+		return fmt.Errorf("%s query: %w", field, err)
+	}
+	if len(docs) > 0 {
+		batch := client.Batch()
+		for _, doc := range docs {
+			batch.Update(doc.Ref, []firestore.Update{{Path: field, Value: firestore.ArrayRemove(values...)}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return fmt.Errorf("%s scrub: %w", field, err)
+		}
+	}
+	job.Counts.References += len(docs)
+	if len(docs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// deleteQueryPage deletes one page of the docs matched by q, adding the count
+// to *counter. Deleted docs stop matching, so no cursor is needed.
+func deleteQueryPage(ctx context.Context, client *firestore.Client, job *deletionJob, q firestore.Query, label string, counter *int) error {
+	docs, err := queryPage(ctx, q)
+	if err != nil {
+		return fmt.Errorf("%s query: %w", label, err)
+	}
+	refs := make([]*firestore.DocumentRef, 0, len(docs))
+	for _, doc := range docs {
+		refs = append(refs, doc.Ref)
+	}
+	if err := commitBatches(ctx, client, refs); err != nil {
+		return fmt.Errorf("%s batch delete: %w", label, err)
+	}
+	*counter += len(refs)
+	if len(refs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// deleteS3Keys removes each key from the storage bucket, counting successes.
+// S3 deletes are idempotent, so a retry after a partial failure is safe.
+func deleteS3Keys(ctx context.Context, s3Client *s3.Client, job *deletionJob, keys []string) error {
+	for _, key := range keys {
+		if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String("reflections-1200b-storage"),
+			Key:    aws.String(key),
+		}); err != nil {
+			return fmt.Errorf("S3 delete %q: %w", key, err)
+		}
+		job.Counts.S3Objects++
+	}
+	return nil
+}
+
+// listS3Prefix returns every key under prefix, following continuation tokens.
+func listS3Prefix(ctx context.Context, s3Client *s3.Client, prefix string) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String("reflections-1200b-storage"),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		result, err := s3Client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("S3 list %q: %w", prefix, err)
+		}
+		for _, obj := range result.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		if !aws.ToBool(result.IsTruncated) {
+			return keys, nil
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+}
+
+// deleteS3PrefixPage deletes one listing page (up to 1000 keys) under prefix
+// and advances the phase once nothing is left. Deleted keys drop out of the
+// listing, so no continuation token needs to be checkpointed.
+func deleteS3PrefixPage(ctx context.Context, s3Client *s3.Client, job *deletionJob, prefix string) error {
+	result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String("reflections-1200b-storage"),
+		Prefix: aws.String(prefix),
+	})
+	if err != nil {
+		return fmt.Errorf("S3 list %q: %w", prefix, err)
+	}
+	if len(result.Contents) > 0 {
+		objects := make([]types.ObjectIdentifier, 0, len(result.Contents))
+		for _, obj := range result.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+		out, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String("reflections-1200b-storage"),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("S3 batch delete %q: %w", prefix, err)
+		}
+		if len(out.Errors) > 0 {
+			first := out.Errors[0]
+			return fmt.Errorf("S3 batch delete %q: %d key(s) failed, first %q: %s", prefix, len(out.Errors), aws.ToString(first.Key), aws.ToString(first.Message))
+		}
+		job.Counts.S3Objects += len(objects)
+	}
+	if !aws.ToBool(result.IsTruncated) {
+		job.advancePhase()
+	}
+	return nil
+}
+
+// residualCheck is one verification probe: a query that must come back empty
+// once the job has removed every reference it owns.
+type residualCheck struct {
+	name  string
+	query firestore.Query
+}
+
+// countResiduals runs each check with a small limit and returns the non-zero
+// hits keyed by check name.
+func countResiduals(ctx context.Context, checks []residualCheck) (map[string]int, error) {
+	residual := map[string]int{}
+	for _, check := range checks {
+		docs, err := check.query.Limit(5).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("verify %s: %w", check.name, err)
+		}
+		if len(docs) > 0 {
+			residual[check.name] = len(docs)
+		}
+	}
+	return residual, nil
+}
+
+// settleVerification finishes the job when residual is empty; otherwise it
+// records what was found and rewinds for another sweep, failing after
+// maxDeletionVerifyRounds.
+func settleVerification(job *deletionJob, residual map[string]int) error {
+	job.Residual = residual
+	if len(residual) == 0 {
+		job.advancePhase()
+		return nil
+	}
+	job.VerifyRounds++
+	if job.VerifyRounds > maxDeletionVerifyRounds {
+		// Re-running the same phases will not help; make the caller's failure
+		// accounting mark the job failed on this error.
+		job.Failures = maxDeletionJobFailures - 1
+		return fmt.Errorf("verification still found references after %d round(s): %v", maxDeletionVerifyRounds, residual)
+	}
+	fmt.Printf("settleVerification: %s job for %s found residual references %v; rewinding (round %d)\n", job.Kind, job.subject(), residual, job.VerifyRounds)
+	job.rewind()
+	return nil
+}
+
+// OnDeletionJobWritten is the re-entrant deletion worker. It fires on every
+// write to deletion_jobs/{jobId}; writes made while another pass holds the
+// lease (including that pass's own checkpoints) are no-ops.
+func OnDeletionJobWritten(ctx context.Context, e event.Event) error {
+	data, err := decodeDocumentEvent(e)
+	if err != nil {
+		return err
+	}
+	doc := data.GetValue()
+	if doc == nil {
+		return nil
+	}
+	jobID := documentID(doc)
+	if s := stringField(doc, "status"); s != deletionStatusPending && s != deletionStatusRunning {
+		return nil
+	}
+
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	s3Client, err := awsS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("OnDeletionJobWritten: AWS config: %w", err)
+	}
+
+	if _, err := runDeletionJobPass(ctx, client, s3Client, jobID, deletionJobPassBudget); err != nil {
+		return fmt.Errorf("OnDeletionJobWritten: job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// ResumeDeletionJobs is a scheduled sweep that releases scheduled jobs whose
+// grace period has ended and nudges unfinished jobs whose lease has lapsed
+// (crashed pass or retry backoff elapsed). Either write re-enters
+// OnDeletionJobWritten.
+func ResumeDeletionJobs(ctx context.Context, e event.Event) error {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	released := 0
+	scheduled := client.Collection(deletionJobsCollection).
+		Where("status", "==", deletionStatusScheduled).
+		Where("notBefore", "<=", now).
+		Documents(ctx)
+	for {
+		doc, err := scheduled.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("ResumeDeletionJobs: scheduled query: %w", err)
+		}
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: deletionStatusPending},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		}, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			fmt.Printf("ResumeDeletionJobs: release %s failed: %v\n", doc.Ref.ID, err)
+			continue
+		}
+		released++
+	}
+
+	resumed := 0
+	iter := client.Collection(deletionJobsCollection).
+		Where("status", "in", []string{deletionStatusPending, deletionStatusRunning}).
+		Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("ResumeDeletionJobs: query: %w", err)
+		}
+		var job deletionJob
+		if err := doc.DataTo(&job); err != nil {
+			fmt.Printf("ResumeDeletionJobs: skipping undecodable job %s: %v\n", doc.Ref.ID, err)
+			continue
+		}
+		if job.LeaseExpiresAt.After(now) {
+			continue
+		}
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "resumeRequestedAt", Value: firestore.ServerTimestamp},
+		}); err != nil {
+			fmt.Printf("ResumeDeletionJobs: touch %s failed: %v\n", doc.Ref.ID, err)
+			continue
+		}
+		resumed++
+	}
+	fmt.Printf("ResumeDeletionJobs: released %d scheduled job(s), resumed %d job(s)\n", released, resumed)
+	return nil
+}
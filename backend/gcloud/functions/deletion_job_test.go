@@ -0,0 +1,160 @@
+package functions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdvancePhase(t *testing.T) {
+	job := &deletionJob{Kind: deletionJobKindCompanion, Phase: companionDeletionPhases[0], Cursor: "last"}
+	for _, want := range companionDeletionPhases[1:] {
+		job.advancePhase()
+		if job.Phase != want || job.Cursor != "" {
+			t.Fatalf("advancePhase: phase %q, cursor %q; want %q", job.Phase, job.Cursor, want)
+		}
+	}
+	job.advancePhase()
+	if job.Phase != deletionPhaseDone {
+		t.Errorf("advancePhase past the end = %q, want %q", job.Phase, deletionPhaseDone)
+	}
+
+	unknown := &deletionJob{Kind: deletionJobKindCompanion, Phase: "unknown"}
+	unknown.advancePhase()
+	if unknown.Phase != deletionPhaseDone {
+		t.Errorf("advancePhase from an unknown phase = %q, want %q", unknown.Phase, deletionPhaseDone)
+	}
+}
+
+func TestFinishPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantPhase  string
+		wantCursor string
+	}{
+		{name: "full page keeps the phase", size: deletionJobPageSize, wantPhase: companionPhaseReflections, wantCursor: "last"},
+		{name: "short page moves on", size: deletionJobPageSize - 1, wantPhase: companionPhaseLegacyReflections},
+		{name: "empty page moves on", size: 0, wantPhase: companionPhaseLegacyReflections},
+	}
+	for _, tt := range tests {
+		job := &deletionJob{Kind: deletionJobKindCompanion, Phase: companionPhaseReflections, Cursor: "previous"}
+		finishPage(job, "last", tt.size)
+		if job.Phase != tt.wantPhase || job.Cursor != tt.wantCursor {
+			t.Errorf("%s: phase %q, cursor %q; want %q, %q", tt.name, job.Phase, job.Cursor, tt.wantPhase, tt.wantCursor)
+		}
+	}
+}
+
+func TestSettleVerification(t *testing.T) {
+	job := &deletionJob{Kind: deletionJobKindCompanion, Phase: companionPhaseVerify, Cursor: "x"}
+	residual := map[string]int{"reflections_likedBy": 1}
+
+	for round := 1; round <= maxDeletionVerifyRounds; round++ {
+		job.Phase = companionPhaseVerify
+		if err := settleVerification(job, residual); err != nil {
+			t.Fatalf("round %d: settleVerification: %v", round, err)
+		}
+		if job.Phase != companionDeletionPhases[0] || job.Cursor != "" || job.VerifyRounds != round {
+			t.Fatalf("round %d: phase %q, cursor %q, rounds %d; want a rewind to %q", round, job.Phase, job.Cursor, job.VerifyRounds, companionDeletionPhases[0])
+		}
+		if job.Residual["reflections_likedBy"] != 1 {
+			t.Errorf("round %d: residual not recorded: %v", round, job.Residual)
+		}
+	}
+
+	job.Phase = companionPhaseVerify
+	if err := settleVerification(job, residual); err == nil {
+		t.Fatal("settleVerification kept rewinding past maxDeletionVerifyRounds")
+	}
+	if job.Failures != maxDeletionJobFailures-1 {
+		t.Errorf("failures = %d, want %d so the next failure is final", job.Failures, maxDeletionJobFailures-1)
+	}
+
+	clean := &deletionJob{Kind: deletionJobKindCompanion, Phase: companionPhaseVerify, VerifyRounds: 1}
+	if err := settleVerification(clean, map[string]int{}); err != nil {
+		t.Fatalf("settleVerification with no residual: %v", err)
+	}
+	if clean.Phase != companionPhaseAuthUser || len(clean.Residual) != 0 {
+		t.Errorf("clean verification: phase %q, residual %v; want %q and none", clean.Phase, clean.Residual, companionPhaseAuthUser)
+	}
+}
+
+func TestDeletionJobStatus(t *testing.T) {
+	tests := []struct {
+		status       string
+		wantTerminal bool
+		wantRunnable bool
+	}{
+		{deletionStatusAwaitingConfirmation, false, false},
+		{deletionStatusScheduled, false, false},
+		{deletionStatusPending, false, true},
+		{deletionStatusRunning, false, true},
+		{deletionStatusCompleted, true, false},
+		{deletionStatusFailed, true, false},
+		{deletionStatusCancelled, true, false},
+	}
+	for _, tt := range tests {
+		job := &deletionJob{Status: tt.status}
+		if got := job.terminal(); got != tt.wantTerminal {
+			t.Errorf("terminal() with status %q = %v, want %v", tt.status, got, tt.wantTerminal)
+		}
+		if got := job.runnable(); got != tt.wantRunnable {
+			t.Errorf("runnable() with status %q = %v, want %v", tt.status, got, tt.wantRunnable)
+		}
+	}
+}
+
+func TestCompanionDeletionGrace(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":      defaultCompanionDeletionGrace,
+		"48":    48 * time.Hour,
+		"0":     defaultCompanionDeletionGrace,
+		"-3":    defaultCompanionDeletionGrace,
+		"a day": defaultCompanionDeletionGrace,
+	}
+	for raw, want := range tests {
+		t.Setenv("COMPANION_DELETION_GRACE_HOURS", raw)
+		if got := companionDeletionGrace(); got != want {
+			t.Errorf("companionDeletionGrace with %q = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestCompanionReflectionKeys(t *testing.T) {
+	keys := companionReflectionKeys("explorer-1", "123")
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			t.Errorf("duplicate key %q", key)
+		}
+		seen[key] = true
+	}
+	for _, want := range []string{"explorer-1/to/123/image.jpg", "explorer-1/to/123/audio.m4a", "explorer-1/to/123/video.mp4"} {
+		if !seen[want] {
+			t.Errorf("companionReflectionKeys is missing %q", want)
+		}
+	}
+}
+
+func TestDeletionJobStarted(t *testing.T) {
+	first := companionDeletionPhases[0]
+	tests := []struct {
+		name string
+		job  deletionJob
+		want bool
+	}{
+		{"fresh pending job", deletionJob{Status: deletionStatusPending, Phase: first}, false},
+		{"scheduled job", deletionJob{Status: deletionStatusScheduled, Phase: first}, false},
+		{"pending job with a cursor", deletionJob{Status: deletionStatusPending, Phase: first, Cursor: "doc-1"}, true},
+		{"pending job past the first phase", deletionJob{Status: deletionStatusPending, Phase: companionDeletionPhases[1]}, true},
+		{"pending job that was leased", deletionJob{Status: deletionStatusPending, Phase: first, LeaseExpiresAt: time.Unix(1, 0)}, true},
+		{"running job", deletionJob{Status: deletionStatusRunning, Phase: first}, true},
+		{"failed job", deletionJob{Status: deletionStatusFailed, Phase: first}, true},
+	}
+	for _, tt := range tests {
+		tt.job.Kind = deletionJobKindCompanion
+		if got := tt.job.started(); got != tt.want {
+			t.Errorf("%s: started() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package functions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	deletionJobKindExplorerCircle = "explorer_circle"
+
+	explorerCirclePhaseMedia         = "media"
+	explorerCirclePhaseStagingMedia  = "staging_media"
+	explorerCirclePhaseSpeechCache   = "speech_cache"
+	explorerCirclePhaseSpeechAudio   = "speech_audio"
+	explorerCirclePhaseResponses     = "responses"
+	explorerCirclePhaseReflections   = "reflections"
+	explorerCirclePhaseNotifications = "notifications"
+	explorerCirclePhaseRelationships = "relationships"
+	explorerCirclePhaseSystemConfig  = "system_config"
+	explorerCirclePhaseGlossary      = "glossary"
+	explorerCirclePhaseExplorer      = "explorer"
+	explorerCirclePhaseVerify        = "verify"
+	explorerCirclePhaseTombstone     = "tombstone"
+
+	explorerTombstonesCollection = "explorer_tombstones"
+
+	triggerExplorerCircleDeletion = "explorer_circle_deletion"
+
+	// The grace period between confirmation and the first deletion pass.
+	// EXPLORER_DELETION_GRACE_HOURS may lengthen it but never go below the
+	// minimum, so companions always get notice.
+	defaultExplorerDeletionGrace = 72 * time.Hour
+	minExplorerDeletionGrace     = 24 * time.Hour
+	// explorerDeletionTokenTTL bounds how long a confirmation token from a
+	// "request" call stays usable.
+	explorerDeletionTokenTTL = 15 * time.Minute
+)
+
+// explorerCircleDeletionPhases deletes in dependency order: media before the
+// docs that name it, Reflections and responses before the relationships
+// that grant access to them, and the Explorer doc last so a partial run
+// still leaves the circle discoverable for a retry. The tombstone is written
+// only after verification passes.
+var explorerCircleDeletionPhases = []string{
+	explorerCirclePhaseMedia,
+	explorerCirclePhaseStagingMedia,
+	explorerCirclePhaseSpeechCache,
+	explorerCirclePhaseSpeechAudio,
+	explorerCirclePhaseResponses,
+	explorerCirclePhaseReflections,
+	explorerCirclePhaseNotifications,
+	explorerCirclePhaseRelationships,
+	explorerCirclePhaseSystemConfig,
+	explorerCirclePhaseGlossary,
+	explorerCirclePhaseExplorer,
+	explorerCirclePhaseVerify,
+	explorerCirclePhaseTombstone,
+	deletionPhaseDone,
+}
+
+var (
+	errExplorerDeletionForbidden = errors.New("only an owner or admin of this Explorer may delete the circle")
+	errExplorerDeletionToken     = errors.New("confirmation token is invalid or expired")
+	errExplorerDeletionState     = errors.New("deletion is not in a state that allows this action")
+)
+
+func explorerCircleJobID(explorerID string) string {
+	return "explorer_" + explorerID
+}
+
+// explorerDeletionGrace reads EXPLORER_DELETION_GRACE_HOURS, clamped to the
+// mandatory minimum.
+func explorerDeletionGrace() time.Duration {
+	grace := defaultExplorerDeletionGrace
+	if raw := strings.TrimSpace(os.Getenv("EXPLORER_DELETION_GRACE_HOURS")); raw != "" {
+		if hours, err := strconv.Atoi(raw); err == nil {
+			grace = time.Duration(hours) * time.Hour
+		} else {
+			fmt.Printf("explorerDeletionGrace: ignoring invalid EXPLORER_DELETION_GRACE_HOURS %q\n", raw)
+		}
+	}
+	return max(grace, minExplorerDeletionGrace)
+}
+
+func hashConfirmationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireExplorerAdmin checks that userID holds an owner or admin
+// relationship with explorerID and returns the relationship data.
+func requireExplorerAdmin(ctx context.Context, client *firestore.Client, explorerID, userID string) (map[string]any, error) {
+	docs, err := client.Collection(relationshipsCollection).
+		Where("userId", "==", userID).
+		Where("explorerId", "==", explorerID).
+		Limit(1).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("relationship lookup: %w", err)
+	}
+	if len(docs) == 0 {
+		return nil, errExplorerDeletionForbidden
+	}
+	data := docs[0].Data()
+	switch role, _ := data["role"].(string); role {
+	case "owner", "admin":
+		return data, nil
+	default:
+		return nil, errExplorerDeletionForbidden
+	}
+}
+
+// requestExplorerCircleDeletion records the job as awaiting confirmation and
+// returns a fresh one-time token; only its hash is stored. Repeating the
+// request before confirming rotates the token. Jobs that are already
+// scheduled or running are returned unchanged with an empty token, and a
+// failed job is resumed.
+func requestExplorerCircleDeletion(ctx context.Context, client *firestore.Client, explorerID, requestedBy string) (*deletionJob, string, error) {
+	jobID := explorerCircleJobID(explorerID)
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("confirmation token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	ref := client.Collection(deletionJobsCollection).Doc(jobID)
+	var job deletionJob
+	issued := false
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		issued = false
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&job); err != nil {
+				return err
+			}
+			if job.Status != deletionStatusAwaitingConfirmation && job.Status != deletionStatusCancelled {
+				return nil
+			}
+		}
+		job = deletionJob{
+			ExplorerID:            explorerID,
+			Kind:                  deletionJobKindExplorerCircle,
+			Status:                deletionStatusAwaitingConfirmation,
+			Phase:                 explorerCircleDeletionPhases[0],
+			RequestedBy:           requestedBy,
+			ConfirmationTokenHash: hashConfirmationToken(token),
+			NotBefore:             time.Now().UTC().Add(explorerDeletionTokenTTL),
+		}
+		issued = true
+		return tx.Set(ref, newDeletionJobData(job))
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("request explorer circle deletion %s: %w", explorerID, err)
+	}
+	if !issued {
+		if job.Status == deletionStatusFailed {
+			resumed, err := createOrResumeDeletionJob(ctx, client, jobID, job)
+			return resumed, "", err
+		}
+		return &job, "", nil
+	}
+	return &job, token, nil
+}
+
+// confirmExplorerCircleDeletion checks the token and schedules the job to
+// start once the grace period has passed. While awaiting confirmation,
+// NotBefore holds the token's expiry.
+func confirmExplorerCircleDeletion(ctx context.Context, client *firestore.Client, explorerID, token string) (*deletionJob, error) {
+	ref := client.Collection(deletionJobsCollection).Doc(explorerCircleJobID(explorerID))
+	var job deletionJob
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status != deletionStatusAwaitingConfirmation {
+			return errExplorerDeletionState
+		}
+		now := time.Now().UTC()
+		expected := []byte(job.ConfirmationTokenHash)
+		if token == "" || now.After(job.NotBefore) || subtle.ConstantTimeCompare(expected, []byte(hashConfirmationToken(token))) != 1 {
+			return errExplorerDeletionToken
+		}
+		job.Status = deletionStatusScheduled
+		job.ConfirmationTokenHash = ""
+		job.NotBefore = now.Add(explorerDeletionGrace())
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: job.Status},
+			{Path: "confirmationTokenHash", Value: ""},
+			{Path: "notBefore", Value: job.NotBefore},
+			{Path: "confirmedAt", Value: firestore.ServerTimestamp},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// cancelExplorerCircleDeletion stops a job that has not started running yet.
+func cancelExplorerCircleDeletion(ctx context.Context, client *firestore.Client, explorerID, cancelledBy string) (*deletionJob, error) {
+	ref := client.Collection(deletionJobsCollection).Doc(explorerCircleJobID(explorerID))
+	var job deletionJob
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status != deletionStatusAwaitingConfirmation && job.Status != deletionStatusScheduled {
+			return errExplorerDeletionState
+		}
+		job.Status = deletionStatusCancelled
+		job.ConfirmationTokenHash = ""
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: job.Status},
+			{Path: "confirmationTokenHash", Value: ""},
+			{Path: "cancelledBy", Value: cancelledBy},
+			{Path: "cancelledAt", Value: firestore.ServerTimestamp},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// notifyExplorerCircleDeletion stages one fast-lane notification per
+// companion (other than the requester) announcing the scheduled deletion.
+// Doc IDs are deterministic so a retried confirmation does not notify twice.
+func notifyExplorerCircleDeletion(ctx context.Context, client *firestore.Client, explorerID, requestedBy, requesterName string) error {
+	iter := client.Collection(relationshipsCollection).Where("explorerId", "==", explorerID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("relationships query: %w", err)
+		}
+		userID, _ := doc.Data()["userId"].(string)
+		if userID == "" || userID == requestedBy {
+			continue
+		}
+		docID := fmt.Sprintf("%s_%s_%s", triggerExplorerCircleDeletion, explorerID, userID)
+		if err := createPendingNotification(ctx, client, docID, pendingNotification{
+			ExplorerID:   explorerID,
+			RecipientIDs: []string{userID},
+			TriggerType:  triggerExplorerCircleDeletion,
+			SenderID:     requestedBy,
+			SenderName:   requesterName,
+			Status:       pendingStatus,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// advanceExplorerCircleDeletion runs one step of an Explorer circle deletion.
+func advanceExplorerCircleDeletion(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	explorerID := job.ExplorerID
+	if explorerID == "" {
+		return errors.New("explorer circle job has no explorerId")
+	}
+
+	switch job.Phase {
+	case explorerCirclePhaseMedia:
+		return deleteS3PrefixPage(ctx, s3Client, job, explorerID+"/")
+	case explorerCirclePhaseStagingMedia:
+		return deleteS3PrefixPage(ctx, s3Client, job, "staging/"+explorerID+"/")
+	case explorerCirclePhaseSpeechCache:
+		return deleteSpeechCachePage(ctx, client, s3Client, job, client.Collection(speechCacheCollection).Where("explorerId", "==", explorerID))
+	case explorerCirclePhaseSpeechAudio:
+		// Audio whose index document was never written.
+		return deleteS3PrefixPage(ctx, s3Client, job, speechCacheExplorerPrefix(explorerID))
+	case explorerCirclePhaseResponses:
+		return deleteQueryPage(ctx, client, job, client.Collection(responsesCollection).Where("explorerId", "==", explorerID), "responses", &job.Counts.Responses)
+	case explorerCirclePhaseReflections:
+		return deleteQueryPage(ctx, client, job, client.Collection(reflectionsCollection).Where("explorerId", "==", explorerID), "reflections", &job.Counts.Reflections)
+	case explorerCirclePhaseNotifications:
+		return deleteQueryPage(ctx, client, job, client.Collection(pendingNotificationsCollection).Where("explorerId", "==", explorerID), "pending_notifications", &job.Counts.Notifications)
+	case explorerCirclePhaseRelationships:
+		return deleteQueryPage(ctx, client, job, client.Collection(relationshipsCollection).Where("explorerId", "==", explorerID), "relationships", &job.Counts.Relationships)
+	case explorerCirclePhaseSystemConfig:
+		if _, err := client.Collection("system_config").Doc(explorerID).Delete(ctx); err != nil {
+			return fmt.Errorf("delete system_config/%s: %w", explorerID, err)
+		}
+		job.advancePhase()
+		return nil
+	case explorerCirclePhaseGlossary:
+		return deleteQueryPage(ctx, client, job, glossaryCollection(client, explorerID).Query, "glossary", &job.Counts.Glossary)
+	case explorerCirclePhaseExplorer:
+		if _, err := client.Collection("explorers").Doc(explorerID).Delete(ctx); err != nil {
+			return fmt.Errorf("delete explorers/%s: %w", explorerID, err)
+		}
+		job.Counts.Explorers = 1
+		job.advancePhase()
+		return nil
+	case explorerCirclePhaseVerify:
+		return verifyExplorerCircleDeletion(ctx, client, s3Client, job)
+	case explorerCirclePhaseTombstone:
+		return writeExplorerTombstone(ctx, client, job)
+	default:
+		return fmt.Errorf("unknown explorer circle deletion phase %q", job.Phase)
+	}
+}
+
+func verifyExplorerCircleDeletion(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob) error {
+	explorerID := job.ExplorerID
+	checks := []residualCheck{
+		{"responses", client.Collection(responsesCollection).Where("explorerId", "==", explorerID)},
+		{"reflections", client.Collection(reflectionsCollection).Where("explorerId", "==", explorerID)},
+		{"pending_notifications", client.Collection(pendingNotificationsCollection).Where("explorerId", "==", explorerID)},
+		{"relationships", client.Collection(relationshipsCollection).Where("explorerId", "==", explorerID)},
+		{"system_config", client.Collection("system_config").Where(firestore.DocumentID, "==", client.Collection("system_config").Doc(explorerID))},
+		{"glossary", glossaryCollection(client, explorerID).Query},
+		{"tts_cache", client.Collection(speechCacheCollection).Where("explorerId", "==", explorerID)},
+		{"explorers", client.Collection("explorers").Where(firestore.DocumentID, "==", client.Collection("explorers").Doc(explorerID))},
+	}
+	residual, err := countResiduals(ctx, checks)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range []string{explorerID + "/", "staging/" + explorerID + "/", speechCacheExplorerPrefix(explorerID)} {
+		keys, err := listS3Prefix(ctx, s3Client, prefix)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			residual["s3:"+prefix] = len(keys)
+		}
+	}
+	return settleVerification(job, residual)
+}
+
+// writeExplorerTombstone leaves a minimal record that the circle existed and
+// was deleted on request, with no personal content.
+func writeExplorerTombstone(ctx context.Context, client *firestore.Client, job *deletionJob) error {
+	if _, err := client.Collection(explorerTombstonesCollection).Doc(job.ExplorerID).Set(ctx, map[string]any{
+		"explorerId":  job.ExplorerID,
+		"requestedBy": job.RequestedBy,
+		"counts":      job.Counts,
+		"deletedAt":   firestore.ServerTimestamp,
+	}); err != nil {
+		return fmt.Errorf("write explorer tombstone %s: %w", job.ExplorerID, err)
+	}
+	job.advancePhase()
+	return nil
+}
+
+type explorerCircleDeletionResponse struct {
+	deletionJobResponse
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+}
+
+// DeleteExplorerCircle is the HTTP Cloud Function entry point for deleting an
+// Explorer and everything shared in their circle. The caller must present a
+// Firebase ID token and be an owner or admin of the Explorer.
+//
+// Deletion takes three steps so it cannot happen by accident:
+//
+//	POST {"explorer_id", "action": "request"}  -> 202 with a confirmation_token
+//	POST {"explorer_id", "action": "confirm", "confirmation_token"}
+//	     -> 202, status "scheduled"; companions are notified and the job
+//	        starts after the grace period (EXPLORER_DELETION_GRACE_HOURS)
+//	POST {"explorer_id", "action": "cancel"}   -> allowed until the job runs
+//
+// GET ?explorer_id=... reports the job's status, not_before and counts to the
+// circle's owners and admins, and once the job has finished only to the
+// companion who requested it. Audit admins can always read it.
+func DeleteExplorerCircle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	uid := caller.UID
+
+	var body struct {
+		ExplorerID        string `json:"explorer_id"`
+		Action            string `json:"action"`
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	if r.Method == http.MethodGet {
+		body.ExplorerID = r.URL.Query().Get("explorer_id")
+	} else if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if body.ExplorerID == "" {
+		http.Error(w, "explorer_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	fsClient, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jobID := explorerCircleJobID(body.ExplorerID)
+	if r.Method == http.MethodGet {
+		job, err := getDeletionJob(ctx, fsClient, jobID)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "deletion job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to load deletion job: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Once the job is past the relationships phase the caller's role
+		// no longer exists, so a finished job is reported only to the
+		// companion who requested it; in-flight jobs still require admin
+		// access.
+		if job.terminal() && job.Status != deletionStatusCancelled {
+			if !canManageDeletionJob(caller, job.RequestedBy) {
+				writeExplorerDeletionError(w, errExplorerDeletionForbidden)
+				return
+			}
+		} else if !isAuditAdmin(caller) {
+			if _, err := requireExplorerAdmin(ctx, fsClient, body.ExplorerID, uid); err != nil {
+				writeExplorerDeletionError(w, err)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(explorerCircleDeletionResponse{deletionJobResponse: job.response(jobID)})
+		return
+	}
+
+	audit := AuditRecord{
+		Actor:      uid,
+		Action:     "delete_explorer_circle." + body.Action,
+		Targets:    []string{"explorers/" + body.ExplorerID, deletionJobsCollection + "/" + jobID},
+		RequestID:  auditRequestID(r),
+		BeforeHash: docBeforeHash(ctx, fsClient.Collection("explorers").Doc(body.ExplorerID)),
+	}
+
+	relationship, err := requireExplorerAdmin(ctx, fsClient, body.ExplorerID, uid)
+	if err != nil {
+		audit.Outcome, audit.Error = auditOutcome(err)
+		if errors.Is(err, errExplorerDeletionForbidden) {
+			audit.Outcome = AuditOutcomeDenied
+		}
+		writeAuditRecord(ctx, fsClient, audit)
+		writeExplorerDeletionError(w, err)
+		return
+	}
+
+	var job *deletionJob
+	var token string
+	switch body.Action {
+	case "request":
+		job, token, err = requestExplorerCircleDeletion(ctx, fsClient, body.ExplorerID, uid)
+	case "confirm":
+		job, err = confirmExplorerCircleDeletion(ctx, fsClient, body.ExplorerID, body.ConfirmationToken)
+		if err == nil {
+			requesterName, _ := relationship["companionName"].(string)
+			if notifyErr := notifyExplorerCircleDeletion(ctx, fsClient, body.ExplorerID, uid, strings.TrimSpace(requesterName)); notifyErr != nil {
+				// The job is already scheduled; a missed heads-up should not
+				// undo the confirmation.
+				fmt.Printf("DeleteExplorerCircle: notify companions of %s failed: %v\n", body.ExplorerID, notifyErr)
+			}
+		}
+	case "cancel":
+		job, err = cancelExplorerCircleDeletion(ctx, fsClient, body.ExplorerID, uid)
+	default:
+		http.Error(w, `action must be "request", "confirm" or "cancel"`, http.StatusBadRequest)
+		return
+	}
+	audit.Outcome, audit.Error = auditOutcome(err)
+	if errors.Is(err, errExplorerDeletionToken) {
+		audit.Outcome = AuditOutcomeDenied
+	}
+	writeAuditRecord(ctx, fsClient, audit)
+	if err != nil {
+		fmt.Printf("DeleteExplorerCircle: %s for explorer %s by %s failed: %v\n", body.Action, body.ExplorerID, uid, err)
+		writeExplorerDeletionError(w, err)
+		return
+	}
+
+	fmt.Printf("DeleteExplorerCircle: %s for explorer %s by %s -> %s\n", body.Action, body.ExplorerID, uid, job.Status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(explorerCircleDeletionResponse{
+		deletionJobResponse: job.response(jobID),
+		ConfirmationToken:   token,
+	})
+}
+
+func writeExplorerDeletionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errExplorerDeletionForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errExplorerDeletionToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errExplorerDeletionState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case status.Code(err) == codes.NotFound:
+		http.Error(w, "deletion job not found", http.StatusNotFound)
+	default:
+		http.Error(w, "explorer deletion failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package functions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExplorerDeletionGrace(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":      defaultExplorerDeletionGrace,
+		"96":    96 * time.Hour,
+		"1":     minExplorerDeletionGrace,
+		"-5":    minExplorerDeletionGrace,
+		"three": defaultExplorerDeletionGrace,
+	}
+	for raw, want := range tests {
+		t.Setenv("EXPLORER_DELETION_GRACE_HOURS", raw)
+		if got := explorerDeletionGrace(); got != want {
+			t.Errorf("explorerDeletionGrace with %q = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestHashConfirmationToken(t *testing.T) {
+	a, b := hashConfirmationToken("token-a"), hashConfirmationToken("token-b")
+	if a == b || a == "token-a" {
+		t.Errorf("hashConfirmationToken does not hide the token: %q, %q", a, b)
+	}
+	if a != hashConfirmationToken("token-a") {
+		t.Error("hashConfirmationToken is not deterministic")
+	}
+}
+
+func TestExplorerCircleDeletionPhases(t *testing.T) {
+	job := &deletionJob{Kind: deletionJobKindExplorerCircle, Phase: explorerCircleDeletionPhases[0]}
+	seen := []string{job.Phase}
+	for job.Phase != deletionPhaseDone {
+		job.advancePhase()
+		seen = append(seen, job.Phase)
+	}
+	if len(seen) != len(explorerCircleDeletionPhases) {
+		t.Fatalf("walked phases %v, want %v", seen, explorerCircleDeletionPhases)
+	}
+	// The tombstone is only written once verification has passed.
+	if seen[len(seen)-2] != explorerCirclePhaseTombstone || seen[len(seen)-3] != explorerCirclePhaseVerify {
+		t.Errorf("phases end with %v, want verify, tombstone, done", seen[len(seen)-3:])
+	}
+}
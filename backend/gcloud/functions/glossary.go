@@ -0,0 +1,418 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	glossarySubcollection = "glossary"
+
+	GlossaryKindPerson = "person"
+	GlossaryKindPet    = "pet"
+	GlossaryKindPlace  = "place"
+
+	maxGlossaryEntries       = 200
+	maxGlossaryPromptEntries = 25
+	maxGlossaryNameChars     = 80
+	maxGlossaryTextChars     = 200
+	maxGlossaryAliases       = 10
+)
+
+var glossaryKinds = []string{GlossaryKindPerson, GlossaryKindPet, GlossaryKindPlace}
+
+var errGlossaryForbidden = errors.New("only members of this Explorer's circle may read the glossary, and only owners or admins may change it")
+
+// GlossaryEntry is one person, pet or place in an explorer's glossary,
+// stored at explorers/{explorerId}/glossary/{id}. Captions refer to the
+// entry by CaptionName and TTS speaks Pronunciation in place of the name.
+type GlossaryEntry struct {
+	ID   string `firestore:"-" json:"id"`
+	Kind string `firestore:"kind" json:"kind"`
+	Name string `firestore:"name" json:"name"`
+	// CaptionName is what captions call them ("Grandma Sue"); empty uses
+	// Name.
+	CaptionName string `firestore:"captionName,omitempty" json:"caption_name,omitempty"`
+	// Relationship is who they are to the explorer ("grandma"), the kind
+	// of animal ("dog") or what the place is ("Nona's house").
+	Relationship string `firestore:"relationship,omitempty" json:"relationship,omitempty"`
+	// Pronunciation is a plain respelling TTS reads instead of the name,
+	// e.g. "Shi-vawn" for Siobhan, optionally followed by IPA between
+	// slashes ("Shi-vawn /ʃɪˈvɔːn/") for voices that take SSML (see
+	// ssml.go). The respelling is required because the others, including
+	// the default voice, cannot read IPA.
+	Pronunciation string `firestore:"pronunciation,omitempty" json:"pronunciation,omitempty"`
+	// Aliases are other names people use in context ("Nana", "Grams").
+	Aliases   []string  `firestore:"aliases" json:"aliases"`
+	CreatedBy string    `firestore:"createdBy" json:"created_by"`
+	CreatedAt time.Time `firestore:"createdAt" json:"created_at"`
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updated_at"`
+}
+
+// displayName is how captions should refer to the entry.
+func (e GlossaryEntry) displayName() string {
+	if e.CaptionName != "" {
+		return e.CaptionName
+	}
+	if e.Kind == GlossaryKindPet && e.Relationship != "" {
+		return e.Name + " the " + e.Relationship
+	}
+	return e.Name
+}
+
+// names returns every name the entry may be mentioned by.
+func (e GlossaryEntry) names() []string {
+	names := []string{e.Name}
+	if e.CaptionName != "" {
+		names = append(names, e.CaptionName)
+	}
+	return append(names, e.Aliases...)
+}
+
+// normalize trims and validates an entry from a client.
+func (e *GlossaryEntry) normalize() error {
+	e.Kind = strings.ToLower(strings.TrimSpace(e.Kind))
+	e.Name = strings.TrimSpace(e.Name)
+	e.CaptionName = strings.TrimSpace(e.CaptionName)
+	e.Relationship = strings.TrimSpace(e.Relationship)
+	e.Pronunciation = strings.TrimSpace(e.Pronunciation)
+	if !containsString(glossaryKinds, e.Kind) {
+		return fmt.Errorf("kind must be one of %s", strings.Join(glossaryKinds, ", "))
+	}
+	if e.Name == "" || len([]rune(e.Name)) > maxGlossaryNameChars {
+		return fmt.Errorf("name is required and must be at most %d characters", maxGlossaryNameChars)
+	}
+	for _, field := range []string{e.CaptionName, e.Relationship, e.Pronunciation} {
+		if len([]rune(field)) > maxGlossaryTextChars || strings.ContainsAny(field, "<>") {
+			return fmt.Errorf("caption_name, relationship and pronunciation must be at most %d characters without < or >", maxGlossaryTextChars)
+		}
+	}
+	if respelling, ipa := splitPronunciation(e.Pronunciation); ipa != "" && respelling == "" {
+		return fmt.Errorf("pronunciation needs a respelling before the IPA, e.g. \"Shi-vawn /ʃɪˈvɔːn/\"")
+	}
+	aliases := []string{}
+	for _, alias := range e.Aliases {
+		if alias = strings.TrimSpace(alias); alias != "" && !containsString(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	if len(aliases) > maxGlossaryAliases {
+		return fmt.Errorf("at most %d aliases are allowed", maxGlossaryAliases)
+	}
+	e.Aliases = aliases
+	return nil
+}
+
+func glossaryCollection(client *firestore.Client, explorerID string) *firestore.CollectionRef {
+	return client.Collection("explorers").Doc(explorerID).Collection(glossarySubcollection)
+}
+
+// loadGlossary returns an explorer's glossary ordered by kind and name. A nil
+// client returns nothing, so callers without Firestore just skip it.
+func loadGlossary(ctx context.Context, client *firestore.Client, explorerID string) ([]GlossaryEntry, error) {
+	if client == nil || explorerID == "" {
+		return nil, nil
+	}
+	entries := []GlossaryEntry{}
+	iter := glossaryCollection(client, explorerID).Limit(maxGlossaryEntries).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load glossary for %s: %w", explorerID, err)
+		}
+		var entry GlossaryEntry
+		if err := doc.DataTo(&entry); err != nil {
+			fmt.Printf("loadGlossary: skipping undecodable entry %s: %v\n", doc.Ref.Path, err)
+			continue
+		}
+		entry.ID = doc.Ref.ID
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return kindOrder(entries[i].Kind) < kindOrder(entries[j].Kind)
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	return entries, nil
+}
+
+func kindOrder(kind string) int {
+	for i, k := range glossaryKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(glossaryKinds)
+}
+
+// mentionsName reports whether name appears in text as whole words,
+// ignoring case.
+func mentionsName(text, name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	pattern := `(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(name) + `($|[^\pL\pN])`
+	matched, err := regexp.MatchString(pattern, text)
+	return err == nil && matched
+}
+
+// relevantGlossaryEntries picks the entries to show the caption model:
+// those mentioned in context (people context, sender name) first, then the
+// rest in glossary order, up to maxGlossaryPromptEntries.
+func relevantGlossaryEntries(entries []GlossaryEntry, context ...string) []GlossaryEntry {
+	text := strings.Join(context, "\n")
+	var mentioned, rest []GlossaryEntry
+	for _, entry := range entries {
+		isMentioned := false
+		for _, name := range entry.names() {
+			if mentionsName(text, name) {
+				isMentioned = true
+				break
+			}
+		}
+		if isMentioned {
+			mentioned = append(mentioned, entry)
+		} else {
+			rest = append(rest, entry)
+		}
+	}
+	relevant := append(mentioned, rest...)
+	if len(relevant) > maxGlossaryPromptEntries {
+		relevant = relevant[:maxGlossaryPromptEntries]
+	}
+	return relevant
+}
+
+// glossaryPrompt adds the glossary to a rendered caption prompt so captions
+// name people, pets and places the same way every time.
+func glossaryPrompt(prompt string, entries []GlossaryEntry) string {
+	if len(entries) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\nPEOPLE, PETS AND PLACES THE VIEWER KNOWS. When you mention one, always use the name in quotes exactly:\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "- %q: %s", e.displayName(), e.Kind)
+		if e.Relationship != "" && e.Kind != GlossaryKindPet {
+			fmt.Fprintf(&b, " (%s)", e.Relationship)
+		}
+		var others []string
+		for _, name := range append([]string{e.Name}, e.Aliases...) {
+			if name != e.displayName() {
+				others = append(others, name)
+			}
+		}
+		if len(others) > 0 {
+			fmt.Fprintf(&b, "; also called %s", strings.Join(others, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// glossaryPronunciations maps each name to how TTS should say it.
+func glossaryPronunciations(entries []GlossaryEntry) map[string]string {
+	pronunciations := map[string]string{}
+	for _, e := range entries {
+		if e.Pronunciation == "" {
+			continue
+		}
+		pronunciations[e.Name] = e.Pronunciation
+	}
+	return pronunciations
+}
+
+// speechPronunciations loads the glossary pronunciations for explorerID,
+// logging and returning nil when the glossary is unavailable.
+func speechPronunciations(ctx context.Context, client *firestore.Client, explorerID string) map[string]string {
+	entries, err := loadGlossary(ctx, client, explorerID)
+	if err != nil {
+		fmt.Printf("speechPronunciations: %v\n", err)
+		return nil
+	}
+	return glossaryPronunciations(entries)
+}
+
+// requireGlossaryAccess checks the caller's role in the circle: any member
+// may read, owners and admins may write. Audit admins may do both.
+func requireGlossaryAccess(ctx context.Context, client *firestore.Client, explorerID, userID string, write bool) error {
+	docs, err := client.Collection(relationshipsCollection).
+		Where("userId", "==", userID).
+		Where("explorerId", "==", explorerID).
+		Limit(1).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return fmt.Errorf("relationship lookup: %w", err)
+	}
+	if len(docs) == 0 {
+		return errGlossaryForbidden
+	}
+	if !write {
+		return nil
+	}
+	switch role, _ := docs[0].Data()["role"].(string); role {
+	case "owner", "admin":
+		return nil
+	default:
+		return errGlossaryForbidden
+	}
+}
+
+// ManageGlossary is the HTTP endpoint for an explorer's glossary:
+//
+//	GET    ?explorer_id=              lists the entries
+//	POST   ?explorer_id=  {entry}     adds one
+//	PUT    ?explorer_id=&id= {entry}  replaces one
+//	DELETE ?explorer_id=&id=          removes one
+//
+// Circle members may list; owners and admins may change entries.
+func ManageGlossary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	explorerID := strings.TrimSpace(r.URL.Query().Get("explorer_id"))
+	if explorerID == "" || strings.Contains(explorerID, "/") {
+		http.Error(w, "explorer_id is required", http.StatusBadRequest)
+		return
+	}
+	entryID := strings.TrimSpace(r.URL.Query().Get("id"))
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !isAuditAdmin(token) {
+		err := requireGlossaryAccess(ctx, client, explorerID, token.UID, r.Method != http.MethodGet)
+		if errors.Is(err, errGlossaryForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	coll := glossaryCollection(client, explorerID)
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := loadGlossary(ctx, client, explorerID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"explorer_id": explorerID, "entries": entries})
+
+	case http.MethodPost, http.MethodPut:
+		var entry GlossaryEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := entry.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now().UTC()
+		entry.UpdatedAt = now
+
+		if r.Method == http.MethodPost {
+			count, err := coll.Limit(maxGlossaryEntries).Documents(ctx).GetAll()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(count) >= maxGlossaryEntries {
+				http.Error(w, fmt.Sprintf("a glossary holds at most %d entries", maxGlossaryEntries), http.StatusBadRequest)
+				return
+			}
+			entry.CreatedBy = token.UID
+			entry.CreatedAt = now
+			ref := coll.NewDoc()
+			if _, err := ref.Create(ctx, entry); err != nil {
+				http.Error(w, "create failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entry.ID = ref.ID
+		} else {
+			if entryID == "" {
+				http.Error(w, "id is required", http.StatusBadRequest)
+				return
+			}
+			ref := coll.Doc(entryID)
+			err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+				snap, err := tx.Get(ref)
+				if err != nil {
+					return err
+				}
+				var existing GlossaryEntry
+				if err := snap.DataTo(&existing); err != nil {
+					return err
+				}
+				entry.CreatedBy = existing.CreatedBy
+				entry.CreatedAt = existing.CreatedAt
+				return tx.Set(ref, entry)
+			})
+			if status.Code(err) == codes.NotFound {
+				http.Error(w, "entry not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "update failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entry.ID = entryID
+		}
+		fmt.Printf("ManageGlossary: %s saved %s entry %s for %s\n", token.UID, entry.Kind, entry.ID, explorerID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+
+	case http.MethodDelete:
+		if entryID == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := coll.Doc(entryID).Delete(ctx); err != nil {
+			http.Error(w, "delete failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("ManageGlossary: %s deleted entry %s for %s\n", token.UID, entryID, explorerID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"id": entryID, "deleted": true})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
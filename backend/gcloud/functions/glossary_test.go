@@ -0,0 +1,92 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRelevantGlossaryEntries(t *testing.T) {
+	entries := []GlossaryEntry{
+		{Kind: GlossaryKindPerson, Name: "Ann"},
+		{Kind: GlossaryKindPerson, Name: "Susan", Aliases: []string{"Nana"}},
+		{Kind: GlossaryKindPet, Name: "Rex", Relationship: "dog"},
+		{Kind: GlossaryKindPlace, Name: "Lake House"},
+	}
+	names := func(entries []GlossaryEntry) string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name    string
+		context []string
+		want    string
+	}{
+		{"no context keeps glossary order", nil, "Ann,Susan,Rex,Lake House"},
+		{"mentioned entries first", []string{"Photo from the lake house", "Rex"}, "Rex,Lake House,Ann,Susan"},
+		{"aliases count as mentions", []string{"Nana says hi"}, "Susan,Ann,Rex,Lake House"},
+		{"only whole words match", []string{"Annabel and Rexford"}, "Ann,Susan,Rex,Lake House"},
+	}
+	for _, tt := range tests {
+		if got := names(relevantGlossaryEntries(entries, tt.context...)); got != tt.want {
+			t.Errorf("%s: relevantGlossaryEntries = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	many := make([]GlossaryEntry, maxGlossaryPromptEntries+5)
+	for i := range many {
+		many[i] = GlossaryEntry{Kind: GlossaryKindPerson, Name: strings.Repeat("x", i+1)}
+	}
+	many[len(many)-1].Name = "Zed"
+	got := relevantGlossaryEntries(many, "Zed waved")
+	if len(got) != maxGlossaryPromptEntries {
+		t.Fatalf("relevantGlossaryEntries returned %d entries, want %d", len(got), maxGlossaryPromptEntries)
+	}
+	if got[0].Name != "Zed" {
+		t.Errorf("a mentioned entry past the limit was dropped; first entry is %q", got[0].Name)
+	}
+}
+
+func TestGlossaryEntryNormalize(t *testing.T) {
+	entry := GlossaryEntry{Kind: " Person ", Name: " Siobhan ", Aliases: []string{" Shiv ", "", "Shiv"}}
+	if err := entry.normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if entry.Kind != GlossaryKindPerson || entry.Name != "Siobhan" || len(entry.Aliases) != 1 || entry.Aliases[0] != "Shiv" {
+		t.Errorf("normalize = %+v", entry)
+	}
+	withIPA := GlossaryEntry{Kind: GlossaryKindPerson, Name: "Siobhan", Pronunciation: " Shi-vawn /ʃɪˈvɔːn/ "}
+	if err := withIPA.normalize(); err != nil || withIPA.Pronunciation != "Shi-vawn /ʃɪˈvɔːn/" {
+		t.Errorf("normalize with IPA = %q, %v", withIPA.Pronunciation, err)
+	}
+
+	for name, bad := range map[string]GlossaryEntry{
+		"unknown kind":             {Kind: "car", Name: "Herbie"},
+		"missing name":             {Kind: GlossaryKindPerson},
+		"markup in a respelling":   {Kind: GlossaryKindPerson, Name: "Siobhan", Pronunciation: "<phoneme>"},
+		"IPA without a respelling": {Kind: GlossaryKindPerson, Name: "Siobhan", Pronunciation: "/ʃɪˈvɔːn/"},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("%s: normalize accepted %+v", name, bad)
+		}
+	}
+}
+
+func TestGlossaryEntryDisplayName(t *testing.T) {
+	tests := []struct {
+		entry GlossaryEntry
+		want  string
+	}{
+		{GlossaryEntry{Kind: GlossaryKindPerson, Name: "Susan", CaptionName: "Grandma Sue"}, "Grandma Sue"},
+		{GlossaryEntry{Kind: GlossaryKindPet, Name: "Rex", Relationship: "dog"}, "Rex the dog"},
+		{GlossaryEntry{Kind: GlossaryKindPerson, Name: "Susan", Relationship: "grandma"}, "Susan"},
+	}
+	for _, tt := range tests {
+		if got := tt.entry.displayName(); got != tt.want {
+			t.Errorf("displayName(%+v) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	mediaBucket = "reflections-1200b-storage"
+
+	maxCaptionImageBytes     = 10 << 20
+	captionImageFetchTimeout = 15 * time.Second
+	maxCaptionImageRedirects = 3
+)
+
+// captionImageURLHosts are the only hosts GenerateAIDescription fetches over
+// HTTP. Our own bucket is never fetched by URL: its URLs are turned back into
+// keys and read through S3.
+var captionImageURLHosts = map[string]bool{
+	"images.unsplash.com": true,
+	"plus.unsplash.com":   true,
+}
+
+// mediaBucketHosts are the virtual-hosted and path-style hostnames a
+// presigned URL for mediaBucket may use.
+var mediaBucketHosts = map[string]bool{
+	mediaBucket + ".s3.amazonaws.com":           true,
+	mediaBucket + ".s3.us-east-1.amazonaws.com": true,
+	"s3.amazonaws.com":                          true,
+	"s3.us-east-1.amazonaws.com":                true,
+}
+
+// errCaptionImageRejected marks client input that was refused rather than
+// failing to load; handlers answer it with 400.
+var errCaptionImageRejected = errors.New("image rejected")
+
+func rejectCaptionImage(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errCaptionImageRejected, fmt.Sprintf(format, args...))
+}
+
+// captionImage is an image loaded for captioning.
+type captionImage struct {
+	Data []byte
+	MIME string
+	// Key is the S3 key when the image came from our bucket.
+	Key string
+}
+
+// captionImageKeyFor validates a client-supplied key: it must stay inside
+// the explorer's own prefix or the shared staging area.
+func captionImageKeyFor(explorerID, key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.Contains(key, "..") || path.Clean(key) != key {
+		return "", rejectCaptionImage("invalid image key %q", key)
+	}
+	if !strings.HasPrefix(key, "staging/") && !strings.HasPrefix(key, explorerID+"/") {
+		return "", rejectCaptionImage("image key %q is outside explorer %s", key, explorerID)
+	}
+	return key, nil
+}
+
+// eventImageKey builds the image key for an event reference, following the
+// layout GetSignedURL uploads to.
+func eventImageKey(explorerID, eventID, eventPath string) (string, error) {
+	if eventID == "" || strings.ContainsAny(eventID, "/.") {
+		return "", rejectCaptionImage("invalid event id %q", eventID)
+	}
+	switch eventPath {
+	case "", "staging":
+		return fmt.Sprintf("staging/%s/image.jpg", eventID), nil
+	case "to", "from":
+		return fmt.Sprintf("%s/%s/%s/image.jpg", explorerID, eventPath, eventID), nil
+	default:
+		return "", rejectCaptionImage("invalid event path %q", eventPath)
+	}
+}
+
+// bucketKeyFromURL recognises URLs (typically presigned) that point at
+// mediaBucket and returns the object key.
+func bucketKeyFromURL(u *url.URL) (string, bool) {
+	host := strings.ToLower(u.Hostname())
+	if !mediaBucketHosts[host] {
+		return "", false
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if !strings.HasPrefix(host, mediaBucket+".") {
+		var ok bool
+		if key, ok = strings.CutPrefix(key, mediaBucket+"/"); !ok {
+			return "", false
+		}
+	}
+	return key, key != ""
+}
+
+// sniffCaptionImageMIME identifies JPEG, PNG and HEIC/HEIF from magic bytes.
+// Declared content types are ignored; anything else is refused.
+func sniffCaptionImageMIME(data []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg", true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png", true
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic", true
+		case "mif1", "msf1":
+			return "image/heif", true
+		}
+	}
+	return "", false
+}
+
+// readCaptionImage reads at most maxCaptionImageBytes from body.
+func readCaptionImage(body io.Reader) ([]byte, error) {
+	return readLimited(body, "image", maxCaptionImageBytes)
+}
+
+func readLimited(body io.Reader, what string, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, rejectCaptionImage("%s is larger than %d bytes", what, maxBytes)
+	}
+	return data, nil
+}
+
+func readS3CaptionImage(ctx context.Context, s3Client *s3.Client, key string) ([]byte, error) {
+	return readS3Object(ctx, s3Client, key, "image", maxCaptionImageBytes)
+}
+
+// readS3Object reads key from mediaBucket, refusing objects over maxBytes;
+// what names the object in errors.
+func readS3Object(ctx context.Context, s3Client *s3.Client, key, what string, maxBytes int64) ([]byte, error) {
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(mediaBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	defer obj.Body.Close()
+	if obj.ContentLength != nil && *obj.ContentLength > maxBytes {
+		return nil, rejectCaptionImage("%s is larger than %d bytes", what, maxBytes)
+	}
+	return readLimited(obj.Body, what, maxBytes)
+}
+
+var captionImageHTTPClient = &http.Client{
+	Timeout: captionImageFetchTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxCaptionImageRedirects {
+			return rejectCaptionImage("too many redirects")
+		}
+		if req.URL.Scheme != "https" || !captionImageURLHosts[strings.ToLower(req.URL.Hostname())] {
+			return rejectCaptionImage("redirect to %s is not allowed", req.URL.Host)
+		}
+		return nil
+	},
+}
+
+func fetchAllowlistedImage(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, rejectCaptionImage("invalid image URL")
+	}
+	res, err := captionImageHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch image: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch image: HTTP %d", res.StatusCode)
+	}
+	if res.ContentLength > maxCaptionImageBytes {
+		return nil, rejectCaptionImage("image is larger than %d bytes", maxCaptionImageBytes)
+	}
+	return readCaptionImage(res.Body)
+}
+
+// loadCaptionImage resolves the image GenerateAIDescription should caption.
+// In order of preference the request names it by image_s3_key, by event_id
+// (with event_path to|from|staging, default staging) or by image_url. URLs
+// into our bucket are read as keys; any other URL must be HTTPS on an
+// allowlisted host. Whatever the source, only JPEG, PNG and HEIC content is
+// accepted.
+func loadCaptionImage(ctx context.Context, s3Client *s3.Client, r *http.Request, explorerID string) (*captionImage, error) {
+	q := r.URL.Query()
+	img := &captionImage{}
+
+	var fetchURL *url.URL
+	switch {
+	case q.Get("image_s3_key") != "":
+		key, err := captionImageKeyFor(explorerID, q.Get("image_s3_key"))
+		if err != nil {
+			return nil, err
+		}
+		img.Key = key
+	case q.Get("event_id") != "":
+		key, err := eventImageKey(explorerID, q.Get("event_id"), q.Get("event_path"))
+		if err != nil {
+			return nil, err
+		}
+		img.Key = key
+	case q.Get("image_url") != "":
+		u, err := url.Parse(q.Get("image_url"))
+		if err != nil || u.Scheme != "https" {
+			return nil, rejectCaptionImage("image_url must be an https URL")
+		}
+		if key, ok := bucketKeyFromURL(u); ok {
+			if img.Key, err = captionImageKeyFor(explorerID, key); err != nil {
+				return nil, err
+			}
+		} else if captionImageURLHosts[strings.ToLower(u.Hostname())] && u.Port() == "" {
+			fetchURL = u
+		} else {
+			return nil, rejectCaptionImage("image host %s is not allowed", u.Host)
+		}
+	default:
+		return nil, rejectCaptionImage("image_s3_key, event_id or image_url is required")
+	}
+
+	var err error
+	if fetchURL != nil {
+		img.Data, err = fetchAllowlistedImage(ctx, fetchURL)
+	} else {
+		img.Data, err = readS3CaptionImage(ctx, s3Client, img.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mime, ok := sniffCaptionImageMIME(img.Data)
+	if !ok {
+		return nil, rejectCaptionImage("image must be JPEG, PNG or HEIC")
+	}
+	img.MIME = mime
+	return img, nil
+}
+
+// stagingEventIDFromKey returns {event_id} for staging/{event_id}/image.*
+// keys so the client can clean the staging folder up.
+func stagingEventIDFromKey(key string) string {
+	rest, ok := strings.CutPrefix(key, "staging/")
+	if !ok {
+		return ""
+	}
+	eventID, file, ok := strings.Cut(rest, "/")
+	if !ok || !strings.HasPrefix(file, "image") {
+		return ""
+	}
+	return eventID
+}
@@ -0,0 +1,202 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCaptionImageKeyFor(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{key: "explorer-1/to/123/image.jpg", want: "explorer-1/to/123/image.jpg"},
+		{key: "/explorer-1/from/123/image.jpg", want: "explorer-1/from/123/image.jpg"},
+		{key: "staging/123/image.jpg", want: "staging/123/image.jpg"},
+		{key: "", wantErr: true},
+		{key: "explorer-2/to/123/image.jpg", wantErr: true},
+		{key: "explorer-10/to/123/image.jpg", wantErr: true},
+		{key: "explorer-1", wantErr: true},
+		{key: "explorer-1/../explorer-2/to/image.jpg", wantErr: true},
+		{key: "staging/../explorer-2/to/image.jpg", wantErr: true},
+		{key: "explorer-1//to/image.jpg", wantErr: true},
+		{key: "explorer-1/./to/image.jpg", wantErr: true},
+		{key: "explorer-1/to/", wantErr: true},
+		{key: "stagingx/123/image.jpg", wantErr: true},
+		{key: "tts-cache/abc.mp3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := captionImageKeyFor("explorer-1", tt.key)
+		if tt.wantErr {
+			if !errors.Is(err, errCaptionImageRejected) {
+				t.Errorf("captionImageKeyFor(%q) = %q, %v; want rejection", tt.key, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("captionImageKeyFor(%q) = %q, %v; want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestEventImageKey(t *testing.T) {
+	tests := []struct {
+		eventID, eventPath string
+		want               string
+		wantErr            bool
+	}{
+		{eventID: "123", want: "staging/123/image.jpg"},
+		{eventID: "123", eventPath: "staging", want: "staging/123/image.jpg"},
+		{eventID: "123", eventPath: "to", want: "explorer-1/to/123/image.jpg"},
+		{eventID: "123", eventPath: "from", want: "explorer-1/from/123/image.jpg"},
+		{eventID: "", wantErr: true},
+		{eventID: "../123", wantErr: true},
+		{eventID: "1/2", wantErr: true},
+		{eventID: "123", eventPath: "avatars", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := eventImageKey("explorer-1", tt.eventID, tt.eventPath)
+		if tt.wantErr {
+			if !errors.Is(err, errCaptionImageRejected) {
+				t.Errorf("eventImageKey(%q, %q) = %q, %v; want rejection", tt.eventID, tt.eventPath, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("eventImageKey(%q, %q) = %q, %v; want %q", tt.eventID, tt.eventPath, got, err, tt.want)
+		}
+	}
+}
+
+func TestBucketKeyFromURL(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{raw: "https://" + mediaBucket + ".s3.amazonaws.com/explorer-1/to/1/image.jpg?X-Amz-Signature=abc", want: "explorer-1/to/1/image.jpg", wantOK: true},
+		{raw: "https://" + strings.ToUpper(mediaBucket) + ".S3.US-EAST-1.AMAZONAWS.COM/staging/1/image.jpg", want: "staging/1/image.jpg", wantOK: true},
+		{raw: "https://s3.amazonaws.com/" + mediaBucket + "/explorer-1/to/1/image.jpg", want: "explorer-1/to/1/image.jpg", wantOK: true},
+		{raw: "https://s3.amazonaws.com/other-bucket/explorer-1/to/1/image.jpg"},
+		{raw: "https://" + mediaBucket + ".s3.amazonaws.com/"},
+		{raw: "https://" + mediaBucket + ".s3.amazonaws.com.evil.example/x.jpg"},
+		{raw: "https://other-bucket.s3.amazonaws.com/explorer-1/to/1/image.jpg"},
+		{raw: "https://images.unsplash.com/photo.jpg"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.raw)
+		if err != nil {
+			t.Fatalf("url.Parse(%q): %v", tt.raw, err)
+		}
+		got, ok := bucketKeyFromURL(u)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("bucketKeyFromURL(%q) = %q, %v; want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSniffCaptionImageMIME(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0}, "image/jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00"), "image/heic"},
+		{"heif", []byte("\x00\x00\x00\x18ftypmif1\x00\x00"), "image/heif"},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00"), ""},
+		{"gif", []byte("GIF89a"), ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), ""},
+		{"html", []byte("<!DOCTYPE html>"), ""},
+		{"truncated ftyp", []byte("\x00\x00\x00\x18ftyp"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		got, ok := sniffCaptionImageMIME(tt.data)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: sniffCaptionImageMIME = %q, %v; want %q", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+// TestLoadCaptionImageRejects covers requests refused before anything is
+// read, so no S3 client is needed.
+func TestLoadCaptionImageRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"nothing named", url.Values{}},
+		{"key outside explorer", url.Values{"image_s3_key": {"explorer-2/to/1/image.jpg"}}},
+		{"key traversal", url.Values{"image_s3_key": {"explorer-1/../explorer-2/to/1/image.jpg"}}},
+		{"bad event path", url.Values{"event_id": {"1"}, "event_path": {"../x"}}},
+		{"plain http", url.Values{"image_url": {"http://images.unsplash.com/photo.jpg"}}},
+		{"unlisted host", url.Values{"image_url": {"https://169.254.169.254/latest/meta-data/"}}},
+		{"localhost", url.Values{"image_url": {"https://localhost/image.jpg"}}},
+		{"allowlisted host on another port", url.Values{"image_url": {"https://images.unsplash.com:8443/photo.jpg"}}},
+		{"allowlisted host as userinfo", url.Values{"image_url": {"https://images.unsplash.com@evil.example/photo.jpg"}}},
+		{"bucket URL outside explorer", url.Values{"image_url": {"https://" + mediaBucket + ".s3.amazonaws.com/explorer-2/to/1/image.jpg"}}},
+		{"bucket URL with encoded traversal", url.Values{"image_url": {"https://" + mediaBucket + ".s3.amazonaws.com/explorer-1/%2e%2e/explorer-2/to/1/image.jpg"}}},
+		{"not a URL", url.Values{"image_url": {"://"}}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query.Encode(), nil)
+		img, err := loadCaptionImage(context.Background(), nil, r, "explorer-1")
+		if !errors.Is(err, errCaptionImageRejected) {
+			t.Errorf("%s: loadCaptionImage = %+v, %v; want rejection", tt.name, img, err)
+		}
+	}
+}
+
+func TestCaptionImageRedirects(t *testing.T) {
+	via := func(n int) []*http.Request { return make([]*http.Request, n) }
+	tests := []struct {
+		name    string
+		target  string
+		via     int
+		wantErr bool
+	}{
+		{name: "allowlisted", target: "https://plus.unsplash.com/photo.jpg", via: 1},
+		{name: "downgrade to http", target: "http://images.unsplash.com/photo.jpg", via: 1, wantErr: true},
+		{name: "unlisted host", target: "https://169.254.169.254/latest/meta-data/", via: 1, wantErr: true},
+		{name: "too many", target: "https://images.unsplash.com/photo.jpg", via: maxCaptionImageRedirects, wantErr: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		err := captionImageHTTPClient.CheckRedirect(req, via(tt.via))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckRedirect = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if data, err := readLimited(strings.NewReader("12345"), "image", 5); err != nil || string(data) != "12345" {
+		t.Errorf("readLimited at the limit = %q, %v", data, err)
+	}
+	if _, err := readLimited(strings.NewReader("123456"), "image", 5); !errors.Is(err, errCaptionImageRejected) {
+		t.Errorf("readLimited over the limit = %v, want rejection", err)
+	}
+}
+
+func TestStagingEventIDFromKey(t *testing.T) {
+	tests := map[string]string{
+		"staging/123/image.jpg":       "123",
+		"staging/123/image.png":       "123",
+		"staging/123/video.mp4":       "",
+		"staging/123":                 "",
+		"explorer-1/to/123/image.jpg": "",
+	}
+	for key, want := range tests {
+		if got := stagingEventIDFromKey(key); got != want {
+			t.Errorf("stagingEventIDFromKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
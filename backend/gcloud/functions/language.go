@@ -0,0 +1,171 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// captionLanguages are the languages captions can be written and spoken in,
+// in the order a bare language ("es") resolves to a regional one. Every
+// entry has voices in googleTTSVoiceCatalog.
+var captionLanguages = []struct {
+	Code string
+	Name string // English name, used in prompts
+}{
+	{"en-US", "English"},
+	{"es-US", "Spanish"},
+	{"es-ES", "Spanish (Spain)"},
+	{"fr-FR", "French"},
+	{"de-DE", "German"},
+	{"it-IT", "Italian"},
+	{"pt-BR", "Portuguese (Brazil)"},
+	{"hi-IN", "Hindi"},
+	{"cmn-CN", "Mandarin Chinese"},
+	{"ja-JP", "Japanese"},
+	{"ko-KR", "Korean"},
+	{"vi-VN", "Vietnamese"},
+	{"ar-XA", "Arabic"},
+}
+
+// normalizeLanguage maps a BCP-47 tag onto a supported caption language:
+// exact matches first ("es-es" is es-ES), then the first language with the
+// same base ("es", "es-MX" are es-US; "zh" is cmn-CN). It reports false for
+// empty or unsupported tags.
+func normalizeLanguage(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if tag == "" {
+		return "", false
+	}
+	for _, l := range captionLanguages {
+		if strings.EqualFold(l.Code, tag) {
+			return l.Code, true
+		}
+	}
+	base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+	if base == "zh" {
+		base = "cmn"
+	}
+	for _, l := range captionLanguages {
+		if lBase, _, _ := strings.Cut(strings.ToLower(l.Code), "-"); lBase == base {
+			return l.Code, true
+		}
+	}
+	return "", false
+}
+
+// languageName returns the English name of a supported language code.
+func languageName(code string) string {
+	for _, l := range captionLanguages {
+		if l.Code == code {
+			return l.Name
+		}
+	}
+	return code
+}
+
+// sameBaseLanguage reports whether two supported codes are the same
+// language, so es-US is never rendered again as es-ES.
+func sameBaseLanguage(a, b string) bool {
+	aBase, _, _ := strings.Cut(a, "-")
+	bBase, _, _ := strings.Cut(b, "-")
+	return strings.EqualFold(aBase, bBase)
+}
+
+// CaptionLanguages is the language choice for one caption: Primary is what
+// short_caption and deep_dive are written in, Second an optional extra
+// rendering ("" for none).
+type CaptionLanguages struct {
+	Primary string
+	Second  string
+}
+
+// resolveCaptionLanguages picks the caption languages from, in order, the
+// request, the explorer's settings.caption_language and
+// settings.second_language, and the companion's users.caption_language,
+// which is rendered as the second language when it differs from the
+// explorer's. Unsupported tags are ignored; the default is English only.
+func resolveCaptionLanguages(requested, requestedSecond string, profile explorerPromptProfile, companionLanguage string) CaptionLanguages {
+	langs := CaptionLanguages{Primary: DefaultGoogleTTSLanguageCode}
+	for _, tag := range []string{requested, profile.Language} {
+		if code, ok := normalizeLanguage(tag); ok {
+			langs.Primary = code
+			break
+		}
+	}
+	for _, tag := range []string{requestedSecond, profile.SecondLanguage, companionLanguage} {
+		if code, ok := normalizeLanguage(tag); ok && !sameBaseLanguage(code, langs.Primary) {
+			langs.Second = code
+			break
+		}
+	}
+	return langs
+}
+
+// loadCompanionLanguage reads users/{companionID}.caption_language; "" when
+// unset or unreadable.
+func loadCompanionLanguage(ctx context.Context, client *firestore.Client, companionID string) string {
+	if client == nil || companionID == "" {
+		return ""
+	}
+	snap, err := client.Collection("users").Doc(companionID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ""
+	}
+	if err != nil {
+		fmt.Printf("loadCompanionLanguage: %s: %v\n", companionID, err)
+		return ""
+	}
+	language, _ := snap.Data()["caption_language"].(string)
+	return language
+}
+
+// languagePrompt tells the model which language to write in. English adds
+// nothing, so existing prompts and cache keys are unchanged.
+func languagePrompt(prompt, language string) string {
+	if language == "" || language == DefaultGoogleTTSLanguageCode {
+		return prompt
+	}
+	return prompt + fmt.Sprintf("\n\nLANGUAGE: Write short_caption and deep_dive in %s (%s), in words a native speaker would use with a child. Keep names as they are. detected_people and safety_flags stay in English.", languageName(language), language)
+}
+
+// translationPrompt asks the caption model to render an existing caption in
+// another language; the image is sent again so the wording can stay true to
+// it. The reply uses the caption schema.
+func translationPrompt(c *Caption, language string, profile CaptionOutputProfile) string {
+	source, _ := json.Marshal(c)
+	return profile.apply(fmt.Sprintf(`Here is a caption for this image, written for a child:
+
+%s
+
+Render the same caption in %s (%s). Keep the meaning, tone and sentence count, and keep names as they are. Return the same JSON object with short_caption and deep_dive in %s and detected_people and safety_flags unchanged.`,
+		source, languageName(language), language, languageName(language)))
+}
+
+// translationProfile is the output profile a second-language rendering is
+// held to: very_simple carries over, but a core vocabulary is a list of
+// words in the primary language, so it cannot.
+func translationProfile(profile CaptionOutputProfile) CaptionOutputProfile {
+	if profile.Name == CaptionProfileVerySimple {
+		return profile
+	}
+	return CaptionOutputProfile{}
+}
+
+// explorerCaptionLanguages resolves the caption languages without rendering
+// a prompt, for requests that only synthesize speech for given texts.
+func explorerCaptionLanguages(ctx context.Context, client *firestore.Client, explorerID, requested, requestedSecond, companionLanguage string) CaptionLanguages {
+	var profile explorerPromptProfile
+	if client != nil {
+		var err error
+		if profile, err = loadExplorerPromptProfile(ctx, client, explorerID); err != nil {
+			fmt.Printf("explorerCaptionLanguages: %v; using defaults\n", err)
+		}
+	}
+	return resolveCaptionLanguages(requested, requestedSecond, profile, companionLanguage)
+}
@@ -0,0 +1,78 @@
+package functions
+
+import "testing"
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := []struct {
+		tag    string
+		want   string
+		wantOK bool
+	}{
+		{"en-US", "en-US", true},
+		{" es_es ", "es-ES", true},
+		{"es", "es-US", true},
+		{"es-MX", "es-US", true},
+		{"zh", "cmn-CN", true},
+		{"zh-TW", "cmn-CN", true},
+		{"PT", "pt-BR", true},
+		{"", "", false},
+		{"xx-YY", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeLanguage(tt.tag)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizeLanguage(%q) = %q, %v; want %q, %v", tt.tag, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCaptionLanguagesHaveVoices(t *testing.T) {
+	for _, l := range captionLanguages {
+		if len(googleTTSVoiceCatalog[l.Code]) == 0 {
+			t.Errorf("caption language %s has no voices in googleTTSVoiceCatalog", l.Code)
+		}
+	}
+}
+
+func TestResolveGoogleTTSVoice(t *testing.T) {
+	tests := []struct {
+		name, voice, language string
+		wantVoice, wantLang   string
+	}{
+		{"defaults", "", "", DefaultGoogleTTSVoiceName, DefaultGoogleTTSLanguageCode},
+		{"catalog voice without a language", "en-US-Studio-O", "", "en-US-Studio-O", "en-US"},
+		{"catalog voice in its language", "fr-FR-Chirp3-HD-Puck", "fr", "fr-FR-Chirp3-HD-Puck", "fr-FR"},
+		{"voice for another language", "en-US-Studio-O", "es-MX", "es-US-Chirp3-HD-Achernar", "es-US"},
+		{"unknown voice", "en-US-Wavenet-Z", "de-DE", "de-DE-Chirp3-HD-Achernar", "de-DE"},
+		{"unsupported language", "", "xx", DefaultGoogleTTSVoiceName, DefaultGoogleTTSLanguageCode},
+	}
+	for _, tt := range tests {
+		voice, language := resolveGoogleTTSVoice(tt.voice, tt.language)
+		if voice != tt.wantVoice || language != tt.wantLang {
+			t.Errorf("%s: resolveGoogleTTSVoice(%q, %q) = %q, %q; want %q, %q", tt.name, tt.voice, tt.language, voice, language, tt.wantVoice, tt.wantLang)
+		}
+	}
+}
+
+func TestResolveCaptionLanguages(t *testing.T) {
+	tests := []struct {
+		name                 string
+		requested, second    string
+		profile              explorerPromptProfile
+		companion            string
+		wantPrimary, wantSec string
+	}{
+		{"default", "", "", explorerPromptProfile{}, "", "en-US", ""},
+		{"explorer settings", "", "", explorerPromptProfile{Language: "es", SecondLanguage: "en"}, "", "es-US", "en-US"},
+		{"request wins", "fr", "", explorerPromptProfile{Language: "es"}, "", "fr-FR", ""},
+		{"companion language as second", "", "", explorerPromptProfile{}, "ja", "en-US", "ja-JP"},
+		{"second never repeats the primary", "", "en-GB", explorerPromptProfile{}, "", "en-US", ""},
+		{"unsupported tags are ignored", "xx", "yy", explorerPromptProfile{Language: "de"}, "ko", "de-DE", "ko-KR"},
+	}
+	for _, tt := range tests {
+		got := resolveCaptionLanguages(tt.requested, tt.second, tt.profile, tt.companion)
+		if got.Primary != tt.wantPrimary || got.Second != tt.wantSec {
+			t.Errorf("%s: resolveCaptionLanguages = %+v, want {%s %s}", tt.name, got, tt.wantPrimary, tt.wantSec)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	ModerationProviderGemini  = "gemini"
+	ModerationProviderKeyword = "keyword"
+	ModerationProviderFake    = "fake"
+
+	// Verdicts, from least to most severe. Flagged content is delivered and
+	// marked for a caregiver to look at; quarantined content is withheld
+	// until someone reviews it.
+	ModerationAllow      = "allow"
+	ModerationFlag       = "flag"
+	ModerationQuarantine = "quarantine"
+
+	moderationLogSource = "moderation"
+
+	moderationPrompt = "In one short sentence, say what this image shows."
+)
+
+// Safety flags raised by the caption model: explicit content is withheld,
+// the others only mark the caption for a caregiver.
+var (
+	quarantineSafetyFlags = []string{"explicit"}
+	flagSafetyFlags       = []string{"medical", "injury", "distress", "unsafe_activity"}
+)
+
+// moderationKeywords is the local stand-in for a text classifier: whole-word
+// matches (case-insensitive) of the quarantine list withhold the content,
+// matches of the flag list only mark it. MODERATION_BLOCKLIST adds
+// quarantine terms separated by "|" (commas would split --set-env-vars).
+var moderationKeywords = map[string][]string{
+	ModerationQuarantine: {
+		"suicide", "kill yourself", "self-harm", "self harm", "cutting herself", "cutting himself",
+		"naked", "nude", "porn", "sex", "sexy",
+		"gore", "dead body", "corpse", "beheaded", "knife attack",
+		"cocaine", "heroin", "meth", "overdose",
+		"fuck", "shit", "bitch", "cunt", "retard",
+	},
+	ModerationFlag: {
+		"blood", "bleeding", "injury", "injured", "wound", "hospital", "ambulance", "surgery",
+		"accident", "crash", "funeral", "died", "death", "sick", "crying", "scared",
+		"gun", "rifle", "shooting", "beer", "wine", "alcohol", "drunk", "cigarette", "smoking",
+		"damn", "hell",
+	},
+}
+
+var moderationKeywordPatterns = sync.OnceValue(func() map[string][]*regexp.Regexp {
+	lists := map[string][]string{
+		ModerationQuarantine: append(append([]string{}, moderationKeywords[ModerationQuarantine]...), strings.Split(os.Getenv("MODERATION_BLOCKLIST"), "|")...),
+		ModerationFlag:       moderationKeywords[ModerationFlag],
+	}
+	patterns := map[string][]*regexp.Regexp{}
+	for verdict, words := range lists {
+		for _, word := range words {
+			if word = strings.TrimSpace(word); word != "" {
+				patterns[verdict] = append(patterns[verdict], regexp.MustCompile(`(?i)(^|[^\pL\pN])`+regexp.QuoteMeta(word)+`($|[^\pL\pN])`))
+			}
+		}
+	}
+	return patterns
+})
+
+// SafetyRating is one provider safety rating, e.g. Gemini's per-category
+// harm probability.
+type SafetyRating struct {
+	Category string `firestore:"category" json:"category"`
+	// Probability is negligible, low, medium or high.
+	Probability string `firestore:"probability" json:"probability"`
+	Blocked     bool   `firestore:"blocked,omitempty" json:"blocked,omitempty"`
+}
+
+func geminiSafetyRatings(ratings []*genai.SafetyRating) []SafetyRating {
+	var out []SafetyRating
+	for _, r := range ratings {
+		if r == nil {
+			continue
+		}
+		out = append(out, SafetyRating{
+			Category:    strings.ToLower(strings.TrimPrefix(r.Category.String(), "HarmCategory")),
+			Probability: strings.ToLower(strings.TrimPrefix(r.Probability.String(), "HarmProbability")),
+			Blocked:     r.Blocked,
+		})
+	}
+	return out
+}
+
+// blockedSafetyRatings collects the ratings from a Gemini BlockedError.
+func blockedSafetyRatings(blocked *genai.BlockedError) []SafetyRating {
+	var ratings []SafetyRating
+	if blocked.Candidate != nil {
+		ratings = append(ratings, geminiSafetyRatings(blocked.Candidate.SafetyRatings)...)
+	}
+	if blocked.PromptFeedback != nil {
+		ratings = append(ratings, geminiSafetyRatings(blocked.PromptFeedback.SafetyRatings)...)
+	}
+	if len(ratings) == 0 {
+		ratings = []SafetyRating{{Category: "unspecified", Probability: "high", Blocked: true}}
+	}
+	return ratings
+}
+
+// ModerationInput is the content to screen. Any field may be empty.
+type ModerationInput struct {
+	// Texts are captions, descriptions and messages shown or spoken.
+	Texts []string
+	Image []byte
+	// ImageMIME is the image's content type; empty means JPEG.
+	ImageMIME string
+	// SafetyFlags are the caption model's own flags (see captionSafetyFlags).
+	SafetyFlags []string
+	// Ratings are provider safety ratings already obtained, e.g. from the
+	// caption call. Providers only rate the image themselves when empty.
+	Ratings []SafetyRating
+}
+
+// ModerationResult is a Moderator's decision.
+type ModerationResult struct {
+	Verdict   string         `firestore:"verdict" json:"verdict"`
+	Reasons   []string       `firestore:"reasons" json:"reasons"`
+	Ratings   []SafetyRating `firestore:"ratings,omitempty" json:"ratings,omitempty"`
+	Moderator string         `firestore:"moderator" json:"moderator"`
+	// Usage counts the tokens a provider call consumed; zero when the local
+	// rules decided alone.
+	Usage CaptionUsage `firestore:"-" json:"-"`
+}
+
+// Quarantined reports whether the content must be withheld.
+func (r *ModerationResult) Quarantined() bool {
+	return r != nil && r.Verdict == ModerationQuarantine
+}
+
+func (r *ModerationResult) raise(verdict, reason string) {
+	if moderationSeverity(verdict) > moderationSeverity(r.Verdict) {
+		r.Verdict = verdict
+	}
+	r.Reasons = append(r.Reasons, reason)
+}
+
+func moderationSeverity(verdict string) int {
+	switch verdict {
+	case ModerationFlag:
+		return 1
+	case ModerationQuarantine:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Moderator screens content before it reaches an explorer. Implementations
+// are selected by NewModerator, mirroring CaptionModel.
+type Moderator interface {
+	Name() string
+	Moderate(ctx context.Context, in ModerationInput) (*ModerationResult, error)
+	Close() error
+}
+
+// ModeratorConfig selects and configures a Moderator.
+type ModeratorConfig struct {
+	Provider string // gemini, keyword or fake
+	Model    string // gemini only; empty uses the caption default
+	APIKey   string
+}
+
+// ModeratorConfigFromEnv reads MODERATION_PROVIDER and MODERATION_MODEL.
+// Without a provider, Gemini is used when GEMINI_API_KEY is set and the
+// keyword rules otherwise.
+func ModeratorConfigFromEnv() ModeratorConfig {
+	cfg := ModeratorConfig{
+		Provider: strings.ToLower(strings.TrimSpace(os.Getenv("MODERATION_PROVIDER"))),
+		Model:    strings.TrimSpace(os.Getenv("MODERATION_MODEL")),
+		APIKey:   os.Getenv("GEMINI_API_KEY"),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ModerationProviderKeyword
+		if cfg.APIKey != "" {
+			cfg.Provider = ModerationProviderGemini
+		}
+	}
+	return cfg
+}
+
+// NewModerator builds the Moderator described by cfg.
+func NewModerator(ctx context.Context, cfg ModeratorConfig) (Moderator, error) {
+	switch cfg.Provider {
+	case ModerationProviderGemini:
+		return NewGeminiModerator(ctx, cfg.APIKey, cfg.Model)
+	case "", ModerationProviderKeyword:
+		return KeywordModerator{}, nil
+	case ModerationProviderFake:
+		return &FakeModerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown moderation provider %q", cfg.Provider)
+	}
+}
+
+// KeywordModerator applies the local rules: keyword lists, the caption
+// model's safety flags and any provider ratings already in the input. It
+// makes no network calls.
+type KeywordModerator struct{}
+
+func (KeywordModerator) Name() string { return ModerationProviderKeyword }
+
+func (KeywordModerator) Close() error { return nil }
+
+func (KeywordModerator) Moderate(ctx context.Context, in ModerationInput) (*ModerationResult, error) {
+	return applyModerationRules(in, ModerationProviderKeyword), nil
+}
+
+func applyModerationRules(in ModerationInput, moderator string) *ModerationResult {
+	result := &ModerationResult{Verdict: ModerationAllow, Reasons: []string{}, Ratings: in.Ratings, Moderator: moderator}
+	text := strings.Join(in.Texts, "\n")
+	patterns := moderationKeywordPatterns()
+	for _, verdict := range []string{ModerationQuarantine, ModerationFlag} {
+		for _, pattern := range patterns[verdict] {
+			if m := pattern.FindString(text); m != "" {
+				word := strings.TrimFunc(strings.ToLower(m), func(r rune) bool { return !unicode.IsLetter(r) })
+				result.raise(verdict, fmt.Sprintf("keyword %q", word))
+			}
+		}
+	}
+	for _, flag := range in.SafetyFlags {
+		switch {
+		case containsString(quarantineSafetyFlags, flag):
+			result.raise(ModerationQuarantine, "caption flag "+flag)
+		case containsString(flagSafetyFlags, flag):
+			result.raise(ModerationFlag, "caption flag "+flag)
+		}
+	}
+	for _, rating := range in.Ratings {
+		switch {
+		case rating.Blocked || rating.Probability == "high":
+			result.raise(ModerationQuarantine, fmt.Sprintf("provider rated %s %s", rating.Category, rating.Probability))
+		case rating.Probability == "medium":
+			result.raise(ModerationFlag, fmt.Sprintf("provider rated %s %s", rating.Category, rating.Probability))
+		}
+	}
+	return result
+}
+
+// GeminiModerator adds Gemini's safety ratings for the image to the local
+// rules. When the input already carries ratings (the caption call rated
+// the image) no extra request is made.
+type GeminiModerator struct {
+	clients   *sharedClient[*genai.Client]
+	modelName string
+}
+
+func NewGeminiModerator(ctx context.Context, apiKey, modelName string) (*GeminiModerator, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not configured")
+	}
+	if modelName == "" {
+		modelName = DefaultGeminiCaptionModel
+	}
+	clients := geminiClientFor(apiKey)
+	if _, err := clients.get(ctx); err != nil {
+		return nil, err
+	}
+	return &GeminiModerator{clients: clients, modelName: modelName}, nil
+}
+
+func (m *GeminiModerator) Name() string { return ModerationProviderGemini + "/" + m.modelName }
+
+// Close is a no-op: the Gemini client is shared (see clients.go).
+func (m *GeminiModerator) Close() error { return nil }
+
+func (m *GeminiModerator) Moderate(ctx context.Context, in ModerationInput) (*ModerationResult, error) {
+	var usage CaptionUsage
+	if len(in.Image) > 0 && len(in.Ratings) == 0 {
+		ratings, rateUsage, err := m.rateImage(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		in.Ratings = ratings
+		usage = rateUsage
+	}
+	result := applyModerationRules(in, m.Name())
+	result.Usage = usage
+	return result, nil
+}
+
+func (m *GeminiModerator) rateImage(ctx context.Context, in ModerationInput) ([]SafetyRating, CaptionUsage, error) {
+	client, err := m.clients.get(ctx)
+	if err != nil {
+		return nil, CaptionUsage{}, err
+	}
+	model := client.GenerativeModel(m.modelName)
+	mime := in.ImageMIME
+	if mime == "" {
+		mime = "image/jpeg"
+	}
+	resp, err := CallWithRetry(ctx, m.Name(), RetryPolicyFromEnv(), func(ctx context.Context) (*genai.GenerateContentResponse, error) {
+		resp, err := model.GenerateContent(ctx, genai.Text(moderationPrompt), genai.ImageData(strings.TrimPrefix(mime, "image/"), in.Image))
+		m.clients.report(client, err)
+		return resp, err
+	})
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return blockedSafetyRatings(blocked), CaptionUsage{}, nil
+	}
+	if err != nil {
+		return nil, CaptionUsage{}, fmt.Errorf("gemini moderate: %w", err)
+	}
+	var usage CaptionUsage
+	if resp.UsageMetadata != nil {
+		usage.InputTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		usage.OutputTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	var ratings []SafetyRating
+	if resp.PromptFeedback != nil {
+		ratings = append(ratings, geminiSafetyRatings(resp.PromptFeedback.SafetyRatings)...)
+	}
+	for _, c := range resp.Candidates {
+		ratings = append(ratings, geminiSafetyRatings(c.SafetyRatings)...)
+	}
+	return ratings, usage, nil
+}
+
+// FakeModerator is a deterministic Moderator for tests and offline runs.
+// With no Result set it applies the local rules.
+type FakeModerator struct {
+	Result *ModerationResult
+	Err    error
+
+	mu     sync.Mutex
+	inputs []ModerationInput
+}
+
+func (m *FakeModerator) Name() string { return ModerationProviderFake }
+
+func (m *FakeModerator) Close() error { return nil }
+
+func (m *FakeModerator) Moderate(ctx context.Context, in ModerationInput) (*ModerationResult, error) {
+	m.mu.Lock()
+	m.inputs = append(m.inputs, in)
+	m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if m.Result != nil {
+		result := *m.Result
+		return &result, nil
+	}
+	return applyModerationRules(in, ModerationProviderFake), nil
+}
+
+// Inputs returns the content screened so far, oldest first.
+func (m *FakeModerator) Inputs() []ModerationInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ModerationInput(nil), m.inputs...)
+}
+
+// moderateContent screens in with the configured Moderator, records any
+// provider tokens against usage's explorer and logs the outcome. Screening
+// never blocks delivery by failing: when the provider is unavailable the
+// local rules decide, and the content is at least flagged.
+func moderateContent(ctx context.Context, client *firestore.Client, usage AIUsage, subject string, in ModerationInput) *ModerationResult {
+	source := usage.Source
+	var result *ModerationResult
+	moderator, err := NewModerator(ctx, ModeratorConfigFromEnv())
+	if err == nil {
+		defer moderator.Close()
+		start := time.Now()
+		result, err = moderator.Moderate(ctx, in)
+		if err == nil && (result.Usage.InputTokens > 0 || result.Usage.OutputTokens > 0) {
+			usage.Kind = AIUsageKindModeration
+			usage.Model = moderator.Name()
+			usage.InputTokens = result.Usage.InputTokens
+			usage.OutputTokens = result.Usage.OutputTokens
+			usage.LatencyMs = time.Since(start).Milliseconds()
+			RecordAIUsage(ctx, client, usage)
+		}
+	}
+	if err != nil {
+		result = applyModerationRules(in, ModerationProviderKeyword)
+		result.raise(ModerationFlag, "moderation provider unavailable")
+		fmt.Printf("moderateContent: %s %s: %v; used local rules\n", source, subject, err)
+	}
+	logModeration(source, subject, result)
+	return result
+}
+
+func logModeration(source, subject string, result *ModerationResult) {
+	severity := "INFO"
+	switch result.Verdict {
+	case ModerationFlag:
+		severity = "WARNING"
+	case ModerationQuarantine:
+		severity = "ERROR"
+	}
+	data, err := json.Marshal(map[string]any{
+		"severity":  severity,
+		"source":    moderationLogSource,
+		"message":   "content screened",
+		"caller":    source,
+		"subject":   subject,
+		"verdict":   result.Verdict,
+		"reasons":   result.Reasons,
+		"moderator": result.Moderator,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", data)
+}
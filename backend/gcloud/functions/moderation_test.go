@@ -0,0 +1,93 @@
+package functions
+
+import (
+	"context"
+	"testing"
+)
+
+func TestApplyModerationRules(t *testing.T) {
+	tests := []struct {
+		name string
+		in   ModerationInput
+		want string
+	}{
+		{"plain caption", ModerationInput{Texts: []string{"Grandma Sue at the lake house"}}, ModerationAllow},
+		{"flag keyword", ModerationInput{Texts: []string{"Rex after his surgery"}}, ModerationFlag},
+		{"quarantine keyword", ModerationInput{Texts: []string{"a nude statue"}}, ModerationQuarantine},
+		{"keywords match whole words only", ModerationInput{Texts: []string{"Sussex coast", "shellfish"}}, ModerationAllow},
+		{"worst verdict wins", ModerationInput{Texts: []string{"wine", "porn"}}, ModerationQuarantine},
+		{"caption flag", ModerationInput{SafetyFlags: []string{"injury"}}, ModerationFlag},
+		{"explicit caption flag", ModerationInput{SafetyFlags: []string{"explicit"}}, ModerationQuarantine},
+		{"medium rating", ModerationInput{Ratings: []SafetyRating{{Category: "dangerous", Probability: "medium"}}}, ModerationFlag},
+		{"blocked rating", ModerationInput{Ratings: []SafetyRating{{Category: "sexual", Probability: "low", Blocked: true}}}, ModerationQuarantine},
+	}
+	for _, tt := range tests {
+		result := applyModerationRules(tt.in, ModerationProviderKeyword)
+		if result.Verdict != tt.want {
+			t.Errorf("%s: verdict %q (%v), want %q", tt.name, result.Verdict, result.Reasons, tt.want)
+		}
+		if (result.Verdict == ModerationAllow) != (len(result.Reasons) == 0) {
+			t.Errorf("%s: verdict %q with reasons %v", tt.name, result.Verdict, result.Reasons)
+		}
+	}
+}
+
+func TestModeratorConfigFromEnv(t *testing.T) {
+	t.Setenv("MODERATION_PROVIDER", "")
+	t.Setenv("MODERATION_MODEL", "")
+	t.Setenv("GEMINI_API_KEY", "")
+	if cfg := ModeratorConfigFromEnv(); cfg.Provider != ModerationProviderKeyword {
+		t.Errorf("provider without a key = %q, want %q", cfg.Provider, ModerationProviderKeyword)
+	}
+	t.Setenv("GEMINI_API_KEY", "key")
+	if cfg := ModeratorConfigFromEnv(); cfg.Provider != ModerationProviderGemini {
+		t.Errorf("provider with a key = %q, want %q", cfg.Provider, ModerationProviderGemini)
+	}
+	t.Setenv("MODERATION_PROVIDER", " Fake ")
+	if cfg := ModeratorConfigFromEnv(); cfg.Provider != ModerationProviderFake {
+		t.Errorf("explicit provider = %q, want %q", cfg.Provider, ModerationProviderFake)
+	}
+}
+
+func TestModerateContentWithoutProvider(t *testing.T) {
+	t.Setenv("MODERATION_PROVIDER", "unknown")
+	usage := AIUsage{ExplorerID: "explorer-1", Source: "test"}
+
+	result := moderateContent(context.Background(), nil, usage, "subject", ModerationInput{Texts: []string{"Lunch at the park"}})
+	if result.Verdict != ModerationFlag || result.Moderator != ModerationProviderKeyword {
+		t.Errorf("unavailable provider: verdict %q from %q, want %q from the local rules", result.Verdict, result.Moderator, ModerationFlag)
+	}
+
+	result = moderateContent(context.Background(), nil, usage, "subject", ModerationInput{Texts: []string{"porn"}})
+	if !result.Quarantined() {
+		t.Errorf("unavailable provider let quarantined text through: %q", result.Verdict)
+	}
+}
+
+func TestScreenedReflectionStatus(t *testing.T) {
+	allow := &ModerationResult{Verdict: ModerationAllow}
+	flag := &ModerationResult{Verdict: ModerationFlag}
+	quarantine := &ModerationResult{Verdict: ModerationQuarantine}
+	tests := []struct {
+		current string
+		result  *ModerationResult
+		held    bool
+		want    string
+	}{
+		{ReflectionStatusScreening, allow, false, ReflectionStatusReady},
+		{ReflectionStatusScreening, flag, false, ReflectionStatusReady},
+		{ReflectionStatusScreening, quarantine, false, ReflectionStatusQuarantined},
+		{ReflectionStatusReady, allow, false, ""},
+		{"", allow, false, ""},
+		{ReflectionStatusReady, quarantine, false, ReflectionStatusQuarantined},
+		{ReflectionStatusPendingReview, allow, true, ""},
+		{ReflectionStatusPendingReview, quarantine, true, ReflectionStatusQuarantined},
+		{ReflectionStatusPendingReview, allow, false, ReflectionStatusReady},
+		{ReflectionStatusScreening, allow, true, ReflectionStatusPendingReview},
+	}
+	for _, tt := range tests {
+		if got := screenedReflectionStatus(tt.current, tt.result, tt.held); got != tt.want {
+			t.Errorf("screenedReflectionStatus(%q, %s, held %v) = %q, want %q", tt.current, tt.result.Verdict, tt.held, got, tt.want)
+		}
+	}
+}
@@ -60,7 +60,13 @@ func firestoreProjectID() (string, error) {
 	return projectID, nil
 }
 
+// firestoreClient returns the instance's shared Firestore client (see
+// clients.go); callers must not Close it.
 func firestoreClient(ctx context.Context) (*firestore.Client, error) {
+	return firestoreClients.get(ctx)
+}
+
+func openFirestoreClient(ctx context.Context) (*firestore.Client, error) {
 	projectID, err := firestoreProjectID()
 	if err != nil {
 		return nil, err
@@ -333,6 +339,8 @@ func updateRelationshipLastReflectionSent(ctx context.Context, client *firestore
 }
 
 // OnReflectionCreated stages a notification when a Companion creates a Reflection or Reaction.
+// Content that moderation quarantines, or that arrives while the Explorer requires review,
+// is held instead and the circle's reviewers are notified (see review.go).
 func OnReflectionCreated(ctx context.Context, e event.Event) error {
 	data, err := decodeDocumentEvent(e)
 	if err != nil {
@@ -354,51 +362,95 @@ func OnReflectionCreated(ctx context.Context, e event.Event) error {
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
-	sender := senderID(doc)
-	if isReactionDocument(doc) {
-		if isNarrationDocument(doc) {
-			fmt.Printf("OnReflectionCreated: skipping narration child %s; parent Reflection notification handles this content\n", id)
-			return nil
-		}
-		parentID := parentReflectionID(doc)
-		if parentID == "" {
-			fmt.Printf("OnReflectionCreated: skipping reaction %s; missing parentReflectionId\n", id)
-			return nil
+	arrival := reflectionArrival{
+		ExplorerID:         explorerID,
+		ReflectionID:       id,
+		SenderID:           senderID(doc),
+		SenderName:         senderName(doc),
+		IsReaction:         isReactionDocument(doc),
+		ParentReflectionID: parentReflectionID(doc),
+	}
+	// Everything, narration children included, is created withheld and only
+	// released here. Narration plays on its parent, so it never waits for
+	// review itself.
+	narration := arrival.IsReaction && isNarrationDocument(doc)
+	held := false
+	if !narration {
+		if held, err = requiresReview(ctx, client, arrival); err != nil {
+			return err
 		}
+	}
+	// Screen before announcing anything; quarantined content waits for a caregiver.
+	moderation, err := screenReflection(ctx, client, doc, explorerID, id, held)
+	if err != nil {
+		return err
+	}
+	if narration {
+		fmt.Printf("OnReflectionCreated: skipping narration child %s; parent Reflection notification handles this content\n", id)
+		return nil
+	}
+	if moderation.Quarantined() {
+		fmt.Printf("OnReflectionCreated: quarantined %s (%s); asking reviewers instead of notifying\n", id, strings.Join(moderation.Reasons, "; "))
+		return requestReflectionReview(ctx, client, arrival)
+	}
+	if arrival.IsReaction && arrival.ParentReflectionID == "" {
+		fmt.Printf("OnReflectionCreated: skipping reaction %s; missing parentReflectionId\n", id)
+		return nil
+	}
+	if held {
+		fmt.Printf("OnReflectionCreated: holding %s for review\n", id)
+		return requestReflectionReview(ctx, client, arrival)
+	}
+	return announceReflection(ctx, client, arrival)
+}
+
+// reflectionArrival is what announcing a new Reflection or Reaction needs.
+type reflectionArrival struct {
+	ExplorerID         string
+	ReflectionID       string
+	SenderID           string
+	SenderName         string
+	IsReaction         bool
+	ParentReflectionID string
+}
 
+// announceReflection stages the companion_upload or companion_reaction
+// notification for content the Explorer can now see. Notification IDs are
+// derived from the Reflection, so announcing twice is harmless.
+func announceReflection(ctx context.Context, client *firestore.Client, a reflectionArrival) error {
+	if a.IsReaction {
 		notification := pendingNotification{
-			ExplorerID:                 explorerID,
+			ExplorerID:                 a.ExplorerID,
 			BroadcastToAllCompanions:   true,
 			RecipientIDs:               []string{},
 			TriggerType:                triggerCompanionReaction,
-			ReflectionID:               id,
-			ParentReflectionID:         parentID,
-			ParentReflectionAuthorName: parentReflectionAuthorName(ctx, client, explorerID, parentID),
-			SenderID:                   sender,
-			SenderName:                 senderName(doc),
+			ReflectionID:               a.ReflectionID,
+			ParentReflectionID:         a.ParentReflectionID,
+			ParentReflectionAuthorName: parentReflectionAuthorName(ctx, client, a.ExplorerID, a.ParentReflectionID),
+			SenderID:                   a.SenderID,
+			SenderName:                 a.SenderName,
 			Status:                     pendingStatus,
 			CreatedAt:                  firestore.ServerTimestamp,
 		}
-		return createPendingNotification(ctx, client, fmt.Sprintf("%s_%s", triggerCompanionReaction, id), notification)
+		return createPendingNotification(ctx, client, fmt.Sprintf("%s_%s", triggerCompanionReaction, a.ReflectionID), notification)
 	}
 
 	notification := pendingNotification{
-		ExplorerID:               explorerID,
+		ExplorerID:               a.ExplorerID,
 		BroadcastToAllCompanions: true,
 		RecipientIDs:             []string{},
 		TriggerType:              triggerCompanionUpload,
-		ReflectionID:             id,
-		SenderID:                 sender,
-		SenderName:               senderName(doc),
+		ReflectionID:             a.ReflectionID,
+		SenderID:                 a.SenderID,
+		SenderName:               a.SenderName,
 		Status:                   pendingStatus,
 		CreatedAt:                firestore.ServerTimestamp,
 	}
-	if err := createPendingNotification(ctx, client, fmt.Sprintf("%s_%s", triggerCompanionUpload, id), notification); err != nil {
+	if err := createPendingNotification(ctx, client, fmt.Sprintf("%s_%s", triggerCompanionUpload, a.ReflectionID), notification); err != nil {
 		return err
 	}
-	return updateRelationshipLastReflectionSent(ctx, client, explorerID, sender)
+	return updateRelationshipLastReflectionSent(ctx, client, a.ExplorerID, a.SenderID)
 }
 
 // OnReflectionUpdated stages fast-lane notifications when the Explorer or a Companion newly likes a Reflection.
@@ -424,7 +476,6 @@ func OnReflectionUpdated(ctx context.Context, e event.Event) error {
 	if err != nil {
 		return err
 	}
-	defer client.Close()
 
 	beforeLikedBy := stringArrayField(before, "likedBy")
 	afterLikedBy := stringArrayField(after, "likedBy")
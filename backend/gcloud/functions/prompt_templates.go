@@ -0,0 +1,544 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	promptTemplatesCollection = "prompt_templates"
+	promptVersionsSubcoll     = "versions"
+
+	// defaultCaptionTemplate is the registry entry used when an explorer has
+	// not selected one.
+	defaultCaptionTemplate = "caption"
+	// clientPromptSelection makes an explorer use the prompt the app sends,
+	// the behaviour before the registry existed.
+	clientPromptSelection = "client"
+	clientPromptVersion   = "client"
+
+	builtinCaptionPromptVersion = "caption@builtin-1"
+)
+
+// PromptVars are the variables available to caption prompt templates.
+type PromptVars struct {
+	ExplorerName    string
+	Age             int    // 0 when unknown
+	Condition       string // e.g. "Angelman Syndrome"; empty when unknown
+	SenderName      string // empty when the sender is not named
+	SenderInImage   bool
+	ExplorerInImage bool
+	PeopleContext   string
+	// Glossary holds the explorer's relevant glossary entries; resolveCaptionPrompt
+	// appends them to every template.
+	Glossary []GlossaryEntry
+	// Language and SecondLanguage are caption languages the request asked
+	// for and CompanionLanguage the sender's preference, all BCP-47 and
+	// optional; resolveCaptionPrompt settles them with the explorer's
+	// settings into RenderedPrompt.Languages.
+	Language          string
+	SecondLanguage    string
+	CompanionLanguage string
+}
+
+// PromptTemplate is one immutable version in the registry, stored at
+// prompt_templates/{name}/versions/{version}.
+type PromptTemplate struct {
+	Name      string    `firestore:"name" json:"name"`
+	Version   int       `firestore:"version" json:"version"`
+	Body      string    `firestore:"body" json:"body"`
+	Notes     string    `firestore:"notes,omitempty" json:"notes,omitempty"`
+	CreatedBy string    `firestore:"createdBy" json:"created_by"`
+	CreatedAt time.Time `firestore:"createdAt,serverTimestamp" json:"created_at"`
+}
+
+// Label is the version string recorded on captions, e.g. "caption@v3".
+func (t *PromptTemplate) Label() string {
+	return fmt.Sprintf("%s@v%d", t.Name, t.Version)
+}
+
+// builtinCaptionPrompt mirrors the prompt the apps built before the registry
+// existed. It is used when Firestore has no published caption template or
+// the selected one cannot be loaded, so captioning never depends on it.
+const builtinCaptionPrompt = `Analyze this image for {{.ExplorerName}}{{if .Age}}, a {{.Age}}-year-old{{end}}{{if .Condition}} with {{.Condition}}{{end}}.
+
+CONTEXT:
+{{if and .SenderName .SenderInImage -}}
+- {{.SenderName}} is the sender and has confirmed they appear in the image.
+{{else if .SenderName -}}
+- {{.SenderName}} is the sender of this Reflection.
+{{else -}}
+- A family member or caregiver is the sender of this Reflection.
+{{end -}}
+{{if .ExplorerInImage -}}
+- {{.ExplorerName}} has been confirmed to be in this image.
+{{else -}}
+- {{.ExplorerName}} is the AUDIENCE — they are NOT in this image.
+{{end -}}
+{{with .PeopleContext -}}
+- The sender provided additional context: {{.}}. Each comma-separated entry describes a person, pet, or setting.
+RULES for these entries:
+  - Entries starting with "at" describe a location (e.g. "at Nona's house" means this was taken at a place called Nona's house). Weave location naturally into the description.
+  - Age/species words (baby, toddler, dog, cat, puppy, kitten) before a name are descriptors regardless of capitalization — they are NOT part of the name and must NEVER appear in your output. "baby Dante" means Dante is a baby — call him "Dante". "dog Dalton" means Dalton is a dog — call him "Dalton".
+  - Relationship words and nicknames (Grandma, Nona, Uncle, Aunt, Papa) ARE how the person is known. "Grandma Marion" stays "Grandma Marion".
+  - Humans are the primary subjects. Pets/animals and locations are mentioned naturally but are secondary.
+{{end}}
+{{if .ExplorerInImage -}}
+IDENTITY RULES:
+1. {{.ExplorerName}} IS confirmed to be in this image. You may use their name when describing them.
+2. If other people are also visible, use their provided names if given above. Otherwise describe them by visible traits.
+3. DO NOT diagnose or guess medical conditions from anyone's appearance.
+{{- else -}}
+CRITICAL IDENTITY RULES:
+1. NEVER identify any person in the image as {{.ExplorerName}}. They are the viewer, not a subject.
+2. If the sender identified people by name above, use those names. Otherwise describe people by visible traits (e.g. "a baby", "a woman").
+3. DO NOT diagnose or guess medical conditions from anyone's appearance.
+{{- end}}
+
+CONTENT RULES:
+4. The short_caption is a warm, high-energy greeting TO {{.ExplorerName}} about what is in the image (max 10 words).
+5. The deep_dive is a 2-3 sentence story about interesting details, written as if speaking TO {{.ExplorerName}}.
+6. {{if and .SenderName .SenderInImage}}Since {{.SenderName}} is both the sender and visible, you may refer to them by name. Write as if {{.SenderName}} is sharing a moment they are part of.{{else if .SenderName}}Briefly work {{.SenderName}}'s name into the wording so it feels like the Reflection comes from them. Do not say {{.SenderName}} is in the image unless confirmed above.{{else}}You may briefly refer to the sender as a family member or caregiver.{{end}}
+7. detected_people lists the names from the context for people you can see; otherwise short visible descriptions.
+8. safety_flags lists any concerns about the image, or is empty.
+
+Return a SINGLE JSON object:
+{"short_caption": "string", "deep_dive": "string", "detected_people": ["string"], "safety_flags": ["string"]}`
+
+var builtinCaptionTemplate = template.Must(parsePromptTemplate("builtin", builtinCaptionPrompt))
+
+func parsePromptTemplate(name, body string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(body)
+}
+
+func renderPromptTemplate(tmpl *template.Template, vars PromptVars) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// explorerPromptProfile is what the registry needs from explorers/{id}.
+type explorerPromptProfile struct {
+	Name      string
+	Age       int
+	Condition string
+	// Template is settings.prompt_template: "" (default template, active
+	// version), "name" (active version of another template), "name@vN"
+	// (pinned version) or "client".
+	Template string
+	// OutputProfile is settings.caption_profile and CoreVocabulary
+	// settings.core_vocabulary (see caption_profile.go).
+	OutputProfile  string
+	CoreVocabulary []string
+	// Language and SecondLanguage are settings.caption_language and
+	// settings.second_language (see language.go).
+	Language       string
+	SecondLanguage string
+}
+
+func loadExplorerPromptProfile(ctx context.Context, client *firestore.Client, explorerID string) (explorerPromptProfile, error) {
+	var profile explorerPromptProfile
+	snap, err := client.Collection("explorers").Doc(explorerID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return profile, nil
+	}
+	if err != nil {
+		return profile, fmt.Errorf("load explorer %s: %w", explorerID, err)
+	}
+	data := snap.Data()
+	// "name" is the circle's system name ("Sam's Reflection"), not the person's.
+	for _, key := range []string{"legalName", "displayName", "display_name"} {
+		if v, _ := data[key].(string); strings.TrimSpace(v) != "" {
+			profile.Name = strings.TrimSpace(v)
+			break
+		}
+	}
+	settings, _ := data["settings"].(map[string]any)
+	switch age := settings["age"].(type) {
+	case int64:
+		profile.Age = int(age)
+	case float64:
+		profile.Age = int(age)
+	}
+	profile.Condition, _ = settings["condition"].(string)
+	profile.Template, _ = settings["prompt_template"].(string)
+	profile.OutputProfile, _ = settings["caption_profile"].(string)
+	if words, ok := settings["core_vocabulary"].([]any); ok {
+		for _, w := range words {
+			if word, ok := w.(string); ok {
+				profile.CoreVocabulary = append(profile.CoreVocabulary, word)
+			}
+		}
+	}
+	profile.Language, _ = settings["caption_language"].(string)
+	profile.SecondLanguage, _ = settings["second_language"].(string)
+	profile.Condition = strings.TrimSpace(profile.Condition)
+	profile.Template = strings.TrimSpace(profile.Template)
+	profile.OutputProfile = strings.TrimSpace(profile.OutputProfile)
+	return profile, nil
+}
+
+// parseTemplateSelection splits "name@vN" into its parts; version 0 means
+// the template's active version.
+func parseTemplateSelection(selection string) (string, int, error) {
+	name, rawVersion, pinned := strings.Cut(selection, "@")
+	if name == "" {
+		name = defaultCaptionTemplate
+	}
+	if !pinned {
+		return name, 0, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(rawVersion, "v"))
+	if err != nil || version <= 0 {
+		return "", 0, fmt.Errorf("invalid prompt template selection %q", selection)
+	}
+	return name, version, nil
+}
+
+// loadPromptTemplate returns the requested version of name, or its active
+// version when version is 0. A nil template with nil error means nothing has
+// been published yet.
+func loadPromptTemplate(ctx context.Context, client *firestore.Client, name string, version int) (*PromptTemplate, error) {
+	ref := client.Collection(promptTemplatesCollection).Doc(name)
+	if version == 0 {
+		snap, err := ref.Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load prompt template %s: %w", name, err)
+		}
+		active, err := snap.DataAt("activeVersion")
+		if err != nil {
+			return nil, nil
+		}
+		v, ok := active.(int64)
+		if !ok || v <= 0 {
+			return nil, nil
+		}
+		version = int(v)
+	}
+
+	snap, err := ref.Collection(promptVersionsSubcoll).Doc(strconv.Itoa(version)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("prompt template %s@v%d not found", name, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load prompt template %s@v%d: %w", name, version, err)
+	}
+	var tmpl PromptTemplate
+	if err := snap.DataTo(&tmpl); err != nil {
+		return nil, fmt.Errorf("decode prompt template %s@v%d: %w", name, version, err)
+	}
+	return &tmpl, nil
+}
+
+// RenderedPrompt is a prompt ready to send together with the version label
+// recorded on the resulting caption.
+type RenderedPrompt struct {
+	Text    string
+	Version string
+	// Output is the explorer's caption output profile; its rules are
+	// already part of Text.
+	Output CaptionOutputProfile
+	// Languages are the caption languages; Text already asks for Primary.
+	Languages CaptionLanguages
+}
+
+// resolveCaptionPrompt renders the caption prompt selected for explorerID.
+// clientPrompt is used only when the explorer selects "client". Any registry
+// problem, including a nil client, falls back to the built-in template rather
+// than failing the caption. The caption language and the explorer's output
+// profile are applied to whichever prompt is chosen.
+func resolveCaptionPrompt(ctx context.Context, client *firestore.Client, explorerID string, vars PromptVars, clientPrompt string) RenderedPrompt {
+	var profile explorerPromptProfile
+	if client != nil {
+		var err error
+		if profile, err = loadExplorerPromptProfile(ctx, client, explorerID); err != nil {
+			fmt.Printf("resolveCaptionPrompt: %v; using defaults\n", err)
+		}
+	}
+	if profile.Name != "" {
+		vars.ExplorerName = profile.Name
+	}
+	vars.Age = profile.Age
+	vars.Condition = profile.Condition
+
+	rendered := selectCaptionPrompt(ctx, client, profile, vars, clientPrompt)
+	rendered.Text = glossaryPrompt(rendered.Text, vars.Glossary)
+	rendered.Languages = resolveCaptionLanguages(vars.Language, vars.SecondLanguage, profile, vars.CompanionLanguage)
+	rendered.Text = languagePrompt(rendered.Text, rendered.Languages.Primary)
+	outputProfile := profile.OutputProfile
+	if outputProfile == CaptionProfileCoreVocabulary && len(profile.CoreVocabulary) == 0 && !sameBaseLanguage(rendered.Languages.Primary, DefaultGoogleTTSLanguageCode) {
+		// The built-in core word list is English.
+		outputProfile = CaptionProfileVerySimple
+	}
+	rendered.Output = captionOutputProfileFor(outputProfile, profile.CoreVocabulary, vars)
+	rendered.Text = rendered.Output.apply(rendered.Text)
+	return rendered
+}
+
+func selectCaptionPrompt(ctx context.Context, client *firestore.Client, profile explorerPromptProfile, vars PromptVars, clientPrompt string) RenderedPrompt {
+	if profile.Template == clientPromptSelection && clientPrompt != "" {
+		return RenderedPrompt{Text: clientPrompt, Version: clientPromptVersion}
+	}
+
+	if client != nil {
+		if rendered, ok := renderRegistryPrompt(ctx, client, profile.Template, vars); ok {
+			return rendered
+		}
+	}
+	text, err := renderPromptTemplate(builtinCaptionTemplate, vars)
+	if err != nil {
+		// The built-in template only reads PromptVars fields, so this is a
+		// programming error; the client prompt is the last resort.
+		fmt.Printf("resolveCaptionPrompt: built-in template failed: %v\n", err)
+		return RenderedPrompt{Text: clientPrompt, Version: clientPromptVersion}
+	}
+	return RenderedPrompt{Text: text, Version: builtinCaptionPromptVersion}
+}
+
+func renderRegistryPrompt(ctx context.Context, client *firestore.Client, selection string, vars PromptVars) (RenderedPrompt, bool) {
+	if selection == clientPromptSelection {
+		selection = ""
+	}
+	name, version, err := parseTemplateSelection(selection)
+	if err != nil {
+		fmt.Printf("resolveCaptionPrompt: %v\n", err)
+		return RenderedPrompt{}, false
+	}
+	stored, err := loadPromptTemplate(ctx, client, name, version)
+	if err != nil {
+		fmt.Printf("resolveCaptionPrompt: %v\n", err)
+		return RenderedPrompt{}, false
+	}
+	if stored == nil {
+		return RenderedPrompt{}, false
+	}
+	tmpl, err := parsePromptTemplate(stored.Label(), stored.Body)
+	if err != nil {
+		fmt.Printf("resolveCaptionPrompt: parse %s: %v\n", stored.Label(), err)
+		return RenderedPrompt{}, false
+	}
+	text, err := renderPromptTemplate(tmpl, vars)
+	if err != nil {
+		fmt.Printf("resolveCaptionPrompt: render %s: %v\n", stored.Label(), err)
+		return RenderedPrompt{}, false
+	}
+	return RenderedPrompt{Text: text, Version: stored.Label()}, true
+}
+
+// samplePromptVars exercises every branch a template might take when a new
+// version is checked before publishing.
+var samplePromptVars = []PromptVars{
+	{ExplorerName: "Sam"},
+	{ExplorerName: "Sam", Age: 15, Condition: "Angelman Syndrome", SenderName: "Grandma", SenderInImage: true, ExplorerInImage: true, PeopleContext: "baby Dante, at Nona's house"},
+}
+
+// publishPromptTemplate stores body as the next version of name and, when
+// activate is set, makes it the active version.
+func publishPromptTemplate(ctx context.Context, client *firestore.Client, name, body, notes, createdBy string, activate bool) (*PromptTemplate, error) {
+	tmpl, err := parsePromptTemplate(name, body)
+	if err != nil {
+		return nil, fmt.Errorf("template does not parse: %w", err)
+	}
+	for _, vars := range samplePromptVars {
+		if _, err := renderPromptTemplate(tmpl, vars); err != nil {
+			return nil, fmt.Errorf("template does not render: %w", err)
+		}
+	}
+
+	ref := client.Collection(promptTemplatesCollection).Doc(name)
+	stored := &PromptTemplate{Name: name, Body: body, Notes: notes, CreatedBy: createdBy}
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		latest := 0
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if v, ok := snap.Data()["latestVersion"].(int64); ok {
+				latest = int(v)
+			}
+		}
+		stored.Version = latest + 1
+		update := map[string]any{"latestVersion": stored.Version, "updatedAt": firestore.ServerTimestamp}
+		if activate {
+			update["activeVersion"] = stored.Version
+		}
+		if err := tx.Set(ref, update, firestore.MergeAll); err != nil {
+			return err
+		}
+		return tx.Create(ref.Collection(promptVersionsSubcoll).Doc(strconv.Itoa(stored.Version)), stored)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("publish prompt template %s: %w", name, err)
+	}
+	return stored, nil
+}
+
+func activatePromptTemplate(ctx context.Context, client *firestore.Client, name string, version int) error {
+	ref := client.Collection(promptTemplatesCollection).Doc(name)
+	if _, err := ref.Collection(promptVersionsSubcoll).Doc(strconv.Itoa(version)).Get(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("prompt template %s@v%d not found", name, version)
+		}
+		return err
+	}
+	_, err := ref.Set(ctx, map[string]any{"activeVersion": version, "updatedAt": firestore.ServerTimestamp}, firestore.MergeAll)
+	return err
+}
+
+// ManagePromptTemplates is the admin HTTP endpoint for the caption prompt
+// registry. GET ?name= lists a template's versions and its active version;
+// POST {"action": "publish", "name", "body", "notes", "activate"} adds a
+// version and POST {"action": "activate", "name", "version"} switches to one.
+// Explorers opt into a template with settings.prompt_template.
+func ManagePromptTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if !isAuditAdmin(token) {
+		http.Error(w, "admin access required", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		name := strings.TrimSpace(r.URL.Query().Get("name"))
+		if name == "" {
+			name = defaultCaptionTemplate
+		}
+		listPromptTemplateVersions(w, r, client, name)
+
+	case http.MethodPost:
+		var body struct {
+			Action   string `json:"action"`
+			Name     string `json:"name"`
+			Body     string `json:"body"`
+			Notes    string `json:"notes"`
+			Activate bool   `json:"activate"`
+			Version  int    `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" || strings.ContainsAny(body.Name, "@/") || body.Name == clientPromptSelection {
+			http.Error(w, "name is required and may not contain @ or /", http.StatusBadRequest)
+			return
+		}
+
+		rec := AuditRecord{
+			Actor:      token.UID,
+			Action:     "prompt_template." + body.Action,
+			Targets:    []string{promptTemplatesCollection + "/" + body.Name},
+			RequestID:  auditRequestID(r),
+			BeforeHash: docBeforeHash(ctx, client.Collection(promptTemplatesCollection).Doc(body.Name)),
+		}
+
+		switch body.Action {
+		case "publish":
+			stored, err := publishPromptTemplate(ctx, client, body.Name, body.Body, strings.TrimSpace(body.Notes), token.UID, body.Activate)
+			rec.Outcome, rec.Error = auditOutcome(err)
+			if stored != nil {
+				rec.Details = map[string]any{"version": stored.Version, "activate": body.Activate}
+			}
+			writeAuditRecord(ctx, client, rec)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Printf("ManagePromptTemplates: %s published %s (activate=%v)\n", token.UID, stored.Label(), body.Activate)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stored)
+
+		case "activate":
+			err := activatePromptTemplate(ctx, client, body.Name, body.Version)
+			rec.Outcome, rec.Error = auditOutcome(err)
+			rec.Details = map[string]any{"version": body.Version}
+			writeAuditRecord(ctx, client, rec)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Printf("ManagePromptTemplates: %s activated %s@v%d\n", token.UID, body.Name, body.Version)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"name": body.Name, "active_version": body.Version})
+
+		default:
+			http.Error(w, "action must be publish or activate", http.StatusBadRequest)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listPromptTemplateVersions(w http.ResponseWriter, r *http.Request, client *firestore.Client, name string) {
+	ctx := r.Context()
+	ref := client.Collection(promptTemplatesCollection).Doc(name)
+	active := 0
+	if snap, err := ref.Get(ctx); err == nil {
+		if v, ok := snap.Data()["activeVersion"].(int64); ok {
+			active = int(v)
+		}
+	}
+
+	versions := []PromptTemplate{}
+	iter := ref.Collection(promptVersionsSubcoll).OrderBy("version", firestore.Desc).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "list versions failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var tmpl PromptTemplate
+		if err := doc.DataTo(&tmpl); err != nil {
+			fmt.Printf("ManagePromptTemplates: skipping undecodable version %s: %v\n", doc.Ref.Path, err)
+			continue
+		}
+		versions = append(versions, tmpl)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"name":           name,
+		"active_version": active,
+		"builtin":        map[string]string{"version": builtinCaptionPromptVersion, "body": builtinCaptionPrompt},
+		"versions":       versions,
+	})
+}
@@ -0,0 +1,92 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplateSelection(t *testing.T) {
+	tests := []struct {
+		selection   string
+		wantName    string
+		wantVersion int
+		wantErr     bool
+	}{
+		{selection: "", wantName: defaultCaptionTemplate},
+		{selection: "caption", wantName: "caption"},
+		{selection: "gentle@v3", wantName: "gentle", wantVersion: 3},
+		{selection: "gentle@4", wantName: "gentle", wantVersion: 4},
+		{selection: "@v2", wantName: defaultCaptionTemplate, wantVersion: 2},
+		{selection: "gentle@v0", wantErr: true},
+		{selection: "gentle@latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		name, version, err := parseTemplateSelection(tt.selection)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTemplateSelection(%q) succeeded, want an error", tt.selection)
+			}
+			continue
+		}
+		if err != nil || name != tt.wantName || version != tt.wantVersion {
+			t.Errorf("parseTemplateSelection(%q) = %q, %d, %v; want %q, %d", tt.selection, name, version, err, tt.wantName, tt.wantVersion)
+		}
+	}
+}
+
+func TestBuiltinCaptionTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		vars     PromptVars
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "minimal",
+			vars:     PromptVars{ExplorerName: "Sam"},
+			contains: []string{"Analyze this image for Sam.", "A family member or caregiver is the sender", "Sam is the AUDIENCE", "NEVER identify any person in the image as Sam"},
+			excludes: []string{"-year-old", "additional context"},
+		},
+		{
+			name: "everything known",
+			vars: PromptVars{ExplorerName: "Sam", Age: 15, Condition: "Angelman Syndrome", SenderName: "Grandma", SenderInImage: true, ExplorerInImage: true, PeopleContext: "baby Dante"},
+			contains: []string{
+				"Analyze this image for Sam, a 15-year-old with Angelman Syndrome.",
+				"Grandma is the sender and has confirmed they appear in the image.",
+				"Sam has been confirmed to be in this image.",
+				"additional context: baby Dante.",
+				"Since Grandma is both the sender and visible",
+			},
+			excludes: []string{"AUDIENCE"},
+		},
+	}
+	for _, tt := range tests {
+		text, err := renderPromptTemplate(builtinCaptionTemplate, tt.vars)
+		if err != nil {
+			t.Fatalf("%s: render: %v", tt.name, err)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s: prompt is missing %q", tt.name, want)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(text, unwanted) {
+				t.Errorf("%s: prompt unexpectedly contains %q", tt.name, unwanted)
+			}
+		}
+	}
+}
+
+func TestPromptTemplateRejectsUnknownFields(t *testing.T) {
+	tmpl, err := parsePromptTemplate("typo", "Hello {{.ExplorerNmae}}")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := renderPromptTemplate(tmpl, samplePromptVars[0]); err == nil {
+		t.Error("a template using an unknown field rendered without error")
+	}
+	if label := (&PromptTemplate{Name: "caption", Version: 3}).Label(); label != "caption@v3" {
+		t.Errorf("Label() = %q, want caption@v3", label)
+	}
+}
@@ -0,0 +1,98 @@
+package functions
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
+)
+
+const (
+	// ReflectionStatusScreening is the status apps create Reflections with
+	// (firestore.rules enforce it). The Explorer feed and ListMirrorEvents
+	// skip it until screening releases the Reflection as ready.
+	ReflectionStatusScreening = "screening"
+	// ReflectionStatusReady is a released Reflection the Explorer may see.
+	ReflectionStatusReady = "ready"
+	// ReflectionStatusQuarantined hides a Reflection from the Explorer until a
+	// caregiver reviews it. The Explorer feed and ListMirrorEvents skip it.
+	ReflectionStatusQuarantined = "quarantined"
+
+	reflectionModerationField = "moderation"
+)
+
+// withheldReflectionStatuses are the statuses the Explorer never sees.
+var withheldReflectionStatuses = []string{ReflectionStatusScreening, ReflectionStatusQuarantined, ReflectionStatusPendingReview, ReflectionStatusRejected}
+
+// reflectionModerationTexts are the metadata fields shown or spoken to the
+// Explorer.
+var reflectionModerationTexts = []string{"short_caption", "deep_dive", "description", "reaction_message"}
+
+// screenReflection moderates a new Reflection's texts and, for Reflections
+// with media, its image, then settles its status: quarantined ones are never
+// shown or announced, held ones wait for a reviewer as pending_review, and
+// everything else still withheld becomes ready. Flagged and quarantined
+// Reflections also get a moderation record.
+func screenReflection(ctx context.Context, client *firestore.Client, doc *firestoredata.Document, explorerID, id string, held bool) (*ModerationResult, error) {
+	var in ModerationInput
+	for _, field := range reflectionModerationTexts {
+		if text := metadataStringField(doc, field); text != "" {
+			in.Texts = append(in.Texts, text)
+		}
+	}
+	if !isReactionDocument(doc) {
+		if s3Client, err := awsS3Client(ctx); err != nil {
+			fmt.Printf("screenReflection: aws config for %s: %v; screening text only\n", id, err)
+		} else {
+			imageKey := fmt.Sprintf("%s/to/%s/image.jpg", explorerID, id)
+			if image, err := readS3Object(ctx, s3Client, imageKey, "image", maxCaptionImageBytes); err != nil {
+				fmt.Printf("screenReflection: %s unavailable: %v; screening text only\n", imageKey, err)
+			} else {
+				in.Image = image
+			}
+		}
+	}
+
+	usage := AIUsage{ExplorerID: explorerID, CompanionID: senderID(doc), Source: "on-reflection-created"}
+	result := moderateContent(ctx, client, usage, id, in)
+	var updates []firestore.Update
+	if result.Verdict != ModerationAllow {
+		updates = append(updates, firestore.Update{Path: reflectionModerationField, Value: map[string]any{
+			"verdict":   result.Verdict,
+			"reasons":   result.Reasons,
+			"moderator": result.Moderator,
+			"checkedAt": firestore.ServerTimestamp,
+		}})
+	}
+	if status := screenedReflectionStatus(stringField(doc, "status"), result, held); status != "" {
+		updates = append(updates, firestore.Update{Path: "status", Value: status})
+	}
+	if len(updates) == 0 {
+		return result, nil
+	}
+	if _, err := client.Collection(reflectionsCollection).Doc(documentID(doc)).Update(ctx, updates); err != nil {
+		return nil, fmt.Errorf("store moderation for %s: %w", id, err)
+	}
+	return result, nil
+}
+
+// screenedReflectionStatus is the status a Reflection gets once screened, or
+// "" to leave current alone (Reflections from older apps that were created
+// ready). Going from screening to pending_review keeps the Reflection
+// withheld, so a review setting turned on after the app read it still holds.
+func screenedReflectionStatus(current string, result *ModerationResult, held bool) string {
+	next := ""
+	switch {
+	case result.Quarantined():
+		next = ReflectionStatusQuarantined
+	case held:
+		next = ReflectionStatusPendingReview
+	case current == ReflectionStatusScreening || current == ReflectionStatusPendingReview:
+		next = ReflectionStatusReady
+	}
+	if next == current {
+		return ""
+	}
+	return next
+}
@@ -0,0 +1,241 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/cloudevents/sdk-go/v2/event"
+	firestoredata "github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
+)
+
+const (
+	// audioSourceCompanion marks metadata.audio_source for audio.m4a the
+	// Companion recorded themselves, as opposed to stored caption TTS.
+	audioSourceCompanion = "companion"
+
+	reflectionTranscriptField      = "transcript"
+	reflectionTranscriptTermsField = "transcriptTerms"
+	maxTranscriptTerms             = 100
+	transcriptionTimeout           = 3 * time.Minute
+
+	TranscriptStatusDone    = "done"
+	TranscriptStatusFailed  = "failed"
+	TranscriptStatusSkipped = "skipped"
+)
+
+// transcriptSourceEditedAt returns the metadata.last_edited_at the stored
+// transcript was made from, and whether a transcript exists at all.
+func transcriptSourceEditedAt(doc *firestoredata.Document) (string, bool) {
+	value, ok := doc.GetFields()[reflectionTranscriptField]
+	if !ok || value.GetMapValue() == nil {
+		return "", false
+	}
+	return value.GetMapValue().GetFields()["sourceEditedAt"].GetStringValue(), true
+}
+
+// transcriptTerms lists the distinct lowercased words of text (three letters
+// or more) so reflections can be found with array-contains queries.
+func transcriptTerms(text string) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if len([]rune(word)) < 3 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == maxTranscriptTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// transcribeAudio runs transcriber over audio and records the usage. It is
+// shared by the reflection trigger and GenerateAIDescription's voice notes.
+func transcribeAudio(ctx context.Context, client *firestore.Client, transcriber Transcriber, usage AIUsage, audio []byte) (*Transcript, error) {
+	start := time.Now()
+	transcript, err := transcriber.Transcribe(ctx, TranscriptionRequest{Audio: audio})
+	if err != nil {
+		return nil, err
+	}
+	usage.Kind = AIUsageKindTranscription
+	usage.Model = transcriber.Name()
+	usage.InputTokens = transcript.Usage.InputTokens
+	usage.OutputTokens = transcript.Usage.OutputTokens
+	usage.LatencyMs = time.Since(start).Milliseconds()
+	RecordAIUsage(ctx, client, usage)
+	return transcript, nil
+}
+
+// voiceNotePrompt adds what the Companion said in their voice note to a
+// caption prompt.
+func voiceNotePrompt(prompt, transcript string) string {
+	return prompt + fmt.Sprintf("\n\nThe sender also recorded a voice note saying: %q. Use it to understand what this moment means to them; do not quote it word for word.", truncateTranscript(transcript))
+}
+
+// loadVoiceNote reads a staged voice note for captioning. Keys follow the
+// same rules as caption images and must name an m4a file.
+func loadVoiceNote(ctx context.Context, s3Client *s3.Client, explorerID, key string) ([]byte, error) {
+	key, err := captionImageKeyFor(explorerID, key)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(key, ".m4a") {
+		return nil, rejectCaptionImage("voice note %q must be an m4a file", key)
+	}
+	return readS3Object(ctx, s3Client, key, "voice note", maxTranscriptionAudioBytes)
+}
+
+// voiceNoteFingerprint folds a voice note into the caption cache's media
+// identity, so the same picture with a different note is captioned afresh.
+func voiceNoteFingerprint(media, voiceNote []byte) []byte {
+	mediaSum := sha256.Sum256(media)
+	noteSum := sha256.Sum256(voiceNote)
+	return append(mediaSum[:], noteSum[:]...)
+}
+
+// transcribeVoiceNote transcribes with the configured Transcriber and
+// returns "" on any failure: a voice note is context, never a requirement.
+func transcribeVoiceNote(ctx context.Context, client *firestore.Client, usage AIUsage, audio []byte) string {
+	transcriber, err := NewTranscriber(ctx, TranscriberConfigFromEnv())
+	if err != nil {
+		log.Printf("Voice note transcription unavailable: %v", err)
+		return ""
+	}
+	defer transcriber.Close()
+	tctx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+	defer cancel()
+	transcript, err := transcribeAudio(tctx, client, transcriber, usage, audio)
+	if err != nil {
+		log.Printf("Voice note transcription with %s failed: %v", transcriber.Name(), err)
+		return ""
+	}
+	return transcript.Text
+}
+
+// TranscribeReflectionAudio transcribes the audio.m4a a Companion recorded
+// for a Reflection and stores the text on the Reflection under transcript,
+// with transcriptTerms for search. It runs on every write but only acts
+// when metadata.audio_source is "companion" and the stored transcript does
+// not match the current metadata.last_edited_at, which also stops it from
+// reacting to its own update. Failures are recorded on the transcript
+// rather than retried.
+func TranscribeReflectionAudio(ctx context.Context, e event.Event) error {
+	data, err := decodeDocumentEvent(e)
+	if err != nil {
+		return err
+	}
+	doc := data.GetValue()
+	if doc == nil || isReactionDocument(doc) {
+		return nil
+	}
+	if metadataStringField(doc, "audio_source") != audioSourceCompanion {
+		// The recording was replaced by TTS or removed: drop its transcript.
+		if _, ok := transcriptSourceEditedAt(doc); ok {
+			return clearReflectionTranscript(ctx, documentID(doc))
+		}
+		return nil
+	}
+	explorerID := stringField(doc, "explorerId")
+	id := reflectionID(doc)
+	if explorerID == "" || id == "" {
+		fmt.Printf("TranscribeReflectionAudio: skipping malformed reflection %s\n", documentID(doc))
+		return nil
+	}
+	sourceEditedAt := metadataStringField(doc, "last_edited_at")
+	if stored, ok := transcriptSourceEditedAt(doc); ok && stored == sourceEditedAt {
+		return nil
+	}
+
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	ref := client.Collection(reflectionsCollection).Doc(documentID(doc))
+
+	cfg := TranscriberConfigFromEnv()
+	result := map[string]any{
+		"provider":       cfg.Provider,
+		"sourceEditedAt": sourceEditedAt,
+		"updatedAt":      firestore.ServerTimestamp,
+	}
+	save := func(status string, transcript *Transcript, cause error) error {
+		result["status"] = status
+		terms := []string{}
+		if transcript != nil {
+			result["text"] = transcript.Text
+			result["language"] = transcript.Language
+			terms = transcriptTerms(transcript.Text)
+		}
+		if cause != nil {
+			result["error"] = cause.Error()
+			fmt.Printf("TranscribeReflectionAudio: %s %s: %v\n", status, id, cause)
+		}
+		_, err := ref.Update(ctx, []firestore.Update{
+			{Path: reflectionTranscriptField, Value: result},
+			{Path: reflectionTranscriptTermsField, Value: terms},
+		})
+		if err != nil {
+			return fmt.Errorf("store transcript for %s: %w", id, err)
+		}
+		return nil
+	}
+
+	if budget := checkAIBudget(ctx, client, explorerID); budget.Action == AIBudgetActionRefuse {
+		return save(TranscriptStatusSkipped, nil, fmt.Errorf("%s", budget.Reason))
+	}
+
+	s3Client, err := awsS3Client(ctx)
+	if err != nil {
+		return fmt.Errorf("aws config: %w", err)
+	}
+	audioKey := fmt.Sprintf("%s/to/%s/audio.m4a", explorerID, id)
+	audio, err := readS3Object(ctx, s3Client, audioKey, "audio", maxTranscriptionAudioBytes)
+	if err != nil {
+		return save(TranscriptStatusFailed, nil, err)
+	}
+
+	transcriber, err := NewTranscriber(ctx, cfg)
+	if err != nil {
+		return save(TranscriptStatusFailed, nil, err)
+	}
+	defer transcriber.Close()
+	result["provider"] = transcriber.Name()
+
+	tctx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+	defer cancel()
+	usage := AIUsage{ExplorerID: explorerID, CompanionID: senderID(doc), Source: "transcribe-reflection-audio"}
+	transcript, err := transcribeAudio(tctx, client, transcriber, usage, audio)
+	if err != nil {
+		return save(TranscriptStatusFailed, nil, err)
+	}
+	log.Printf("Transcribed %s with %s (%d chars, %s)", audioKey, transcriber.Name(), len(transcript.Text), transcript.Language)
+	return save(TranscriptStatusDone, transcript, nil)
+}
+
+func clearReflectionTranscript(ctx context.Context, docID string) error {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.Collection(reflectionsCollection).Doc(docID).Update(ctx, []firestore.Update{
+		{Path: reflectionTranscriptField, Value: firestore.Delete},
+		{Path: reflectionTranscriptTermsField, Value: firestore.Delete},
+	})
+	if err != nil {
+		return fmt.Errorf("clear transcript for %s: %w", docID, err)
+	}
+	return nil
+}
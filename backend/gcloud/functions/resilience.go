@@ -0,0 +1,304 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Every AI and TTS call goes through CallWithRetry: transient failures are
+// retried with exponential backoff and full jitter, and each dependency
+// (a provider/model such as "gemini/gemini-2.5-flash-lite", or
+// googleTTSDependency) has a circuit breaker so a failing one is skipped
+// instead of making every request wait out its retries. Caption models
+// additionally fall back through CAPTION_FALLBACK_MODELS (see
+// fallbackCaptionModel); when speech synthesis is down, callers return
+// captions without audio.
+
+const (
+	googleTTSDependency = "google/text-to-speech"
+
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 500 * time.Millisecond
+	// Handlers run under the function timeout, so waits stay short; batch
+	// tools can raise MaxDelay for long rate-limit windows.
+	defaultRetryMaxDelay = 4 * time.Second
+
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// RetryPolicy is how often and how patiently a call is retried.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call; 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// RetryPolicyFromEnv reads AI_RETRY_MAX_ATTEMPTS, AI_RETRY_BASE_DELAY_MS and
+// AI_RETRY_MAX_DELAY_MS; unset or invalid values use the defaults.
+func RetryPolicyFromEnv() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: envInt("AI_RETRY_MAX_ATTEMPTS", defaultRetryMaxAttempts),
+		BaseDelay:   time.Duration(envInt("AI_RETRY_BASE_DELAY_MS", int(defaultRetryBaseDelay/time.Millisecond))) * time.Millisecond,
+		MaxDelay:    time.Duration(envInt("AI_RETRY_MAX_DELAY_MS", int(defaultRetryMaxDelay/time.Millisecond))) * time.Millisecond,
+	}
+}
+
+// envInt reads a positive integer from the environment.
+func envInt(name string, fallback int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		fmt.Printf("envInt: ignoring invalid %s %q\n", name, raw)
+		return fallback
+	}
+	return n
+}
+
+// Backoff is the wait before retry number attempt (1 for the first retry):
+// a uniformly random duration up to BaseDelay*2^(attempt-1), capped at
+// MaxDelay ("full jitter"), so callers that failed together do not retry
+// together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, p.MaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// HTTPStatusError is a non-2xx reply from a provider reached over plain
+// HTTP. RetryAfter is the server's Retry-After hint, if any.
+type HTTPStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// newHTTPStatusError builds an HTTPStatusError from a reply whose body has
+// been read, keeping a short snippet of it.
+func newHTTPStatusError(res *http.Response, body []byte) *HTTPStatusError {
+	snippet := string(body)
+	if len(snippet) > 300 {
+		snippet = snippet[:300] + "..."
+	}
+	err := &HTTPStatusError{StatusCode: res.StatusCode, Body: snippet}
+	if seconds, convErr := strconv.Atoi(res.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// CircuitOpenError is returned without calling a dependency whose breaker
+// is open.
+type CircuitOpenError struct {
+	Dependency string
+	RetryIn    time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable (circuit open, retry in %s)", e.Dependency, e.RetryIn.Round(time.Second))
+}
+
+// retryableHTTPStatus reports the statuses worth retrying: timeouts, rate
+// limits and server-side failures.
+func retryableHTTPStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryableError classifies err: transient failures (gRPC Unavailable,
+// ResourceExhausted, DeadlineExceeded, Aborted or Internal; HTTP 408, 429 or
+// 5xx; timeouts and dropped connections) are worth retrying, everything
+// else, including safety blocks, malformed replies and bad requests, is
+// not. The duration is the provider's requested wait, when it gave one.
+func RetryableError(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+	var blocked *CaptionBlockedError
+	var open *CircuitOpenError
+	if errors.As(err, &blocked) || errors.As(err, &open) || errors.Is(err, errCaptionSchema) {
+		return false, 0
+	}
+
+	var httpErr *HTTPStatusError
+	if errors.As(err, &httpErr) {
+		return retryableHTTPStatus(httpErr.StatusCode), httpErr.RetryAfter
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return retryableHTTPStatus(apiErr.Code), 0
+	}
+	// gax's APIError reports the HTTP status of REST calls this way.
+	var httpCoder interface{ HTTPCode() int }
+	if errors.As(err, &httpCoder) && httpCoder.HTTPCode() > 0 {
+		return retryableHTTPStatus(httpCoder.HTTPCode()), 0
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+			return true, 0
+		}
+		return false, 0
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true, 0
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true, 0
+	}
+	return false, 0
+}
+
+// CircuitBreaker stops calls to a dependency after Failures consecutive
+// transient failures. Once Cooldown has passed one probe call is let
+// through: success closes the breaker, failure reopens it. Failures that
+// are the caller's fault (bad input, safety blocks) do not count.
+type CircuitBreaker struct {
+	Dependency string
+	Failures   int
+	Cooldown   time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = map[string]*CircuitBreaker{}
+)
+
+// circuitBreakerFor returns the process-wide breaker for dependency,
+// configured from AI_BREAKER_FAILURES and AI_BREAKER_COOLDOWN_SECONDS.
+// Breakers live as long as the instance, so a warm function remembers an
+// outage across requests.
+func circuitBreakerFor(dependency string) *CircuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+	b, ok := circuitBreakers[dependency]
+	if !ok {
+		b = &CircuitBreaker{
+			Dependency: dependency,
+			Failures:   envInt("AI_BREAKER_FAILURES", defaultBreakerFailures),
+			Cooldown:   time.Duration(envInt("AI_BREAKER_COOLDOWN_SECONDS", int(defaultBreakerCooldown/time.Second))) * time.Second,
+		}
+		circuitBreakers[dependency] = b
+	}
+	return b
+}
+
+// Allow reports whether a call may go ahead, returning a CircuitOpenError
+// when it may not.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if wait := b.Cooldown - time.Since(b.openedAt); wait > 0 || b.probing {
+		return &CircuitOpenError{Dependency: b.Dependency, RetryIn: max(wait, 0)}
+	}
+	b.probing = true
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed call.
+func (b *CircuitBreaker) Record(err error) {
+	retryable, _ := RetryableError(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && !retryable {
+		// The dependency answered; only a probe needs settling.
+		err = nil
+	}
+	wasProbing := b.probing
+	b.probing = false
+	if err == nil {
+		if !b.openedAt.IsZero() {
+			log.Printf("Circuit for %s closed", b.Dependency)
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if wasProbing || b.failures >= b.Failures {
+		if b.openedAt.IsZero() || wasProbing {
+			log.Printf("Circuit for %s opened after %d failures: %v", b.Dependency, b.failures, err)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// CallWithRetry calls call until it succeeds, fails with an error that is
+// not worth retrying, or policy runs out of attempts, sleeping Backoff (or
+// the provider's Retry-After, up to MaxDelay) in between. Calls go through
+// dependency's circuit breaker. The last result is returned together with
+// its error, so replies that carry usage are not lost.
+func CallWithRetry[T any](ctx context.Context, dependency string, policy RetryPolicy, call func(context.Context) (T, error)) (T, error) {
+	breaker := circuitBreakerFor(dependency)
+	attempts := max(policy.MaxAttempts, 1)
+	var result T
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = breaker.Allow(); err != nil {
+			var zero T
+			return zero, err
+		}
+		result, err = call(ctx)
+		breaker.Record(err)
+		if err == nil {
+			return result, nil
+		}
+		retryable, retryAfter := RetryableError(err)
+		if !retryable || attempt >= attempts || ctx.Err() != nil {
+			return result, err
+		}
+		wait := min(max(policy.Backoff(attempt), retryAfter), policy.MaxDelay)
+		log.Printf("%s: attempt %d/%d failed, retrying in %s: %v", dependency, attempt, attempts, wait.Round(time.Millisecond), err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := p.Backoff(tt.attempt); got < 0 || got > tt.ceiling {
+				t.Fatalf("Backoff(%d) = %s, want within [0, %s]", tt.attempt, got, tt.ceiling)
+			}
+		}
+	}
+	if got := (RetryPolicy{}).Backoff(1); got != 0 {
+		t.Errorf("zero policy Backoff = %s, want 0", got)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{"nil", nil, false, 0},
+		{"canceled", context.Canceled, false, 0},
+		{"deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), true, 0},
+		{"blocked", &CaptionBlockedError{}, false, 0},
+		{"circuit open", &CircuitOpenError{Dependency: "x"}, false, 0},
+		{"schema", fmt.Errorf("%w: bad", errCaptionSchema), false, 0},
+		{"http 429 with Retry-After", &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second}, true, 3 * time.Second},
+		{"http 503", fmt.Errorf("call: %w", &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}), true, 0},
+		{"http 400", &HTTPStatusError{StatusCode: http.StatusBadRequest}, false, 0},
+		{"googleapi 500", &googleapi.Error{Code: http.StatusInternalServerError}, true, 0},
+		{"googleapi 403", &googleapi.Error{Code: http.StatusForbidden}, false, 0},
+		{"grpc unavailable", status.Error(codes.Unavailable, "down"), true, 0},
+		{"grpc resource exhausted", status.Error(codes.ResourceExhausted, "quota"), true, 0},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad"), false, 0},
+		{"net timeout", timeoutError{}, true, 0},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true, 0},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true, 0},
+		{"plain error", errors.New("something else"), false, 0},
+	}
+	for _, tt := range tests {
+		retryable, retryAfter := RetryableError(tt.err)
+		if retryable != tt.retryable || retryAfter != tt.retryAfter {
+			t.Errorf("%s: RetryableError = %v, %s; want %v, %s", tt.name, retryable, retryAfter, tt.retryable, tt.retryAfter)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	transient := &HTTPStatusError{StatusCode: http.StatusBadGateway}
+	b := &CircuitBreaker{Dependency: "test/breaker", Failures: 2, Cooldown: time.Hour}
+
+	b.Record(transient)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("breaker opened after one failure: %v", err)
+	}
+	b.Record(&HTTPStatusError{StatusCode: http.StatusBadRequest})
+	b.Record(transient)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("caller errors should not count towards opening: %v", err)
+	}
+	b.Record(transient)
+	var open *CircuitOpenError
+	if err := b.Allow(); !errors.As(err, &open) {
+		t.Fatalf("Allow after %d failures = %v, want CircuitOpenError", b.Failures, err)
+	}
+
+	// After the cooldown a single probe goes through.
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	b.mu.Unlock()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe not allowed after cooldown: %v", err)
+	}
+	if err := b.Allow(); !errors.As(err, &open) {
+		t.Fatalf("second call during probe = %v, want CircuitOpenError", err)
+	}
+	b.Record(transient)
+	if err := b.Allow(); !errors.As(err, &open) {
+		t.Fatalf("failed probe did not reopen the breaker: %v", err)
+	}
+
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	b.mu.Unlock()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe not allowed after cooldown: %v", err)
+	}
+	b.Record(nil)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("successful probe did not close the breaker: %v", err)
+	}
+}
+
+func TestCallWithRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	transient := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	permanent := &HTTPStatusError{StatusCode: http.StatusBadRequest}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "succeeds first time", errs: []error{nil}, wantCalls: 1},
+		{name: "retries transient failures", errs: []error{transient, transient, nil}, wantCalls: 3},
+		{name: "gives up after MaxAttempts", errs: []error{transient, transient, transient}, wantErr: transient, wantCalls: 3},
+		{name: "does not retry permanent failures", errs: []error{permanent}, wantErr: permanent, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dependency := "test/" + tt.name
+			t.Cleanup(func() {
+				circuitBreakersMu.Lock()
+				delete(circuitBreakers, dependency)
+				circuitBreakersMu.Unlock()
+			})
+			calls := 0
+			result, err := CallWithRetry(context.Background(), dependency, policy, func(context.Context) (int, error) {
+				calls++
+				return calls, tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls || result != tt.wantCalls {
+				t.Errorf("calls = %d, result = %d; want %d", calls, result, tt.wantCalls)
+			}
+		})
+	}
+}
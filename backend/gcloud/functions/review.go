@@ -0,0 +1,349 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ReflectionStatusPendingReview holds a Reflection from an Explorer with
+	// settings.require_review until an owner or admin approves it. Apps
+	// create such Reflections with it (firestore.rules enforce this).
+	ReflectionStatusPendingReview = "pending_review"
+	// ReflectionStatusRejected keeps a reviewed-and-refused Reflection hidden
+	// from the Explorer; its sender can still see and delete it.
+	ReflectionStatusRejected = "rejected"
+
+	triggerReviewRequested = "review_requested"
+
+	reviewActionApprove = "approve"
+	reviewActionReject  = "reject"
+
+	maxReviewNoteLength = 500
+	// maxReviewQueue bounds the GET listing; a queue this long means nobody
+	// is reviewing.
+	maxReviewQueue = 100
+)
+
+// heldReflectionStatuses are the statuses a reviewer can approve or reject.
+// None of them reach the Explorer (see hiddenReflectionIDs).
+var heldReflectionStatuses = []string{ReflectionStatusPendingReview, ReflectionStatusQuarantined}
+
+var (
+	errReviewForbidden = errors.New("only an owner or admin of this Explorer may review Reflections")
+	errReviewNotHeld   = errors.New("reflection is not waiting for review")
+	errReviewNotFound  = errors.New("reflection not found")
+)
+
+// explorerRequiresReview reports whether the Explorer has opted into
+// caregiver approval with settings.require_review.
+func explorerRequiresReview(ctx context.Context, client *firestore.Client, explorerID string) (bool, error) {
+	snap, err := client.Collection("explorers").Doc(explorerID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("load explorer %s: %w", explorerID, err)
+	}
+	settings, _ := snap.Data()["settings"].(map[string]any)
+	required, _ := settings["require_review"].(bool)
+	return required, nil
+}
+
+// reflectionReviewers returns the user IDs of the Explorer's owners and
+// admins.
+func reflectionReviewers(ctx context.Context, client *firestore.Client, explorerID string) ([]string, error) {
+	docs, err := client.Collection(relationshipsCollection).
+		Where("explorerId", "==", explorerID).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("reviewer lookup: %w", err)
+	}
+	var reviewers []string
+	for _, doc := range docs {
+		data := doc.Data()
+		userID, _ := data["userId"].(string)
+		switch role, _ := data["role"].(string); role {
+		case "owner", "admin":
+			if userID != "" && !containsString(reviewers, userID) {
+				reviewers = append(reviewers, userID)
+			}
+		}
+	}
+	return reviewers, nil
+}
+
+// requiresReview reports whether a new Reflection must stay pending_review
+// after screening: its Explorer requires approval and the sender is not a
+// reviewer. Reviewers' own Reflections are released once screened.
+func requiresReview(ctx context.Context, client *firestore.Client, a reflectionArrival) (bool, error) {
+	required, err := explorerRequiresReview(ctx, client, a.ExplorerID)
+	if err != nil || !required {
+		return false, err
+	}
+	reviewers, err := reflectionReviewers(ctx, client, a.ExplorerID)
+	if err != nil {
+		return false, err
+	}
+	return !containsString(reviewers, a.SenderID), nil
+}
+
+// requestReflectionReview notifies each reviewer, other than the sender,
+// that a held Reflection is waiting. Held uploads still count as the
+// sender's latest Reflection for posting reminders.
+func requestReflectionReview(ctx context.Context, client *firestore.Client, a reflectionArrival) error {
+	reviewers, err := reflectionReviewers(ctx, client, a.ExplorerID)
+	if err != nil {
+		return err
+	}
+	for _, reviewerID := range reviewers {
+		if reviewerID == a.SenderID {
+			continue
+		}
+		docID := fmt.Sprintf("%s_%s_%s", triggerReviewRequested, a.ReflectionID, reviewerID)
+		if err := createPendingNotification(ctx, client, docID, pendingNotification{
+			ExplorerID:   a.ExplorerID,
+			RecipientIDs: []string{reviewerID},
+			TriggerType:  triggerReviewRequested,
+			ReflectionID: a.ReflectionID,
+			SenderID:     a.SenderID,
+			SenderName:   a.SenderName,
+			Status:       pendingStatus,
+		}); err != nil {
+			return err
+		}
+	}
+	if a.IsReaction {
+		return nil
+	}
+	return updateRelationshipLastReflectionSent(ctx, client, a.ExplorerID, a.SenderID)
+}
+
+// heldReflection is one entry of the review queue.
+type heldReflection struct {
+	ReflectionID string         `json:"reflection_id"`
+	EventID      string         `json:"event_id"`
+	Status       string         `json:"status"`
+	SenderID     string         `json:"sender_id,omitempty"`
+	SenderName   string         `json:"sender_name"`
+	IsReaction   bool           `json:"is_reaction,omitempty"`
+	Metadata     map[string]any `json:"metadata,omitempty"`
+	Moderation   map[string]any `json:"moderation,omitempty"`
+	arrivedAt    int64
+}
+
+// reviewDecision is the body of a ReviewReflection POST.
+type reviewDecision struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+// decideReflectionReview applies a reviewer's decision and returns the
+// Reflection's data as it was before. Only held Reflections can be decided,
+// so a second decision fails with errReviewNotHeld.
+func decideReflectionReview(ctx context.Context, client *firestore.Client, explorerID, docID, reviewerID string, decision reviewDecision) (map[string]any, error) {
+	ref := client.Collection(reflectionsCollection).Doc(docID)
+	var data map[string]any
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errReviewNotFound
+		}
+		if err != nil {
+			return err
+		}
+		data = snap.Data()
+		if owner, _ := data["explorerId"].(string); owner != explorerID {
+			return errReviewNotFound
+		}
+		if current, _ := data["status"].(string); !containsString(heldReflectionStatuses, current) {
+			return errReviewNotHeld
+		}
+		next, outcome := ReflectionStatusReady, "approved"
+		if decision.Action == reviewActionReject {
+			next, outcome = ReflectionStatusRejected, "rejected"
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: next},
+			{Path: "review", Value: map[string]any{
+				"decision":   outcome,
+				"reviewedBy": reviewerID,
+				"reviewedAt": firestore.ServerTimestamp,
+				"note":       decision.Note,
+			}},
+		})
+	})
+	return data, err
+}
+
+// ReviewReflection is the caregiver approval endpoint:
+//
+//	GET  ?explorer_id=                                            lists held Reflections
+//	POST ?explorer_id=&reflection_id= {"action":"approve"|"reject","note":""}
+//
+// Held Reflections are those pending_review (settings.require_review) or
+// quarantined by moderation. Approving one makes it visible to the Explorer
+// and only then stages its companion_upload or companion_reaction
+// notification. Owners, admins and audit admins may review.
+func ReviewReflection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	token, code, err := verifyBearerToken(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	explorerID := strings.TrimSpace(r.URL.Query().Get("explorer_id"))
+	if explorerID == "" || strings.Contains(explorerID, "/") {
+		http.Error(w, "explorer_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !isAuditAdmin(token) {
+		reviewers, err := reflectionReviewers(ctx, client, explorerID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !containsString(reviewers, token.UID) {
+			http.Error(w, errReviewForbidden.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		docs, err := client.Collection(reflectionsCollection).
+			Where("explorerId", "==", explorerID).
+			Where("status", "in", heldReflectionStatuses).
+			Limit(maxReviewQueue).
+			Documents(ctx).
+			GetAll()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		queue := make([]heldReflection, 0, len(docs))
+		for _, doc := range docs {
+			data := doc.Data()
+			held := heldReflection{
+				ReflectionID: doc.Ref.ID,
+				EventID:      doc.Ref.ID,
+				SenderName:   senderNameFromReflectionData(data, explorerID, client, ctx),
+			}
+			if id, _ := data["event_id"].(string); id != "" {
+				held.EventID = id
+			}
+			held.Status, _ = data["status"].(string)
+			held.SenderID = reflectionDataSenderID(data)
+			held.IsReaction, _ = data["isReaction"].(bool)
+			held.Metadata, _ = data["metadata"].(map[string]any)
+			held.Moderation, _ = data[reflectionModerationField].(map[string]any)
+			switch ts := data["timestamp"].(type) {
+			case time.Time:
+				held.arrivedAt = ts.UnixMilli()
+			case int64:
+				held.arrivedAt = ts
+			case float64:
+				held.arrivedAt = int64(ts)
+			}
+			queue = append(queue, held)
+		}
+		// Oldest first, so reviewers work through the queue in arrival order.
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].arrivedAt < queue[j].arrivedAt })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"explorer_id": explorerID, "reflections": queue})
+
+	case http.MethodPost:
+		docID := strings.TrimSpace(r.URL.Query().Get("reflection_id"))
+		if docID == "" || strings.Contains(docID, "/") {
+			http.Error(w, "reflection_id is required", http.StatusBadRequest)
+			return
+		}
+		var decision reviewDecision
+		if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if decision.Action != reviewActionApprove && decision.Action != reviewActionReject {
+			http.Error(w, `action must be "approve" or "reject"`, http.StatusBadRequest)
+			return
+		}
+		decision.Note = strings.TrimSpace(decision.Note)
+		if len(decision.Note) > maxReviewNoteLength {
+			http.Error(w, fmt.Sprintf("note must be at most %d characters", maxReviewNoteLength), http.StatusBadRequest)
+			return
+		}
+
+		data, err := decideReflectionReview(ctx, client, explorerID, docID, token.UID, decision)
+		switch {
+		case errors.Is(err, errReviewNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errReviewNotHeld):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("ReviewReflection: %s decided %s on %s for explorer %s\n", token.UID, decision.Action, docID, explorerID)
+
+		if decision.Action == reviewActionApprove {
+			arrival := reflectionArrival{
+				ExplorerID:   explorerID,
+				ReflectionID: docID,
+				SenderID:     reflectionDataSenderID(data),
+				SenderName:   senderNameFromReflectionData(data, explorerID, client, ctx),
+			}
+			if id, _ := data["event_id"].(string); id != "" {
+				arrival.ReflectionID = id
+			}
+			arrival.IsReaction, _ = data["isReaction"].(bool)
+			arrival.ParentReflectionID, _ = data["parentReflectionId"].(string)
+			if err := announceReflection(ctx, client, arrival); err != nil {
+				// The Reflection is already visible; only the notification is lost.
+				fmt.Printf("ReviewReflection: approved %s but staging its notification failed: %v\n", docID, err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"reflection_id": docID, "action": decision.Action})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reflectionDataSenderID reads sender_id from a Reflection's data, falling
+// back to metadata.sender_id like senderID does for event documents.
+func reflectionDataSenderID(data map[string]any) string {
+	if id, _ := data["sender_id"].(string); id != "" {
+		return id
+	}
+	metadata, _ := data["metadata"].(map[string]any)
+	id, _ := metadata["sender_id"].(string)
+	return id
+}
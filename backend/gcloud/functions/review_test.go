@@ -0,0 +1,34 @@
+package functions
+
+import "testing"
+
+func TestReflectionDataSenderID(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]any
+		want string
+	}{
+		{"top-level sender", map[string]any{"sender_id": "uid-1", "metadata": map[string]any{"sender_id": "uid-2"}}, "uid-1"},
+		{"older Reflections keep it in metadata", map[string]any{"metadata": map[string]any{"sender_id": "uid-2"}}, "uid-2"},
+		{"no sender", map[string]any{"sender_id": ""}, ""},
+	}
+	for _, tt := range tests {
+		if got := reflectionDataSenderID(tt.data); got != tt.want {
+			t.Errorf("%s: reflectionDataSenderID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithheldReflectionStatuses(t *testing.T) {
+	for _, status := range append([]string{ReflectionStatusScreening}, heldReflectionStatuses...) {
+		if !containsString(withheldReflectionStatuses, status) {
+			t.Errorf("%q can be held but is not withheld from the Explorer", status)
+		}
+	}
+	if containsString(withheldReflectionStatuses, ReflectionStatusReady) {
+		t.Error("ready Reflections are withheld from the Explorer")
+	}
+	if len(withheldReflectionStatuses) > firestoreInLimit {
+		t.Errorf("%d withheld statuses do not fit one Firestore in filter", len(withheldReflectionStatuses))
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -30,17 +29,14 @@ func GetSignedURL(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.TODO()
 
-	// 2. Load Config with absolute error checking
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion("us-east-1"), // AWS SDK requires a region even for custom endpoints
-	)
+	// 2. Shared S3 client (see clients.go)
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "AWS Config Error: "+err.Error(), 500)
 		return
 	}
 
-	// 3. Initialize Client safely
-	s3Client := s3.NewFromConfig(cfg)
+	// 3. Initialize Presign Client
 	presignClient := s3.NewPresignClient(s3Client)
 
 	// 4. Explorer ID extraction and validation
@@ -124,12 +120,11 @@ func UploadToS3(ctx context.Context, key string, data []byte, contentType string
 	if len(data) == 0 {
 		return fmt.Errorf("refusing to upload empty data to S3 at %s", key)
 	}
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	s3Client := s3.NewFromConfig(cfg)
 	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String("reflections-1200b-storage"),
 		Key:         aws.String(key),
@@ -185,17 +180,14 @@ func ListMirrorEvents(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.TODO()
 
-	// 2. Load Config with region
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion("us-east-1"),
-	)
+	// 2. Shared S3 client (see clients.go)
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "AWS Config Error: "+err.Error(), 500)
 		return
 	}
 
-	// 3. Initialize Client and Presign Client
-	s3Client := s3.NewFromConfig(cfg)
+	// 3. Initialize Presign Client
 	presignClient := s3.NewPresignClient(s3Client)
 
 	// 4. Explorer ID extraction and validation
@@ -289,13 +281,32 @@ func ListMirrorEvents(w http.ResponseWriter, r *http.Request) {
 		listInput.ContinuationToken = result.NextContinuationToken
 	}
 
-	// 7. Convert map to slice
+	// 7. Drop Reflections still being screened, quarantined, awaiting or
+	// refused review, and those from companions whose account deletion is
+	// scheduled. Withheld content must never leak, so a lookup failure fails
+	// the whole listing.
+	fsClient, err := firestoreClient(ctx)
+	if err != nil {
+		http.Error(w, "Firestore Error: "+err.Error(), 500)
+		return
+	}
+	hidden, err := hiddenReflectionIDs(ctx, fsClient, explorerID)
+	if err != nil {
+		fmt.Printf("ListMirrorEvents: hidden-reflection filter for %s: %v\n", explorerID, err)
+		http.Error(w, "Firestore Error: "+err.Error(), 500)
+		return
+	}
+	for eventID := range hidden {
+		delete(eventMap, eventID)
+	}
+
+	// 8. Convert map to slice
 	var events []Event
 	for _, event := range eventMap {
 		events = append(events, *event)
 	}
 
-	// 8. Return as JSON
+	// 9. Return as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"events": events,
@@ -322,13 +333,12 @@ func GetEventBundle(w http.ResponseWriter, r *http.Request) {
 	ctx := context.TODO()
 
 	// 2. Load Config
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "AWS Config Error: "+err.Error(), 500)
 		return
 	}
 
-	s3Client := s3.NewFromConfig(cfg)
 	presignClient := s3.NewPresignClient(s3Client)
 
 	// 3. Explorer ID extraction and validation
@@ -390,16 +400,25 @@ func GetEventBundle(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(event)
 }
 
-// DeleteMirrorEvent handles deletion of an event bundle (S3 objects)
+// DeleteMirrorEvent handles deletion of an event bundle (S3 objects). The
+// caller must present a Firebase ID token.
 func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 	// 1. Standard CORS
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	if r.Method == "OPTIONS" {
 		return
 	}
 
+	// 1b. Deletes must come from a signed-in user so the audit record can
+	// name who made them.
+	uid, code, err := verifyBearerUID(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
 	ctx := context.TODO()
 
 	// 2. Get event_id from query parameter (optional when extra_keys is provided for TTS-only cleanup)
@@ -417,19 +436,14 @@ func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. Load Config with region
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion("us-east-1"),
-	)
+	// 4. Shared S3 client (see clients.go)
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "AWS Config Error: "+err.Error(), 500)
 		return
 	}
 
-	// 5. Initialize S3 Client
-	s3Client := s3.NewFromConfig(cfg)
-
-	// 6. Determine path: "to" (companion -> explorer), "from" (explorer -> companion, selfie responses), or "staging" (temporary)
+	// 5. Determine path: "to" (companion -> explorer), "from" (explorer -> companion, selfie responses), or "staging" (temporary)
 	path := r.URL.Query().Get("path")
 	if path != "to" && path != "from" && path != "staging" {
 		path = "to" // Default to "to" for backward compatibility
@@ -446,9 +460,21 @@ func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 				fmt.Sprintf("%s/%s/%s/image_original.jpg", explorerID, path, eventID),
 			}
 		} else if path == "staging" {
-			// For staging, delete image.jpg (TTS files deleted via extra_keys)
+			// For staging, delete image.jpg, the caption voice note and any caption keyframes (TTS files deleted via extra_keys)
 			objectsToDelete = []string{
 				fmt.Sprintf("staging/%s/image.jpg", eventID),
+				fmt.Sprintf("staging/%s/voice_note.m4a", eventID),
+			}
+			keyframes, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket: aws.String(bucket),
+				Prefix: aws.String(fmt.Sprintf("staging/%s/frame_", eventID)),
+			})
+			if err == nil {
+				for _, obj := range keyframes.Contents {
+					objectsToDelete = append(objectsToDelete, aws.ToString(obj.Key))
+				}
+			} else {
+				fmt.Printf("Failed to list staging keyframes for %s: %v\n", eventID, err)
 			}
 		} else {
 			// For regular reflections: all media + backups (image_original from shrink-images, video_original from shrink-videos)
@@ -479,6 +505,8 @@ func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	beforeState := s3BeforeState(ctx, s3Client, bucket, objectsToDelete)
+
 	var errors []string
 	for _, key := range objectsToDelete {
 		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -493,6 +521,35 @@ func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// 6a. Audit: targets include the Firestore path so a Reflection's deletion
+	// can be found by its doc ID as well as by its S3 keys.
+	targets := append([]string{}, objectsToDelete...)
+	if eventID != "" && path != "staging" {
+		collection := reflectionsCollection
+		if path == "from" {
+			collection = responsesCollection
+		}
+		targets = append(targets, collection+"/"+eventID)
+	}
+	rec := AuditRecord{
+		Actor:      uid,
+		Action:     "delete_mirror_event",
+		Targets:    targets,
+		RequestID:  auditRequestID(r),
+		Outcome:    AuditOutcomeSuccess,
+		BeforeHash: AuditBeforeHash(beforeState),
+		Details:    map[string]any{"explorerId": explorerID, "eventId": eventID, "path": path},
+	}
+	if len(errors) > 0 {
+		rec.Outcome = AuditOutcomeFailure
+		rec.Error = strings.Join(errors, "; ")
+	}
+	if fsClient, err := firestoreClient(ctx); err != nil {
+		fmt.Printf("DeleteMirrorEvent: audit skipped: %v\n", err)
+	} else {
+		writeAuditRecord(ctx, fsClient, rec)
+	}
+
 	// 6. Return response
 	w.Header().Set("Content-Type", "application/json")
 	if len(errors) > 0 {
@@ -509,6 +566,38 @@ func DeleteMirrorEvent(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// s3BeforeState maps each key to its ETag ("" when absent) using one listing
+// per containing folder, as the before-state for an audit record. A key at
+// the bucket root is listed by itself rather than listing the whole bucket.
+func s3BeforeState(ctx context.Context, s3Client *s3.Client, bucket string, keys []string) map[string]string {
+	state := make(map[string]string, len(keys))
+	listed := map[string]map[string]string{}
+	for _, key := range keys {
+		dir := key
+		if slash := strings.LastIndex(key, "/"); slash >= 0 {
+			dir = key[:slash+1]
+		}
+		etags, ok := listed[dir]
+		if !ok {
+			etags = map[string]string{}
+			result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket: aws.String(bucket),
+				Prefix: aws.String(dir),
+			})
+			if err != nil {
+				fmt.Printf("s3BeforeState: list %s failed: %v\n", dir, err)
+			} else {
+				for _, obj := range result.Contents {
+					etags[aws.ToString(obj.Key)] = aws.ToString(obj.ETag)
+				}
+			}
+			listed[dir] = etags
+		}
+		state[key] = etags[key]
+	}
+	return state
+}
+
 type BatchUploadRequest struct {
 	ExplorerID string   `json:"explorer_id"`
 	EventID    string   `json:"event_id"`
@@ -551,13 +640,12 @@ func GetBatchS3UploadURLs(w http.ResponseWriter, r *http.Request) {
 
 	// 3. Load Config
 	ctx := context.TODO()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "AWS Config Error: "+err.Error(), 500)
 		return
 	}
 
-	s3Client := s3.NewFromConfig(cfg)
 	presignClient := s3.NewPresignClient(s3Client)
 
 	// 4. Default path logic
@@ -0,0 +1,385 @@
+package functions
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+)
+
+// Speech may carry a safe subset of SSML: pauses (<break>), <emphasis>,
+// <sub> and <phoneme> pronunciations, <p> and <s> structure and
+// <prosody rate>. Markup is checked against that subset and re-serialized
+// from its parsed form, so nothing else reaches Google. Only some voices
+// accept SSML (voiceSupportsSSML), and the default Journey voice is not one
+// of them. The others are sent a plain reading: <sub> aliases and glossary
+// respellings are spoken, pauses become an ellipsis, which those voices
+// pause on, and the rest of the markup is dropped.
+
+var errInvalidSSML = errors.New("invalid SSML")
+
+const (
+	maxSSMLBreak         = 5 * time.Second
+	maxSSMLDepth         = 8
+	maxSSMLAttributeRune = 100
+
+	// narrationSentencePause paces generated captions; our listeners follow
+	// slower, well-separated sentences better.
+	narrationSentencePause = 400 * time.Millisecond
+
+	// plainTextPause stands in for a pause for voices that only take plain
+	// text.
+	plainTextPause = " … "
+)
+
+var (
+	ssmlBreakTime  = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ms|s)$`)
+	ssmlRatePct    = regexp.MustCompile(`^(\d{1,3})%$`)
+	sentenceBreaks = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+`)
+)
+
+func ssmlOneOf(values ...string) func(string) bool {
+	return func(v string) bool { return containsString(values, v) }
+}
+
+func ssmlShortText(v string) bool {
+	v = strings.TrimSpace(v)
+	return v != "" && utf8.RuneCountInString(v) <= maxSSMLAttributeRune
+}
+
+func ssmlValidBreakTime(v string) bool {
+	m := ssmlBreakTime.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return false
+	}
+	if m[2] == "s" {
+		n *= 1000
+	}
+	return time.Duration(n*float64(time.Millisecond)) <= maxSSMLBreak
+}
+
+func ssmlValidRate(v string) bool {
+	if containsString([]string{"x-slow", "slow", "medium", "fast", "x-fast", "default"}, v) {
+		return true
+	}
+	m := ssmlRatePct.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	pct, _ := strconv.Atoi(m[1])
+	return pct >= 25 && pct <= 200
+}
+
+// ssmlTags are the allowed elements and, for each, its allowed attributes
+// with their value checks.
+var ssmlTags = map[string]map[string]func(string) bool{
+	"speak": {},
+	"p":     {},
+	"s":     {},
+	"break": {
+		"time":     ssmlValidBreakTime,
+		"strength": ssmlOneOf("none", "x-weak", "weak", "medium", "strong", "x-strong"),
+	},
+	"emphasis": {"level": ssmlOneOf("strong", "moderate", "none", "reduced")},
+	"sub":      {"alias": ssmlShortText},
+	"phoneme": {
+		"alphabet": ssmlOneOf("ipa", "x-sampa"),
+		"ph":       ssmlShortText,
+	},
+	"prosody": {"rate": ssmlValidRate},
+}
+
+var ssmlRequiredAttrs = map[string][]string{
+	"sub":     {"alias"},
+	"phoneme": {"ph"},
+	"prosody": {"rate"},
+}
+
+func invalidSSML(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errInvalidSSML, fmt.Sprintf(format, args...))
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// normalizeSSML checks markup against the allowed subset and returns it
+// re-serialized inside a single <speak>, together with its plain reading.
+// The <speak> wrapper is optional in markup.
+func normalizeSSML(markup string) (string, string, error) {
+	markup = strings.TrimSpace(markup)
+	if !strings.HasPrefix(markup, "<speak>") && !strings.HasPrefix(markup, "<speak ") {
+		markup = "<speak>" + markup + "</speak>"
+	}
+	dec := xml.NewDecoder(strings.NewReader(markup))
+	dec.Strict = true
+
+	var out, plain strings.Builder
+	var stack []string
+	closed := false
+	inSub := 0
+	// pause is set by a <break> after spoken text and written as
+	// plainTextPause before the next text, so leading and trailing breaks
+	// add nothing to the plain reading.
+	pause := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", invalidSSML("%v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			allowed, ok := ssmlTags[name]
+			if !ok || t.Name.Space != "" {
+				return "", "", invalidSSML("<%s> is not allowed", name)
+			}
+			if (name == "speak") != (len(stack) == 0) || closed {
+				return "", "", invalidSSML("markup must be a single <speak> element")
+			}
+			if len(stack) >= maxSSMLDepth {
+				return "", "", invalidSSML("elements are nested more than %d deep", maxSSMLDepth)
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == "break" {
+				return "", "", invalidSSML("<break> must be empty")
+			}
+			out.WriteString("<" + name)
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				check, ok := allowed[a.Name.Local]
+				if !ok || a.Name.Space != "" || !check(a.Value) {
+					return "", "", invalidSSML("%s=%q is not allowed on <%s>", a.Name.Local, a.Value, name)
+				}
+				attrs[a.Name.Local] = a.Value
+				out.WriteString(" " + a.Name.Local + `="` + escapeXML(a.Value) + `"`)
+			}
+			for _, required := range ssmlRequiredAttrs[name] {
+				if attrs[required] == "" {
+					return "", "", invalidSSML("<%s> needs %s", name, required)
+				}
+			}
+			if name == "break" {
+				out.WriteString("/>")
+			} else {
+				out.WriteString(">")
+			}
+			switch name {
+			case "break":
+				pause = pause || (attrs["strength"] != "none" && strings.TrimSpace(plain.String()) != "")
+			case "sub":
+				if inSub == 0 {
+					plain.WriteString(attrs["alias"])
+				}
+				inSub++
+			case "p", "s":
+				plain.WriteString(" ")
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if name != "break" {
+				out.WriteString("</" + name + ">")
+			}
+			switch name {
+			case "speak":
+				closed = true
+			case "sub":
+				inSub--
+			case "p", "s":
+				plain.WriteString(" ")
+			}
+		case xml.CharData:
+			if len(stack) == 0 {
+				if strings.TrimSpace(string(t)) != "" {
+					return "", "", invalidSSML("text outside <speak>")
+				}
+				continue
+			}
+			if stack[len(stack)-1] == "break" && strings.TrimSpace(string(t)) != "" {
+				return "", "", invalidSSML("<break> must be empty")
+			}
+			out.WriteString(escapeXML(string(t)))
+			if inSub == 0 {
+				if pause && strings.TrimSpace(string(t)) != "" {
+					plain.WriteString(plainTextPause)
+					pause = false
+				}
+				plain.Write(t)
+			}
+		default:
+			return "", "", invalidSSML("comments, directives and processing instructions are not allowed")
+		}
+	}
+	text := strings.Join(strings.Fields(plain.String()), " ")
+	if text == "" {
+		return "", "", invalidSSML("nothing to speak")
+	}
+	return out.String(), text, nil
+}
+
+// voiceSupportsSSML reports whether Google accepts SSML for voice. Journey,
+// Chirp and Casual voices only take plain text.
+func voiceSupportsSSML(voice string) bool {
+	for _, family := range []string{"-Journey-", "-Chirp", "-Casual-"} {
+		if strings.Contains(voice, family) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPronunciation separates a glossary pronunciation into its plain
+// respelling and the IPA written between slashes after it:
+// "Shi-vawn /ʃɪˈvɔːn/" has both, "Shi-vawn" only the respelling and
+// "/ʃɪˈvɔːn/" only the IPA.
+func splitPronunciation(spoken string) (respelling, ipa string) {
+	spoken = strings.TrimSpace(spoken)
+	if !strings.HasSuffix(spoken, "/") {
+		return spoken, ""
+	}
+	if i := strings.LastIndex(spoken[:len(spoken)-1], "/"); i >= 0 && len(spoken)-i > 2 {
+		if ipa = strings.TrimSpace(spoken[i+1 : len(spoken)-1]); ipa != "" {
+			return strings.TrimSpace(spoken[:i]), ipa
+		}
+	}
+	return spoken, ""
+}
+
+// pronunciationsSSML renders text as SSML content with each whole-word,
+// case-insensitive name match wrapped in <phoneme> when its pronunciation
+// has IPA, or in <sub> with the respelling. Longer names win, as in
+// applyPronunciations.
+func pronunciationsSSML(text string, pronunciations map[string]string) string {
+	spokenBy := map[string]string{}
+	var names []string
+	for name, spoken := range pronunciations {
+		name, spoken = strings.TrimSpace(name), strings.TrimSpace(spoken)
+		if name != "" && spoken != "" {
+			spokenBy[strings.ToLower(name)] = spoken
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	if len(names) == 0 {
+		return escapeXML(text)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	pattern := regexp.MustCompile(`(?i)` + strings.Join(names, "|"))
+
+	wordRune := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+	var b strings.Builder
+	last := 0
+	for _, m := range pattern.FindAllStringIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:m[0]])
+		after, _ := utf8.DecodeRuneInString(text[m[1]:])
+		if (m[0] > 0 && wordRune(before)) || (m[1] < len(text) && wordRune(after)) {
+			continue
+		}
+		name := text[m[0]:m[1]]
+		spoken := spokenBy[strings.ToLower(name)]
+		b.WriteString(escapeXML(text[last:m[0]]))
+		if respelling, ipa := splitPronunciation(spoken); ipa != "" {
+			b.WriteString(`<phoneme alphabet="ipa" ph="` + escapeXML(ipa) + `">` + escapeXML(name) + `</phoneme>`)
+		} else {
+			b.WriteString(`<sub alias="` + escapeXML(respelling) + `">` + escapeXML(name) + `</sub>`)
+		}
+		last = m[1]
+	}
+	b.WriteString(escapeXML(text[last:]))
+	return b.String()
+}
+
+// speechSSML renders plain text as SSML: pronunciations become <sub> or
+// <phoneme> and, when pause is set, a <break> follows every sentence but
+// the last.
+func speechSSML(text string, pronunciations map[string]string, pause time.Duration) string {
+	var b strings.Builder
+	b.WriteString("<speak>")
+	if pause <= 0 {
+		b.WriteString(pronunciationsSSML(text, pronunciations))
+	} else {
+		pauseTag := fmt.Sprintf(`<break time="%dms"/>`, min(pause, maxSSMLBreak).Milliseconds())
+		last := 0
+		for _, m := range sentenceBreaks.FindAllStringIndex(text, -1) {
+			if m[1] == len(text) {
+				break
+			}
+			b.WriteString(pronunciationsSSML(strings.TrimSpace(text[last:m[1]]), pronunciations))
+			b.WriteString(pauseTag + " ")
+			last = m[1]
+		}
+		b.WriteString(pronunciationsSSML(text[last:], pronunciations))
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+// pacePlainText separates the sentences of text with plainTextPause, for
+// voices that cannot take a <break>.
+func pacePlainText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range sentenceBreaks.FindAllStringIndex(text, -1) {
+		if m[1] == len(text) {
+			break
+		}
+		b.WriteString(strings.TrimSpace(text[last:m[1]]))
+		b.WriteString(plainTextPause)
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// synthesisInput is what Google is asked to speak for text in voice: SSML
+// when opts carry markup, pronunciations or pauses and the voice accepts
+// it, plain text with respellings and ellipsis pauses otherwise.
+func synthesisInput(text, voice string, opts SpeechOptions) (*texttospeechpb.SynthesisInput, error) {
+	if opts.SSML {
+		markup, plain, err := normalizeSSML(text)
+		if err != nil {
+			return nil, err
+		}
+		if voiceSupportsSSML(voice) {
+			return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Ssml{Ssml: markup}}, nil
+		}
+		return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Text{Text: plain}}, nil
+	}
+	if voiceSupportsSSML(voice) && (len(opts.Pronunciations) > 0 || opts.SentencePause > 0) {
+		return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Ssml{
+			Ssml: speechSSML(text, opts.Pronunciations, opts.SentencePause),
+		}}, nil
+	}
+	text = applyPronunciations(text, opts.Pronunciations)
+	if opts.SentencePause > 0 {
+		text = pacePlainText(text)
+	}
+	return &texttospeechpb.SynthesisInput{InputSource: &texttospeechpb.SynthesisInput_Text{Text: text}}, nil
+}
+
+// synthesisInputText is the text or markup in, prefixed "ssml:" for markup
+// so cache keys never confuse the two.
+func synthesisInputText(in *texttospeechpb.SynthesisInput) string {
+	if ssml := in.GetSsml(); ssml != "" {
+		return "ssml:" + ssml
+	}
+	return in.GetText()
+}
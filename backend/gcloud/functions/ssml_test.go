@@ -0,0 +1,243 @@
+package functions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSSML(t *testing.T) {
+	tests := []struct {
+		name      string
+		markup    string
+		want      string
+		wantPlain string
+	}{
+		{name: "plain text is wrapped", markup: "Hello there.",
+			want: "<speak>Hello there.</speak>", wantPlain: "Hello there."},
+		{name: "break", markup: `<speak>Hi.<break time="500ms"/>Bye.</speak>`,
+			want: `<speak>Hi.<break time="500ms"/>Bye.</speak>`, wantPlain: "Hi. … Bye."},
+		{name: "break strength and seconds", markup: `One<break strength="strong"></break> two<break time="1.5s"/>`,
+			want: `<speak>One<break strength="strong"/> two<break time="1.5s"/></speak>`, wantPlain: "One … two"},
+		{name: "leading break and no-pause break", markup: `<break time="1s"/>One<break strength="none"/> two`,
+			want: `<speak><break time="1s"/>One<break strength="none"/> two</speak>`, wantPlain: "One two"},
+		{name: "sub speaks its alias", markup: `Hi <sub alias="Siobhan">Shiv</sub>!`,
+			want: `<speak>Hi <sub alias="Siobhan">Shiv</sub>!</speak>`, wantPlain: "Hi Siobhan!"},
+		{name: "phoneme", markup: `<phoneme alphabet="ipa" ph="ʃɪˈvɔːn">Siobhan</phoneme>`,
+			want: `<speak><phoneme alphabet="ipa" ph="ʃɪˈvɔːn">Siobhan</phoneme></speak>`, wantPlain: "Siobhan"},
+		{name: "structure and prosody", markup: `<p><s>One.</s><s><prosody rate="80%"><emphasis level="strong">Two.</emphasis></prosody></s></p>`,
+			want: `<speak><p><s>One.</s><s><prosody rate="80%"><emphasis level="strong">Two.</emphasis></prosody></s></p></speak>`, wantPlain: "One. Two."},
+		{name: "text is re-escaped", markup: `Tom &amp; Jerry &lt;3`,
+			want: `<speak>Tom &amp; Jerry &lt;3</speak>`, wantPlain: "Tom & Jerry <3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, plain, err := normalizeSSML(tt.markup)
+			if err != nil {
+				t.Fatalf("normalizeSSML: %v", err)
+			}
+			if got != tt.want || plain != tt.wantPlain {
+				t.Errorf("normalizeSSML = %q, %q; want %q, %q", got, plain, tt.want, tt.wantPlain)
+			}
+			// Normalized markup is a fixed point.
+			if again, _, err := normalizeSSML(got); err != nil || again != got {
+				t.Errorf("re-normalizing = %q, %v; want %q", again, err, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeSSMLRejects(t *testing.T) {
+	tests := map[string]string{
+		"unknown element":         `<audio src="https://example.com/a.mp3"/>`,
+		"unknown attribute":       `<break time="1s" onload="x"/>`,
+		"namespaced attribute":    `<sub xml:alias="x">y</sub>`,
+		"namespaced element":      `<speak xmlns:x="urn:x"><x:mark/></speak>`,
+		"long break":              `<break time="6s"/>`,
+		"bad break unit":          `<break time="1m"/>`,
+		"fast prosody":            `<prosody rate="500%">Hi</prosody>`,
+		"prosody pitch":           `<prosody rate="slow" pitch="high">Hi</prosody>`,
+		"sub without alias":       `<sub>Hi</sub>`,
+		"phoneme without ph":      `<phoneme alphabet="ipa">Hi</phoneme>`,
+		"unknown alphabet":        `<phoneme alphabet="arpabet" ph="HH AY">Hi</phoneme>`,
+		"long alias":              `<sub alias="` + strings.Repeat("a", maxSSMLAttributeRune+1) + `">Hi</sub>`,
+		"break with text":         `<break time="1s">Hi</break>`,
+		"nested speak":            `<speak><speak>Hi</speak></speak>`,
+		"two speak elements":      `<speak>Hi</speak><speak>Bye</speak>`,
+		"text after speak":        `<speak>Hi</speak> Bye`,
+		"comment":                 `<speak>Hi<!-- note --></speak>`,
+		"processing instruction":  `<speak>Hi<?php echo 1 ?></speak>`,
+		"doctype":                 `<!DOCTYPE speak><speak>Hi</speak>`,
+		"entity":                  `<speak>&xxe;</speak>`,
+		"unclosed":                `<speak><p>Hi</speak>`,
+		"nothing to speak":        `<speak><break time="1s"/></speak>`,
+		"only a sub alias spoken": `<sub alias=" ">Hi</sub>`,
+		"too deep":                strings.Repeat("<p>", maxSSMLDepth) + "Hi" + strings.Repeat("</p>", maxSSMLDepth),
+	}
+	for name, markup := range tests {
+		if got, _, err := normalizeSSML(markup); !errors.Is(err, errInvalidSSML) {
+			t.Errorf("%s: normalizeSSML(%q) = %q, %v; want errInvalidSSML", name, markup, got, err)
+		}
+	}
+}
+
+func TestApplyPronunciations(t *testing.T) {
+	pronunciations := map[string]string{
+		"Sue":         "Soo",
+		"Grandma Sue": "Grandma Soozie",
+		"Siobhan":     "/ʃɪˈvɔːn/",
+		"Aoife":       "Ee-fa /ˈiːfə/",
+		"Cash":        "$5 man",
+		"  ":          "blank",
+	}
+	tests := map[string]string{
+		"Sue and Grandma Sue":       "Soo and Grandma Soozie",
+		"sue, SUE!":                 "Soo, Soo!",
+		"Suez and Susan":            "Suez and Susan",
+		"Siobhan waves":             "Siobhan waves",
+		"Aoife waves":               "Ee-fa waves",
+		"Cash is here":              "$5 man is here",
+		"No names here.":            "No names here.",
+		"Sue's dog met Grandma Sue": "Soo's dog met Grandma Soozie",
+	}
+	for text, want := range tests {
+		if got := applyPronunciations(text, pronunciations); got != want {
+			t.Errorf("applyPronunciations(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSpeechSSML(t *testing.T) {
+	pronunciations := map[string]string{"Siobhan": "Shi-vawn /ʃɪˈvɔːn/", "Sue": "Soo", "Grandma Sue": "Grandma Soozie"}
+	tests := []struct {
+		name  string
+		text  string
+		pause time.Duration
+		want  string
+	}{
+		{name: "pronunciations", text: "Siobhan & Grandma Sue <3",
+			want: `<speak><phoneme alphabet="ipa" ph="ʃɪˈvɔːn">Siobhan</phoneme> &amp; <sub alias="Grandma Soozie">Grandma Sue</sub> &lt;3</speak>`},
+		{name: "whole words only", text: "Suez", want: `<speak>Suez</speak>`},
+		{name: "sentence pauses", text: "Hi Sue. How are you? Bye!", pause: 400 * time.Millisecond,
+			want: `<speak>Hi <sub alias="Soo">Sue</sub>.<break time="400ms"/> How are you?<break time="400ms"/> Bye!</speak>`},
+		{name: "pause is capped", text: "One. Two.", pause: time.Minute,
+			want: `<speak>One.<break time="5000ms"/> Two.</speak>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := speechSSML(tt.text, pronunciations, tt.pause)
+			if got != tt.want {
+				t.Errorf("speechSSML = %q, want %q", got, tt.want)
+			}
+			if _, _, err := normalizeSSML(got); err != nil {
+				t.Errorf("speechSSML output is not accepted by normalizeSSML: %v", err)
+			}
+		})
+	}
+}
+
+func TestSynthesisInput(t *testing.T) {
+	const ssmlVoice, plainVoice = "en-US-Neural2-C", "en-US-Journey-O"
+	pronunciations := map[string]string{"Sue": "Soo"}
+	tests := []struct {
+		name     string
+		text     string
+		voice    string
+		opts     SpeechOptions
+		wantSSML string
+		wantText string
+		wantErr  bool
+	}{
+		{name: "plain", text: "Hi Sue.", voice: ssmlVoice, wantText: "Hi Sue."},
+		{name: "pronunciations as SSML", text: "Hi Sue.", voice: ssmlVoice, opts: SpeechOptions{Pronunciations: pronunciations},
+			wantSSML: `<speak>Hi <sub alias="Soo">Sue</sub>.</speak>`},
+		{name: "pronunciations as text", text: "Hi Sue.", voice: plainVoice, opts: SpeechOptions{Pronunciations: pronunciations},
+			wantText: "Hi Soo."},
+		{name: "pauses for a plain-text voice", text: "Hi. Bye.", voice: plainVoice, opts: SpeechOptions{SentencePause: time.Second},
+			wantText: "Hi. … Bye."},
+		{name: "SSML markup", text: `Hi<break time="1s"/>there`, voice: ssmlVoice, opts: SpeechOptions{SSML: true},
+			wantSSML: `<speak>Hi<break time="1s"/>there</speak>`},
+		{name: "SSML for a plain-text voice", text: `Hi <sub alias="Soo">Sue</sub><break time="1s"/>Bye`, voice: plainVoice, opts: SpeechOptions{SSML: true},
+			wantText: "Hi Soo … Bye"},
+		{name: "invalid SSML", text: `<audio src="x"/>`, voice: ssmlVoice, opts: SpeechOptions{SSML: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := synthesisInput(tt.text, tt.voice, tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidSSML) {
+					t.Fatalf("synthesisInput error = %v, want errInvalidSSML", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("synthesisInput: %v", err)
+			}
+			if in.GetSsml() != tt.wantSSML || in.GetText() != tt.wantText {
+				t.Errorf("synthesisInput = ssml %q, text %q; want ssml %q, text %q", in.GetSsml(), in.GetText(), tt.wantSSML, tt.wantText)
+			}
+			wantKey := tt.wantText
+			if tt.wantSSML != "" {
+				wantKey = "ssml:" + tt.wantSSML
+			}
+			if got := synthesisInputText(in); got != wantKey {
+				t.Errorf("synthesisInputText = %q, want %q", got, wantKey)
+			}
+		})
+	}
+}
+
+// Narration in the default voice, which takes no SSML, still gets the
+// glossary respellings and sentence pauses.
+func TestSynthesisInputDefaultVoice(t *testing.T) {
+	voice, _ := resolveGoogleTTSVoice("", "")
+	if voice != DefaultGoogleTTSVoiceName || voiceSupportsSSML(voice) {
+		t.Fatalf("default voice %q: this test expects a plain-text voice", voice)
+	}
+	in, err := synthesisInput("Siobhan is here. She waves!", voice, SpeechOptions{
+		Pronunciations: map[string]string{"Siobhan": "Shi-vawn /ʃɪˈvɔːn/"},
+		SentencePause:  narrationSentencePause,
+	})
+	if err != nil {
+		t.Fatalf("synthesisInput: %v", err)
+	}
+	if want := "Shi-vawn is here. … She waves!"; in.GetText() != want || in.GetSsml() != "" {
+		t.Errorf("synthesisInput = ssml %q, text %q; want text %q", in.GetSsml(), in.GetText(), want)
+	}
+}
+
+func TestSplitPronunciation(t *testing.T) {
+	tests := map[string][2]string{
+		"Shi-vawn":               {"Shi-vawn", ""},
+		" Shi-vawn /ʃɪˈvɔːn/ ":   {"Shi-vawn", "ʃɪˈvɔːn"},
+		"/ʃɪˈvɔːn/":              {"", "ʃɪˈvɔːn"},
+		"either/or":              {"either/or", ""},
+		"AC/DC /eɪ siː diː siː/": {"AC/DC", "eɪ siː diː siː"},
+		"//":                     {"//", ""},
+		"":                       {"", ""},
+	}
+	for spoken, want := range tests {
+		respelling, ipa := splitPronunciation(spoken)
+		if respelling != want[0] || ipa != want[1] {
+			t.Errorf("splitPronunciation(%q) = %q, %q; want %q, %q", spoken, respelling, ipa, want[0], want[1])
+		}
+	}
+}
+
+func TestVoiceSupportsSSML(t *testing.T) {
+	tests := map[string]bool{
+		"en-US-Neural2-C":      true,
+		"en-US-Studio-O":       true,
+		"en-US-Journey-O":      false,
+		"en-US-Casual-K":       false,
+		"en-US-Chirp3-HD-Puck": false,
+		"en-US-Chirp-HD-F":     false,
+		"es-US-Neural2-A":      true,
+	}
+	for voice, want := range tests {
+		if got := voiceSupportsSSML(voice); got != want {
+			t.Errorf("voiceSupportsSSML(%q) = %v, want %v", voice, got, want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package functions
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,9 +10,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	firebase "firebase.google.com/go/v4"
-	"firebase.google.com/go/v4/auth"
 )
 
 const (
@@ -43,10 +39,6 @@ func resolveClientLogSource(raw *string) string {
 }
 
 var (
-	clientLogAuthOnce sync.Once
-	clientLogAuth     *auth.Client
-	clientLogAuthErr  error
-
 	clientLogRateMu sync.Mutex
 	clientLogRate   = map[string][]time.Time{}
 
@@ -86,26 +78,6 @@ type clientDiagnosticBatch struct {
 	Entries        []clientDiagnosticEntry `json:"entries"`
 }
 
-func getClientLogAuth(ctx context.Context) (*auth.Client, error) {
-	clientLogAuthOnce.Do(func() {
-		projectID := os.Getenv("GCP_PROJECT")
-		if projectID == "" {
-			projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
-		}
-		if projectID == "" {
-			projectID = "reflections-1200b"
-		}
-		conf := &firebase.Config{ProjectID: projectID}
-		app, err := firebase.NewApp(ctx, conf)
-		if err != nil {
-			clientLogAuthErr = fmt.Errorf("firebase app: %w", err)
-			return
-		}
-		clientLogAuth, clientLogAuthErr = app.Auth(ctx)
-	})
-	return clientLogAuth, clientLogAuthErr
-}
-
 func allowClientLogBatch(uid string) bool {
 	now := time.Now()
 	cutoff := now.Add(-1 * time.Hour)
@@ -162,29 +134,12 @@ func SubmitClientLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "missing bearer token", http.StatusUnauthorized)
-		return
-	}
-	idToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
-	if idToken == "" {
-		http.Error(w, "missing bearer token", http.StatusUnauthorized)
-		return
-	}
-
-	ctx := r.Context()
-	authClient, err := getClientLogAuth(ctx)
-	if err != nil {
-		http.Error(w, "auth init failed", http.StatusInternalServerError)
-		return
-	}
-	decoded, err := authClient.VerifyIDToken(ctx, idToken)
+	uid, code, err := verifyBearerUID(r)
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		http.Error(w, err.Error(), code)
 		return
 	}
-	if !allowClientLogBatch(decoded.UID) {
+	if !allowClientLogBatch(uid) {
 		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 		return
 	}
@@ -235,7 +190,7 @@ func SubmitClientLogs(w http.ResponseWriter, r *http.Request) {
 			"source":         source,
 			"batchId":        batch.BatchID,
 			"installId":      batch.InstallID,
-			"firebaseUid":    decoded.UID,
+			"firebaseUid":    uid,
 			"companionName":  batch.CompanionName,
 			"explorerName":   batch.ExplorerName,
 			"explorerId":     batch.ExplorerID,
@@ -4,9 +4,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 const maxSynthesizeSpeechChars = 180
@@ -14,14 +19,26 @@ const maxSynthesizeSpeechChars = 180
 type synthesizeSpeechRequest struct {
 	Text  string `json:"text"`
 	Voice string `json:"voice"`
+	// ExplorerID attributes the characters to a circle's usage and budget.
+	// Older app builds omit it; their usage is recorded as unattributed.
+	ExplorerID string `json:"explorer_id"`
+	// SSML marks Text as SSML (see ssml.go); its plain reading is held to
+	// the same length limit.
+	SSML bool `json:"ssml,omitempty"`
+	// Format "url" asks for a presigned URL to the cached audio instead of
+	// bytes; audio that could not be cached is still returned as base64.
+	Format string `json:"format,omitempty"`
 }
 
 type synthesizeSpeechResponse struct {
-	AudioBase64 string `json:"audioBase64"`
+	AudioBase64 string `json:"audioBase64,omitempty"`
+	AudioURL    string `json:"audioUrl,omitempty"`
 }
 
-// SynthesizeSpeech generates ephemeral Google TTS audio and returns MP3 bytes as base64.
-// No S3 persistence — clients play and discard.
+// SynthesizeSpeech generates Google TTS audio and returns MP3 bytes as base64,
+// or a presigned URL when asked. Audio comes from the synthesis cache when
+// the same text and voice were spoken before (see tts_cache.go); clients play
+// and discard it.
 func SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -47,22 +64,61 @@ func SynthesizeSpeech(w http.ResponseWriter, r *http.Request) {
 	}
 
 	text := strings.TrimSpace(req.Text)
-	if text == "" || utf8.RuneCountInString(text) > maxSynthesizeSpeechChars {
+	spoken := text
+	if req.SSML {
+		var err error
+		if _, spoken, err = normalizeSSML(text); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if spoken == "" || utf8.RuneCountInString(spoken) > maxSynthesizeSpeechChars {
 		http.Error(w, "invalid text", http.StatusBadRequest)
 		return
 	}
 
+	ctx := r.Context()
+	explorerID := strings.TrimSpace(req.ExplorerID)
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		log.Printf("SynthesizeSpeech: Firestore unavailable, usage not recorded: %v", err)
+		client = nil
+	}
+	if explorerID != "" {
+		if budget := checkAIBudget(ctx, client, explorerID); budget.Action != "" {
+			// Like feedback is audio only, so text-only means no speech at all.
+			http.Error(w, budget.Reason, http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	voice := sanitizeGoogleTTSVoice(req.Voice)
-	speechData, err := GenerateSpeechWithOptions(text, SpeechOptions{
-		VoiceName: voice,
+	speech, err := GenerateCachedSpeech(ctx, client, AIUsage{ExplorerID: explorerID, Source: "synthesize-speech"}, text, SpeechOptions{
+		VoiceName:      voice,
+		Pronunciations: speechPronunciations(ctx, client, explorerID),
+		SSML:           req.SSML,
 	})
-	if err != nil || len(speechData) == 0 {
+	if err != nil || speech == nil || len(speech.Audio) == 0 {
 		http.Error(w, "synthesis failed", http.StatusInternalServerError)
 		return
 	}
 
+	var resp synthesizeSpeechResponse
+	if req.Format == "url" && speech.S3Key != "" {
+		if s3Client, err := awsS3Client(ctx); err == nil {
+			presigned, err := s3.NewPresignClient(s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(mediaBucket),
+				Key:    aws.String(speech.S3Key),
+			}, s3.WithPresignExpires(15*time.Minute))
+			if err == nil {
+				resp.AudioURL = presigned.URL
+			}
+		}
+	}
+	if resp.AudioURL == "" {
+		resp.AudioBase64 = base64.StdEncoding.EncodeToString(speech.Audio)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(synthesizeSpeechResponse{
-		AudioBase64: base64.StdEncoding.EncodeToString(speechData),
-	})
+	json.NewEncoder(w).Encode(resp)
 }
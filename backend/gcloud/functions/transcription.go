@@ -0,0 +1,305 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	TranscriptionProviderGemini  = "gemini"
+	TranscriptionProviderWhisper = "whisper"
+	TranscriptionProviderFake    = "fake"
+
+	// Voice notes are short m4a clips; this also keeps Gemini requests under
+	// its inline data limit.
+	maxTranscriptionAudioBytes = 20 << 20
+
+	// Any server exposing the OpenAI audio API: faster-whisper-server and
+	// speaches listen on :8000/v1; whisper.cpp needs its --inference-path set.
+	defaultWhisperBaseURL = "http://localhost:8000/v1"
+	defaultWhisperModel   = "whisper-1"
+	whisperTimeout        = 2 * time.Minute
+
+	transcriptionPrompt = `Transcribe this voice note exactly as spoken, in the language spoken.
+Do not summarise, translate or add anything. If nothing is said, return an empty text.
+Return JSON with "text" (the transcript) and "language" (its BCP-47 code, e.g. "en-US").`
+)
+
+// TranscriptionRequest is one audio clip to transcribe.
+type TranscriptionRequest struct {
+	Audio []byte
+	// MIME is the audio's content type; empty means "audio/mp4" (m4a).
+	MIME string
+}
+
+// Transcript is what a Transcriber heard.
+type Transcript struct {
+	Text string
+	// Language is a BCP-47 code when the provider reports one.
+	Language string
+	// Usage counts model tokens for providers that bill by token.
+	Usage CaptionUsage
+}
+
+// Transcriber turns speech into text. Implementations are selected by
+// NewTranscriber, mirroring CaptionModel.
+type Transcriber interface {
+	// Name identifies the provider and model, e.g. "whisper/whisper-1".
+	Name() string
+	Transcribe(ctx context.Context, req TranscriptionRequest) (*Transcript, error)
+	Close() error
+}
+
+// TranscriberConfig selects and configures a Transcriber.
+type TranscriberConfig struct {
+	Provider string // gemini (default), whisper or fake
+	Model    string // provider-specific model name; empty uses the provider default
+	APIKey   string // Gemini API key, or optional bearer token for whisper
+	BaseURL  string // whisper only: server root including /v1
+}
+
+// TranscriberConfigFromEnv reads TRANSCRIPTION_PROVIDER, TRANSCRIPTION_MODEL,
+// TRANSCRIPTION_BASE_URL and TRANSCRIPTION_API_KEY, falling back to
+// GEMINI_API_KEY for the Gemini provider.
+func TranscriberConfigFromEnv() TranscriberConfig {
+	cfg := TranscriberConfig{
+		Provider: strings.ToLower(strings.TrimSpace(os.Getenv("TRANSCRIPTION_PROVIDER"))),
+		Model:    strings.TrimSpace(os.Getenv("TRANSCRIPTION_MODEL")),
+		APIKey:   os.Getenv("TRANSCRIPTION_API_KEY"),
+		BaseURL:  strings.TrimSpace(os.Getenv("TRANSCRIPTION_BASE_URL")),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = TranscriptionProviderGemini
+	}
+	if cfg.APIKey == "" && cfg.Provider == TranscriptionProviderGemini {
+		cfg.APIKey = os.Getenv("GEMINI_API_KEY")
+	}
+	return cfg
+}
+
+// NewTranscriber builds the Transcriber described by cfg.
+func NewTranscriber(ctx context.Context, cfg TranscriberConfig) (Transcriber, error) {
+	switch cfg.Provider {
+	case "", TranscriptionProviderGemini:
+		return NewGeminiTranscriber(ctx, cfg.APIKey, cfg.Model)
+	case TranscriptionProviderWhisper:
+		return NewWhisperTranscriber(cfg.BaseURL, cfg.Model, cfg.APIKey), nil
+	case TranscriptionProviderFake:
+		return &FakeTranscriber{}, nil
+	default:
+		return nil, fmt.Errorf("unknown transcription provider %q", cfg.Provider)
+	}
+}
+
+func transcriptionAudioMIME(req TranscriptionRequest) string {
+	if req.MIME == "" {
+		return "audio/mp4"
+	}
+	return req.MIME
+}
+
+// GeminiTranscriber transcribes with a multimodal Gemini model.
+type GeminiTranscriber struct {
+	clients   *sharedClient[*genai.Client]
+	modelName string
+}
+
+func NewGeminiTranscriber(ctx context.Context, apiKey, modelName string) (*GeminiTranscriber, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not configured")
+	}
+	if modelName == "" {
+		modelName = DefaultGeminiCaptionModel
+	}
+	clients := geminiClientFor(apiKey)
+	if _, err := clients.get(ctx); err != nil {
+		return nil, err
+	}
+	return &GeminiTranscriber{clients: clients, modelName: modelName}, nil
+}
+
+func (t *GeminiTranscriber) Name() string { return TranscriptionProviderGemini + "/" + t.modelName }
+
+// Close is a no-op: the Gemini client is shared (see clients.go).
+func (t *GeminiTranscriber) Close() error { return nil }
+
+func (t *GeminiTranscriber) Transcribe(ctx context.Context, req TranscriptionRequest) (*Transcript, error) {
+	client, err := t.clients.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model := client.GenerativeModel(t.modelName)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"text":     {Type: genai.TypeString},
+			"language": {Type: genai.TypeString},
+		},
+		Required: []string{"text", "language"},
+	}
+	resp, err := CallWithRetry(ctx, t.Name(), RetryPolicyFromEnv(), func(ctx context.Context) (*genai.GenerateContentResponse, error) {
+		resp, err := model.GenerateContent(ctx,
+			genai.Text(transcriptionPrompt),
+			genai.Blob{MIMEType: transcriptionAudioMIME(req), Data: req.Audio},
+		)
+		t.clients.report(client, err)
+		return resp, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gemini transcribe: %w", err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no response from Gemini")
+	}
+	text, ok := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Gemini response type %T", resp.Candidates[0].Content.Parts[0])
+	}
+	var reply struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal([]byte(text), &reply); err != nil {
+		return nil, fmt.Errorf("decode Gemini transcript: %w", err)
+	}
+	transcript := &Transcript{Text: strings.TrimSpace(reply.Text), Language: reply.Language}
+	if resp.UsageMetadata != nil {
+		transcript.Usage.InputTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		transcript.Usage.OutputTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	return transcript, nil
+}
+
+// WhisperTranscriber transcribes through any server speaking the OpenAI
+// audio transcription API, typically a local Whisper.
+type WhisperTranscriber struct {
+	baseURL    string
+	modelName  string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewWhisperTranscriber(baseURL, modelName, apiKey string) *WhisperTranscriber {
+	if baseURL == "" {
+		baseURL = defaultWhisperBaseURL
+	}
+	if modelName == "" {
+		modelName = defaultWhisperModel
+	}
+	return &WhisperTranscriber{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		modelName:  modelName,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: whisperTimeout},
+	}
+}
+
+func (t *WhisperTranscriber) Name() string { return TranscriptionProviderWhisper + "/" + t.modelName }
+
+func (t *WhisperTranscriber) Close() error { return nil }
+
+func (t *WhisperTranscriber) Transcribe(ctx context.Context, req TranscriptionRequest) (*Transcript, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "audio.m4a")
+	if err != nil {
+		return nil, fmt.Errorf("build transcription request: %w", err)
+	}
+	part.Write(req.Audio)
+	form.WriteField("model", t.modelName)
+	form.WriteField("response_format", "verbose_json")
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("build transcription request: %w", err)
+	}
+
+	respBody, err := CallWithRetry(ctx, t.Name(), RetryPolicyFromEnv(), func(ctx context.Context) ([]byte, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/audio/transcriptions", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("build transcription request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", form.FormDataContentType())
+		if t.apiKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+t.apiKey)
+		}
+
+		res, err := t.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("transcription request: %w", err)
+		}
+		defer res.Body.Close()
+		respBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read transcription response: %w", err)
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("transcription request: %w", newHTTPStatusError(res, respBody))
+		}
+		return respBody, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal(respBody, &reply); err != nil {
+		return nil, fmt.Errorf("decode transcription response: %w", err)
+	}
+	return &Transcript{Text: strings.TrimSpace(reply.Text), Language: reply.Language}, nil
+}
+
+// FakeTranscriber is a deterministic Transcriber for tests and offline
+// runs. With no Response set, the text is derived from the audio hash.
+type FakeTranscriber struct {
+	Response *Transcript
+	Err      error
+
+	mu       sync.Mutex
+	requests []TranscriptionRequest
+}
+
+func (t *FakeTranscriber) Name() string { return TranscriptionProviderFake }
+
+func (t *FakeTranscriber) Close() error { return nil }
+
+func (t *FakeTranscriber) Transcribe(ctx context.Context, req TranscriptionRequest) (*Transcript, error) {
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.mu.Unlock()
+
+	if t.Err != nil {
+		return nil, t.Err
+	}
+	if t.Response != nil {
+		transcript := *t.Response
+		return &transcript, nil
+	}
+	sum := sha256.Sum256(req.Audio)
+	return &Transcript{
+		Text:     fmt.Sprintf("Hi, this is voice note %s. I love you!", hex.EncodeToString(sum[:4])),
+		Language: "en-US",
+	}, nil
+}
+
+// Requests returns the calls made so far, oldest first.
+func (t *FakeTranscriber) Requests() []TranscriptionRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TranscriptionRequest(nil), t.requests...)
+}
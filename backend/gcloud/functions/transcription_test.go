@@ -0,0 +1,159 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFakeTranscriber(t *testing.T) {
+	ctx := context.Background()
+	tr := &FakeTranscriber{}
+	first, err := tr.Transcribe(ctx, TranscriptionRequest{Audio: []byte("one")})
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	again, _ := tr.Transcribe(ctx, TranscriptionRequest{Audio: []byte("one")})
+	other, _ := tr.Transcribe(ctx, TranscriptionRequest{Audio: []byte("two")})
+	if first.Text != again.Text || first.Text == other.Text {
+		t.Errorf("transcripts not derived from the audio: %q, %q, %q", first.Text, again.Text, other.Text)
+	}
+	if got := len(tr.Requests()); got != 3 {
+		t.Errorf("Requests() has %d calls, want 3", got)
+	}
+
+	fixed := &FakeTranscriber{Response: &Transcript{Text: "Hello."}}
+	transcript, _ := fixed.Transcribe(ctx, TranscriptionRequest{})
+	transcript.Text = "changed"
+	if fixed.Response.Text != "Hello." {
+		t.Error("Transcribe returned the Response itself instead of a copy")
+	}
+	if _, err := (&FakeTranscriber{Err: errors.New("boom")}).Transcribe(ctx, TranscriptionRequest{}); err == nil {
+		t.Error("Transcribe with Err set returned no error")
+	}
+}
+
+func TestWhisperTranscriber(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if calls == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("FormFile: %v", err)
+			return
+		}
+		audio, _ := io.ReadAll(file)
+		if string(audio) != "audio bytes" || r.FormValue("model") != "whisper-large" || r.FormValue("response_format") != "verbose_json" {
+			t.Errorf("form = audio %q, model %q, format %q", audio, r.FormValue("model"), r.FormValue("response_format"))
+		}
+		json.NewEncoder(w).Encode(map[string]string{"text": "  Hello there. ", "language": "en"})
+	}))
+	defer server.Close()
+	t.Setenv("AI_RETRY_MAX_ATTEMPTS", "2")
+	t.Setenv("AI_RETRY_BASE_DELAY_MS", "1")
+	t.Setenv("AI_RETRY_MAX_DELAY_MS", "1")
+
+	tr := NewWhisperTranscriber(server.URL+"/v1/", "whisper-large", "secret")
+	t.Cleanup(func() {
+		circuitBreakersMu.Lock()
+		delete(circuitBreakers, tr.Name())
+		circuitBreakersMu.Unlock()
+	})
+	transcript, err := tr.Transcribe(context.Background(), TranscriptionRequest{Audio: []byte("audio bytes")})
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if transcript.Text != "Hello there." || transcript.Language != "en" {
+		t.Errorf("transcript = %+v", transcript)
+	}
+	if calls != 2 {
+		t.Errorf("server saw %d calls, want 2 (one retry)", calls)
+	}
+}
+
+func TestTranscriberConfigFromEnv(t *testing.T) {
+	t.Setenv("TRANSCRIPTION_PROVIDER", "")
+	t.Setenv("TRANSCRIPTION_MODEL", "")
+	t.Setenv("TRANSCRIPTION_API_KEY", "")
+	t.Setenv("TRANSCRIPTION_BASE_URL", "")
+	t.Setenv("GEMINI_API_KEY", "gemini-key")
+	if got, want := TranscriberConfigFromEnv(), (TranscriberConfig{Provider: TranscriptionProviderGemini, APIKey: "gemini-key"}); got != want {
+		t.Errorf("default config = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("TRANSCRIPTION_PROVIDER", " Whisper ")
+	t.Setenv("TRANSCRIPTION_BASE_URL", "http://whisper:8000/v1")
+	if got, want := TranscriberConfigFromEnv(), (TranscriberConfig{Provider: TranscriptionProviderWhisper, BaseURL: "http://whisper:8000/v1"}); got != want {
+		t.Errorf("whisper config = %+v, want %+v", got, want)
+	}
+
+	if _, err := NewTranscriber(context.Background(), TranscriberConfig{Provider: "parrot"}); err == nil {
+		t.Error("NewTranscriber accepted an unknown provider")
+	}
+}
+
+func TestTranscriptTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Hi! We went to the zoo, the ZOO.", []string{"went", "the", "zoo"}},
+		{"Grandma's garden, 2024", []string{"grandma's", "garden", "2024"}},
+		{"'Quoted' words", []string{"quoted", "words"}},
+	}
+	for _, tt := range tests {
+		if got := transcriptTerms(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("transcriptTerms(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	var many string
+	for i := 0; i < maxTranscriptTerms+20; i++ {
+		many += strings.Repeat("x", 3+i) + " "
+	}
+	if got := len(transcriptTerms(many)); got != maxTranscriptTerms {
+		t.Errorf("transcriptTerms kept %d terms, want %d", got, maxTranscriptTerms)
+	}
+}
+
+func TestLoadVoiceNoteRejects(t *testing.T) {
+	for _, key := range []string{
+		"explorer-2/to/1/audio.m4a",
+		"explorer-1/../explorer-2/to/1/audio.m4a",
+		"explorer-1/to/1/image.jpg",
+		"staging/1/audio.mp3",
+	} {
+		if _, err := loadVoiceNote(context.Background(), nil, "explorer-1", key); !errors.Is(err, errCaptionImageRejected) {
+			t.Errorf("loadVoiceNote(%q) = %v, want rejection", key, err)
+		}
+	}
+}
+
+func TestVoiceNoteFingerprint(t *testing.T) {
+	media := []byte("picture")
+	a := voiceNoteFingerprint(media, []byte("note a"))
+	b := voiceNoteFingerprint(media, []byte("note b"))
+	if string(a) == string(b) {
+		t.Error("different voice notes gave the same fingerprint")
+	}
+	if string(a) != string(voiceNoteFingerprint(media, []byte("note a"))) {
+		t.Error("fingerprint is not deterministic")
+	}
+}
@@ -3,9 +3,11 @@ package functions
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
 )
 
@@ -14,38 +16,78 @@ const (
 	DefaultGoogleTTSVoiceName    = "en-US-Journey-O"
 )
 
-// Limited allowlist keeps behavior predictable and avoids invalid user-supplied voices.
-var allowedGoogleTTSVoices = map[string]struct{}{
-	"en-US-Journey-F": {},
-	"en-US-Journey-D": {},
-	"en-US-Journey-O": {},
-	"en-US-Studio-O": {},
-	"en-US-Neural2-C": {},
-	"en-US-Studio-Q": {},
-	"en-US-Casual-K": {},
-	"en-US-Chirp3-HD-Puck": {},
-	"en-US-Chirp3-HD-Achird": {},
-	"en-US-Chirp3-HD-Sulafat": {},
-	"en-US-Chirp3-HD-Achernar": {},
-	"en-US-Chirp3-HD-Despina": {},
+// googleTTSVoiceCatalog lists the voices callers may choose, keyed by
+// language code (see captionLanguages). The first voice of each language is
+// its default. A limited catalog keeps behavior predictable and avoids
+// invalid user-supplied voices.
+var googleTTSVoiceCatalog = map[string][]string{
+	"en-US": {
+		DefaultGoogleTTSVoiceName,
+		"en-US-Journey-F",
+		"en-US-Journey-D",
+		"en-US-Studio-O",
+		"en-US-Neural2-C",
+		"en-US-Studio-Q",
+		"en-US-Casual-K",
+		"en-US-Chirp3-HD-Puck",
+		"en-US-Chirp3-HD-Achird",
+		"en-US-Chirp3-HD-Sulafat",
+		"en-US-Chirp3-HD-Achernar",
+		"en-US-Chirp3-HD-Despina",
+	},
+	"es-US":  {"es-US-Chirp3-HD-Achernar", "es-US-Chirp3-HD-Puck", "es-US-Neural2-A", "es-US-Neural2-B"},
+	"es-ES":  {"es-ES-Chirp3-HD-Achernar", "es-ES-Chirp3-HD-Puck"},
+	"fr-FR":  {"fr-FR-Chirp3-HD-Achernar", "fr-FR-Chirp3-HD-Puck"},
+	"de-DE":  {"de-DE-Chirp3-HD-Achernar", "de-DE-Chirp3-HD-Puck"},
+	"it-IT":  {"it-IT-Chirp3-HD-Achernar", "it-IT-Chirp3-HD-Puck"},
+	"pt-BR":  {"pt-BR-Chirp3-HD-Achernar", "pt-BR-Chirp3-HD-Puck"},
+	"hi-IN":  {"hi-IN-Chirp3-HD-Achernar", "hi-IN-Chirp3-HD-Puck"},
+	"cmn-CN": {"cmn-CN-Chirp3-HD-Achernar", "cmn-CN-Chirp3-HD-Puck"},
+	"ja-JP":  {"ja-JP-Chirp3-HD-Achernar", "ja-JP-Chirp3-HD-Puck"},
+	"ko-KR":  {"ko-KR-Chirp3-HD-Achernar", "ko-KR-Chirp3-HD-Puck"},
+	"vi-VN":  {"vi-VN-Chirp3-HD-Achernar", "vi-VN-Chirp3-HD-Puck"},
+	"ar-XA":  {"ar-XA-Chirp3-HD-Achernar", "ar-XA-Chirp3-HD-Puck"},
 }
 
+// allowedGoogleTTSVoices maps every catalog voice to its language.
+var allowedGoogleTTSVoices = func() map[string]string {
+	voices := map[string]string{}
+	for language, names := range googleTTSVoiceCatalog {
+		for _, name := range names {
+			voices[name] = language
+		}
+	}
+	return voices
+}()
+
 // SpeechOptions allows callers to customize synthesis while keeping backward compatibility.
 type SpeechOptions struct {
-	VoiceName    string
+	VoiceName string
+	// LanguageCode picks the language spoken; a VoiceName from another
+	// language is replaced by this language's default voice. Empty uses the
+	// voice's own language.
 	LanguageCode string
+	// Pronunciations maps names to how they should be spoken (see the
+	// explorer glossary); each whole-word match is replaced before synthesis,
+	// or marked up with <sub> or <phoneme> for voices that take SSML. They
+	// do not apply to SSML text, which carries its own.
+	Pronunciations map[string]string
+	// SSML marks the text as SSML in the subset ssml.go allows; anything
+	// else fails with errInvalidSSML.
+	SSML bool
+	// SentencePause adds a pause after each sentence of plain text: a
+	// <break> for voices that take SSML, an ellipsis for the others.
+	SentencePause time.Duration
+	// SpeakingRate is Google's speaking_rate (0.25 to 4.0); zero is the
+	// voice's normal speed.
+	SpeakingRate float64
 }
 
 // GenerateSpeech calls Google Cloud Text-to-Speech and returns MP3 audio bytes.
+// Transient errors are retried through CallWithRetry.
 func GenerateSpeech(text string) ([]byte, error) {
 	ctx := context.Background()
 
-	client, err := texttospeech.NewClient(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Google TTS client: %w", err)
-	}
-	defer client.Close()
-
 	req := &texttospeechpb.SynthesizeSpeechRequest{
 		Input: &texttospeechpb.SynthesisInput{
 			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
@@ -59,14 +101,57 @@ func GenerateSpeech(text string) ([]byte, error) {
 		},
 	}
 
-	resp, err := client.SynthesizeSpeech(ctx, req)
+	resp, err := synthesizeWithSharedClient(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+		return nil, err
 	}
 
 	return resp.AudioContent, nil
 }
 
+// synthesizeWithSharedClient sends req with the shared TTS client (see
+// clients.go), retrying transient errors through CallWithRetry. Each
+// attempt fetches the client again, so a retry after a reconnect uses the
+// new one.
+func synthesizeWithSharedClient(ctx context.Context, req *texttospeechpb.SynthesizeSpeechRequest) (*texttospeechpb.SynthesizeSpeechResponse, error) {
+	resp, err := CallWithRetry(ctx, googleTTSDependency, RetryPolicyFromEnv(), func(ctx context.Context) (*texttospeechpb.SynthesizeSpeechResponse, error) {
+		client, err := ttsClients.get(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Google TTS client: %w", err)
+		}
+		resp, err := client.SynthesizeSpeech(ctx, req)
+		ttsClients.report(client, err)
+		return resp, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	return resp, nil
+}
+
+// applyPronunciations replaces whole-word, case-insensitive matches of each
+// name in text with its pronunciation, longest names first so "Grandma Sue"
+// wins over "Sue". Only the respelling of a pronunciation is spoken; names
+// whose pronunciation is IPA alone are left as written.
+func applyPronunciations(text string, pronunciations map[string]string) string {
+	names := make([]string, 0, len(pronunciations))
+	respellings := make(map[string]string, len(pronunciations))
+	for name, spoken := range pronunciations {
+		respelling, _ := splitPronunciation(spoken)
+		if strings.TrimSpace(name) != "" && respelling != "" {
+			names = append(names, name)
+			respellings[name] = respelling
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		pattern := regexp.MustCompile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(name) + `($|[^\pL\pN])`)
+		spoken := strings.ReplaceAll(respellings[name], "$", "$$")
+		text = pattern.ReplaceAllString(text, "${1}"+spoken+"${2}")
+	}
+	return text
+}
+
 func sanitizeGoogleTTSVoice(voiceName string) string {
 	normalized := strings.TrimSpace(voiceName)
 	if normalized == "" {
@@ -78,40 +163,48 @@ func sanitizeGoogleTTSVoice(voiceName string) string {
 	return DefaultGoogleTTSVoiceName
 }
 
-func sanitizeGoogleTTSLanguage(languageCode string) string {
-	normalized := strings.TrimSpace(languageCode)
-	if normalized == "" {
-		return DefaultGoogleTTSLanguageCode
+// resolveGoogleTTSVoice returns the voice and language to synthesize with.
+// The requested voice is kept when it is in the catalog and speaks the
+// requested language; otherwise that language's default voice is used.
+// Unsupported languages fall back to English.
+func resolveGoogleTTSVoice(voiceName, languageCode string) (string, string) {
+	voiceName = strings.TrimSpace(voiceName)
+	voiceLanguage, allowed := allowedGoogleTTSVoices[voiceName]
+	language, ok := normalizeLanguage(languageCode)
+	if allowed && (!ok || language == voiceLanguage) {
+		return voiceName, voiceLanguage
+	}
+	if !ok {
+		language = DefaultGoogleTTSLanguageCode
 	}
-	return normalized
+	return googleTTSVoiceCatalog[language][0], language
 }
 
 // GenerateSpeechWithOptions allows voice/language overrides without breaking existing callers.
+// Transient errors are retried through CallWithRetry.
 func GenerateSpeechWithOptions(text string, opts SpeechOptions) ([]byte, error) {
 	ctx := context.Background()
 
-	client, err := texttospeech.NewClient(ctx)
+	voice, language := resolveGoogleTTSVoice(opts.VoiceName, opts.LanguageCode)
+	input, err := synthesisInput(text, voice, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Google TTS client: %w", err)
+		return nil, err
 	}
-	defer client.Close()
-
 	req := &texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{Text: text},
-		},
+		Input: input,
 		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: sanitizeGoogleTTSLanguage(opts.LanguageCode),
-			Name:         sanitizeGoogleTTSVoice(opts.VoiceName),
+			LanguageCode: language,
+			Name:         voice,
 		},
 		AudioConfig: &texttospeechpb.AudioConfig{
 			AudioEncoding: texttospeechpb.AudioEncoding_MP3,
+			SpeakingRate:  opts.SpeakingRate,
 		},
 	}
 
-	resp, err := client.SynthesizeSpeech(ctx, req)
+	resp, err := synthesizeWithSharedClient(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+		return nil, err
 	}
 
 	return resp.AudioContent, nil
@@ -0,0 +1,316 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Synthesized speech is cached by what was spoken and how: the same text in
+// the same voice, language, encoding and rate always sounds the same, so
+// repeated greetings and captions are served from the bucket instead of
+// calling Google. Captions and greetings name people, so the cache is
+// scoped to a circle: audio lives at tts-cache/{explorerId}/{key}.mp3, and
+// the tts_cache collection indexes it with the Explorer, the companion whose
+// request stored it and the last use, which drives eviction. Deleting a
+// companion removes the entries they stored; deleting a circle removes all
+// of its entries. Calls not attributed to an Explorer are never cached.
+//
+// Entries expire TTS_CACHE_TTL_DAYS after their last use and the least
+// recently used ones are evicted beyond TTS_CACHE_MAX_ENTRIES. Eviction
+// deletes the audio together with its index document, so the collection
+// must not get a Firestore TTL policy, which would orphan the audio.
+
+const (
+	speechCacheCollection = "tts_cache"
+	speechCachePrefix     = "tts-cache/"
+
+	defaultSpeechCacheTTL        = 30 * 24 * time.Hour
+	defaultSpeechCacheMaxEntries = 5000
+	// Sweeps run after a store, at most this often per instance.
+	speechCacheSweepInterval = 10 * time.Minute
+	speechCacheSweepBatch    = 100
+	maxSpeechCacheBytes      = 4 << 20
+
+	speechEncodingMP3 = "MP3"
+)
+
+// speechCacheEntry is one tts_cache document.
+type speechCacheEntry struct {
+	ExplorerID  string    `firestore:"explorerId"`
+	CompanionID string    `firestore:"companionId,omitempty"`
+	S3Key       string    `firestore:"s3Key"`
+	Voice       string    `firestore:"voice"`
+	Language    string    `firestore:"language"`
+	Encoding    string    `firestore:"encoding"`
+	Rate        float64   `firestore:"rate"`
+	Characters  int64     `firestore:"characters"`
+	Bytes       int64     `firestore:"bytes"`
+	Hits        int64     `firestore:"hits"`
+	CreatedAt   time.Time `firestore:"createdAt,serverTimestamp"`
+	LastUsedAt  time.Time `firestore:"lastUsedAt"`
+	ExpiresAt   time.Time `firestore:"expiresAt"`
+}
+
+// CachedSpeech is synthesized audio and where the cache keeps it. S3Key is
+// empty when the audio could not be cached.
+type CachedSpeech struct {
+	Audio []byte
+	S3Key string
+	// Hit is true when the audio came from the cache, without calling Google.
+	Hit bool
+}
+
+var speechCacheStats struct {
+	hits, misses atomic.Int64
+	lastSweep    atomic.Int64
+}
+
+// speechCacheTTL reads TTS_CACHE_TTL_DAYS. Zero disables the cache.
+func speechCacheTTL() time.Duration {
+	raw := os.Getenv("TTS_CACHE_TTL_DAYS")
+	if raw == "" {
+		return defaultSpeechCacheTTL
+	}
+	days, err := strconv.ParseFloat(raw, 64)
+	if err != nil || days < 0 {
+		return defaultSpeechCacheTTL
+	}
+	return time.Duration(days * float64(24*time.Hour))
+}
+
+// speechCacheKey identifies audio by the circle it was spoken in and exactly
+// what Google would be asked to synthesize.
+func speechCacheKey(explorerID, text, voice, language, encoding string, rate float64) string {
+	h := sha256.New()
+	for _, part := range []string{explorerID, text, voice, language, encoding, strconv.FormatFloat(rate, 'f', -1, 64)} {
+		fmt.Fprintf(h, "%d:%s|", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func speechCacheObjectKey(explorerID, key string) string {
+	return speechCacheExplorerPrefix(explorerID) + key + ".mp3"
+}
+
+// speechCacheExplorerPrefix is where an Explorer's cached audio lives.
+func speechCacheExplorerPrefix(explorerID string) string {
+	return speechCachePrefix + explorerID + "/"
+}
+
+// logSpeechCacheLookup counts a lookup and logs this instance's running hit rate.
+func logSpeechCacheLookup(hit bool, key string) {
+	outcome := "miss"
+	if hit {
+		outcome = "hit"
+		speechCacheStats.hits.Add(1)
+	} else {
+		speechCacheStats.misses.Add(1)
+	}
+	hits, misses := speechCacheStats.hits.Load(), speechCacheStats.misses.Load()
+	log.Printf("TTS cache %s %s (instance hit rate %.0f%%, %d/%d)", outcome, key[:12], 100*float64(hits)/float64(hits+misses), hits, hits+misses)
+}
+
+// lookupSpeechCache returns the cached audio for key and marks the entry
+// used, or nil on a miss or any error (the cache is an optimisation, never
+// a dependency).
+func lookupSpeechCache(ctx context.Context, client *firestore.Client, s3Client *s3.Client, key string, ttl time.Duration) []byte {
+	ref := client.Collection(speechCacheCollection).Doc(key)
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			log.Printf("TTS cache lookup %s failed: %v", key, err)
+		}
+		return nil
+	}
+	var entry speechCacheEntry
+	if err := snap.DataTo(&entry); err != nil {
+		log.Printf("TTS cache decode %s failed: %v", key, err)
+		return nil
+	}
+	if !entry.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	audio, err := readS3Object(ctx, s3Client, entry.S3Key, "cached speech", maxSpeechCacheBytes)
+	if err != nil || len(audio) == 0 {
+		// Evicted underneath us; the miss re-synthesizes and re-stores it.
+		log.Printf("TTS cache audio for %s unavailable: %v", key, err)
+		return nil
+	}
+	now := time.Now()
+	if _, err := ref.Update(ctx, []firestore.Update{
+		{Path: "lastUsedAt", Value: now},
+		{Path: "expiresAt", Value: now.Add(ttl)},
+		{Path: "hits", Value: firestore.Increment(1)},
+	}); err != nil {
+		log.Printf("TTS cache touch %s failed: %v", key, err)
+	}
+	return audio
+}
+
+// storeSpeechCache uploads audio and indexes it, then sweeps if a sweep is
+// due. It returns the audio's S3 key, or "" when it could not be stored.
+func storeSpeechCache(ctx context.Context, client *firestore.Client, s3Client *s3.Client, key string, entry speechCacheEntry, audio []byte, ttl time.Duration) string {
+	entry.S3Key = speechCacheObjectKey(entry.ExplorerID, key)
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(mediaBucket),
+		Key:         aws.String(entry.S3Key),
+		Body:        bytes.NewReader(audio),
+		ContentType: aws.String("audio/mpeg"),
+	}); err != nil {
+		log.Printf("TTS cache upload %s failed: %v", key, err)
+		return ""
+	}
+	now := time.Now()
+	entry.Bytes = int64(len(audio))
+	entry.LastUsedAt = now
+	entry.ExpiresAt = now.Add(ttl)
+	if _, err := client.Collection(speechCacheCollection).Doc(key).Set(ctx, entry); err != nil {
+		log.Printf("TTS cache store %s failed: %v", key, err)
+		return ""
+	}
+
+	last := speechCacheStats.lastSweep.Load()
+	if now.UnixNano()-last >= int64(speechCacheSweepInterval) && speechCacheStats.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		sweepSpeechCache(ctx, client, s3Client, envInt("TTS_CACHE_MAX_ENTRIES", defaultSpeechCacheMaxEntries))
+	}
+	return entry.S3Key
+}
+
+// sweepSpeechCache deletes expired entries, then the least recently used
+// ones beyond maxEntries, a batch of each at a time.
+func sweepSpeechCache(ctx context.Context, client *firestore.Client, s3Client *s3.Client, maxEntries int) {
+	col := client.Collection(speechCacheCollection)
+	evict := func(reason string, docs []*firestore.DocumentSnapshot) {
+		for _, doc := range docs {
+			objectKey, _ := doc.DataAt("s3Key")
+			if key, ok := objectKey.(string); ok && key != "" {
+				if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(mediaBucket), Key: aws.String(key)}); err != nil {
+					log.Printf("TTS cache delete audio %s failed: %v", key, err)
+					continue
+				}
+			}
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				log.Printf("TTS cache delete %s failed: %v", doc.Ref.ID, err)
+			}
+		}
+		if len(docs) > 0 {
+			log.Printf("TTS cache evicted %d %s entries", len(docs), reason)
+		}
+	}
+
+	expired, err := col.Where("expiresAt", "<", time.Now()).Limit(speechCacheSweepBatch).Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("TTS cache expired query failed: %v", err)
+		return
+	}
+	evict("expired", expired)
+
+	result, err := col.NewAggregationQuery().WithCount("entries").Get(ctx)
+	if err != nil {
+		log.Printf("TTS cache count failed: %v", err)
+		return
+	}
+	count, ok := result["entries"].(*firestorepb.Value)
+	if !ok {
+		return
+	}
+	excess := int(count.GetIntegerValue()) - maxEntries
+	if excess <= 0 {
+		return
+	}
+	oldest, err := col.OrderBy("lastUsedAt", firestore.Asc).Limit(min(excess, speechCacheSweepBatch)).Documents(ctx).GetAll()
+	if err != nil {
+		log.Printf("TTS cache LRU query failed: %v", err)
+		return
+	}
+	evict("least recently used", oldest)
+}
+
+// GenerateCachedSpeech is GenerateMeteredSpeech with the synthesis cache in
+// front of it. Hits are not metered, since Google is not called. Without a
+// Firestore client or an Explorer ID, or with TTS_CACHE_TTL_DAYS=0, every
+// call synthesizes.
+func GenerateCachedSpeech(ctx context.Context, client *firestore.Client, u AIUsage, text string, opts SpeechOptions) (*CachedSpeech, error) {
+	ttl := speechCacheTTL()
+	var s3Client *s3.Client
+	if client != nil && u.ExplorerID != "" && ttl > 0 {
+		var err error
+		if s3Client, err = awsS3Client(ctx); err != nil {
+			log.Printf("TTS cache S3 unavailable, not caching: %v", err)
+		}
+	}
+	if s3Client == nil {
+		audio, err := generateMeteredSpeech(ctx, client, u, text, opts)
+		return &CachedSpeech{Audio: audio}, err
+	}
+
+	voice, language := resolveGoogleTTSVoice(opts.VoiceName, opts.LanguageCode)
+	input, err := synthesisInput(text, voice, opts)
+	if err != nil {
+		return nil, err
+	}
+	key := speechCacheKey(u.ExplorerID, synthesisInputText(input), voice, language, speechEncodingMP3, opts.SpeakingRate)
+	if audio := lookupSpeechCache(ctx, client, s3Client, key, ttl); audio != nil {
+		logSpeechCacheLookup(true, key)
+		return &CachedSpeech{Audio: audio, S3Key: speechCacheObjectKey(u.ExplorerID, key), Hit: true}, nil
+	}
+	logSpeechCacheLookup(false, key)
+
+	audio, err := generateMeteredSpeech(ctx, client, u, text, opts)
+	if err != nil || len(audio) == 0 {
+		return &CachedSpeech{Audio: audio}, err
+	}
+	s3Key := storeSpeechCache(ctx, client, s3Client, key, speechCacheEntry{
+		ExplorerID:  u.ExplorerID,
+		CompanionID: u.CompanionID,
+		Voice:       voice,
+		Language:    language,
+		Encoding:    speechEncodingMP3,
+		Rate:        opts.SpeakingRate,
+		Characters:  int64(len([]rune(text))),
+	}, audio, ttl)
+	return &CachedSpeech{Audio: audio, S3Key: s3Key}, nil
+}
+
+// deleteSpeechCachePage deletes one page of cache entries matching q along
+// with their audio, for account and circle deletion.
+func deleteSpeechCachePage(ctx context.Context, client *firestore.Client, s3Client *s3.Client, job *deletionJob, q firestore.Query) error {
+	docs, err := queryPage(ctx, q)
+	if err != nil {
+		return fmt.Errorf("tts_cache query: %w", err)
+	}
+	var keys []string
+	refs := make([]*firestore.DocumentRef, 0, len(docs))
+	for _, doc := range docs {
+		if key, _ := doc.Data()["s3Key"].(string); key != "" {
+			keys = append(keys, key)
+		}
+		refs = append(refs, doc.Ref)
+	}
+	if err := deleteS3Keys(ctx, s3Client, job, keys); err != nil {
+		return err
+	}
+	if err := commitBatches(ctx, client, refs); err != nil {
+		return fmt.Errorf("tts_cache batch delete: %w", err)
+	}
+	job.Counts.SpeechCache += len(refs)
+	if len(refs) < deletionJobPageSize {
+		job.advancePhase()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpeechCacheKey(t *testing.T) {
+	base := speechCacheKey("explorer-1", "Good night, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0)
+	if base != speechCacheKey("explorer-1", "Good night, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0) {
+		t.Fatal("speechCacheKey is not deterministic")
+	}
+	if strings.Contains(base, "Nona") || len(base) != 64 {
+		t.Errorf("speechCacheKey = %q, want a hex digest that hides the text", base)
+	}
+
+	variants := map[string]string{
+		"explorer": speechCacheKey("explorer-2", "Good night, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0),
+		"text":     speechCacheKey("explorer-1", "Good morning, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0),
+		"voice":    speechCacheKey("explorer-1", "Good night, Nona", "en-US-Neural2-F", "en-US", speechEncodingMP3, 0),
+		"language": speechCacheKey("explorer-1", "Good night, Nona", "en-US-Journey-O", "en-GB", speechEncodingMP3, 0),
+		"encoding": speechCacheKey("explorer-1", "Good night, Nona", "en-US-Journey-O", "en-US", "OGG_OPUS", 0),
+		"rate":     speechCacheKey("explorer-1", "Good night, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0.9),
+		// Length prefixes keep parts from running into each other.
+		"boundary": speechCacheKey("explorer-1Good", " night, Nona", "en-US-Journey-O", "en-US", speechEncodingMP3, 0),
+	}
+	for name, key := range variants {
+		if key == base {
+			t.Errorf("changing the %s does not change the key", name)
+		}
+	}
+}
+
+func TestSpeechCacheObjectKey(t *testing.T) {
+	got := speechCacheObjectKey("explorer-1", "abc123")
+	if got != "tts-cache/explorer-1/abc123.mp3" {
+		t.Errorf("speechCacheObjectKey = %q", got)
+	}
+	// Circle deletion removes everything under the Explorer's prefix.
+	if !strings.HasPrefix(got, speechCacheExplorerPrefix("explorer-1")) {
+		t.Errorf("%q is outside %q", got, speechCacheExplorerPrefix("explorer-1"))
+	}
+	if strings.HasPrefix(speechCacheObjectKey("explorer-10", "abc123"), speechCacheExplorerPrefix("explorer-1")) {
+		t.Error("one Explorer's prefix covers another's audio")
+	}
+}
+
+func TestSpeechCacheTTL(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":     defaultSpeechCacheTTL,
+		"7":    7 * 24 * time.Hour,
+		"0.5":  12 * time.Hour,
+		"0":    0,
+		"-1":   defaultSpeechCacheTTL,
+		"week": defaultSpeechCacheTTL,
+	}
+	for raw, want := range tests {
+		t.Setenv("TTS_CACHE_TTL_DAYS", raw)
+		if got := speechCacheTTL(); got != want {
+			t.Errorf("speechCacheTTL with %q = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestSpeechCacheDeletionPhases(t *testing.T) {
+	for kind, want := range map[string][]string{
+		deletionJobKindCompanion:      {companionPhaseSpeechCache},
+		deletionJobKindExplorerCircle: {explorerCirclePhaseSpeechCache, explorerCirclePhaseSpeechAudio},
+	} {
+		for _, phase := range want {
+			found := false
+			for _, p := range deletionPhases[kind] {
+				found = found || p == phase
+			}
+			if !found {
+				t.Errorf("%s deletion never runs the %s phase", kind, phase)
+			}
+		}
+	}
+}
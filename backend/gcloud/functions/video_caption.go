@@ -0,0 +1,205 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	maxCaptionKeyframes = 6
+	// Gemini accepts inline request data up to 20 MB; larger clips fall back
+	// to keyframes.
+	maxCaptionVideoBytes      = 20 << 20
+	maxCaptionVideoDuration   = 60 * time.Second
+	maxCaptionTranscriptChars = 1500
+
+	CaptionVideoInputClip      = "clip"
+	CaptionVideoInputKeyframes = "keyframes"
+)
+
+// captionKeyframePattern matches keyframes the apps upload next to a video's
+// thumbnail: frame_{offsetMs}.jpg.
+var captionKeyframePattern = regexp.MustCompile(`^frame_(\d+)\.jpg$`)
+
+// CaptionFrame is one still from a video, offset from the start of the
+// (trimmed) clip.
+type CaptionFrame struct {
+	Image  []byte
+	MIME   string
+	Offset time.Duration
+}
+
+// captionVideo is the video material found next to a caption image.
+type captionVideo struct {
+	Frames     []CaptionFrame
+	Clip       []byte
+	ClipMIME   string
+	Duration   time.Duration
+	Transcript string
+}
+
+// Input names what the model is given: the clip or keyframes.
+func (v *captionVideo) Input() string {
+	if len(v.Clip) > 0 {
+		return CaptionVideoInputClip
+	}
+	return CaptionVideoInputKeyframes
+}
+
+// fingerprint identifies the video material for the caption cache.
+func (v *captionVideo) fingerprint(image []byte) []byte {
+	h := sha256.New()
+	h.Write(image)
+	for _, f := range v.Frames {
+		fmt.Fprintf(h, "|frame:%d:", f.Offset.Milliseconds())
+		h.Write(f.Image)
+	}
+	fmt.Fprintf(h, "|clip:%d:", len(v.Clip))
+	h.Write(v.Clip)
+	fmt.Fprintf(h, "|transcript:%s", v.Transcript)
+	return h.Sum(nil)
+}
+
+// sampleKeyframes keeps at most maxCaptionKeyframes frames spread evenly
+// across frames, which must be sorted by offset.
+func sampleKeyframes[T any](frames []T) []T {
+	if len(frames) <= maxCaptionKeyframes {
+		return frames
+	}
+	sampled := make([]T, 0, maxCaptionKeyframes)
+	step := float64(len(frames)-1) / float64(maxCaptionKeyframes-1)
+	for i := range maxCaptionKeyframes {
+		sampled = append(sampled, frames[int(float64(i)*step+0.5)])
+	}
+	return sampled
+}
+
+// loadCaptionVideo collects keyframes and, when wantClip is set and the clip
+// fits the duration and size caps, the clip itself from the folder holding
+// imageKey. Frames past maxCaptionVideoDuration are ignored. It returns nil
+// when there is nothing beyond the thumbnail, and every failure degrades to
+// that thumbnail-only result rather than failing the caption.
+func loadCaptionVideo(ctx context.Context, s3Client *s3.Client, imageKey string, duration time.Duration, transcript string, wantClip bool) *captionVideo {
+	if imageKey == "" {
+		return nil
+	}
+	dir := path.Dir(imageKey)
+	video := &captionVideo{Duration: duration, Transcript: truncateTranscript(transcript)}
+
+	if wantClip && duration > 0 && duration <= maxCaptionVideoDuration {
+		clipKey := dir + "/video.mp4"
+		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(mediaBucket), Key: aws.String(clipKey)})
+		if err == nil && head.ContentLength != nil && *head.ContentLength <= maxCaptionVideoBytes {
+			if clip, err := readS3Object(ctx, s3Client, clipKey, "video", maxCaptionVideoBytes); err == nil {
+				video.Clip = clip
+				video.ClipMIME = "video/mp4"
+			} else {
+				log.Printf("Video caption: clip %s unreadable, using keyframes: %v", clipKey, err)
+			}
+		}
+	}
+
+	if len(video.Clip) == 0 {
+		video.Frames = loadCaptionKeyframes(ctx, s3Client, dir)
+	}
+	if len(video.Clip) == 0 && len(video.Frames) == 0 {
+		return nil
+	}
+	return video
+}
+
+func loadCaptionKeyframes(ctx context.Context, s3Client *s3.Client, dir string) []CaptionFrame {
+	listed, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(mediaBucket),
+		Prefix:  aws.String(dir + "/frame_"),
+		MaxKeys: aws.Int32(100),
+	})
+	if err != nil {
+		log.Printf("Video caption: listing keyframes in %s failed: %v", dir, err)
+		return nil
+	}
+
+	type keyframe struct {
+		key    string
+		offset time.Duration
+	}
+	var found []keyframe
+	for _, obj := range listed.Contents {
+		m := captionKeyframePattern.FindStringSubmatch(path.Base(aws.ToString(obj.Key)))
+		if m == nil {
+			continue
+		}
+		ms, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		offset := time.Duration(ms) * time.Millisecond
+		if offset > maxCaptionVideoDuration {
+			continue
+		}
+		found = append(found, keyframe{key: aws.ToString(obj.Key), offset: offset})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].offset < found[j].offset })
+
+	var frames []CaptionFrame
+	for _, kf := range sampleKeyframes(found) {
+		data, err := readS3CaptionImage(ctx, s3Client, kf.key)
+		if err != nil {
+			log.Printf("Video caption: skipping keyframe %s: %v", kf.key, err)
+			continue
+		}
+		mime, ok := sniffCaptionImageMIME(data)
+		if !ok {
+			log.Printf("Video caption: skipping keyframe %s: not an image", kf.key)
+			continue
+		}
+		frames = append(frames, CaptionFrame{Image: data, MIME: mime, Offset: kf.offset})
+	}
+	return frames
+}
+
+func truncateTranscript(transcript string) string {
+	transcript = strings.Join(strings.Fields(transcript), " ")
+	if r := []rune(transcript); len(r) > maxCaptionTranscriptChars {
+		transcript = string(r[:maxCaptionTranscriptChars]) + "…"
+	}
+	return transcript
+}
+
+// videoCaptionPrompt adds the video instructions to a rendered caption
+// prompt. The caption rules themselves are unchanged.
+func videoCaptionPrompt(prompt string, v *captionVideo) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\nTHIS REFLECTION IS A VIDEO")
+	if v.Duration > 0 {
+		fmt.Fprintf(&b, " (about %d seconds long)", int(v.Duration.Round(time.Second)/time.Second))
+	}
+	b.WriteString(". ")
+	if len(v.Clip) > 0 {
+		b.WriteString("You are given the whole clip. ")
+	} else {
+		fmt.Fprintf(&b, "You are given %d frames in time order, each labelled with its time. ", len(v.Frames))
+	}
+	b.WriteString("Describe what happens over time, not just one moment: the short caption names the main action, and the deep dive tells it in order (first, then, at the end).")
+	if v.Transcript != "" {
+		fmt.Fprintf(&b, "\nWhat is said in the video: %q. Use it to understand the moment; do not quote it word for word.", v.Transcript)
+	}
+	return b.String()
+}
+
+// frameLabel introduces a keyframe to the model, e.g. "Frame at 3.5s:".
+func frameLabel(f CaptionFrame) string {
+	return fmt.Sprintf("Frame at %.1fs:", f.Offset.Seconds())
+}
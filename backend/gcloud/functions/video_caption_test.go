@@ -0,0 +1,91 @@
+package functions
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleKeyframes(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{0, []int{}},
+		{3, []int{0, 1, 2}},
+		{maxCaptionKeyframes, []int{0, 1, 2, 3, 4, 5}},
+		{11, []int{0, 2, 4, 6, 8, 10}},
+		{20, []int{0, 4, 8, 11, 15, 19}},
+	}
+	for _, tt := range tests {
+		frames := make([]int, tt.n)
+		for i := range frames {
+			frames[i] = i
+		}
+		if got := sampleKeyframes(frames); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sampleKeyframes(%d frames) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateTranscript(t *testing.T) {
+	if got := truncateTranscript("  hello \n\t there  "); got != "hello there" {
+		t.Errorf("truncateTranscript collapsed whitespace to %q", got)
+	}
+	long := strings.Repeat("é", maxCaptionTranscriptChars+10)
+	got := truncateTranscript(long)
+	if r := []rune(got); len(r) != maxCaptionTranscriptChars+1 || !strings.HasSuffix(got, "…") {
+		t.Errorf("truncateTranscript kept %d runes, want %d plus an ellipsis", len(r)-1, maxCaptionTranscriptChars)
+	}
+}
+
+func TestVideoCaptionPrompt(t *testing.T) {
+	keyframes := &captionVideo{Frames: make([]CaptionFrame, 4), Duration: 12400 * time.Millisecond, Transcript: "look at me"}
+	prompt := videoCaptionPrompt("Base prompt.", keyframes)
+	for _, want := range []string{"Base prompt.", "about 12 seconds long", "given 4 frames", `"look at me"`} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("keyframe prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+	if keyframes.Input() != CaptionVideoInputKeyframes {
+		t.Errorf("Input() = %q, want keyframes", keyframes.Input())
+	}
+
+	clip := &captionVideo{Clip: []byte("mp4")}
+	prompt = videoCaptionPrompt("Base prompt.", clip)
+	if !strings.Contains(prompt, "whole clip") || strings.Contains(prompt, "seconds long") || strings.Contains(prompt, "What is said") {
+		t.Errorf("clip prompt without duration or transcript:\n%s", prompt)
+	}
+	if clip.Input() != CaptionVideoInputClip {
+		t.Errorf("Input() = %q, want clip", clip.Input())
+	}
+
+	if got := frameLabel(CaptionFrame{Offset: 3500 * time.Millisecond}); got != "Frame at 3.5s:" {
+		t.Errorf("frameLabel = %q", got)
+	}
+}
+
+func TestCaptionVideoFingerprint(t *testing.T) {
+	image := []byte("thumbnail")
+	base := &captionVideo{Frames: []CaptionFrame{{Image: []byte("a"), Offset: time.Second}}}
+	same := &captionVideo{Frames: []CaptionFrame{{Image: []byte("a"), Offset: time.Second}}}
+	if !bytes.Equal(base.fingerprint(image), same.fingerprint(image)) {
+		t.Fatal("fingerprint is not stable")
+	}
+	variants := map[string]*captionVideo{
+		"frame offset": {Frames: []CaptionFrame{{Image: []byte("a"), Offset: 2 * time.Second}}},
+		"frame image":  {Frames: []CaptionFrame{{Image: []byte("b"), Offset: time.Second}}},
+		"clip":         {Frames: base.Frames, Clip: []byte("mp4")},
+		"transcript":   {Frames: base.Frames, Transcript: "hi"},
+	}
+	for changed, v := range variants {
+		if bytes.Equal(v.fingerprint(image), base.fingerprint(image)) {
+			t.Errorf("changing the %s did not change the fingerprint", changed)
+		}
+	}
+	if bytes.Equal(base.fingerprint([]byte("other")), base.fingerprint(image)) {
+		t.Error("changing the thumbnail did not change the fingerprint")
+	}
+}
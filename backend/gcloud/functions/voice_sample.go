@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
@@ -30,13 +29,13 @@ func GetVoiceSample(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	s3Client, err := awsS3Client(ctx)
 	if err != nil {
 		http.Error(w, "aws config error: "+err.Error(), 500)
 		return
 	}
 
-	presignClient := s3.NewPresignClient(s3.NewFromConfig(cfg))
+	presignClient := s3.NewPresignClient(s3Client)
 	s3Key := "assets/voice-samples/" + voice + ".mp3"
 
 	presigned, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
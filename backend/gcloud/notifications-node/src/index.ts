@@ -15,7 +15,14 @@ const COMPANION_REACTION_TRIGGER = 'companion_reaction';
 const REFLECTIONS_COLLECTION = 'reflections';
 const EXPLORER_LIKE_TRIGGER = 'explorer_like';
 const COMPANION_LIKE_TRIGGER = 'companion_like';
+const EXPLORER_CIRCLE_DELETION_TRIGGER = 'explorer_circle_deletion';
+const REVIEW_REQUESTED_TRIGGER = 'review_requested';
 const FAST_LANE_LIKE_TRIGGERS = new Set([EXPLORER_LIKE_TRIGGER, COMPANION_LIKE_TRIGGER]);
+const FAST_LANE_TRIGGERS = new Set([
+  ...FAST_LANE_LIKE_TRIGGERS,
+  EXPLORER_CIRCLE_DELETION_TRIGGER,
+  REVIEW_REQUESTED_TRIGGER,
+]);
 const DEFAULT_DEBOUNCE_MINUTES = 15;
 const DEFAULT_MIN_HOURS_BETWEEN_DIGESTS = 2;
 const DEFAULT_UPLOAD_DIGEST_MODE = 'batched';
@@ -635,7 +642,7 @@ export async function sendFastLaneNotification(
     ? pendingNotificationFromDocument(document)
     : pendingNotificationFromData(notificationSnapshot.data() ?? {});
 
-  if (notification.status !== PENDING_STATUS || !FAST_LANE_LIKE_TRIGGERS.has(notification.triggerType)) {
+  if (notification.status !== PENDING_STATUS || !FAST_LANE_TRIGGERS.has(notification.triggerType)) {
     return;
   }
 
@@ -671,6 +678,12 @@ export async function sendFastLaneNotification(
         (typeof explorerData.name === 'string' && explorerData.name.trim()) ||
         'Explorer';
       body = `❤️ ${explorerName} loved your Reflection!`;
+    } else if (notification.triggerType === EXPLORER_CIRCLE_DELETION_TRIGGER) {
+      const explorerName = await resolveExplorerName(notification.explorerId, new Map());
+      body = `${notification.senderName} is closing ${possessiveName(explorerName)} Reflections circle. Save anything you'd like to keep — it will be deleted in a few days.`;
+    } else if (notification.triggerType === REVIEW_REQUESTED_TRIGGER) {
+      const explorerName = await resolveExplorerName(notification.explorerId, new Map());
+      body = `${notification.senderName} sent ${explorerName} a Reflection that is waiting for your review.`;
     } else {
       const likerName = notification.likerName || 'A Companion';
       body = `❤️ ${likerName} loved your Reflection!`;
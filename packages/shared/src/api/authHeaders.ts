@@ -0,0 +1,12 @@
+import { auth } from '../firebase';
+
+/**
+ * Authorization header carrying the signed-in user's Firebase ID token, for
+ * Cloud Functions that check (and audit) who is calling them. Empty when no
+ * one is signed in, in which case those functions will refuse the call.
+ */
+export async function getAuthHeaders(): Promise<Record<string, string>> {
+  const user = auth.currentUser;
+  if (!user) return {};
+  return { Authorization: `Bearer ${await user.getIdToken()}` };
+}
@@ -13,5 +13,12 @@ export const API_ENDPOINTS = {
   GET_VOICE_SAMPLE: 'https://us-central1-reflections-1200b.cloudfunctions.net/get-voice-sample',
   SYNTHESIZE_SPEECH: 'https://us-central1-reflections-1200b.cloudfunctions.net/synthesize-speech',
   DELETE_COMPANION_ACCOUNT: 'https://us-central1-reflections-1200b.cloudfunctions.net/delete-companion-account',
+  DELETE_EXPLORER_CIRCLE: 'https://us-central1-reflections-1200b.cloudfunctions.net/delete-explorer-circle',
+  QUERY_AUDIT_LOG: 'https://us-central1-reflections-1200b.cloudfunctions.net/query-audit-log',
+  MANAGE_PROMPT_TEMPLATES: 'https://us-central1-reflections-1200b.cloudfunctions.net/manage-prompt-templates',
+  GET_AI_USAGE_REPORT: 'https://us-central1-reflections-1200b.cloudfunctions.net/get-ai-usage-report',
+  MANAGE_GLOSSARY: 'https://us-central1-reflections-1200b.cloudfunctions.net/manage-glossary',
+  REVIEW_REFLECTION: 'https://us-central1-reflections-1200b.cloudfunctions.net/review-reflection',
+  REVISE_CAPTION: 'https://us-central1-reflections-1200b.cloudfunctions.net/revise-caption',
   SUBMIT_CLIENT_LOGS: 'https://us-central1-reflections-1200b.cloudfunctions.net/submit-client-logs',
 } as const;
\ No newline at end of file
@@ -18,6 +18,8 @@ export interface CompanionAvatar {
   companionName: string;
   role: string | null;
   isCaregiver: boolean;
+  /** True while this Companion's account deletion is scheduled; their Reflections stay hidden. */
+  pendingDeletion: boolean;
   avatarUrl: string | null;
   avatarS3Key: string | null;
   color: string;
@@ -56,6 +58,7 @@ export function useCompanionAvatars(explorerId: string | null): {
           userId: data.userId as string,
           companionName: (data.companionName as string) || 'Companion',
           role: typeof data.role === 'string' ? data.role : null,
+          pendingDeletion: data.pendingDeletionAt != null,
           avatarS3Key: (data.companionAvatarS3Key as string) || null,
         };
       });
@@ -80,6 +83,7 @@ export function useCompanionAvatars(explorerId: string | null): {
             companionName: c.companionName,
             role: c.role,
             isCaregiver: c.role === 'caregiver',
+            pendingDeletion: c.pendingDeletion,
             avatarUrl,
             avatarS3Key: c.avatarS3Key,
             color: getAvatarColor(c.userId),
@@ -5,6 +5,7 @@
 
 // API endpoints
 export * from './api/endpoints';
+export * from './api/authHeaders';
 
 // S3 utilities
 export * from './s3/constants';
@@ -38,6 +39,7 @@ export type { WaitOverlayProps } from './components/WaitOverlay';
 export * from './utils/avatarDefaults';
 export * from './reflections/likes';
 export * from './reflections/likeFeedback';
+export * from './reflections/initialStatus';
 export * from './notifications/uploadDigest';
 export * from './notifications/postingReminder';
 
@@ -0,0 +1,16 @@
+import { db, doc, getDoc } from '../firebase';
+import { ExplorerConfig } from '../explorer/ExplorerConfig';
+
+/** Withheld statuses a new Reflection or Reaction may be created with. */
+export type InitialReflectionStatus = 'screening' | 'pending_review';
+
+/**
+ * Status to write on a new Reflection or Reaction: `pending_review` while the
+ * Explorer's `settings.require_review` is on, `screening` otherwise.
+ * firestore.rules reject anything else, and the Explorer sees neither until
+ * the server releases the Reflection.
+ */
+export async function initialReflectionStatus(explorerId: string): Promise<InitialReflectionStatus> {
+  const snap = await getDoc(doc(db, ExplorerConfig.collections.explorers, explorerId));
+  return snap.exists() && snap.data()?.settings?.require_review === true ? 'pending_review' : 'screening';
+}
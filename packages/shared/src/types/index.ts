@@ -28,6 +28,12 @@ export interface EventMetadata {
   short_caption?: string; // Brief greeting - auto-played on load
   deep_dive?: string; // Detailed story - played when ✨ is tapped
   deep_dive_audio_url?: string; // Optional TTS for deep dive
+  /** BCP-47 language short_caption and deep_dive are written in (default "en-US"). */
+  caption_language?: string;
+  /** Optional second rendering of the caption, e.g. for a bilingual household. */
+  second_language?: string;
+  second_short_caption?: string;
+  second_deep_dive?: string;
   // Narrative context captured during creation/edit flow (legacy mirrors below)
   companion_in_reflection?: boolean;
   explorer_in_reflection?: boolean;
@@ -49,8 +55,12 @@ export interface EventMetadata {
   is_selfie?: boolean;
   /** Free-form people / scene hints (preferred over legacy `people_context` when both exist). */
   people_context_hints?: string;
+  /** Caption prompt template version that produced the AI caption (e.g. "caption@v3"), for A/B comparison. */
+  prompt_version?: string;
   /** ISO timestamp when a Companion last saved edits to this reflection (metadata and/or media). */
   last_edited_at?: string;
+  /** Who made audio.m4a: the Companion's own recording (transcribed server-side) or caption TTS. */
+  audio_source?: 'companion' | 'tts';
   /** Typed reaction message (display only; spoken via audio_url). */
   reaction_message?: string;
   /** True when this image Reflection has a Bring-It-to-Life selfie narration child doc. */
@@ -99,13 +109,32 @@ export interface Event {
   narration_event_id?: string;
 }
 
+/** Server-written transcript of a Companion's recorded audio.m4a. */
+export interface ReflectionTranscript {
+  status: 'done' | 'failed' | 'skipped';
+  text?: string;
+  /** BCP-47 code reported by the transcription provider. */
+  language?: string;
+  provider?: string;
+  /** `metadata.last_edited_at` of the audio this was made from. */
+  sourceEditedAt?: string;
+  error?: string;
+  updatedAt?: unknown;
+}
+
 /** Merged Firestore signal doc (`mirror_event` + engagement overlays); rich content lives in `metadata`. */
 export interface ReflectionDocument {
   explorerId: string;
   event_id: string;
   sender?: string;
   sender_id?: string;
-  status?: 'ready' | 'engaged' | 'replayed' | 'deleted';
+  /**
+   * New Reflections are written as `screening`, or `pending_review` while the Explorer
+   * requires caregiver approval (see initialReflectionStatus). The server then sets `ready`,
+   * `quarantined` by moderation, and a reviewer `ready` or `rejected`. The Explorer never
+   * sees the withheld statuses.
+   */
+  status?: 'screening' | 'ready' | 'engaged' | 'replayed' | 'deleted' | 'quarantined' | 'pending_review' | 'rejected';
   timestamp?: unknown;
   type?: 'mirror_event' | 'engagement_heartbeat' | string;
   metadata?: EventMetadata;
@@ -135,13 +164,41 @@ export interface ReflectionDocument {
   respondedRelationshipIds?: string[];
   /** @deprecated Legacy writes used Firebase Auth UIDs; read for backward compatibility only. */
   respondedCompanionIds?: string[];
+  /** Set by the server when `metadata.audio_source` is `companion`. */
+  transcript?: ReflectionTranscript;
+  /** Distinct lowercased transcript words, for `array-contains` search. */
+  transcriptTerms?: string[];
+  /** Set by the server when moderation flags or quarantines the Reflection. */
+  moderation?: ReflectionModeration;
+  /** Set by review-reflection when an owner or admin approves or rejects a held Reflection. */
+  review?: ReflectionReview;
+}
+
+export interface ReflectionReview {
+  decision: 'approved' | 'rejected';
+  reviewedBy: string;
+  reviewedAt?: unknown;
+  note?: string;
+}
+
+export type ModerationVerdict = 'allow' | 'flag' | 'quarantine';
+
+/** Server content screening outcome, on Reflections and AI caption responses. */
+export interface ReflectionModeration {
+  verdict: ModerationVerdict;
+  reasons: string[];
+  /** e.g. `keyword` or `gemini/gemini-2.5-flash-lite`. */
+  moderator: string;
+  checkedAt?: unknown;
 }
 
 export type PendingNotificationTriggerType =
   | 'companion_upload'
   | 'companion_reaction'
   | 'explorer_like'
-  | 'companion_like';
+  | 'companion_like'
+  | 'explorer_circle_deletion'
+  | 'review_requested';
 export type PendingNotificationStatus = 'pending';
 
 // Collection: system_config
@@ -200,6 +257,10 @@ export interface UserProfile {
   upload_digest_hours?: number;
   /** Server push when a Companion has not shared in 7 days. Default: true. */
   posting_reminders_enabled?: boolean;
+  /** BCP-47 language this Companion reads captions in; added as a second caption language when it differs from the Explorer's. */
+  caption_language?: string;
+  /** Set by delete-companion-account in scheduled mode; the purge runs at this time unless cancelled. */
+  pendingDeletionAt?: unknown;
 }
 
 // The Explorer (Device/Context) Document
@@ -216,11 +277,79 @@ export interface Explorer {
   settings?: {
     allow_video?: boolean;
     autoplay?: boolean;
+    /** Caption prompt variables; omitted from the prompt when unset. */
+    age?: number;
+    condition?: string;
+    /** Caption prompt template: "caption" (default), "name@vN" to pin a version, or "client" for the app-built prompt. */
+    prompt_template?: string;
+    /** Caption wording: "standard" (default), "very_simple" (3-5 word phrases) or "core_vocabulary". */
+    caption_profile?: 'standard' | 'very_simple' | 'core_vocabulary';
+    /** Words core_vocabulary captions may use (e.g. the Explorer's AAC board); unset uses a built-in core list. */
+    core_vocabulary?: string[];
+    /** BCP-47 caption and narration language (e.g. "es-US"); default "en-US". */
+    caption_language?: string;
+    /** Optional second caption language, rendered and narrated alongside the first. */
+    second_language?: string;
+    /** Hold new Reflections as `pending_review` until an owner or admin approves them. */
+    require_review?: boolean;
+    /** Estimated AI spend limits in USD; unset or 0 uses the deployment default. */
+    ai_budget?: {
+      daily_usd?: number;
+      monthly_usd?: number;
+      /** What happens once a limit is reached (default "text_only": captions without synthesized audio). */
+      action?: 'text_only' | 'refuse';
+    };
   };
   explorerAvatarS3Key?: string; // S3 key for the Explorer's profile photo
   created_at: string;
 }
 
+// A person, pet or place the Explorer knows, used to name them consistently in captions
+// Collection: explorers/{explorerId}/glossary (managed through manage-glossary)
+export interface GlossaryEntry {
+  id: string;
+  kind: 'person' | 'pet' | 'place';
+  name: string;
+  /** What captions call them, e.g. "Grandma Sue"; defaults to name ("Biscuit the dog" for pets with a relationship). */
+  caption_name?: string;
+  /** Who they are to the Explorer ("grandma"), the kind of animal ("dog") or what the place is. */
+  relationship?: string;
+  /**
+   * Plain respelling spoken by TTS instead of the name, e.g. "Shi-vawn",
+   * optionally followed by IPA between slashes ("Shi-vawn /ʃɪˈvɔːn/") for
+   * SSML-capable voices. IPA alone is rejected: the default voice cannot read it.
+   */
+  pronunciation?: string;
+  aliases: string[];
+  created_by: string;
+  created_at: string;
+  updated_at: string;
+}
+
+// One version of a draft Reflection's caption, rewritten from Companion feedback
+// Collection: caption_revisions (managed through revise-caption; expires with the staging event)
+export interface CaptionRevision {
+  staging_event_id: string;
+  revision: number;
+  /** The revision the feedback was applied to; absent for original and edited captions. */
+  based_on?: number;
+  source: 'original' | 'edited' | 'feedback';
+  /** e.g. "shorter", "mention the beach", "don't say Grandpa". */
+  feedback?: string;
+  short_caption: string;
+  deep_dive: string;
+  language?: string;
+  prompt_version?: string;
+  moderation?: ReflectionModeration;
+  audio_s3_key?: string;
+  deep_dive_audio_s3_key?: string;
+  /** Presigned while the staging audio still exists. */
+  audio_url?: string;
+  deep_dive_audio_url?: string;
+  created_by: string;
+  created_at: string;
+}
+
 // The Invite Code Document
 // Collection: invites
 export interface Invite {
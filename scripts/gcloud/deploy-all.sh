@@ -80,9 +80,30 @@ SLOW_LANE_SCHEDULE="*/15 * * * *"
 POSTING_REMINDERS_TOPIC="send-posting-reminders"
 POSTING_REMINDERS_SCHEDULER_JOB="send-posting-reminders"
 POSTING_REMINDERS_SCHEDULE="0 14 * * *"
+DELETION_JOBS_TOPIC="resume-deletion-jobs"
+DELETION_JOBS_SCHEDULER_JOB="resume-deletion-jobs"
+DELETION_JOBS_SCHEDULE="*/5 * * * *"
 PUBSUB_TRIGGER_LOCATION="${PUBSUB_TRIGGER_LOCATION:-${REGION}}"
 SCHEDULER_LOCATION="${SCHEDULER_LOCATION:-${REGION}}"
 ENV_VARS="AWS_ACCESS_KEY_ID=${AWS_ACCESS_KEY_ID},AWS_SECRET_ACCESS_KEY=${AWS_SECRET_ACCESS_KEY},AWS_REGION=${AWS_REGION}"
+# Caption model selection (gemini | openai | fake); unset keeps Gemini.
+# AI_BUDGET_* set default per-explorer spend limits (USD; unset = unlimited).
+# TRANSCRIPTION_PROVIDER (gemini | whisper | fake) selects voice note transcription.
+# MODERATION_PROVIDER (gemini | keyword | fake) screens captions and new Reflections;
+# MODERATION_BLOCKLIST adds |-separated terms that quarantine content.
+# CAPTION_FALLBACK_MODELS is a |-separated fallback chain (e.g. gemini-2.5-flash);
+# AI_RETRY_* and AI_BREAKER_* tune retries and circuit breakers for AI and TTS calls.
+# TTS_CACHE_TTL_DAYS (0 disables) and TTS_CACHE_MAX_ENTRIES bound the synthesis cache.
+AI_ENV_VARS="${ENV_VARS},GEMINI_API_KEY=${GEMINI_API_KEY}"
+for var in CAPTION_PROVIDER CAPTION_MODEL CAPTION_BASE_URL CAPTION_API_KEY AI_BUDGET_DAILY_USD AI_BUDGET_MONTHLY_USD AI_BUDGET_ACTION \
+  TRANSCRIPTION_PROVIDER TRANSCRIPTION_MODEL TRANSCRIPTION_BASE_URL TRANSCRIPTION_API_KEY \
+  MODERATION_PROVIDER MODERATION_MODEL MODERATION_BLOCKLIST CAPTION_FALLBACK_MODELS \
+  AI_RETRY_MAX_ATTEMPTS AI_RETRY_BASE_DELAY_MS AI_RETRY_MAX_DELAY_MS AI_BREAKER_FAILURES AI_BREAKER_COOLDOWN_SECONDS \
+  TTS_CACHE_TTL_DAYS TTS_CACHE_MAX_ENTRIES; do
+  if [ -n "${!var}" ]; then
+    AI_ENV_VARS="${AI_ENV_VARS},${var}=${!var}"
+  fi
+done
 
 # Function 1: get-s3-url
 echo -e "${YELLOW}Deploying get-s3-url...${NC}"
@@ -220,7 +241,7 @@ gcloud functions deploy synthesize-speech \
   --entry-point=SynthesizeSpeech \
   --trigger-http \
   --allow-unauthenticated \
-  --set-env-vars ${ENV_VARS} \
+  --set-env-vars ${AI_ENV_VARS} \
   --quiet
 
 if [ $? -eq 0 ]; then
@@ -280,6 +301,218 @@ else
 fi
 echo ""
 
+# Function 8b: on-deletion-job-written
+echo -e "${YELLOW}Deploying on-deletion-job-written...${NC}"
+gcloud functions deploy on-deletion-job-written \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=OnDeletionJobWritten \
+  --trigger-event-filters=type=google.cloud.firestore.document.v1.written \
+  --trigger-event-filters=database='(default)' \
+  --trigger-event-filters-path-pattern=document='deletion_jobs/{jobId}' \
+  --timeout=120s \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ on-deletion-job-written deployed successfully${NC}"
+else
+  echo -e "${RED}✗ on-deletion-job-written deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8c: resume-deletion-jobs
+echo -e "${YELLOW}Ensuring Pub/Sub topic ${DELETION_JOBS_TOPIC} exists...${NC}"
+gcloud pubsub topics describe "${DELETION_JOBS_TOPIC}" --quiet >/dev/null 2>&1 || \
+  gcloud pubsub topics create "${DELETION_JOBS_TOPIC}" --quiet
+
+echo -e "${YELLOW}Deploying resume-deletion-jobs...${NC}"
+gcloud functions deploy resume-deletion-jobs \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --trigger-location=${PUBSUB_TRIGGER_LOCATION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=ResumeDeletionJobs \
+  --trigger-topic="${DELETION_JOBS_TOPIC}" \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ resume-deletion-jobs deployed successfully${NC}"
+else
+  echo -e "${RED}✗ resume-deletion-jobs deployment failed${NC}"
+  exit 1
+fi
+
+echo -e "${YELLOW}Ensuring 5-minute scheduler job ${DELETION_JOBS_SCHEDULER_JOB} exists...${NC}"
+if gcloud scheduler jobs describe "${DELETION_JOBS_SCHEDULER_JOB}" --location="${SCHEDULER_LOCATION}" --quiet >/dev/null 2>&1; then
+  gcloud scheduler jobs update pubsub "${DELETION_JOBS_SCHEDULER_JOB}" \
+    --location="${SCHEDULER_LOCATION}" \
+    --schedule="${DELETION_JOBS_SCHEDULE}" \
+    --topic="${DELETION_JOBS_TOPIC}" \
+    --message-body='{}' \
+    --quiet
+else
+  gcloud scheduler jobs create pubsub "${DELETION_JOBS_SCHEDULER_JOB}" \
+    --location="${SCHEDULER_LOCATION}" \
+    --schedule="${DELETION_JOBS_SCHEDULE}" \
+    --topic="${DELETION_JOBS_TOPIC}" \
+    --message-body='{}' \
+    --quiet
+fi
+echo ""
+
+# Function 8d: delete-explorer-circle
+echo -e "${YELLOW}Deploying delete-explorer-circle...${NC}"
+gcloud functions deploy delete-explorer-circle \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=DeleteExplorerCircle \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ delete-explorer-circle deployed successfully${NC}"
+else
+  echo -e "${RED}✗ delete-explorer-circle deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8e: query-audit-log
+echo -e "${YELLOW}Deploying query-audit-log...${NC}"
+gcloud functions deploy query-audit-log \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=QueryAuditLog \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ query-audit-log deployed successfully${NC}"
+else
+  echo -e "${RED}✗ query-audit-log deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8f: manage-prompt-templates
+echo -e "${YELLOW}Deploying manage-prompt-templates...${NC}"
+gcloud functions deploy manage-prompt-templates \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=ManagePromptTemplates \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ manage-prompt-templates deployed successfully${NC}"
+else
+  echo -e "${RED}✗ manage-prompt-templates deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8g: get-ai-usage-report
+echo -e "${YELLOW}Deploying get-ai-usage-report...${NC}"
+gcloud functions deploy get-ai-usage-report \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=GetAIUsageReport \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ get-ai-usage-report deployed successfully${NC}"
+else
+  echo -e "${RED}✗ get-ai-usage-report deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8h: manage-glossary
+echo -e "${YELLOW}Deploying manage-glossary...${NC}"
+gcloud functions deploy manage-glossary \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=ManageGlossary \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ manage-glossary deployed successfully${NC}"
+else
+  echo -e "${RED}✗ manage-glossary deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8i: review-reflection
+echo -e "${YELLOW}Deploying review-reflection...${NC}"
+gcloud functions deploy review-reflection \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=ReviewReflection \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ review-reflection deployed successfully${NC}"
+else
+  echo -e "${RED}✗ review-reflection deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
+# Function 8j: revise-caption
+echo -e "${YELLOW}Deploying revise-caption...${NC}"
+gcloud functions deploy revise-caption \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=ReviseCaption \
+  --trigger-http \
+  --allow-unauthenticated \
+  --set-env-vars ${AI_ENV_VARS} \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ revise-caption deployed successfully${NC}"
+else
+  echo -e "${RED}✗ revise-caption deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
 # Function 6: generate-ai-description
 if [ "$SKIP_AI" = false ]; then
   echo -e "${YELLOW}Deploying generate-ai-description...${NC}"
@@ -291,7 +524,7 @@ if [ "$SKIP_AI" = false ]; then
     --entry-point=GenerateAIDescription \
     --trigger-http \
     --allow-unauthenticated \
-    --set-env-vars ${ENV_VARS},GEMINI_API_KEY=${GEMINI_API_KEY} \
+    --set-env-vars ${AI_ENV_VARS} \
     --quiet
 
   if [ $? -eq 0 ]; then
@@ -315,6 +548,7 @@ gcloud functions deploy on-reflection-created \
   --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
   --source="${SOURCE_DIR}" \
   --entry-point=OnReflectionCreated \
+  --set-env-vars ${AI_ENV_VARS} \
   --trigger-event-filters=type=google.cloud.firestore.document.v1.created \
   --trigger-event-filters=database='(default)' \
   --trigger-event-filters-path-pattern=document='reflections/{reflectionId}' \
@@ -350,6 +584,30 @@ else
 fi
 echo ""
 
+# Function 10b: transcribe-reflection-audio
+echo -e "${YELLOW}Deploying transcribe-reflection-audio...${NC}"
+gcloud functions deploy transcribe-reflection-audio \
+  --gen2 \
+  --runtime=${RUNTIME} \
+  --region=${REGION} \
+  --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
+  --source="${SOURCE_DIR}" \
+  --entry-point=TranscribeReflectionAudio \
+  --trigger-event-filters=type=google.cloud.firestore.document.v1.written \
+  --trigger-event-filters=database='(default)' \
+  --trigger-event-filters-path-pattern=document='reflections/{reflectionId}' \
+  --set-env-vars ${AI_ENV_VARS} \
+  --timeout=300s \
+  --quiet
+
+if [ $? -eq 0 ]; then
+  echo -e "${GREEN}✓ transcribe-reflection-audio deployed successfully${NC}"
+else
+  echo -e "${RED}✗ transcribe-reflection-audio deployment failed${NC}"
+  exit 1
+fi
+echo ""
+
 # Function 11: send-fast-lane-notification
 echo -e "${YELLOW}Deploying send-fast-lane-notification...${NC}"
 gcloud functions deploy send-fast-lane-notification \
@@ -466,6 +724,15 @@ echo "  • get-event-bundle"
 echo "  • get-voice-sample"
 echo "  • synthesize-speech"
 echo "  • delete-companion-account"
+echo "  • on-deletion-job-written"
+echo "  • resume-deletion-jobs"
+echo "  • delete-explorer-circle"
+echo "  • query-audit-log"
+echo "  • manage-prompt-templates"
+echo "  • get-ai-usage-report"
+echo "  • manage-glossary"
+echo "  • review-reflection"
+echo "  • revise-caption"
 if [ "$SKIP_UNSPLASH" = false ]; then
   echo "  • unsplash-search"
 fi
@@ -474,6 +741,7 @@ if [ "$SKIP_AI" = false ]; then
 fi
 echo "  • on-reflection-created"
 echo "  • on-reflection-updated"
+echo "  • transcribe-reflection-audio"
 echo "  • send-fast-lane-notification"
 echo "  • aggregate-slow-lane-notifications"
 echo "  • send-posting-reminders"
@@ -27,11 +27,21 @@ ALL_FUNCTIONS=(
   get-voice-sample
   synthesize-speech
   delete-companion-account
+  on-deletion-job-written
+  resume-deletion-jobs
+  delete-explorer-circle
+  query-audit-log
+  manage-prompt-templates
+  get-ai-usage-report
+  manage-glossary
+  review-reflection
+  revise-caption
   submit-client-logs
   unsplash-search
   generate-ai-description
   on-reflection-created
   on-reflection-updated
+  transcribe-reflection-audio
   send-fast-lane-notification
   aggregate-slow-lane-notifications
   send-posting-reminders
@@ -83,9 +93,30 @@ SLOW_LANE_SCHEDULE="*/15 * * * *"
 POSTING_REMINDERS_TOPIC="send-posting-reminders"
 POSTING_REMINDERS_SCHEDULER_JOB="send-posting-reminders"
 POSTING_REMINDERS_SCHEDULE="0 14 * * *"
+DELETION_JOBS_TOPIC="resume-deletion-jobs"
+DELETION_JOBS_SCHEDULER_JOB="resume-deletion-jobs"
+DELETION_JOBS_SCHEDULE="*/5 * * * *"
 PUBSUB_TRIGGER_LOCATION="${PUBSUB_TRIGGER_LOCATION:-${REGION}}"
 SCHEDULER_LOCATION="${SCHEDULER_LOCATION:-${REGION}}"
 ENV_VARS="AWS_ACCESS_KEY_ID=${AWS_ACCESS_KEY_ID},AWS_SECRET_ACCESS_KEY=${AWS_SECRET_ACCESS_KEY},AWS_REGION=${AWS_REGION}"
+# Caption model selection (gemini | openai | fake); unset keeps Gemini.
+# AI_BUDGET_* set default per-explorer spend limits (USD; unset = unlimited).
+# TRANSCRIPTION_PROVIDER (gemini | whisper | fake) selects voice note transcription.
+# MODERATION_PROVIDER (gemini | keyword | fake) screens captions and new Reflections;
+# MODERATION_BLOCKLIST adds |-separated terms that quarantine content.
+# CAPTION_FALLBACK_MODELS is a |-separated fallback chain (e.g. gemini-2.5-flash);
+# AI_RETRY_* and AI_BREAKER_* tune retries and circuit breakers for AI and TTS calls.
+# TTS_CACHE_TTL_DAYS (0 disables) and TTS_CACHE_MAX_ENTRIES bound the synthesis cache.
+AI_ENV_VARS="${ENV_VARS},GEMINI_API_KEY=${GEMINI_API_KEY}"
+for var in CAPTION_PROVIDER CAPTION_MODEL CAPTION_BASE_URL CAPTION_API_KEY AI_BUDGET_DAILY_USD AI_BUDGET_MONTHLY_USD AI_BUDGET_ACTION \
+  TRANSCRIPTION_PROVIDER TRANSCRIPTION_MODEL TRANSCRIPTION_BASE_URL TRANSCRIPTION_API_KEY \
+  MODERATION_PROVIDER MODERATION_MODEL MODERATION_BLOCKLIST CAPTION_FALLBACK_MODELS \
+  AI_RETRY_MAX_ATTEMPTS AI_RETRY_BASE_DELAY_MS AI_RETRY_MAX_DELAY_MS AI_BREAKER_FAILURES AI_BREAKER_COOLDOWN_SECONDS \
+  TTS_CACHE_TTL_DAYS TTS_CACHE_MAX_ENTRIES; do
+  if [ -n "${!var}" ]; then
+    AI_ENV_VARS="${AI_ENV_VARS},${var}=${!var}"
+  fi
+done
 
 # Deploy based on function name
 case "$FUNCTION_NAME" in
@@ -177,7 +208,7 @@ case "$FUNCTION_NAME" in
       --entry-point=GenerateAIDescription \
       --trigger-http \
       --allow-unauthenticated \
-      --set-env-vars ${ENV_VARS},GEMINI_API_KEY=${GEMINI_API_KEY} \
+      --set-env-vars ${AI_ENV_VARS} \
       --quiet
     ;;
   
@@ -219,7 +250,7 @@ case "$FUNCTION_NAME" in
       --entry-point=SynthesizeSpeech \
       --trigger-http \
       --allow-unauthenticated \
-      --set-env-vars ${ENV_VARS} \
+      --set-env-vars ${AI_ENV_VARS} \
       --quiet
     ;;
 
@@ -237,6 +268,155 @@ case "$FUNCTION_NAME" in
       --quiet
     ;;
 
+  on-deletion-job-written)
+    echo -e "${YELLOW}Deploying on-deletion-job-written...${NC}"
+    gcloud functions deploy on-deletion-job-written \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=OnDeletionJobWritten \
+      --trigger-event-filters=type=google.cloud.firestore.document.v1.written \
+      --trigger-event-filters=database='(default)' \
+      --trigger-event-filters-path-pattern=document='deletion_jobs/{jobId}' \
+      --timeout=120s \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  resume-deletion-jobs)
+    echo -e "${YELLOW}Ensuring Pub/Sub topic ${DELETION_JOBS_TOPIC} exists...${NC}"
+    gcloud pubsub topics describe "${DELETION_JOBS_TOPIC}" --quiet >/dev/null 2>&1 || \
+      gcloud pubsub topics create "${DELETION_JOBS_TOPIC}" --quiet
+
+    echo -e "${YELLOW}Deploying resume-deletion-jobs...${NC}"
+    gcloud functions deploy resume-deletion-jobs \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --trigger-location=${PUBSUB_TRIGGER_LOCATION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=ResumeDeletionJobs \
+      --trigger-topic="${DELETION_JOBS_TOPIC}" \
+      --quiet
+
+    echo -e "${YELLOW}Ensuring 5-minute scheduler job ${DELETION_JOBS_SCHEDULER_JOB} exists...${NC}"
+    if gcloud scheduler jobs describe "${DELETION_JOBS_SCHEDULER_JOB}" --location="${SCHEDULER_LOCATION}" --quiet >/dev/null 2>&1; then
+      gcloud scheduler jobs update pubsub "${DELETION_JOBS_SCHEDULER_JOB}" \
+        --location="${SCHEDULER_LOCATION}" \
+        --schedule="${DELETION_JOBS_SCHEDULE}" \
+        --topic="${DELETION_JOBS_TOPIC}" \
+        --message-body='{}' \
+        --quiet
+    else
+      gcloud scheduler jobs create pubsub "${DELETION_JOBS_SCHEDULER_JOB}" \
+        --location="${SCHEDULER_LOCATION}" \
+        --schedule="${DELETION_JOBS_SCHEDULE}" \
+        --topic="${DELETION_JOBS_TOPIC}" \
+        --message-body='{}' \
+        --quiet
+    fi
+    ;;
+
+  delete-explorer-circle)
+    echo -e "${YELLOW}Deploying delete-explorer-circle...${NC}"
+    gcloud functions deploy delete-explorer-circle \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=DeleteExplorerCircle \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  query-audit-log)
+    echo -e "${YELLOW}Deploying query-audit-log...${NC}"
+    gcloud functions deploy query-audit-log \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=QueryAuditLog \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  manage-prompt-templates)
+    echo -e "${YELLOW}Deploying manage-prompt-templates...${NC}"
+    gcloud functions deploy manage-prompt-templates \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=ManagePromptTemplates \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  get-ai-usage-report)
+    echo -e "${YELLOW}Deploying get-ai-usage-report...${NC}"
+    gcloud functions deploy get-ai-usage-report \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=GetAIUsageReport \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  manage-glossary)
+    echo -e "${YELLOW}Deploying manage-glossary...${NC}"
+    gcloud functions deploy manage-glossary \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=ManageGlossary \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  review-reflection)
+    echo -e "${YELLOW}Deploying review-reflection...${NC}"
+    gcloud functions deploy review-reflection \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=ReviewReflection \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${ENV_VARS} \
+      --quiet
+    ;;
+
+  revise-caption)
+    echo -e "${YELLOW}Deploying revise-caption...${NC}"
+    gcloud functions deploy revise-caption \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=ReviseCaption \
+      --trigger-http \
+      --allow-unauthenticated \
+      --set-env-vars ${AI_ENV_VARS} \
+      --quiet
+    ;;
+
   submit-client-logs)
     echo -e "${YELLOW}Deploying submit-client-logs...${NC}"
     gcloud functions deploy submit-client-logs \
@@ -260,6 +440,7 @@ case "$FUNCTION_NAME" in
       --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
       --source="${SOURCE_DIR}" \
       --entry-point=OnReflectionCreated \
+      --set-env-vars ${AI_ENV_VARS} \
       --trigger-event-filters=type=google.cloud.firestore.document.v1.created \
       --trigger-event-filters=database='(default)' \
       --trigger-event-filters-path-pattern=document='reflections/{reflectionId}' \
@@ -281,6 +462,23 @@ case "$FUNCTION_NAME" in
       --quiet
     ;;
 
+  transcribe-reflection-audio)
+    echo -e "${YELLOW}Deploying transcribe-reflection-audio...${NC}"
+    gcloud functions deploy transcribe-reflection-audio \
+      --gen2 \
+      --runtime=${RUNTIME} \
+      --region=${REGION} \
+      --trigger-location=${FIRESTORE_TRIGGER_LOCATION} \
+      --source="${SOURCE_DIR}" \
+      --entry-point=TranscribeReflectionAudio \
+      --trigger-event-filters=type=google.cloud.firestore.document.v1.written \
+      --trigger-event-filters=database='(default)' \
+      --trigger-event-filters-path-pattern=document='reflections/{reflectionId}' \
+      --set-env-vars ${AI_ENV_VARS} \
+      --timeout=300s \
+      --quiet
+    ;;
+
   send-fast-lane-notification)
     if [ ! -f "${NOTIFICATIONS_NODE_SOURCE_DIR}/package.json" ]; then
       echo -e "${RED}Error: package.json not found in ${NOTIFICATIONS_NODE_SOURCE_DIR}${NC}"